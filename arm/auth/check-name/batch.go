@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-go-samples/helpers"
+)
+
+// reasonInvalid marks names rejected by the local naming rules, and reasonCheckFailed those whose
+// availability couldn't be determined because the request to ARM failed. The other reasons are
+// those returned by the service.
+const (
+	reasonInvalid     = "Invalid"
+	reasonCheckFailed = "CheckFailed"
+)
+
+// nameResult is the outcome of checking one name, printed as one row of the result table.
+type nameResult struct {
+	Name        string   `json:"name"`
+	Available   bool     `json:"available"`
+	Reason      string   `json:"reason,omitempty"`
+	Message     string   `json:"message,omitempty"`
+	Suggestions []string `json:"suggestions,omitempty"`
+}
+
+// readNames collects the names to check from the command line arguments and from a file with one
+// name per line, where "-" stands for standard input. When neither is given, the names are read
+// from standard input. Blank lines and lines starting with '#' are skipped, and each name is
+// only checked once.
+func readNames(args []string, fileName string, stdin io.Reader) ([]string, error) {
+	names := []string{}
+	seen := map[string]bool{}
+	add := func(name string) {
+		name = strings.TrimSpace(name)
+		if name == "" || strings.HasPrefix(name, "#") || seen[name] {
+			return
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+
+	for _, arg := range args {
+		add(arg)
+	}
+
+	if fileName == "" && len(args) == 0 {
+		fileName = "-"
+	}
+	if fileName == "" {
+		return names, nil
+	}
+
+	r := stdin
+	if fileName != "-" {
+		f, err := os.Open(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to open name file '%s' (%v)", fileName, err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		add(scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Failed to read names from '%s' (%v)", fileName, err)
+	}
+	return names, nil
+}
+
+// checkNames checks the availability of the passed names, running at most 'parallel' requests at
+// a time. Names that break the checker's naming rule are rejected without asking ARM. When
+// 'suggest' is positive, up to that many available alternatives are looked for each name that
+// can't be used. The results are in the same order as the names.
+func checkNames(checker nameChecker, names []string, parallel, suggest int) []nameResult {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	results := make([]nameResult, len(names))
+	work := make(chan int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range work {
+				results[n] = checkOne(checker, names[n], suggest)
+			}
+		}()
+	}
+
+	for n := range names {
+		work <- n
+	}
+	close(work)
+	wg.Wait()
+
+	return results
+}
+
+func checkOne(checker nameChecker, name string, suggest int) nameResult {
+	result := nameResult{Name: name}
+
+	if err := checker.Rule().Validate(name); err != nil {
+		result.Reason = reasonInvalid
+		result.Message = strings.TrimPrefix(err.Error(), "ERROR: ")
+	} else {
+		a, err := checker.Check(name)
+		if err != nil {
+			result.Reason = reasonCheckFailed
+			result.Message = err.Error()
+			return result
+		}
+		result.Available = a.Available
+		result.Reason = a.Reason
+		result.Message = a.Message
+	}
+
+	if !result.Available && suggest > 0 {
+		result.Suggestions = suggestNames(checker, name, suggest)
+	}
+	return result
+}
+
+// suggestNames makes up names resembling the passed one and returns up to 'count' of those that
+// are available. The generator is seeded from the name, so the same suggestions come up each
+// time, as long as they stay available.
+func suggestNames(checker nameChecker, name string, count int) []string {
+	g := helpers.NewNameGenerator(helpers.SeedFromString(name))
+	g.SuffixLength = 4
+
+	var suggestions []string
+	for attempt := 0; attempt < 3*count && len(suggestions) < count; attempt++ {
+		candidate, err := g.Name(checker.Rule(), name)
+		if err != nil {
+			break
+		}
+		a, err := checker.Check(candidate)
+		if err != nil {
+			break
+		}
+		if a.Available {
+			suggestions = append(suggestions, candidate)
+		}
+	}
+	return suggestions
+}
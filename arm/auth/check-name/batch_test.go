@@ -0,0 +1,154 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-go-samples/fakes/armfake"
+	"github.com/Azure/azure-go-samples/helpers"
+	"github.com/Azure/azure-sdk-for-go/Godeps/_workspace/src/github.com/Azure/go-autorest/autorest"
+)
+
+func TestReadNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "check-name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "names.txt")
+	if err := ioutil.WriteFile(fileName, []byte("# candidates\nsamplesone\n\n  samplestwo  \nsamplesone\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := readNames([]string{"samplesarg"}, fileName, strings.NewReader("samplesstdin\n"))
+	if err != nil {
+		t.Fatalf("readNames failed: %v", err)
+	}
+	if expected := []string{"samplesarg", "samplesone", "samplestwo"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+
+	names, err = readNames(nil, "", strings.NewReader("samplesstdin\nsamplesarg\n"))
+	if err != nil {
+		t.Fatalf("readNames failed: %v", err)
+	}
+	if expected := []string{"samplesstdin", "samplesarg"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected the names from standard input, got %v", names)
+	}
+
+	if _, err := readNames(nil, filepath.Join(dir, "missing.txt"), nil); err == nil {
+		t.Errorf("expected reading a missing file to fail")
+	}
+}
+
+func TestCheckNames(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	srv.TakeStorageAccountName("samplestaken")
+
+	names := []string{"samplesfree", "samplestaken", "Samples-Invalid"}
+	results := checkNames(storageChecker{helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)}, names, 2, 2)
+	if len(results) != len(names) {
+		t.Fatalf("expected %d results, got %d", len(names), len(results))
+	}
+
+	for i, c := range []struct {
+		available bool
+		reason    string
+	}{
+		{true, ""},
+		{false, "AlreadyExists"},
+		{false, reasonInvalid},
+	} {
+		r := results[i]
+		if r.Name != names[i] || r.Available != c.available || r.Reason != c.reason {
+			t.Errorf("%s: expected available=%v reason '%s', got %+v", names[i], c.available, c.reason, r)
+		}
+		if !r.Available && len(r.Suggestions) != 2 {
+			t.Errorf("%s: expected two suggestions, got %v", names[i], r.Suggestions)
+		}
+		for _, s := range r.Suggestions {
+			if err := helpers.StorageAccountName.Validate(s); err != nil || s == "samplestaken" {
+				t.Errorf("%s: unusable suggestion '%s'", names[i], s)
+			}
+		}
+	}
+	if r := results[0]; len(r.Suggestions) != 0 {
+		t.Errorf("unexpected suggestions for an available name: %v", r.Suggestions)
+	}
+
+	// The invalid name is rejected locally; only its suggestions are checked by ARM.
+	for _, r := range srv.Requests() {
+		if r.Body["name"] == "Samples-Invalid" {
+			t.Errorf("the invalid name was sent to ARM")
+		}
+	}
+}
+
+func TestCheckNamesFailure(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	srv.Fail("POST", "checkNameAvailability", 500, "InternalServerError", "Something went wrong.", false)
+
+	results := checkNames(storageChecker{helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)}, []string{"samplesfree", "x"}, 1, 1)
+	if r := results[0]; r.Reason != reasonCheckFailed || r.Message == "" || len(r.Suggestions) != 0 {
+		t.Errorf("expected the check to fail, got %+v", r)
+	}
+	if r := results[1]; r.Reason != reasonInvalid {
+		t.Errorf("expected the short name to be rejected locally, got %+v", r)
+	}
+}
+
+func TestCheckNamesParallelism(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+
+	var mu sync.Mutex
+	inFlight, peak := 0, 0
+
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+	client.RequestInspector = helpers.ChainPreparers(client.RequestInspector, func(p autorest.Preparer) autorest.Preparer {
+		return autorest.PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			mu.Lock()
+			inFlight++
+			if inFlight > peak {
+				peak = inFlight
+			}
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			return p.Prepare(r)
+		})
+	})
+	client.ResponseInspector = func(r autorest.Responder) autorest.Responder {
+		return autorest.ResponderFunc(func(resp *http.Response) error {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+			return r.Respond(resp)
+		})
+	}
+
+	var names []string
+	for i := 0; i < 12; i++ {
+		names = append(names, "samplesname"+string('a'+byte(i)))
+	}
+	for _, r := range checkNames(storageChecker{client}, names, 3, 0) {
+		if !r.Available {
+			t.Errorf("%s: expected the name to be available, got %+v", r.Name, r)
+		}
+	}
+	if peak > 3 {
+		t.Errorf("expected at most 3 concurrent requests, got %d", peak)
+	}
+	if peak < 2 {
+		t.Errorf("expected the names to be checked concurrently, got at most %d request at a time", peak)
+	}
+}
@@ -1,8 +1,9 @@
 package main
 
 import (
-	"fmt"
+	"flag"
 	"log"
+	"os"
 
 	"github.com/Azure/azure-sdk-for-go/Godeps/_workspace/src/github.com/Azure/go-autorest/autorest/azure"
 	"github.com/Azure/azure-sdk-for-go/Godeps/_workspace/src/github.com/Azure/go-autorest/autorest/to"
@@ -14,7 +15,28 @@ import (
 
 func main() {
 	
-	name := "storage-account-name"
+	resourceType := flag.String("type", typeStorage, "type of the names to check: storage, dnslabel or webapp")
+	location := flag.String("location", "", "location of the DNS labels to check, e.g. 'westus'")
+	fileName := flag.String("file", "", "read the names to check from a file, one per line; '-' reads standard input")
+	parallel := flag.Int("parallel", 4, "maximum number of names checked at the same time")
+	suggest := flag.Int("suggest", 0, "number of available alternatives to suggest for each name that can't be used")
+
+	output := helpers.OutputFlags(nil)
+	flag.Lookup("output").DefValue = helpers.FormatTable
+	output.Format = helpers.FormatTable
+	flag.Usage = func() {
+		helpers.Logf("Usage: %s [flags] [name ...]\n\nNames are read from standard input when none are passed.\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	
+	names, err := readNames(flag.Args(), *fileName, os.Stdin)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if len(names) == 0 {
+		log.Fatalf("Error: No names to check")
+	}
 	
 	c, err := helpers.LoadCredentials()
 	if err != nil {
@@ -35,20 +57,40 @@ func main() {
 	arm.RequestInspector = helpers.WithInspection()
 	arm.ResponseInspector = helpers.ByInspecting()
 
-	ac := arm.StorageAccounts()
-
-	cna, err := ac.CheckNameAvailability(
-		storage.AccountCheckNameAvailabilityParameters {
-			Name: to.StringPtr(name),
-			Type: to.StringPtr("Microsoft.Storage/storageAccounts")})
-
+	checker, err := newNameChecker(arm, *resourceType, *location)
 	if err != nil {
 		log.Fatalf("Error: %v", err)
-	} else {
-		if to.Bool(cna.NameAvailable) {
-			fmt.Printf("The name '%s' is available\n", name)
-		} else {
-			fmt.Printf("The name '%s' is unavailable because %s\n", name, to.String(cna.Message))
+	}
+
+	results := checkNames(checker, names, *parallel, *suggest)
+
+	available, failed := 0, 0
+	for _, r := range results {
+		if r.Available {
+			available++
 		}
+		if r.Reason == reasonCheckFailed {
+			failed++
+		}
+	}
+	helpers.Logf("%d of %d names are available\n", available, len(results))
+
+	if err := output.Print(results); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if failed > 0 {
+		log.Fatalf("Error: Unable to check %d of the names", failed)
 	}
+}
+
+// checkName asks ARM whether a storage account name is available. Storage account names are
+// global, so the answer depends on all accounts, not just those of the subscription.
+func checkName(client arm.Client, name string) (storage.CheckNameAvailabilityResult, error) {
+	
+	ac := client.StorageAccounts()
+
+	return ac.CheckNameAvailability(
+		storage.AccountCheckNameAvailabilityParameters {
+			Name: to.StringPtr(name),
+			Type: to.StringPtr("Microsoft.Storage/storageAccounts")})
 }
\ No newline at end of file
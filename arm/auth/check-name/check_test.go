@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-go-samples/fakes/armfake"
+	"github.com/Azure/azure-go-samples/helpers"
+	"github.com/Azure/azure-sdk-for-go/Godeps/_workspace/src/github.com/Azure/go-autorest/autorest/to"
+	"github.com/Azure/azure-sdk-for-go/arm/storage"
+)
+
+func TestCheckNameAvailable(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+
+	cna, err := checkName(helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL), "samplesfreename")
+	if err != nil {
+		t.Fatalf("checkName failed: %v", err)
+	}
+	if !to.Bool(cna.NameAvailable) {
+		t.Errorf("expected the name to be available, got reason '%s'", cna.Reason)
+	}
+
+	reqs := srv.Requests()
+	if len(reqs) != 1 {
+		t.Fatalf("expected a single request, got %d", len(reqs))
+	}
+	r := reqs[0]
+	if r.Method != "POST" || !strings.HasSuffix(strings.ToLower(r.Path), "/providers/microsoft.storage/checknameavailability") {
+		t.Errorf("unexpected request %s %s", r.Method, r.Path)
+	}
+	if r.Body["name"] != "samplesfreename" || r.Body["type"] != "Microsoft.Storage/storageAccounts" {
+		t.Errorf("unexpected request body %v", r.Body)
+	}
+}
+
+func TestCheckNameUnavailable(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	srv.TakeStorageAccountName("samplestaken")
+
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+
+	cases := []struct {
+		name   string
+		reason storage.Reason
+	}{
+		{"samplestaken", storage.AlreadyExists},
+		{"storage-account-name", storage.AccountNameInvalid},
+	}
+	for _, c := range cases {
+		cna, err := checkName(client, c.name)
+		if err != nil {
+			t.Fatalf("checkName(%s) failed: %v", c.name, err)
+		}
+		if to.Bool(cna.NameAvailable) || cna.Reason != c.reason {
+			t.Errorf("checkName(%s): expected reason '%s', got available=%v reason '%s'", c.name, c.reason, to.Bool(cna.NameAvailable), cna.Reason)
+		}
+		if to.String(cna.Message) == "" {
+			t.Errorf("checkName(%s): expected a message", c.name)
+		}
+	}
+}
+
+func TestCheckNameError(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	srv.Fail("POST", "checkNameAvailability", 500, "InternalServerError", "Something went wrong.", false)
+
+	if _, err := checkName(helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL), "samplesfreename"); err == nil {
+		t.Errorf("expected checkName to fail")
+	}
+}
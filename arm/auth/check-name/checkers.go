@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-go-samples/helpers"
+	"github.com/Azure/azure-sdk-for-go/Godeps/_workspace/src/github.com/Azure/go-autorest/autorest/to"
+	"github.com/Azure/azure-sdk-for-go/arm"
+	"github.com/Azure/azure-sdk-for-go/arm/network"
+	"github.com/Azure/azure-sdk-for-go/arm/web"
+)
+
+// The resource types whose names can be checked, as passed to --type.
+const (
+	typeStorage  = "storage"
+	typeDNSLabel = "dnslabel"
+	typeWebApp   = "webapp"
+)
+
+// availability is the answer to a name check, in the same terms for all resource types.
+type availability struct {
+	Available bool
+	Reason    string
+	Message   string
+}
+
+// nameChecker checks the availability of the names of one resource type. Names are validated
+// against Rule before they are passed to Check, which asks ARM.
+type nameChecker interface {
+	Rule() helpers.NameRule
+	Check(name string) (availability, error)
+}
+
+// newNameChecker returns the checker for the passed resource type. DNS labels are only unique
+// within a location, so the location is required for them and ignored for the other types.
+func newNameChecker(client arm.Client, resourceType, location string) (nameChecker, error) {
+
+	// The arm.Client has no accessor for the provider-level operations of the network and web
+	// providers, so their clients borrow the authorizer and inspectors of the storage client.
+	sc := client.StorageAccounts()
+
+	switch strings.ToLower(resourceType) {
+	case typeStorage:
+		return storageChecker{client: client}, nil
+	case typeDNSLabel:
+		if location == "" {
+			return nil, fmt.Errorf("A location is required to check DNS labels")
+		}
+		nc := network.New(sc.SubscriptionID)
+		nc.Client = sc.Client
+		return dnsLabelChecker{location: location, check: nc.CheckDNSNameAvailability}, nil
+	case typeWebApp:
+		wc := web.NewGlobalSiteManagementClient(sc.SubscriptionID)
+		wc.Client = sc.Client
+		return webAppChecker{check: wc.CheckNameAvailability}, nil
+	}
+	return nil, fmt.Errorf("Unknown resource type '%s', use %s, %s or %s", resourceType, typeStorage, typeDNSLabel, typeWebApp)
+}
+
+// storageChecker checks storage account names, which are global.
+type storageChecker struct {
+	client arm.Client
+}
+
+func (c storageChecker) Rule() helpers.NameRule {
+	return helpers.StorageAccountName
+}
+
+func (c storageChecker) Check(name string) (availability, error) {
+	cna, err := checkName(c.client, name)
+	if err != nil {
+		return availability{}, err
+	}
+	return availability{Available: to.Bool(cna.NameAvailable), Reason: string(cna.Reason), Message: to.String(cna.Message)}, nil
+}
+
+// dnsLabelChecker checks the DNS labels of public IP addresses, {label}.{location}.cloudapp.azure.com.
+// The service only answers yes or no, so the reason and message are made up here.
+type dnsLabelChecker struct {
+	location string
+	check    func(location, label string) (network.DNSNameAvailabilityResult, error)
+}
+
+func (c dnsLabelChecker) Rule() helpers.NameRule {
+	return helpers.DNSLabel
+}
+
+func (c dnsLabelChecker) Check(name string) (availability, error) {
+	result, err := c.check(c.location, name)
+	if err != nil {
+		return availability{}, err
+	}
+	if to.Bool(result.Available) {
+		return availability{Available: true}, nil
+	}
+	return availability{
+		Reason:  "AlreadyExists",
+		Message: fmt.Sprintf("The DNS label '%s' is already used in %s", name, c.location)}, nil
+}
+
+// webAppChecker checks web app names, which become host names in azurewebsites.net.
+type webAppChecker struct {
+	check func(web.ResourceNameAvailabilityRequest) (web.ResourceNameAvailability, error)
+}
+
+func (c webAppChecker) Rule() helpers.NameRule {
+	return helpers.WebAppName
+}
+
+func (c webAppChecker) Check(name string) (availability, error) {
+	result, err := c.check(web.ResourceNameAvailabilityRequest{
+		Name: to.StringPtr(name),
+		Type: to.StringPtr("Site")})
+	if err != nil {
+		return availability{}, err
+	}
+	return availability{Available: to.Bool(result.NameAvailable), Reason: to.String(result.Reason), Message: to.String(result.Message)}, nil
+}
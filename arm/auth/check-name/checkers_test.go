@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-go-samples/fakes/armfake"
+	"github.com/Azure/azure-go-samples/helpers"
+)
+
+func TestDNSLabelChecker(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	srv.TakeDNSLabel("westus", "samples-taken")
+
+	checker, err := newNameChecker(helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL), typeDNSLabel, "westus")
+	if err != nil {
+		t.Fatalf("newNameChecker failed: %v", err)
+	}
+
+	results := checkNames(checker, []string{"samples-free", "samples-taken", "1samples"}, 2, 1)
+	if r := results[0]; !r.Available {
+		t.Errorf("expected 'samples-free' to be available, got %+v", r)
+	}
+	if r := results[1]; r.Available || r.Reason != "AlreadyExists" || !strings.Contains(r.Message, "westus") || len(r.Suggestions) != 1 {
+		t.Errorf("expected 'samples-taken' to be taken, with a suggestion, got %+v", r)
+	}
+	if r := results[2]; r.Reason != reasonInvalid {
+		t.Errorf("expected '1samples' to be rejected locally, got %+v", r)
+	}
+
+	// DNS labels are unique per location.
+	for _, r := range srv.Requests() {
+		if strings.Contains(r.Query, "domainNameLabel=samples-taken") && !strings.HasSuffix(strings.ToLower(r.Path), "/locations/westus/checkdnsnameavailability") {
+			t.Errorf("unexpected request %s %s?%s", r.Method, r.Path, r.Query)
+		}
+	}
+}
+
+func TestWebAppChecker(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	srv.TakeSiteName("SamplesTaken")
+
+	checker, err := newNameChecker(helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL), typeWebApp, "")
+	if err != nil {
+		t.Fatalf("newNameChecker failed: %v", err)
+	}
+
+	results := checkNames(checker, []string{"SamplesFree", "samplestaken", "-samples"}, 2, 0)
+	if r := results[0]; !r.Available {
+		t.Errorf("expected 'SamplesFree' to be available, got %+v", r)
+	}
+	if r := results[1]; r.Available || r.Reason != "AlreadyExists" || r.Message == "" {
+		t.Errorf("expected 'samplestaken' to be taken, got %+v", r)
+	}
+	if r := results[2]; r.Reason != reasonInvalid {
+		t.Errorf("expected '-samples' to be rejected locally, got %+v", r)
+	}
+
+	reqs := srv.Requests()
+	if len(reqs) != 2 {
+		t.Fatalf("expected two requests, got %d", len(reqs))
+	}
+	for _, r := range reqs {
+		if r.Method != "POST" || !strings.HasSuffix(strings.ToLower(r.Path), "/providers/microsoft.web/checknameavailability") || r.Body["type"] != "Site" {
+			t.Errorf("unexpected request %s %s %v", r.Method, r.Path, r.Body)
+		}
+	}
+}
+
+func TestNewNameCheckerErrors(t *testing.T) {
+	client := helpers.ARMClientForEndpoint(armfake.DefaultSubscriptionID, "http://localhost")
+
+	if _, err := newNameChecker(client, typeDNSLabel, ""); err == nil {
+		t.Errorf("expected a location to be required for DNS labels")
+	}
+	if _, err := newNameChecker(client, "vault", ""); err == nil || !strings.Contains(err.Error(), "Unknown resource type 'vault'") {
+		t.Errorf("expected an unknown type to be rejected, got %v", err)
+	}
+}
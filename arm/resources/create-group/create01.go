@@ -1,8 +1,10 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 
+	"github.com/Azure/azure-sdk-for-go/arm"
 	"github.com/Azure/azure-sdk-for-go/arm/resources/resources"
 	"github.com/Azure/azure-go-samples/helpers"
 )
@@ -12,24 +14,63 @@ func main() {
 	groupName := "armtestgroup"
 	groupLocation := "West US"
 
+	lock := flag.String("lock", "", "lock the group once created, with level CanNotDelete or ReadOnly")
+	output := helpers.OutputFlags(nil)
+	flag.Parse()
+
+	if *lock != "" {
+		if _,err := helpers.ValidateLockLevel(*lock); err != nil {
+			helpers.Logf("%s\n", err.Error())
+			return
+		}
+	}
+
 	arm,err := helpers.AuthenticateForARM()
 	if err != nil {
-		fmt.Printf("Failed to authenticate: '%s'\n", err.Error())
+		helpers.Logf("Failed to authenticate: '%s'\n", err.Error())
 		return
 	}
 	
 	arm.RequestInspector = helpers.WithInspection()
 	arm.ResponseInspector = helpers.ByInspecting()
 		
-	rgc := arm.ResourceGroups()
+	group,err := createGroup(arm, groupName, groupLocation)
+	if err != nil {
+		helpers.Logf("%s", err.Error())
+		return
+	}
+
+	if *lock != "" {
+		if _,err := helpers.LockGroup(arm, groupName, *lock); err != nil {
+			helpers.Logf("%s\n", err.Error())
+			return
+		}
+	}
+
+	if err := output.Print(group); err != nil {
+		helpers.Logf("%s\n", err.Error())
+	}
+}
+
+// createGroup creates a resource group, or updates it if it already exists. The location is
+// checked against those of the subscription first, so that a typo fails before anything is created.
+func createGroup(client arm.Client, name, location string) (group resources.ResourceGroup, err error) {
+	
+	if _,err = helpers.ValidateLocation(client, location, "Microsoft.Resources/resourceGroups"); err != nil {
+		return
+	}
+
+	rgc := client.ResourceGroups()
 	
-	params := resources.ResourceGroup{Name:&groupName,Location:&groupLocation}
+	params := resources.ResourceGroup{Name:&name,Location:&location}
 	
-	group,err := rgc.CreateOrUpdate(groupName, params)
+	group,err = rgc.CreateOrUpdate(name, params)
 	if err != nil {
-		fmt.Printf("Failed to create resource group '%s' in location '%s': '%s'\n", groupName, groupLocation, err.Error())
+		err = fmt.Errorf("Failed to create resource group '%s' in location '%s': '%s'\n", name, location, err.Error())
 		return
 	}
 	
-	fmt.Printf("Created resource group '%s'\n", *group.Name)
+	helpers.Logf("Created resource group '%s'\n", *group.Name)
+	
+	return
 }
\ No newline at end of file
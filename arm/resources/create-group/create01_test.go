@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-go-samples/fakes/armfake"
+	"github.com/Azure/azure-go-samples/helpers"
+)
+
+func TestCreateGroup(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+
+	group, err := createGroup(helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL), "armtestgroup", "West US")
+	if err != nil {
+		t.Fatalf("createGroup failed: %v", err)
+	}
+	if *group.Name != "armtestgroup" || *group.Location != "westus" {
+		t.Errorf("unexpected group %s in %s", *group.Name, *group.Location)
+	}
+
+	// The location is looked up before the group is created, with reads only.
+	var puts []armfake.Request
+	for _, r := range srv.Requests() {
+		if r.Method != "GET" {
+			puts = append(puts, r)
+		}
+	}
+	if len(puts) != 1 {
+		t.Fatalf("expected a single request creating the group, got %d", len(puts))
+	}
+	r := puts[0]
+	if r.Method != "PUT" || !strings.HasSuffix(strings.ToLower(r.Path), "/resourcegroups/armtestgroup") {
+		t.Errorf("unexpected request %s %s", r.Method, r.Path)
+	}
+	if r.Body["location"] != "West US" {
+		t.Errorf("unexpected request body %v", r.Body)
+	}
+
+	if _, ok := srv.Resource("/subscriptions/" + srv.SubscriptionID + "/resourceGroups/armtestgroup"); !ok {
+		t.Errorf("the group was not created")
+	}
+}
+
+func TestCreateGroupTwice(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+
+	if _, err := createGroup(client, "armtestgroup", "West US"); err != nil {
+		t.Fatalf("createGroup failed: %v", err)
+	}
+
+	// Creating the group again in the same location is an update, in another one an error.
+	if _, err := createGroup(client, "armtestgroup", "West US"); err != nil {
+		t.Errorf("updating the group failed: %v", err)
+	}
+	_, err := createGroup(client, "armtestgroup", "East US")
+	if err == nil || !strings.Contains(err.Error(), "Failed to create resource group 'armtestgroup' in location 'East US'") {
+		t.Errorf("expected moving the group to fail, got %v", err)
+	}
+}
+
+func TestCreateGroupInvalidLocation(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+
+	_, err := createGroup(helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL), "armtestgroup", "Atlantis")
+	if err == nil || !strings.Contains(err.Error(), "Unknown location 'Atlantis'") || !strings.Contains(err.Error(), "'West US'") {
+		t.Errorf("expected the location to be rejected, got %v", err)
+	}
+	for _, r := range srv.Requests() {
+		if r.Method != "GET" {
+			t.Errorf("unexpected request %s %s", r.Method, r.Path)
+		}
+	}
+}
+
+func TestCreateGroupNormalizesLocation(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+
+	group, err := createGroup(helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL), "armtestgroup", "westus")
+	if err != nil {
+		t.Fatalf("createGroup failed: %v", err)
+	}
+	if *group.Location != "westus" {
+		t.Errorf("unexpected location '%s'", *group.Location)
+	}
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"strings"
 
 	"github.com/Azure/azure-go-samples/helpers"
 	"github.com/Azure/azure-sdk-for-go/Godeps/_workspace/src/github.com/Azure/go-autorest/autorest/to"
@@ -17,51 +19,169 @@ func main() {
 	groupName := "createvm01"
 	groupLocation := "West US"
 
-	var group resources.ResourceGroup
-	var subnet network.Subnet
-	var nic network.Interface
-	var avset compute.AvailabilitySet
+	specFile := flag.String("spec", "", "provision the resources described by a YAML or JSON `file` instead of the sample's virtual machine, see specs/createvm01.yaml")
+	count := flag.Int("count", 0, "provision this many copies of the virtual machine, each with its own network interface, public IP address and disk")
+	pattern := flag.String("name-pattern", "vm%03d", "the `pattern` the copies made with --count are named by, given the numbers from 1")
+	osName := flag.String("os", "", "run Windows or Linux on the sample's virtual machine, which runs Windows by default")
+	sshKey := flag.String("ssh-key", "", "log in to a Linux virtual machine with the SSH public key in `file`, instead of a generated one")
+	cloudInit := flag.String("cloud-init", "", "pass the cloud-init `file` to a Linux virtual machine")
+	callerIP := flag.String("caller-ip", "", "let the `address` in where security rules allow the caller, instead of looking up the public IP address of this machine")
+	planOnly := flag.Bool("plan", false, "only print the changes provisioning would make, without making them")
+	lock := flag.String("lock", "", "lock the resource group once the virtual machine is provisioned, with level CanNotDelete or ReadOnly")
+	parallel := flag.Int("parallel", 4, "the most resources to create or update at the same time, 0 for no limit")
+	rollback := flag.Bool("rollback", false, "delete the resources created by this run when provisioning fails or is interrupted with Ctrl-C")
+	showRules := flag.Bool("rules", false, "print the security rules filtering the traffic of the virtual machines once provisioned, instead of the virtual machines")
+	output := helpers.OutputFlags(nil)
+	flag.Parse()
+
+	if *lock != "" {
+		if _, err := helpers.ValidateLockLevel(*lock); err != nil {
+			helpers.Logf("%s\n", err.Error())
+			return
+		}
+	}
+
+	spec := defaultSpec(groupName, groupLocation, "vm001", "admin", "foobar1234")
+	if *specFile != "" {
+		if *osName != "" || *sshKey != "" || *cloudInit != "" {
+			helpers.Logf("ERROR: With --spec, set the os, sshPublicKeyFile and cloudInit of the virtual machines in the spec\n")
+			return
+		}
+		var err error
+		if spec, err = readSpec(*specFile); err != nil {
+			helpers.Logf("%s\n", err.Error())
+			return
+		}
+	} else if *osName != "" || *sshKey != "" || *cloudInit != "" {
+		if err := spec.withOS(*osName, *sshKey, *cloudInit); err != nil {
+			helpers.Logf("%s\n", err.Error())
+			return
+		}
+	}
+	if *callerIP != "" {
+		spec.CallerIP = *callerIP
+		if err := spec.validate(); err != nil {
+			helpers.Logf("ERROR: --caller-ip is not valid:\n%v\n", err)
+			return
+		}
+	}
+	if *count != 0 {
+		if err := spec.withCount(*count, *pattern); err != nil {
+			helpers.Logf("%s\n", err.Error())
+			return
+		}
+	}
 
 	client, err := helpers.AuthenticateForARM()
 	if err != nil {
-		fmt.Printf("Failed to authenticate: '%s'\n", err.Error())
+		helpers.Logf("Failed to authenticate: '%s'\n", err.Error())
 		return
 	}
 
 	client.RequestInspector = helpers.WithInspection()
 	client.ResponseInspector = helpers.ByInspecting()
 
-	group, err = createResourceGroup(groupName, groupLocation, client)
-
+	p, vms, err := provisionSpec(spec, provisionOptions{planOnly: *planOnly, parallel: *parallel, rollback: *rollback, lock: *lock}, client)
 	if err != nil {
-		fmt.Printf("ERROR:'%s'\n", err.Error())
 		return
 	}
-
-	if err := createStorageAccount(group, client); err != nil {
-		fmt.Printf("ERROR: '%s'\n", err.Error())
+	if *planOnly {
+		if err := output.Print(p); err != nil {
+			helpers.Logf("ERROR: '%s'\n", err.Error())
+		}
 		return
 	}
 
-	if avset, err = createAvailabilitySet(group, client); err != nil {
-		fmt.Printf("ERROR: '%s'\n", err.Error())
-		return
+	// A single virtual machine is printed on its own, as the sample always did.
+	var result interface{} = vms
+	if len(vms) == 1 {
+		result = vms[0]
+	}
+	if *showRules {
+		if result, err = effectiveRules(spec, client); err != nil {
+			helpers.Logf("ERROR: '%s'\n", err.Error())
+			return
+		}
+	}
+	if err := output.Print(result); err != nil {
+		helpers.Logf("ERROR: '%s'\n", err.Error())
 	}
+}
 
-	if subnet, err = createNetwork(group, client); err != nil {
-		fmt.Printf("ERROR: '%s'\n", err.Error())
-		return
+// vmResourceTypes are the resource types the sample creates, which must all be available in the
+// location of the virtual machine.
+var vmResourceTypes = []string{
+	"Microsoft.Resources/resourceGroups",
+	"Microsoft.Storage/storageAccounts",
+	"Microsoft.Compute/availabilitySets",
+	"Microsoft.Network/virtualNetworks",
+	"Microsoft.Network/networkSecurityGroups",
+	"Microsoft.Network/loadBalancers",
+	"Microsoft.Network/publicIPAddresses",
+	"Microsoft.Network/networkInterfaces",
+	"Microsoft.Compute/virtualMachines",
+}
+
+// provisionOptions are the flags of the sample that change how a spec is provisioned.
+type provisionOptions struct {
+	planOnly bool   // only plan the changes, without making them
+	parallel int    // the most steps to take at the same time, 0 for no limit
+	rollback bool   // delete the resources created by the run when it fails or is interrupted
+	lock     string // the level of the lock taken on the resource group once provisioned, if any
+}
+
+// provisionSpec plans the changes a spec makes, which validates the location of its resources
+// before anything is created, then logs and applies them, and logs the status of each virtual
+// machine. When a step fails, the resources created so far are deleted with the rollback option,
+// which also stops provisioning when the user presses Ctrl-C, and left in place otherwise. The
+// resource group is only locked once everything is provisioned: a ReadOnly lock would make the
+// remaining steps fail.
+//
+// Failures are logged as they happen, and the first is returned. With the planOnly option, the plan
+// is returned without being logged or applied. Running it again with the same spec changes nothing.
+func provisionSpec(spec *vmSpec, opts provisionOptions, client arm.Client) (*provisioningPlan, []compute.VirtualMachine, error) {
+
+	p, err := planProvisioning(spec, client)
+	if err != nil {
+		helpers.Logf("ERROR: '%s'\n", err.Error())
+		return nil, nil, err
+	}
+	if opts.planOnly {
+		return p, nil, nil
 	}
+	p.log()
 
-	if nic, err = createNetworkInterface("01", group, subnet, client); err != nil {
-		fmt.Printf("ERROR: '%s'\n", err.Error())
-		return
+	tx := newTransaction(spec.ResourceGroup.Name)
+	if opts.rollback {
+		defer tx.interruptOnSignal()()
 	}
 
-	if err := createVirtualMachine(group, "vm001", "admin", "foobar1234", avset, nic, client); err != nil {
-		fmt.Printf("ERROR: '%s'\n", err.Error())
-		return
+	vms, err := applyPlan(p, tx, opts.parallel, client)
+	for _, s := range vmStatuses(p, err) {
+		helpers.Logf("%-9s virtual machine '%s'\n", s.Status, s.Name)
+		if s.Error != "" {
+			helpers.Logf("    %s\n", s.Error)
+		}
+	}
+	if err != nil {
+		helpers.Logf("ERROR: '%s'\n", err.Error())
+		if opts.rollback {
+			if _, err := tx.rollback(client); err != nil {
+				helpers.Logf("ERROR: '%s'\n", err.Error())
+			}
+		} else if len(tx.created) > 0 {
+			helpers.Logf("The resources created so far are left in resource group '%s': run the sample again to finish, or with --rollback to delete them on failure\n", spec.ResourceGroup.Name)
+		}
+		return p, nil, err
 	}
+
+	if opts.lock != "" {
+		if _, err := helpers.LockGroup(client, spec.ResourceGroup.Name, opts.lock); err != nil {
+			helpers.Logf("%s\n", err.Error())
+			return p, nil, err
+		}
+	}
+	return p, vms, nil
 }
 
 func createResourceGroup(
@@ -69,7 +189,6 @@ func createResourceGroup(
 	arm arm.Client) (group resources.ResourceGroup, err error) {
 
 	rgc := arm.ResourceGroups()
-	rpc := arm.Providers()
 
 	params := resources.ResourceGroup{Name: &name, Location: &location}
 
@@ -79,210 +198,453 @@ func createResourceGroup(
 		return
 	}
 
-	fmt.Printf("Created resource group '%s'\n", *group.Name)
-
-	if _, err1 := rpc.Register("Microsoft.Storage"); err != nil {
-		err = fmt.Errorf("Failed to register resource provider 'Microsoft.Storage': '%s'\n", err1.Error())
-	}
-	if _, err1 := rpc.Register("Microsoft.Network"); err != nil {
-		err = fmt.Errorf("Failed to register resource provider 'Microsoft.Network': '%s'\n", err1.Error())
-	}
-	if _, err1 := rpc.Register("Microsoft.Compute"); err != nil {
-		err = fmt.Errorf("Failed to register resource provider 'Microsoft.Compute': '%s'\n", err1.Error())
-	}
+	helpers.Logf("Created resource group '%s'\n", *group.Name)
 
 	return
 }
 
+// vmProviders are the resource providers that must be registered with the subscription before
+// their resources can be created.
+var vmProviders = []string{"Microsoft.Storage", "Microsoft.Network", "Microsoft.Compute"}
+
+func registerProvider(namespace string, arm arm.Client) error {
+	if _, err := arm.Providers().Register(namespace); err != nil {
+		return fmt.Errorf("Failed to register resource provider '%s': '%s'\n", namespace, err.Error())
+	}
+	return nil
+}
+
+// createStorageAccount creates the storage account, or updates it when it exists. A new account
+// needs a name no other account uses, in any subscription.
 func createStorageAccount(
 	group resources.ResourceGroup,
+	spec storageSpec,
+	exists bool,
 	arm arm.Client) error {
 
 	ac := arm.StorageAccounts()
 
-	cna, err := ac.CheckNameAvailability(
-		storage.AccountCheckNameAvailabilityParameters{
-			Name: group.Name,
-			Type: to.StringPtr("Microsoft.Storage/storageAccounts")})
+	name := spec.Name
 
-	if err != nil {
-		return err
+	if !exists {
+		cna, err := ac.CheckNameAvailability(
+			storage.AccountCheckNameAvailabilityParameters{
+				Name: to.StringPtr(name),
+				Type: to.StringPtr("Microsoft.Storage/storageAccounts")})
+
+		if err != nil {
+			return err
+		}
+
+		if !to.Bool(cna.NameAvailable) {
+			return fmt.Errorf("Failed to create storage account '%s' in location '%s': '%s'\n", name, *group.Location, to.String(cna.Message))
+		}
 	}
 
-	if to.Bool(cna.NameAvailable) {
+	_, err := ac.Create(*group.Name, name, storageParams(group.Location, spec))
+	if err != nil {
+		return fmt.Errorf("Failed to create storage account '%s' in location '%s': '%s'\n", name, *group.Location, err.Error())
+	}
 
-		name := *group.Name
-		props := storage.AccountPropertiesCreateParameters{AccountType: storage.StandardLRS}
+	return nil
+}
 
-		_, err = ac.Create(name, name,
-			storage.AccountCreateParameters{
-				Location:   group.Location,
-				Properties: &props,
-			})
+func storageParams(location *string, spec storageSpec) storage.AccountCreateParameters {
+	props := storage.AccountPropertiesCreateParameters{AccountType: storage.AccountType(spec.Type)}
 
-		if err != nil {
-			return fmt.Errorf("Failed to create storage account '%s' in location '%s': '%s'\n", name, *group.Location, err.Error())
-		}
+	return storage.AccountCreateParameters{
+		Location:   location,
+		Properties: &props,
 	}
+}
 
-	return nil
+// storageAccountName derives the storage account name from the group name. Storage account
+// names are global and more restricted than group names, so the name is sanitized and given
+// a suffix that is seeded by the group name, which keeps it the same from one run to the next.
+func storageAccountName(group resources.ResourceGroup) (string, error) {
+	return helpers.NewNameGenerator(helpers.SeedFromString(*group.Name)).Name(helpers.StorageAccountName, *group.Name)
 }
 
 func createAvailabilitySet(
 	group resources.ResourceGroup,
+	spec avsetSpec,
 	arm arm.Client) (result compute.AvailabilitySet, err error) {
 
 	avsc := arm.AvailabilitySets()
 
-	name := *group.Name
-
-	result, err = avsc.CreateOrUpdate(name, name+"avset", compute.AvailabilitySet{Location: group.Location})
+	result, err = avsc.CreateOrUpdate(*group.Name, spec.Name, compute.AvailabilitySet{Location: group.Location})
 	if err != nil {
-		err = fmt.Errorf("Failed to create availability set '%s' in location '%s': '%s'\n", name, *group.Location, err.Error())
+		err = fmt.Errorf("Failed to create availability set '%s' in location '%s': '%s'\n", spec.Name, *group.Location, err.Error())
 		return
 	}
 
 	return result, nil
 }
 
+// createNetwork creates or updates the virtual network with its subnets, each associated with its
+// network security group, if any, and returns the subnets by name.
 func createNetwork(
 	group resources.ResourceGroup,
-	arm arm.Client) (snetResult network.Subnet, err error) {
+	spec vnetSpec,
+	nsgs map[string]*network.SecurityGroup,
+	arm arm.Client) (snetResults map[string]network.Subnet, err error) {
 
 	vnetc := arm.VirtualNetworks()
 	snetc := arm.Subnets()
 
 	name := *group.Name
-	vnet := name + "vnet"
-	subnet := name + "subnet"
+	vnet := spec.Name
+	params := vnetParams(group.Location, spec, nsgs)
 
-	snet := network.Subnet{
-		Name:       &subnet,
-		Properties: &network.SubnetPropertiesFormat{AddressPrefix: to.StringPtr("10.0.0.0/24")}}
-	snets := make([]network.Subnet, 1, 1)
-	snets[0] = snet
+	_, err = vnetc.CreateOrUpdate(name, vnet, params)
+	if err != nil {
+		err = fmt.Errorf("Failed to create virtual network '%s' in location '%s': '%s'\n", vnet, *group.Location, err.Error())
+		return
+	}
+
+	snetResults = make(map[string]network.Subnet)
+	for _, snet := range *params.Properties.Subnets {
+		subnet := *snet.Name
+		if snetResults[subnet], err = snetc.CreateOrUpdate(name, vnet, subnet, snet); err != nil {
+			err = fmt.Errorf("Failed to create subnet '%s' in location '%s': '%s'\n", subnet, *group.Location, err.Error())
+			return
+		}
+	}
+
+	return
+}
+
+func vnetParams(location *string, spec vnetSpec, nsgs map[string]*network.SecurityGroup) network.VirtualNetwork {
+	snets := make([]network.Subnet, len(spec.Subnets))
+	for i, s := range spec.Subnets {
+		snets[i] = network.Subnet{
+			Name:       to.StringPtr(s.Name),
+			Properties: &network.SubnetPropertiesFormat{AddressPrefix: to.StringPtr(s.AddressPrefix)}}
+		if s.NetworkSecurityGroup != "" {
+			snets[i].Properties.NetworkSecurityGroup = nsgs[s.NetworkSecurityGroup]
+		}
+	}
 
-	addrPrefixes := make([]string, 1, 1)
-	addrPrefixes[0] = "10.0.0.0/16"
+	addrPrefixes := append([]string(nil), spec.AddressPrefixes...)
 	address := network.AddressSpace{AddressPrefixes: &addrPrefixes}
 
 	nwkProps := network.VirtualNetworkPropertiesFormat{AddressSpace: &address, Subnets: &snets}
 
-	_, err = vnetc.CreateOrUpdate(name, vnet, network.VirtualNetwork{Location: group.Location, Properties: &nwkProps})
+	return network.VirtualNetwork{Location: location, Properties: &nwkProps}
+}
+
+func createPublicIPAddress(
+	group resources.ResourceGroup,
+	ipName string,
+	arm arm.Client) (pipResult network.PublicIPAddress, err error) {
+
+	pipc := arm.PublicIPAddresses()
+
+	pipResult, err = pipc.CreateOrUpdate(*group.Name, ipName, publicIPParams(group.Location))
 	if err != nil {
-		err = fmt.Errorf("Failed to create virtual network '%s' in location '%s': '%s'\n", vnet, *group.Location, err.Error())
-		return
+		err = fmt.Errorf("Failed to create public ip address '%s' in location '%s': '%s'\n", ipName, *group.Location, err.Error())
+	}
+
+	return
+}
+
+func publicIPParams(location *string) network.PublicIPAddress {
+	return network.PublicIPAddress{
+		Location: location,
+		Properties: &network.PublicIPAddressPropertiesFormat{
+			PublicIPAllocationMethod: network.Dynamic,
+		},
+	}
+}
+
+// createSecurityGroup creates or updates a network security group with its rules, in which the
+// caller source stands for callerIP.
+func createSecurityGroup(
+	group resources.ResourceGroup,
+	spec nsgSpec,
+	callerIP string,
+	arm arm.Client) (nsg network.SecurityGroup, err error) {
+
+	nsgc := arm.NetworkSecurityGroups()
+
+	nsg, err = nsgc.CreateOrUpdate(*group.Name, spec.Name, nsgParams(group.Location, spec, callerIP))
+	if err != nil {
+		err = fmt.Errorf("Failed to create network security group '%s' in location '%s': '%s'\n", spec.Name, *group.Location, err.Error())
+	}
+
+	return
+}
+
+func nsgParams(location *string, spec nsgSpec, callerIP string) network.SecurityGroup {
+	rules := make([]network.SecurityRule, len(spec.Rules))
+	for i, r := range spec.Rules {
+		source := r.SourceAddressPrefix
+		if strings.EqualFold(source, callerSource) {
+			source = callerIP
+		}
+		rules[i] = network.SecurityRule{
+			Name: to.StringPtr(r.Name),
+			Properties: &network.SecurityRulePropertiesFormat{
+				Priority:                 to.IntPtr(r.Priority),
+				Direction:                network.SecurityRuleDirection(r.Direction),
+				Access:                   network.SecurityRuleAccess(r.Access),
+				Protocol:                 network.SecurityRuleProtocol(r.Protocol),
+				SourceAddressPrefix:      to.StringPtr(source),
+				SourcePortRange:          to.StringPtr("*"),
+				DestinationAddressPrefix: to.StringPtr(r.DestinationAddressPrefix),
+				DestinationPortRange:     to.StringPtr(r.DestinationPortRange),
+			},
+		}
+	}
+
+	return network.SecurityGroup{
+		Location:   location,
+		Properties: &network.SecurityGroupPropertiesFormat{SecurityRules: &rules},
 	}
+}
+
+// The names of the frontend IP configuration and of the backend pool of the load balancer.
+const (
+	lbFrontendName = "frontend"
+	lbPoolName     = "backend"
+)
+
+// createLoadBalancer creates or updates the load balancer in front of the virtual machines, which
+// answers at the public IP address. The rules refer to its frontend, backend pool and probes by
+// ID, which is why lbID, the ID the load balancer gets, is needed to create it.
+func createLoadBalancer(
+	group resources.ResourceGroup,
+	spec lbSpec,
+	lbID string,
+	publicIP *network.PublicIPAddress,
+	arm arm.Client) (lb network.LoadBalancer, err error) {
 
-	snetResult, err = snetc.CreateOrUpdate(name, vnet, subnet, snet)
+	lbc := arm.LoadBalancers()
+
+	lb, err = lbc.CreateOrUpdate(*group.Name, spec.Name, lbParams(group.Location, spec, lbID, publicIP))
 	if err != nil {
-		err = fmt.Errorf("Failed to create subnet '%s' in location '%s': '%s'\n", subnet, *group.Location, err.Error())
+		err = fmt.Errorf("Failed to create load balancer '%s' in location '%s': '%s'\n", spec.Name, *group.Location, err.Error())
 	}
 
 	return
 }
 
+func lbParams(location *string, spec lbSpec, lbID string, publicIP *network.PublicIPAddress) network.LoadBalancer {
+	child := func(collection, name string) *network.SubResource {
+		return &network.SubResource{ID: to.StringPtr(lbID + "/" + collection + "/" + name)}
+	}
+
+	frontends := []network.FrontendIPConfiguration{{
+		Name:       to.StringPtr(lbFrontendName),
+		Properties: &network.FrontendIPConfigurationPropertiesFormat{PublicIPAddress: publicIP},
+	}}
+	pools := []network.BackendAddressPool{{Name: to.StringPtr(lbPoolName)}}
+
+	probes := make([]network.Probe, len(spec.Probes))
+	for i, p := range spec.Probes {
+		probes[i] = network.Probe{
+			Name: to.StringPtr(p.Name),
+			Properties: &network.ProbePropertiesFormat{
+				Protocol:          network.ProbeProtocol(p.Protocol),
+				Port:              to.IntPtr(p.Port),
+				IntervalInSeconds: to.IntPtr(p.IntervalInSeconds),
+				NumberOfProbes:    to.IntPtr(p.NumberOfProbes),
+			},
+		}
+		if p.RequestPath != "" {
+			probes[i].Properties.RequestPath = to.StringPtr(p.RequestPath)
+		}
+	}
+
+	rules := make([]network.LoadBalancingRule, len(spec.Rules))
+	for i, r := range spec.Rules {
+		rules[i] = network.LoadBalancingRule{
+			Name: to.StringPtr(r.Name),
+			Properties: &network.LoadBalancingRulePropertiesFormat{
+				FrontendIPConfiguration: child("frontendIPConfigurations", lbFrontendName),
+				BackendAddressPool:      child("backendAddressPools", lbPoolName),
+				Protocol:                network.TransportProtocol(r.Protocol),
+				LoadDistribution:        network.LoadDistribution(r.LoadDistribution),
+				FrontendPort:            to.IntPtr(r.FrontendPort),
+				BackendPort:             to.IntPtr(r.BackendPort),
+				IdleTimeoutInMinutes:    to.IntPtr(r.IdleTimeoutInMinutes),
+			},
+		}
+		if r.Probe != "" {
+			rules[i].Properties.Probe = child("probes", r.Probe)
+		}
+	}
+
+	natRules := make([]network.InboundNatRule, len(spec.NatRules))
+	for i, r := range spec.NatRules {
+		natRules[i] = network.InboundNatRule{
+			Name: to.StringPtr(r.Name),
+			Properties: &network.InboundNatRulePropertiesFormat{
+				FrontendIPConfiguration: child("frontendIPConfigurations", lbFrontendName),
+				Protocol:                network.TransportProtocol(r.Protocol),
+				FrontendPort:            to.IntPtr(r.FrontendPort),
+				BackendPort:             to.IntPtr(r.BackendPort),
+			},
+		}
+	}
+
+	return network.LoadBalancer{
+		Location: location,
+		Properties: &network.LoadBalancerPropertiesFormat{
+			FrontendIPConfigurations: &frontends,
+			BackendAddressPools:      &pools,
+			Probes:                   &probes,
+			LoadBalancingRules:       &rules,
+			InboundNatRules:          &natRules,
+		},
+	}
+}
+
+// lbBackend refers a network interface to the backend pool of the load balancer, and to the NAT
+// rules forwarding ports to it, by ID. The zero value is for network interfaces that aren't behind
+// the load balancer.
+type lbBackend struct {
+	pools    *[]network.BackendAddressPool
+	natRules *[]network.InboundNatRule
+}
+
+func lbBackendOf(spec *vmSpec, lbID, nic string) lbBackend {
+	if !spec.behindLoadBalancer(nic) {
+		return lbBackend{}
+	}
+	pools := []network.BackendAddressPool{{ID: to.StringPtr(lbID + "/backendAddressPools/" + lbPoolName)}}
+	backend := lbBackend{pools: &pools}
+
+	var natRules []network.InboundNatRule
+	for _, r := range spec.LoadBalancer.NatRules {
+		if r.NetworkInterface == nic {
+			natRules = append(natRules, network.InboundNatRule{ID: to.StringPtr(lbID + "/inboundNatRules/" + r.Name)})
+		}
+	}
+	if len(natRules) > 0 {
+		backend.natRules = &natRules
+	}
+	return backend
+}
+
+// createNetworkInterface creates or updates a network interface in a subnet, reachable at the
+// public IP address if there is one, and through the load balancer if it is behind it, through
+// its network security group if it has one.
 func createNetworkInterface(
-	suffix string,
 	group resources.ResourceGroup,
+	spec nicSpec,
 	subnet network.Subnet,
+	publicIP *network.PublicIPAddress,
+	nsg *network.SecurityGroup,
+	backend lbBackend,
 	arm arm.Client) (networkInterface network.Interface, err error) {
 
-	pipc := arm.PublicIPAddresses()
 	nicc := arm.NetworkInterfaces()
 
-	groupName := *group.Name
-	ipName := "ip" + suffix
-	nicName := "nic" + suffix
-
-	pipResult, err := pipc.CreateOrUpdate(
-		groupName,
-		ipName,
-		network.PublicIPAddress{
-			Location: group.Location,
-			Properties: &network.PublicIPAddressPropertiesFormat{
-				PublicIPAllocationMethod: network.Dynamic,
-			},
-		})
-
+	networkInterface, err = nicc.CreateOrUpdate(*group.Name, spec.Name, nicParams(group.Location, spec, subnet, publicIP, nsg, backend))
 	if err != nil {
-		err = fmt.Errorf("Failed to create public ip address '%s' in location '%s': '%s'\n", ipName, *group.Location, err.Error())
-		return
+		err = fmt.Errorf("Failed to create network interface '%s' in location '%s': '%s'\n", spec.Name, *group.Location, err.Error())
 	}
 
+	return
+}
+
+func nicParams(location *string, spec nicSpec, subnet network.Subnet, publicIP *network.PublicIPAddress, nsg *network.SecurityGroup, backend lbBackend) network.Interface {
 	nicProps := network.InterfaceIPConfigurationPropertiesFormat{
-		PublicIPAddress: &pipResult,
-		Subnet:          &subnet}
+		PublicIPAddress:                 publicIP,
+		Subnet:                          &subnet,
+		LoadBalancerBackendAddressPools: backend.pools,
+		LoadBalancerInboundNatRules:     backend.natRules}
 
 	ipConfigs := make([]network.InterfaceIPConfiguration, 1, 1)
 	ipConfigs[0] = network.InterfaceIPConfiguration{
-		Name:       to.StringPtr(nicName + "Config"),
+		Name:       to.StringPtr(spec.Name + "Config"),
 		Properties: &nicProps,
 	}
-	props := network.InterfacePropertiesFormat{IPConfigurations: &ipConfigs}
+	props := network.InterfacePropertiesFormat{IPConfigurations: &ipConfigs, NetworkSecurityGroup: nsg}
 
-	networkInterface, err = nicc.CreateOrUpdate(
-		groupName,
-		nicName,
-		network.Interface{
-			Location:   group.Location,
-			Properties: &props,
-		})
-	if err != nil {
-		err = fmt.Errorf("Failed to create network interface '%s' in location '%s': '%s'\n", nicName, *group.Location, err.Error())
+	return network.Interface{
+		Location:   location,
+		Properties: &props,
 	}
-
-	return
 }
 
+// createVirtualMachine creates or updates a virtual machine in the availability set, with its OS
+// disk in the storage account.
 func createVirtualMachine(
 	group resources.ResourceGroup,
-	vmName, adminName, adminPassword string,
+	spec machineSpec,
+	accountName string,
 	availSet compute.AvailabilitySet,
 	networkInterface network.Interface,
-	arm arm.Client) error {
+	arm arm.Client) (vm compute.VirtualMachine, err error) {
 
 	vmc := arm.VirtualMachines()
 
-	netRefs := make([]compute.NetworkInterfaceReference, 1, 1)
-	netRefs[0] = compute.NetworkInterfaceReference{ID: networkInterface.ID}
+	vm, err = vmc.CreateOrUpdate(*group.Name, spec.Name, vmParams(group.Location, spec, accountName, availSet.ID, networkInterface.ID))
+	if err != nil {
+		err = fmt.Errorf("Failed to create virtual machine '%s' in location '%s': '%s'\n", spec.Name, *group.Location, err.Error())
+	}
+
+	return
+}
 
-	groupName := *group.Name
-	accountName := groupName
+func vmParams(location *string, spec machineSpec, accountName string, availSetID, networkInterfaceID *string) compute.VirtualMachine {
+	netRefs := make([]compute.NetworkInterfaceReference, 1, 1)
+	netRefs[0] = compute.NetworkInterfaceReference{ID: networkInterfaceID}
 
-	vmParams := compute.VirtualMachine{
-		Location: group.Location,
+	return compute.VirtualMachine{
+		Location: location,
 		Properties: &compute.VirtualMachineProperties{
-			AvailabilitySet: &compute.SubResource{ID: availSet.ID},
-			HardwareProfile: &compute.HardwareProfile{VMSize: compute.StandardA0},
+			AvailabilitySet: &compute.SubResource{ID: availSetID},
+			HardwareProfile: &compute.HardwareProfile{VMSize: compute.VirtualMachineSizeTypes(spec.Size)},
 			NetworkProfile:  &compute.NetworkProfile{NetworkInterfaces: &netRefs},
 			StorageProfile: &compute.StorageProfile{
 				ImageReference: &compute.ImageReference{
-					Publisher: to.StringPtr("MicrosoftWindowsServer"),
-					Offer:     to.StringPtr("WindowsServer"),
-					Sku:       to.StringPtr("2012-R2-Datacenter"),
-					Version:   to.StringPtr("latest"),
+					Publisher: to.StringPtr(spec.Image.Publisher),
+					Offer:     to.StringPtr(spec.Image.Offer),
+					Sku:       to.StringPtr(spec.Image.Sku),
+					Version:   to.StringPtr(spec.Image.Version),
 				},
 				OsDisk: &compute.OSDisk{
-					Name:         to.StringPtr("mytestod1"),
+					Name:         to.StringPtr(spec.OsDisk),
 					CreateOption: compute.FromImage,
 					Vhd: &compute.VirtualHardDisk{
-						URI: to.StringPtr("http://" + accountName + ".blob.core.windows.net/vhds/mytestod1.vhd"),
+						URI: to.StringPtr("http://" + accountName + ".blob.core.windows.net/vhds/" + spec.OsDisk + ".vhd"),
 					},
 				},
 			},
-			OsProfile: &compute.OSProfile{
-				AdminUsername:        to.StringPtr(adminName),
-				AdminPassword:        to.StringPtr(adminPassword),
-				ComputerName:         to.StringPtr(vmName),
-				WindowsConfiguration: &compute.WindowsConfiguration{ProvisionVMAgent: to.BoolPtr(true)},
-			},
+			OsProfile: osProfile(spec),
 		},
 	}
+}
 
-	if _, err := vmc.CreateOrUpdate(groupName, vmName, vmParams); err != nil {
-		return fmt.Errorf("Failed to create virtual machine '%s' in location '%s': '%s'\n", vmName, *group.Location, err.Error())
+// osProfile sets up the admin of a virtual machine: Windows virtual machines get a password, Linux
+// ones an SSH key, with password authentication disabled, and their cloud-init file if any.
+func osProfile(spec machineSpec) *compute.OSProfile {
+	if spec.OS != linuxOS {
+		return &compute.OSProfile{
+			AdminUsername:        to.StringPtr(spec.AdminUsername),
+			AdminPassword:        to.StringPtr(spec.Password),
+			ComputerName:         to.StringPtr(spec.Name),
+			WindowsConfiguration: &compute.WindowsConfiguration{ProvisionVMAgent: to.BoolPtr(true)},
+		}
 	}
 
-	return nil
+	keys := []compute.SSHPublicKey{{
+		Path:    to.StringPtr("/home/" + spec.AdminUsername + "/.ssh/authorized_keys"),
+		KeyData: to.StringPtr(spec.SSHPublicKey),
+	}}
+	profile := &compute.OSProfile{
+		AdminUsername: to.StringPtr(spec.AdminUsername),
+		ComputerName:  to.StringPtr(spec.Name),
+		LinuxConfiguration: &compute.LinuxConfiguration{
+			DisablePasswordAuthentication: to.BoolPtr(true),
+			SSH:                           &compute.SSHConfiguration{PublicKeys: &keys},
+		},
+	}
+	if spec.CustomData != "" {
+		profile.CustomData = to.StringPtr(spec.CustomData)
+	}
+	return profile
 }
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-go-samples/fakes/armfake"
+	"github.com/Azure/azure-go-samples/helpers"
+	"github.com/Azure/azure-sdk-for-go/Godeps/_workspace/src/github.com/Azure/go-autorest/autorest/to"
+	"github.com/Azure/azure-sdk-for-go/arm/resources/resources"
+)
+
+func init() {
+	// The rules letting the caller in allow a documentation address, rather than looking it up.
+	lookupCallerIP = func() (string, error) { return "203.0.113.10", nil }
+}
+
+// calls returns the requests that changed something, as method and path relative to the
+// subscription, in lower case and without trailing slashes. Reads and operation polls are left out.
+func calls(srv *armfake.Server) []string {
+	prefix := strings.ToLower("/subscriptions/" + srv.SubscriptionID)
+	var result []string
+	for _, r := range srv.Requests() {
+		path := strings.TrimSuffix(strings.ToLower(r.Path), "/")
+		if r.Method == "GET" || !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		result = append(result, r.Method+" "+strings.TrimPrefix(path, prefix))
+	}
+	return result
+}
+
+func lastRequest(srv *armfake.Server, method, pathSuffix string) *armfake.Request {
+	reqs := srv.Requests()
+	for i := len(reqs) - 1; i >= 0; i-- {
+		if reqs[i].Method == method && strings.HasSuffix(strings.TrimSuffix(strings.ToLower(reqs[i].Path), "/"), strings.ToLower(pathSuffix)) {
+			return &reqs[i]
+		}
+	}
+	return nil
+}
+
+func TestCreateVM(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+
+	_, vms, err := provisionSpec(defaultSpec("createvm01", "West US", "vm001", "admin", "foobar1234"), provisionOptions{parallel: 1}, helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL))
+	if err != nil {
+		t.Fatalf("provisionSpec failed: %v", err)
+	}
+	vm := vms[0]
+	if to.String(vm.Name) != "vm001" {
+		t.Errorf("unexpected virtual machine name '%s'", to.String(vm.Name))
+	}
+
+	account, err := storageAccountName(resources.ResourceGroup{Name: to.StringPtr("createvm01")})
+	if err != nil {
+		t.Fatalf("storageAccountName failed: %v", err)
+	}
+
+	group := "/resourcegroups/createvm01/providers"
+	expected := []string{
+		"PUT /resourcegroups/createvm01",
+		"POST /providers/microsoft.storage/register",
+		"POST /providers/microsoft.network/register",
+		"POST /providers/microsoft.compute/register",
+		"POST /providers/microsoft.storage/checknameavailability",
+		"PUT " + group + "/microsoft.storage/storageaccounts/" + account,
+		"PUT " + group + "/microsoft.compute/availabilitysets/createvm01avset",
+		"PUT " + group + "/microsoft.network/networksecuritygroups/createvm01nsg",
+		"PUT " + group + "/microsoft.network/virtualnetworks/createvm01vnet",
+		"PUT " + group + "/microsoft.network/virtualnetworks/createvm01vnet/subnets/createvm01subnet",
+		"PUT " + group + "/microsoft.network/publicipaddresses/ip01",
+		"PUT " + group + "/microsoft.network/networkinterfaces/nic01",
+		"PUT " + group + "/microsoft.compute/virtualmachines/vm001",
+	}
+	actual := calls(srv)
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected requests:\n%s\nexpected:\n%s", strings.Join(actual, "\n"), strings.Join(expected, "\n"))
+	}
+
+	// The virtual machine has to refer to the resources created before it.
+	r := lastRequest(srv, "PUT", "/virtualMachines/vm001")
+	if r == nil {
+		t.Fatalf("the virtual machine was not created")
+	}
+	body := helpers.ToJSON(r.Body)
+	for _, ref := range []string{
+		"/availabilitySets/createvm01avset",
+		"/networkInterfaces/nic01",
+		"http://" + account + ".blob.core.windows.net/vhds/mytestod1.vhd",
+	} {
+		if !strings.Contains(body, ref) {
+			t.Errorf("the virtual machine doesn't refer to '%s':\n%s", ref, body)
+		}
+	}
+}
+
+func TestCreateVMStopsAtFirstError(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	srv.Fail("PUT", "/publicIPAddresses/", 400, "InvalidRequestFormat", "Cannot parse the request.", false)
+
+	_, _, err := provisionSpec(defaultSpec("createvm01", "West US", "vm001", "admin", "foobar1234"), provisionOptions{parallel: 1}, helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL))
+	if err == nil || !strings.Contains(err.Error(), "Failed to create public ip address 'ip01'") {
+		t.Fatalf("expected creating the public IP address to fail, got %v", err)
+	}
+	for _, c := range calls(srv) {
+		if strings.Contains(c, "/networkinterfaces/") || strings.Contains(c, "/virtualmachines/") {
+			t.Errorf("unexpected request after the failure: %s", c)
+		}
+	}
+}
+
+func TestCreateVMRegistrationError(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	srv.Fail("POST", "/providers/Microsoft.Compute/register", 403, "AuthorizationFailed", "The client does not have authorization to perform action 'Microsoft.Compute/register/action'.", false)
+
+	_, _, err := provisionSpec(defaultSpec("createvm01", "West US", "vm001", "admin", "foobar1234"), provisionOptions{parallel: 1}, helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL))
+	if err == nil || !strings.Contains(err.Error(), "Failed to register resource provider 'Microsoft.Compute'") {
+		t.Fatalf("expected registering Microsoft.Compute to fail, got %v", err)
+	}
+	// The resources of the other providers are still created, but none of Microsoft.Compute.
+	created := strings.Join(calls(srv), "\n")
+	if strings.Contains(created, "createvm01/providers/microsoft.compute/") || !strings.Contains(created, "/storageaccounts/") {
+		t.Errorf("unexpected requests after the failure:\n%s", created)
+	}
+}
+
+func TestCreateVMExistingStorageAccount(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+
+	if _, _, err := provisionSpec(defaultSpec("createvm01", "West US", "vm001", "admin", "foobar1234"), provisionOptions{parallel: 1}, client); err != nil {
+		t.Fatalf("provisionSpec failed: %v", err)
+	}
+
+	// Running the sample again finds its storage account taken, by itself, and goes on using it.
+	if _, _, err := provisionSpec(defaultSpec("createvm01", "West US", "vm001", "admin", "foobar1234"), provisionOptions{parallel: 1}, client); err != nil {
+		t.Fatalf("running provisionSpec again failed: %v", err)
+	}
+	n := 0
+	for _, c := range calls(srv) {
+		if strings.Contains(c, "/storageaccounts/") {
+			n++
+		}
+	}
+	if n != 1 {
+		t.Errorf("expected the storage account to be created once, got %d", n)
+	}
+}
+
+func TestCreateVMLocationNotAvailable(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	srv.LimitResourceType("Microsoft.Compute/virtualMachines", "East US", "West Europe")
+
+	_, _, err := provisionSpec(defaultSpec("createvm01", "West US", "vm001", "admin", "foobar1234"), provisionOptions{parallel: 1}, helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL))
+	if err == nil || !strings.Contains(err.Error(), "'Microsoft.Compute/virtualMachines' is not available in 'West US', use one of 'East US', 'West Europe'") {
+		t.Fatalf("expected the location to be rejected, got %v", err)
+	}
+	if c := calls(srv); len(c) != 0 {
+		t.Errorf("expected nothing to be created, got:\n%s", strings.Join(c, "\n"))
+	}
+}
+
+func TestProvisionSpecOptions(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+	spec := defaultSpec("createvm01", "West US", "vm001", "admin", "foobar1234")
+
+	// Planning alone changes nothing.
+	p, vms, err := provisionSpec(spec, provisionOptions{planOnly: true, parallel: 4, lock: "ReadOnly"}, client)
+	if err != nil || p == nil || vms != nil {
+		t.Fatalf("unexpected plan %v, virtual machines %v and error %v", p, vms, err)
+	}
+	if c := calls(srv); len(c) != 0 {
+		t.Errorf("expected nothing to be created, got:\n%s", strings.Join(c, "\n"))
+	}
+
+	// A failed run is rolled back, and the group is not locked.
+	srv.Fail("PUT", "/networkInterfaces/", 400, "InvalidRequestFormat", "Cannot parse the request.", true)
+	_, _, err = provisionSpec(spec, provisionOptions{parallel: 4, rollback: true, lock: "ReadOnly"}, client)
+	if err == nil || !strings.Contains(err.Error(), "Failed to create network interface 'nic01'") {
+		t.Fatalf("expected the network interface to fail, got %v", err)
+	}
+	if ids := srv.ResourceIDs(); len(ids) != 0 {
+		t.Errorf("expected the resources to be deleted, got %v", ids)
+	}
+	if lastRequest(srv, "PUT", "/locks/createvm01-lock") != nil {
+		t.Errorf("the resource group was locked")
+	}
+
+	// A successful run locks the group once everything is provisioned.
+	_, vms, err = provisionSpec(spec, provisionOptions{parallel: 4, rollback: true, lock: "ReadOnly"}, client)
+	if err != nil || len(vms) != 1 {
+		t.Fatalf("unexpected virtual machines %v and error %v", vms, err)
+	}
+	if c := calls(srv); !strings.HasSuffix(c[len(c)-1], "/locks/createvm01-lock") {
+		t.Errorf("expected the group to be locked last, got:\n%s", strings.Join(c, "\n"))
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-go-samples/fakes/armfake"
+	"github.com/Azure/azure-go-samples/fakes/golden"
+	"github.com/Azure/azure-go-samples/helpers"
+)
+
+// TestRequestPayloads compares the bodies of the requests sent by createSecurityGroup,
+// createNetwork, createNetworkInterface and createVirtualMachine with the golden files in testdata. Run the
+// test with -update to regenerate them after changing what the sample sends.
+func TestRequestPayloads(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+
+	if _, _, err := provisionSpec(defaultSpec("createvm01", "West US", "vm001", "admin", "foobar1234"), provisionOptions{parallel: 1}, helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)); err != nil {
+		t.Fatalf("provisionSpec failed: %v", err)
+	}
+
+	for _, c := range []struct {
+		golden     string
+		pathSuffix string
+	}{
+		{"nsg", "/networksecuritygroups/createvm01nsg"},
+		{"network-vnet", "/virtualnetworks/createvm01vnet"},
+		{"network-subnet", "/virtualnetworks/createvm01vnet/subnets/createvm01subnet"},
+		{"nic-publicip", "/publicipaddresses/ip01"},
+		{"nic-interface", "/networkinterfaces/nic01"},
+		{"vm", "/virtualmachines/vm001"},
+	} {
+		r := lastRequest(srv, "PUT", c.pathSuffix)
+		if r == nil {
+			t.Errorf("no request was sent to %s", c.pathSuffix)
+			continue
+		}
+		golden.CheckJSON(t, c.golden, r.Body)
+	}
+}
@@ -0,0 +1,584 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-go-samples/helpers"
+	"github.com/Azure/azure-sdk-for-go/Godeps/_workspace/src/github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/azure-sdk-for-go/Godeps/_workspace/src/github.com/Azure/go-autorest/autorest/to"
+	"github.com/Azure/azure-sdk-for-go/arm"
+	"github.com/Azure/azure-sdk-for-go/arm/compute"
+	"github.com/Azure/azure-sdk-for-go/arm/network"
+	"github.com/Azure/azure-sdk-for-go/arm/resources/resources"
+	"github.com/Azure/azure-sdk-for-go/arm/storage"
+)
+
+// The actions of a plan step.
+const (
+	actionCreate    = "Create"
+	actionUpdate    = "Update"
+	actionReplace   = "Replace"
+	actionRegister  = "Register"
+	actionUnchanged = "Unchanged"
+)
+
+// The types of the resources in a plan.
+const (
+	groupType    = "Microsoft.Resources/resourceGroups"
+	providerType = "Microsoft.Resources/providers"
+	storageType  = "Microsoft.Storage/storageAccounts"
+	avsetType    = "Microsoft.Compute/availabilitySets"
+	nsgType      = "Microsoft.Network/networkSecurityGroups"
+	lbType       = "Microsoft.Network/loadBalancers"
+	vnetType     = "Microsoft.Network/virtualNetworks"
+	ipType       = "Microsoft.Network/publicIPAddresses"
+	nicType      = "Microsoft.Network/networkInterfaces"
+	vmType       = "Microsoft.Compute/virtualMachines"
+)
+
+// planStep is what provisioning a spec does to one resource. Resources that exist are compared
+// with the spec field by field, and only updated when they have drifted from it. Some fields can't
+// be changed once a resource is created, such as the image of a virtual machine; a resource that
+// drifted in those needs replacing, which the sample leaves to the user.
+type planStep struct {
+	Type    string           `json:"type"`
+	Name    string           `json:"name"`
+	Action  string           `json:"action"`
+	Changes []helpers.Change `json:"changes,omitempty"`
+
+	// current is the resource as read when planning, used in place of the one provisioning would
+	// return when the resource is left unchanged.
+	current interface{}
+}
+
+// provisioningPlan lists the steps provisioning a spec takes, in order. Planning only reads: the
+// plan can be printed for review, and applied later with applyPlan.
+type provisioningPlan struct {
+	ResourceGroup string      `json:"resourceGroup"`
+	Steps         []*planStep `json:"steps"`
+
+	spec *vmSpec
+
+	// callerIP is the address the caller source of the security rules stands for.
+	callerIP string
+}
+
+// lookupCallerIP finds the public IP address of the machine running the sample, for the security
+// rules allowing it in.
+var lookupCallerIP = func() (string, error) {
+	return helpers.CallerIPAddress(helpers.CallerIPService)
+}
+
+// planProvisioning reads the resources of a spec and compares them with it. The location is
+// validated for all of them first, and the address of the caller looked up when security rules
+// allow it in, unless the spec gives it.
+func planProvisioning(spec *vmSpec, arm arm.Client) (*provisioningPlan, error) {
+
+	if _, err := helpers.ValidateLocation(arm, spec.ResourceGroup.Location, vmResourceTypes...); err != nil {
+		return nil, err
+	}
+
+	name := spec.ResourceGroup.Name
+	location := to.StringPtr(spec.ResourceGroup.Location)
+	p := &provisioningPlan{ResourceGroup: name, spec: spec}
+
+	if spec.CallerIP != "" {
+		p.callerIP = spec.CallerIP
+	} else if spec.allowsCaller() {
+		ip, err := lookupCallerIP()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to find the address of this machine, which security rules allow in: '%s'\n", err.Error())
+		}
+		p.callerIP = ip
+	}
+
+	group, err := arm.ResourceGroups().Get(name)
+	groupExists, err := exists(group.Response, err)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get resource group '%s': '%s'\n", name, err.Error())
+	}
+	p.add(groupType, name, groupExists, group, resources.ResourceGroup{Location: location}, nil, "location")
+
+	for _, ns := range vmProviders {
+		provider, err := arm.Providers().Get(ns)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get resource provider '%s': '%s'\n", ns, err.Error())
+		}
+		step := &planStep{Type: providerType, Name: ns, Action: actionUnchanged}
+		if !strings.EqualFold(to.String(provider.RegistrationState), "Registered") {
+			step.Action = actionRegister
+		}
+		p.Steps = append(p.Steps, step)
+	}
+
+	// Nothing can exist in a group that doesn't, which saves reading every resource.
+	get := func(what, resourceName string, read func() (autorest.Response, error)) (bool, error) {
+		if !groupExists {
+			return false, nil
+		}
+		found, err := exists(read())
+		if err != nil {
+			return false, fmt.Errorf("Failed to get %s '%s': '%s'\n", what, resourceName, err.Error())
+		}
+		return found, nil
+	}
+	ids := resourceIDs{subscription: arm.ResourceGroups().SubscriptionID, group: name}
+
+	var account storage.Account
+	found, err := get("storage account", spec.StorageAccount.Name, func() (autorest.Response, error) {
+		account, err = arm.StorageAccounts().GetProperties(name, spec.StorageAccount.Name)
+		return account.Response, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.add(storageType, spec.StorageAccount.Name, found, account, storageParams(location, spec.StorageAccount), nil, "location")
+
+	var avset compute.AvailabilitySet
+	found, err = get("availability set", spec.AvailabilitySet.Name, func() (autorest.Response, error) {
+		avset, err = arm.AvailabilitySets().Get(name, spec.AvailabilitySet.Name)
+		return avset.Response, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.add(avsetType, spec.AvailabilitySet.Name, found, avset, compute.AvailabilitySet{Location: location}, nil, "location")
+
+	nsgRefs := make(map[string]*network.SecurityGroup)
+	for _, n := range spec.NetworkSecurityGroups {
+		var nsg network.SecurityGroup
+		found, err = get("network security group", n.Name, func() (autorest.Response, error) {
+			nsg, err = arm.NetworkSecurityGroups().Get(name, n.Name)
+			return nsg.Response, err
+		})
+		if err != nil {
+			return nil, err
+		}
+		p.add(nsgType, n.Name, found, nsg, nsgParams(location, n, p.callerIP), nil, "location")
+		nsgRefs[n.Name] = &network.SecurityGroup{ID: ids.of(nsgType, n.Name)}
+	}
+
+	var vnet network.VirtualNetwork
+	found, err = get("virtual network", spec.VirtualNetwork.Name, func() (autorest.Response, error) {
+		vnet, err = arm.VirtualNetworks().Get(name, spec.VirtualNetwork.Name)
+		return vnet.Response, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.add(vnetType, spec.VirtualNetwork.Name, found, vnet, vnetParams(location, spec.VirtualNetwork, nsgRefs), nil, "location")
+
+	lbID := ""
+	if lb := spec.LoadBalancer; lb != nil {
+		var ip network.PublicIPAddress
+		found, err = get("public ip address", lb.PublicIPAddress, func() (autorest.Response, error) {
+			ip, err = arm.PublicIPAddresses().Get(name, lb.PublicIPAddress)
+			return ip.Response, err
+		})
+		if err != nil {
+			return nil, err
+		}
+		p.add(ipType, lb.PublicIPAddress, found, ip, publicIPParams(location), nil, "location")
+
+		var current network.LoadBalancer
+		found, err = get("load balancer", lb.Name, func() (autorest.Response, error) {
+			current, err = arm.LoadBalancers().Get(name, lb.Name)
+			return current.Response, err
+		})
+		if err != nil {
+			return nil, err
+		}
+		lbID = *ids.of(lbType, lb.Name)
+		publicIP := &network.PublicIPAddress{ID: ids.of(ipType, lb.PublicIPAddress)}
+		p.add(lbType, lb.Name, found, current, lbParams(location, *lb, lbID, publicIP), nil, "location")
+	}
+
+	for _, n := range spec.NetworkInterfaces {
+		var publicIP *network.PublicIPAddress
+		if n.PublicIPAddress != "" {
+			var ip network.PublicIPAddress
+			found, err = get("public ip address", n.PublicIPAddress, func() (autorest.Response, error) {
+				ip, err = arm.PublicIPAddresses().Get(name, n.PublicIPAddress)
+				return ip.Response, err
+			})
+			if err != nil {
+				return nil, err
+			}
+			p.add(ipType, n.PublicIPAddress, found, ip, publicIPParams(location), nil, "location")
+			publicIP = &network.PublicIPAddress{ID: ids.of(ipType, n.PublicIPAddress)}
+		}
+
+		var nic network.Interface
+		found, err = get("network interface", n.Name, func() (autorest.Response, error) {
+			nic, err = arm.NetworkInterfaces().Get(name, n.Name, "")
+			return nic.Response, err
+		})
+		if err != nil {
+			return nil, err
+		}
+		subnet := network.Subnet{ID: ids.of(vnetType, spec.VirtualNetwork.Name+"/subnets/"+n.Subnet)}
+		p.add(nicType, n.Name, found, nic, nicParams(location, n, subnet, publicIP, nsgRefs[n.NetworkSecurityGroup], lbBackendOf(spec, lbID, n.Name)), nil, "location")
+	}
+
+	for _, m := range spec.VirtualMachines {
+		var vm compute.VirtualMachine
+		found, err = get("virtual machine", m.Name, func() (autorest.Response, error) {
+			vm, err = arm.VirtualMachines().Get(name, m.Name, "")
+			return vm.Response, err
+		})
+		if err != nil {
+			return nil, err
+		}
+		desired := vmParams(location, m, spec.StorageAccount.Name, ids.of(avsetType, spec.AvailabilitySet.Name), ids.of(nicType, m.NetworkInterface))
+		// ARM never returns the password or the custom data, and doesn't allow changing the disks,
+		// the image, the OS profile or the availability set of a virtual machine.
+		p.add(vmType, m.Name, found, vm, desired, []string{"properties.osProfile.adminPassword", "properties.osProfile.customData"},
+			"location", "properties.availabilitySet", "properties.storageProfile", "properties.osProfile")
+	}
+
+	return p, nil
+}
+
+// exists reports whether the resource read by an SDK call exists; only errors other than a 404
+// are returned.
+func exists(r autorest.Response, err error) (bool, error) {
+	if err == nil {
+		return true, nil
+	}
+	if r.Response != nil && r.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	return false, err
+}
+
+// add compares a resource with the state the spec wants it in, and adds the step that gets it
+// there. Changes to the immutable fields, or to fields below them, make the step a replacement.
+func (p *provisioningPlan) add(typ, name string, found bool, current, desired interface{}, ignore []string, immutable ...string) {
+	s := &planStep{Type: typ, Name: name, Action: actionCreate}
+	if found {
+		s.current = current
+		s.Changes = helpers.Diff(current, desired, ignore...)
+		s.Action = actionUnchanged
+		if len(s.Changes) > 0 {
+			s.Action = actionUpdate
+		}
+		for _, c := range s.Changes {
+			for _, f := range immutable {
+				if c.Field == f || strings.HasPrefix(c.Field, f+".") || strings.HasPrefix(c.Field, f+"[") {
+					s.Action = actionReplace
+				}
+			}
+		}
+	}
+	p.Steps = append(p.Steps, s)
+}
+
+// step returns the step of a resource, which must be part of the plan.
+func (p *provisioningPlan) step(typ, name string) *planStep {
+	for _, s := range p.Steps {
+		if s.Type == typ && strings.EqualFold(s.Name, name) {
+			return s
+		}
+	}
+	panic(fmt.Sprintf("no step for %s '%s'", typ, name))
+}
+
+// unchanged returns the resource a step leaves unchanged, as read when planning.
+func (p *provisioningPlan) unchanged(typ, name string) (interface{}, bool) {
+	s := p.step(typ, name)
+	return s.current, s.Action == actionUnchanged
+}
+
+// log prints the steps that change something, with the fields that drifted.
+func (p *provisioningPlan) log() {
+	unchanged := 0
+	for _, s := range p.Steps {
+		if s.Action == actionUnchanged {
+			unchanged++
+			continue
+		}
+		helpers.Logf("%s %s '%s'\n", s.Action, s.Type, s.Name)
+		for _, c := range s.Changes {
+			helpers.Logf("    %s\n", c)
+		}
+	}
+	helpers.Logf("%d of %d resources are up to date in resource group '%s'\n", unchanged, len(p.Steps), p.ResourceGroup)
+}
+
+// applyPlan takes the steps of a plan, each once the steps of the resources it refers to are done,
+// and runs up to 'parallel' steps at the same time, or all that are ready when it is zero. When a
+// step fails, those that depend on it are reported as not run, and the others still run. Nothing
+// is changed if some resource needs replacing. The SSH keys planning left out are generated first.
+// The resources it creates are recorded in the transaction, for rolling them back.
+func applyPlan(p *provisioningPlan, tx *transaction, parallel int, arm arm.Client) ([]compute.VirtualMachine, error) {
+
+	var replace []string
+	for _, s := range p.Steps {
+		if s.Action == actionReplace {
+			replace = append(replace, fmt.Sprintf("%s '%s'", s.Type, s.Name))
+		}
+	}
+	if len(replace) > 0 {
+		return nil, fmt.Errorf("Failed to apply the plan: %s can't be changed in place, delete them or change the spec back\n", strings.Join(replace, ", "))
+	}
+
+	spec := p.spec
+	if err := spec.generateKeys(); err != nil {
+		return nil, err
+	}
+	g := helpers.NewGraph()
+
+	// add adds a step to the graph, after the steps it depends on. Steps that leave a resource
+	// unchanged only pass on the resource read when planning.
+	add := func(s *planStep, apply func() error, dependsOn ...*planStep) {
+		deps := make([]string, len(dependsOn))
+		for i, d := range dependsOn {
+			deps[i] = d.task()
+		}
+		g.Add(s.task(), func() error { return tx.do(s, apply) }, deps...)
+	}
+
+	groupStep := p.step(groupType, spec.ResourceGroup.Name)
+	var group resources.ResourceGroup
+	add(groupStep, func() (err error) {
+		if current, ok := p.unchanged(groupType, spec.ResourceGroup.Name); ok {
+			group = current.(resources.ResourceGroup)
+			return nil
+		}
+		group, err = createResourceGroup(spec.ResourceGroup.Name, spec.ResourceGroup.Location, arm)
+		return
+	})
+
+	providers := make(map[string]*planStep)
+	for _, ns := range vmProviders {
+		ns, s := ns, p.step(providerType, ns)
+		providers[ns] = s
+		add(s, func() error {
+			if s.Action != actionRegister {
+				return nil
+			}
+			return registerProvider(ns, arm)
+		})
+	}
+
+	storageStep := p.step(storageType, spec.StorageAccount.Name)
+	add(storageStep, func() error {
+		if storageStep.Action == actionUnchanged {
+			return nil
+		}
+		return createStorageAccount(group, spec.StorageAccount, storageStep.Action == actionUpdate, arm)
+	}, groupStep, providers["Microsoft.Storage"])
+
+	avsetStep := p.step(avsetType, spec.AvailabilitySet.Name)
+	var avset compute.AvailabilitySet
+	add(avsetStep, func() (err error) {
+		if current, ok := p.unchanged(avsetType, spec.AvailabilitySet.Name); ok {
+			avset = current.(compute.AvailabilitySet)
+			return nil
+		}
+		avset, err = createAvailabilitySet(group, spec.AvailabilitySet, arm)
+		return
+	}, groupStep, providers["Microsoft.Compute"])
+
+	nsgSteps := make(map[string]*planStep)
+	nsgs := make(map[string]*network.SecurityGroup)
+	for _, n := range spec.NetworkSecurityGroups {
+		n := n
+		nsgSteps[n.Name] = p.step(nsgType, n.Name)
+		nsg := &network.SecurityGroup{}
+		nsgs[n.Name] = nsg
+		add(nsgSteps[n.Name], func() (err error) {
+			if current, ok := p.unchanged(nsgType, n.Name); ok {
+				*nsg = current.(network.SecurityGroup)
+				return nil
+			}
+			*nsg, err = createSecurityGroup(group, n, p.callerIP, arm)
+			return
+		}, groupStep, providers["Microsoft.Network"])
+	}
+
+	// nsgRef refers to a network security group by its ID, once its step is done.
+	nsgRef := func(name string) *network.SecurityGroup {
+		if name == "" {
+			return nil
+		}
+		return &network.SecurityGroup{ID: nsgs[name].ID}
+	}
+
+	vnetStep := p.step(vnetType, spec.VirtualNetwork.Name)
+	vnetDependsOn := []*planStep{groupStep, providers["Microsoft.Network"]}
+	for _, snet := range spec.VirtualNetwork.Subnets {
+		if snet.NetworkSecurityGroup != "" {
+			vnetDependsOn = append(vnetDependsOn, nsgSteps[snet.NetworkSecurityGroup])
+		}
+	}
+	var subnets map[string]network.Subnet
+	add(vnetStep, func() (err error) {
+		if current, ok := p.unchanged(vnetType, spec.VirtualNetwork.Name); ok {
+			subnets = subnetsByName(current.(network.VirtualNetwork))
+			return nil
+		}
+		refs := make(map[string]*network.SecurityGroup)
+		for _, snet := range spec.VirtualNetwork.Subnets {
+			if snet.NetworkSecurityGroup != "" {
+				refs[snet.NetworkSecurityGroup] = nsgRef(snet.NetworkSecurityGroup)
+			}
+		}
+		subnets, err = createNetwork(group, spec.VirtualNetwork, refs, arm)
+		return
+	}, vnetDependsOn...)
+
+	// The network interfaces behind the load balancer refer to it by ID, and so wait for it.
+	var lbStep *planStep
+	lbID := ""
+	if lb := spec.LoadBalancer; lb != nil {
+		ipStep := p.step(ipType, lb.PublicIPAddress)
+		ip := &network.PublicIPAddress{}
+		add(ipStep, func() (err error) {
+			if current, ok := p.unchanged(ipType, lb.PublicIPAddress); ok {
+				*ip = current.(network.PublicIPAddress)
+				return nil
+			}
+			*ip, err = createPublicIPAddress(group, lb.PublicIPAddress, arm)
+			return
+		}, groupStep, providers["Microsoft.Network"])
+
+		lbStep = p.step(lbType, lb.Name)
+		lbID = *resourceIDs{subscription: arm.ResourceGroups().SubscriptionID, group: spec.ResourceGroup.Name}.of(lbType, lb.Name)
+		add(lbStep, func() error {
+			if lbStep.Action == actionUnchanged {
+				return nil
+			}
+			_, err := createLoadBalancer(group, *lb, lbID, ip, arm)
+			return err
+		}, ipStep, groupStep, providers["Microsoft.Network"])
+	}
+
+	// Each step sets its own resource, so that steps running at the same time don't share a map.
+	nicSteps := make(map[string]*planStep)
+	nics := make(map[string]*network.Interface)
+	for _, n := range spec.NetworkInterfaces {
+		n := n
+		dependsOn := []*planStep{vnetStep, providers["Microsoft.Network"]}
+
+		var publicIP *network.PublicIPAddress
+		if n.PublicIPAddress != "" {
+			ipStep := p.step(ipType, n.PublicIPAddress)
+			ip := &network.PublicIPAddress{}
+			add(ipStep, func() (err error) {
+				if current, ok := p.unchanged(ipType, n.PublicIPAddress); ok {
+					*ip = current.(network.PublicIPAddress)
+					return nil
+				}
+				*ip, err = createPublicIPAddress(group, n.PublicIPAddress, arm)
+				return
+			}, groupStep, providers["Microsoft.Network"])
+			publicIP = ip
+			dependsOn = append(dependsOn, ipStep)
+		}
+
+		if n.NetworkSecurityGroup != "" {
+			dependsOn = append(dependsOn, nsgSteps[n.NetworkSecurityGroup])
+		}
+		backend := lbBackendOf(spec, lbID, n.Name)
+		if backend.pools != nil {
+			dependsOn = append(dependsOn, lbStep)
+		}
+
+		nicSteps[n.Name] = p.step(nicType, n.Name)
+		nic := &network.Interface{}
+		nics[n.Name] = nic
+		add(nicSteps[n.Name], func() (err error) {
+			if current, ok := p.unchanged(nicType, n.Name); ok {
+				*nic = current.(network.Interface)
+				return nil
+			}
+			*nic, err = createNetworkInterface(group, n, subnets[n.Subnet], publicIP, nsgRef(n.NetworkSecurityGroup), backend, arm)
+			return
+		}, dependsOn...)
+	}
+
+	vms := make([]compute.VirtualMachine, len(spec.VirtualMachines))
+	for i, m := range spec.VirtualMachines {
+		i, m := i, m
+		add(p.step(vmType, m.Name), func() (err error) {
+			if current, ok := p.unchanged(vmType, m.Name); ok {
+				vms[i] = current.(compute.VirtualMachine)
+				return nil
+			}
+			vms[i], err = createVirtualMachine(group, m, spec.StorageAccount.Name, avset, *nics[m.NetworkInterface], arm)
+			return
+		}, nicSteps[m.NetworkInterface], avsetStep, storageStep, providers["Microsoft.Compute"])
+	}
+
+	if err := g.Run(parallel); err != nil {
+		return nil, err
+	}
+	return vms, nil
+}
+
+// task names the step in the graph applyPlan runs.
+func (s *planStep) task() string {
+	return s.Type + "/" + s.Name
+}
+
+// vmStatus is what applying a plan did to one of its virtual machines.
+type vmStatus struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// vmStatuses reports what applying a plan did to each of its virtual machines, given the error
+// applyPlan returned: "Created", "Updated" or "Unchanged", "Failed" when its step failed, or
+// "Skipped" when it wasn't run because a step it depends on failed.
+func vmStatuses(p *provisioningPlan, err error) []vmStatus {
+	done := map[string]string{actionCreate: "Created", actionUpdate: "Updated", actionUnchanged: "Unchanged"}
+	graphErr, _ := err.(*helpers.GraphError)
+
+	var statuses []vmStatus
+	for _, m := range p.spec.VirtualMachines {
+		s := p.step(vmType, m.Name)
+		status := vmStatus{Name: m.Name, Status: done[s.Action]}
+		switch {
+		case err == nil:
+		case graphErr == nil:
+			status.Status, status.Error = "Skipped", strings.TrimSuffix(err.Error(), "\n")
+		default:
+			for _, f := range graphErr.Failed {
+				if f.Task == s.task() {
+					status.Status, status.Error = "Failed", strings.TrimSuffix(f.Err.Error(), "\n")
+				}
+			}
+			for _, f := range graphErr.Skipped {
+				if f.Task == s.task() {
+					status.Status, status.Error = "Skipped", f.Err.Error()
+				}
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+func subnetsByName(vnet network.VirtualNetwork) map[string]network.Subnet {
+	subnets := make(map[string]network.Subnet)
+	if vnet.Properties != nil && vnet.Properties.Subnets != nil {
+		for _, s := range *vnet.Properties.Subnets {
+			subnets[to.String(s.Name)] = s
+		}
+	}
+	return subnets
+}
+
+// resourceIDs builds the IDs of the resources of a group, which resources that don't exist yet
+// are referred to by in a plan.
+type resourceIDs struct {
+	subscription, group string
+}
+
+func (r resourceIDs) of(typ, name string) *string {
+	return to.StringPtr(fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/%s/%s", r.subscription, r.group, typ, name))
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-go-samples/fakes/armfake"
+	"github.com/Azure/azure-go-samples/helpers"
+	"github.com/Azure/azure-sdk-for-go/Godeps/_workspace/src/github.com/Azure/go-autorest/autorest/to"
+	"github.com/Azure/azure-sdk-for-go/arm/network"
+)
+
+// actions returns the steps of a plan that change something, as action, type and name.
+func actions(p *provisioningPlan) []string {
+	var result []string
+	for _, s := range p.Steps {
+		if s.Action != actionUnchanged {
+			result = append(result, s.Action+" "+s.Type+" "+s.Name)
+		}
+	}
+	return result
+}
+
+func TestPlanNewGroup(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+
+	spec := defaultSpec("createvm01", "West US", "vm001", "admin", "foobar1234")
+	p, err := planProvisioning(spec, client)
+	if err != nil {
+		t.Fatalf("planProvisioning failed: %v", err)
+	}
+
+	expected := []string{
+		"Create Microsoft.Resources/resourceGroups createvm01",
+		"Register Microsoft.Resources/providers Microsoft.Storage",
+		"Register Microsoft.Resources/providers Microsoft.Network",
+		"Register Microsoft.Resources/providers Microsoft.Compute",
+		"Create Microsoft.Storage/storageAccounts " + spec.StorageAccount.Name,
+		"Create Microsoft.Compute/availabilitySets createvm01avset",
+		"Create Microsoft.Network/networkSecurityGroups createvm01nsg",
+		"Create Microsoft.Network/virtualNetworks createvm01vnet",
+		"Create Microsoft.Network/publicIPAddresses ip01",
+		"Create Microsoft.Network/networkInterfaces nic01",
+		"Create Microsoft.Compute/virtualMachines vm001",
+	}
+	if a := actions(p); strings.Join(a, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected plan:\n%s\nexpected:\n%s", strings.Join(a, "\n"), strings.Join(expected, "\n"))
+	}
+
+	// Planning only reads, and doesn't read the resources of a group that doesn't exist.
+	if c := calls(srv); len(c) != 0 {
+		t.Errorf("expected no changes, got:\n%s", strings.Join(c, "\n"))
+	}
+	for _, r := range srv.Requests() {
+		if strings.Contains(strings.ToLower(r.Path), "/resourcegroups/createvm01/") {
+			t.Errorf("unexpected request %s %s", r.Method, r.Path)
+		}
+	}
+}
+
+func TestProvisionIsIdempotent(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+
+	spec := defaultSpec("createvm01", "West US", "vm001", "admin", "foobar1234")
+	if _, _, err := provisionSpec(spec, provisionOptions{parallel: 1}, client); err != nil {
+		t.Fatalf("provisionSpec failed: %v", err)
+	}
+	created := len(calls(srv))
+
+	p, err := planProvisioning(spec, client)
+	if err != nil {
+		t.Fatalf("planProvisioning failed: %v", err)
+	}
+	if a := actions(p); len(a) != 0 {
+		t.Errorf("expected nothing to change, got:\n%s\n%s", strings.Join(a, "\n"), helpers.ToJSON(p))
+	}
+
+	vms, err := applyPlan(p, newTransaction("createvm01"), 1, client)
+	if err != nil {
+		t.Fatalf("applyPlan failed: %v", err)
+	}
+	if len(vms) != 1 || to.String(vms[0].Name) != "vm001" {
+		t.Errorf("unexpected virtual machines %+v", vms)
+	}
+	if c := calls(srv); len(c) != created {
+		t.Errorf("expected no more changes, got:\n%s", strings.Join(c[created:], "\n"))
+	}
+}
+
+func TestProvisionDrift(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+
+	spec := defaultSpec("createvm01", "West US", "vm001", "admin", "foobar1234")
+	if _, _, err := provisionSpec(spec, provisionOptions{parallel: 1}, client); err != nil {
+		t.Fatalf("provisionSpec failed: %v", err)
+	}
+
+	// Someone makes the public IP address static, and the spec asks for a bigger machine.
+	ip := network.PublicIPAddress{Location: to.StringPtr("West US"), Properties: &network.PublicIPAddressPropertiesFormat{PublicIPAllocationMethod: network.Static}}
+	if _, err := client.PublicIPAddresses().CreateOrUpdate("createvm01", "ip01", ip); err != nil {
+		t.Fatal(err)
+	}
+	spec.VirtualMachines[0].Size = "Standard_A1"
+	before := len(calls(srv))
+
+	p, err := planProvisioning(spec, client)
+	if err != nil {
+		t.Fatalf("planProvisioning failed: %v", err)
+	}
+	expected := []string{
+		"Update Microsoft.Network/publicIPAddresses ip01",
+		"Update Microsoft.Compute/virtualMachines vm001",
+	}
+	if a := actions(p); strings.Join(a, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected plan:\n%s\nexpected:\n%s", strings.Join(a, "\n"), strings.Join(expected, "\n"))
+	}
+	for _, c := range []struct {
+		typ, name, change string
+	}{
+		{ipType, "ip01", `properties.publicIPAllocationMethod: "Static" => "Dynamic"`},
+		{vmType, "vm001", `properties.hardwareProfile.vmSize: "Standard_A0" => "Standard_A1"`},
+	} {
+		if changes := p.step(c.typ, c.name).Changes; len(changes) != 1 || changes[0].String() != c.change {
+			t.Errorf("unexpected changes of '%s': %v", c.name, changes)
+		}
+	}
+
+	if _, err := applyPlan(p, newTransaction("createvm01"), 1, client); err != nil {
+		t.Fatalf("applyPlan failed: %v", err)
+	}
+	expectedCalls := []string{
+		"PUT /resourcegroups/createvm01/providers/microsoft.network/publicipaddresses/ip01",
+		"PUT /resourcegroups/createvm01/providers/microsoft.compute/virtualmachines/vm001",
+	}
+	if c := calls(srv)[before:]; strings.Join(c, "\n") != strings.Join(expectedCalls, "\n") {
+		t.Errorf("unexpected requests:\n%s\nexpected:\n%s", strings.Join(c, "\n"), strings.Join(expectedCalls, "\n"))
+	}
+
+	if p, err = planProvisioning(spec, client); err != nil {
+		t.Fatalf("planProvisioning failed: %v", err)
+	}
+	if a := actions(p); len(a) != 0 {
+		t.Errorf("expected the drift to be fixed, got:\n%s", strings.Join(a, "\n"))
+	}
+}
+
+func TestProvisionRefusesReplacement(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+
+	spec := defaultSpec("createvm01", "West US", "vm001", "admin", "foobar1234")
+	if _, _, err := provisionSpec(spec, provisionOptions{parallel: 1}, client); err != nil {
+		t.Fatalf("provisionSpec failed: %v", err)
+	}
+	before := len(calls(srv))
+
+	spec.VirtualMachines[0].Image.Sku = "2016-Datacenter"
+	p, err := planProvisioning(spec, client)
+	if err != nil {
+		t.Fatalf("planProvisioning failed: %v", err)
+	}
+	if a := actions(p); len(a) != 1 || a[0] != "Replace Microsoft.Compute/virtualMachines vm001" {
+		t.Errorf("unexpected plan:\n%s", strings.Join(a, "\n"))
+	}
+
+	_, err = applyPlan(p, newTransaction("createvm01"), 1, client)
+	if err == nil || !strings.Contains(err.Error(), "Microsoft.Compute/virtualMachines 'vm001' can't be changed in place") {
+		t.Errorf("expected the plan to be refused, got %v", err)
+	}
+	if c := calls(srv); len(c) != before {
+		t.Errorf("expected nothing to be changed, got:\n%s", strings.Join(c[before:], "\n"))
+	}
+}
+
+func TestApplyPlanInParallel(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+
+	os.Setenv("CREATEVM_PASSWORD", "foobar1234")
+	defer os.Unsetenv("CREATEVM_PASSWORD")
+	spec, err := readSpec("specs/staging.json")
+	if err != nil {
+		t.Fatalf("readSpec failed: %v", err)
+	}
+	p, err := planProvisioning(spec, client)
+	if err != nil {
+		t.Fatalf("planProvisioning failed: %v", err)
+	}
+	vms, err := applyPlan(p, newTransaction(spec.ResourceGroup.Name), 0, client)
+	if err != nil {
+		t.Fatalf("applyPlan failed: %v", err)
+	}
+	if len(vms) != 2 || to.String(vms[0].Name) != "web01" || to.String(vms[1].Name) != "db01" {
+		t.Fatalf("unexpected virtual machines %+v", vms)
+	}
+
+	// The order of the steps that run at the same time varies, but each resource is created after
+	// those it refers to.
+	position := make(map[string]int)
+	for i, c := range calls(srv) {
+		if strings.HasPrefix(c, "PUT ") {
+			position[c[strings.LastIndex(c, "/")+1:]] = i
+		}
+	}
+	account := strings.ToLower(spec.StorageAccount.Name)
+	for resource, dependencies := range map[string][]string{
+		"webnic": {"frontend", "webip", "createvmstagingnsg"},
+		"dbnic":  {"backend"},
+		"web01":  {"webnic", "createvmstagingavset", account},
+		"db01":   {"dbnic", "createvmstagingavset", account},
+	} {
+		for _, d := range dependencies {
+			if position[resource] <= position[d] {
+				t.Errorf("%s was created before %s", resource, d)
+			}
+		}
+	}
+	if len(position) != 12 {
+		t.Errorf("expected the group and 11 resources to be created, got %v", position)
+	}
+}
+
+func TestProvisionCount(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+
+	spec := defaultSpec("createvm01", "West US", "vm001", "admin", "foobar1234")
+	if _, _, err := provisionSpec(spec, provisionOptions{parallel: 1}, client); err != nil {
+		t.Fatalf("provisionSpec failed: %v", err)
+	}
+
+	// Adding two virtual machines leaves the first one alone.
+	if err := spec.withCount(3, "vm%03d"); err != nil {
+		t.Fatalf("withCount failed: %v", err)
+	}
+	p, err := planProvisioning(spec, client)
+	if err != nil {
+		t.Fatalf("planProvisioning failed: %v", err)
+	}
+	expected := []string{
+		"Create Microsoft.Network/publicIPAddresses ip02",
+		"Create Microsoft.Network/networkInterfaces nic02",
+		"Create Microsoft.Network/publicIPAddresses ip03",
+		"Create Microsoft.Network/networkInterfaces nic03",
+		"Create Microsoft.Compute/virtualMachines vm002",
+		"Create Microsoft.Compute/virtualMachines vm003",
+	}
+	if a := actions(p); strings.Join(a, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected plan:\n%s\nexpected:\n%s", strings.Join(a, "\n"), strings.Join(expected, "\n"))
+	}
+
+	vms, err := applyPlan(p, newTransaction("createvm01"), 0, client)
+	if err != nil {
+		t.Fatalf("applyPlan failed: %v", err)
+	}
+	if len(vms) != 3 {
+		t.Fatalf("unexpected virtual machines %+v", vms)
+	}
+	for i, name := range []string{"vm001", "vm002", "vm003"} {
+		if to.String(vms[i].Name) != name {
+			t.Errorf("expected virtual machine %d to be %s, got %s", i, name, to.String(vms[i].Name))
+		}
+	}
+	body := helpers.ToJSON(lastRequest(srv, "PUT", "/virtualMachines/vm003").Body)
+	for _, ref := range []string{"/availabilitySets/createvm01avset", "/networkInterfaces/nic03", "/vhds/vm003osdisk.vhd"} {
+		if !strings.Contains(body, ref) {
+			t.Errorf("vm003 doesn't refer to '%s':\n%s", ref, body)
+		}
+	}
+
+	statuses := vmStatuses(p, nil)
+	if len(statuses) != 3 || statuses[0].Status != "Unchanged" || statuses[1].Status != "Created" || statuses[2].Status != "Created" {
+		t.Errorf("unexpected statuses %+v", statuses)
+	}
+}
+
+func TestVMStatusesAfterFailure(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+	srv.Fail("PUT", "/networkInterfaces/nic02", 400, "InvalidRequestFormat", "Cannot parse the request.", false)
+
+	spec := defaultSpec("createvm01", "West US", "vm001", "admin", "foobar1234")
+	if err := spec.withCount(5, "vm%03d"); err != nil {
+		t.Fatalf("withCount failed: %v", err)
+	}
+	p, err := planProvisioning(spec, client)
+	if err != nil {
+		t.Fatalf("planProvisioning failed: %v", err)
+	}
+	_, err = applyPlan(p, newTransaction("createvm01"), 4, client)
+	if err == nil || !strings.Contains(err.Error(), "Failed to create network interface 'nic02'") {
+		t.Fatalf("expected the network interface to fail, got %v", err)
+	}
+
+	// Only the virtual machine of the failed network interface is skipped: the others are created.
+	expected := []string{
+		"vm001 Created ",
+		"vm002 Skipped ERROR: Task '" + vmType + "/vm002' was not run, since it depends on '" + nicType + "/nic02', which failed",
+		"vm003 Created ",
+		"vm004 Created ",
+		"vm005 Created ",
+	}
+	var statuses []string
+	for _, s := range vmStatuses(p, err) {
+		statuses = append(statuses, s.Name+" "+s.Status+" "+s.Error)
+	}
+	if strings.Join(statuses, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected statuses:\n%s\nexpected:\n%s", strings.Join(statuses, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestPlanCallerLookupFailure(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+
+	lookup := lookupCallerIP
+	defer func() { lookupCallerIP = lookup }()
+	lookupCallerIP = func() (string, error) {
+		return "", fmt.Errorf("ERROR: Unable to find the public IP address of this machine (timeout)")
+	}
+
+	spec := defaultSpec("createvm01", "West US", "vm001", "admin", "foobar1234")
+	if _, err := planProvisioning(spec, client); err == nil || !strings.Contains(err.Error(), "Failed to find the address of this machine, which security rules allow in") {
+		t.Errorf("expected the failed lookup to be reported, got %v", err)
+	}
+	if c := calls(srv); len(c) != 0 {
+		t.Errorf("expected nothing to be created, got:\n%s", strings.Join(c, "\n"))
+	}
+
+	// Specs giving the address are not looked up.
+	spec.CallerIP = "198.51.100.7"
+	if p, err := planProvisioning(spec, client); err != nil || p.callerIP != spec.CallerIP {
+		t.Errorf("expected the address of the spec to be used, got %v", err)
+	}
+
+	// Rules that don't allow the caller in don't need its address.
+	spec.CallerIP = ""
+	spec.NetworkSecurityGroups[0].Rules[0].SourceAddressPrefix = "Internet"
+	if _, err := planProvisioning(spec, client); err != nil {
+		t.Errorf("planProvisioning failed: %v", err)
+	}
+}
+
+func TestProvisionLoadBalancer(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+
+	os.Setenv("CREATEVM_PASSWORD", "foobar1234")
+	defer os.Unsetenv("CREATEVM_PASSWORD")
+	spec, err := readSpec("specs/balanced.yaml")
+	if err != nil {
+		t.Fatalf("readSpec failed: %v", err)
+	}
+	_, vms, err := provisionSpec(spec, provisionOptions{parallel: 1}, client)
+	if err != nil {
+		t.Fatalf("provisionSpec failed: %v", err)
+	}
+	if len(vms) != 2 {
+		t.Fatalf("unexpected virtual machines %+v", vms)
+	}
+
+	position := make(map[string]int)
+	for i, c := range calls(srv) {
+		if strings.HasPrefix(c, "PUT ") {
+			position[c[strings.LastIndex(c, "/")+1:]] = i
+		}
+	}
+	for resource, dependencies := range map[string][]string{
+		"weblb": {"webip"},
+		"nic01": {"weblb", "createvmbalancedvnet", "createvmbalancednsg"},
+		"nic02": {"weblb", "createvmbalancedvnet", "createvmbalancednsg"},
+	} {
+		for _, d := range dependencies {
+			if position[resource] <= position[d] {
+				t.Errorf("%s was created before %s", resource, d)
+			}
+		}
+	}
+
+	lb := helpers.ToJSON(lastRequest(srv, "PUT", "/loadBalancers/weblb").Body)
+	for _, ref := range []string{"/publicIPAddresses/webip", "/weblb/probes/http", "/weblb/backendAddressPools/backend", "web02-rdp"} {
+		if !strings.Contains(lb, ref) {
+			t.Errorf("the load balancer doesn't refer to '%s':\n%s", ref, lb)
+		}
+	}
+	nic := helpers.ToJSON(lastRequest(srv, "PUT", "/networkInterfaces/nic01").Body)
+	for _, ref := range []string{"/weblb/backendAddressPools/backend", "/weblb/inboundNatRules/web01-rdp"} {
+		if !strings.Contains(nic, ref) {
+			t.Errorf("nic01 doesn't refer to '%s':\n%s", ref, nic)
+		}
+	}
+	if strings.Contains(nic, "web02-rdp") {
+		t.Errorf("nic01 is behind the NAT rule of web02:\n%s", nic)
+	}
+
+	p, err := planProvisioning(spec, client)
+	if err != nil {
+		t.Fatalf("planProvisioning failed: %v", err)
+	}
+	if a := actions(p); len(a) != 0 {
+		t.Errorf("expected nothing to change, got:\n%s\n%s", strings.Join(a, "\n"), helpers.ToJSON(p))
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-go-samples/helpers"
+	"github.com/Azure/azure-sdk-for-go/Godeps/_workspace/src/github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/azure-sdk-for-go/arm"
+)
+
+// transaction records the resources a run of the sample creates, in order, so that they can be
+// deleted again when a later step fails or the user interrupts the run. A resource is recorded
+// before it is created: ARM may keep a resource whose provisioning failed, such as a virtual
+// machine whose image couldn't be deployed. Resources that existed before the run are never
+// recorded, and neither updates nor provider registrations are undone.
+type transaction struct {
+	group   string
+	created []*planStep
+	mu      sync.Mutex
+
+	stopped chan struct{}
+	stop    sync.Once
+}
+
+func newTransaction(group string) *transaction {
+	return &transaction{group: group, stopped: make(chan struct{})}
+}
+
+// do takes a step of a plan, unless the transaction was interrupted. Steps that run at the same
+// time may call it concurrently.
+func (tx *transaction) do(s *planStep, apply func() error) error {
+	select {
+	case <-tx.stopped:
+		return fmt.Errorf("Provisioning was interrupted before %s '%s'\n", s.Type, s.Name)
+	default:
+	}
+
+	if s.Action == actionCreate {
+		tx.mu.Lock()
+		tx.created = append(tx.created, s)
+		tx.mu.Unlock()
+	}
+	return apply()
+}
+
+// interrupt makes the transaction stop before its next step. The step in progress can't be
+// cancelled, since the SDK waits for it to finish.
+func (tx *transaction) interrupt() {
+	tx.stop.Do(func() { close(tx.stopped) })
+}
+
+// interruptOnSignal interrupts the transaction when the user presses Ctrl-C, until the returned
+// function is called. The handler is removed once it has fired, so that pressing Ctrl-C again exits
+// right away.
+func (tx *transaction) interruptOnSignal() (stop func()) {
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		select {
+		case <-signals:
+			signal.Stop(signals)
+			helpers.Logf("Interrupted, stopping after the current step; press Ctrl-C again to exit right away\n")
+			tx.interrupt()
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// rollbackSummary lists the resources a rollback deleted, and those it failed to delete.
+type rollbackSummary struct {
+	ResourceGroup string            `json:"resourceGroup"`
+	Deleted       []string          `json:"deleted"`
+	Failed        []rollbackFailure `json:"failed,omitempty"`
+}
+
+type rollbackFailure struct {
+	Resource string `json:"resource"`
+	Error    string `json:"error"`
+}
+
+// rollback deletes the resources created in the transaction in the reverse order of their
+// creation, which is the reverse of their dependencies, since a step only starts once those it
+// depends on are done: the virtual machines go before their network interfaces, and the resource
+// group last. A resource that fails to delete doesn't stop the rollback, though the resources it
+// depends on are likely to fail as well.
+func (tx *transaction) rollback(arm arm.Client) (summary rollbackSummary, err error) {
+
+	summary.ResourceGroup = tx.group
+	for i := len(tx.created) - 1; i >= 0; i-- {
+		s := tx.created[i]
+		what := fmt.Sprintf("%s '%s'", s.Type, s.Name)
+		if err := deleteResource(tx.group, s.Type, s.Name, arm); err != nil {
+			summary.Failed = append(summary.Failed, rollbackFailure{Resource: what, Error: err.Error()})
+			helpers.Logf("Failed to delete %s: '%s'\n", what, err.Error())
+			continue
+		}
+		summary.Deleted = append(summary.Deleted, what)
+		helpers.Logf("Deleted %s\n", what)
+	}
+
+	helpers.Logf("Deleted %d of the %d resources created in resource group '%s'\n", len(summary.Deleted), len(tx.created), tx.group)
+	if len(summary.Failed) > 0 {
+		failed := make([]string, len(summary.Failed))
+		for i, f := range summary.Failed {
+			failed[i] = f.Resource
+		}
+		err = fmt.Errorf("Failed to delete %s in resource group '%s', delete them before trying again\n", strings.Join(failed, ", "), tx.group)
+	}
+	return
+}
+
+// deleteResource deletes a resource of a type the sample creates. Resources that are already gone
+// count as deleted.
+func deleteResource(group, typ, name string, arm arm.Client) error {
+	var r autorest.Response
+	var err error
+
+	switch typ {
+	case groupType:
+		r, err = arm.ResourceGroups().Delete(name)
+	case storageType:
+		r, err = arm.StorageAccounts().Delete(group, name)
+	case avsetType:
+		r, err = arm.AvailabilitySets().Delete(group, name)
+	case nsgType:
+		r, err = arm.NetworkSecurityGroups().Delete(group, name)
+	case lbType:
+		r, err = arm.LoadBalancers().Delete(group, name)
+	case vnetType:
+		r, err = arm.VirtualNetworks().Delete(group, name)
+	case ipType:
+		r, err = arm.PublicIPAddresses().Delete(group, name)
+	case nicType:
+		r, err = arm.NetworkInterfaces().Delete(group, name)
+	case vmType:
+		r, err = arm.VirtualMachines().Delete(group, name)
+	default:
+		return fmt.Errorf("Resources of type %s can't be deleted\n", typ)
+	}
+
+	_, err = exists(r, err)
+	return err
+}
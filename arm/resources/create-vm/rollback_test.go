@@ -0,0 +1,186 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-go-samples/fakes/armfake"
+	"github.com/Azure/azure-go-samples/helpers"
+)
+
+// applyFailing plans and applies a spec, which is expected to fail, and returns the transaction.
+func applyFailing(t *testing.T, spec *vmSpec, srv *armfake.Server) *transaction {
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+	p, err := planProvisioning(spec, client)
+	if err != nil {
+		t.Fatalf("planProvisioning failed: %v", err)
+	}
+	tx := newTransaction(spec.ResourceGroup.Name)
+	if _, err := applyPlan(p, tx, 1, client); err == nil {
+		t.Fatalf("expected applyPlan to fail")
+	}
+	return tx
+}
+
+func TestRollbackNewGroup(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+	srv.Fail("PUT", "/networkInterfaces/", 400, "InvalidRequestFormat", "Cannot parse the request.", false)
+
+	spec := defaultSpec("createvm01", "West US", "vm001", "admin", "foobar1234")
+	tx := applyFailing(t, spec, srv)
+	before := len(calls(srv))
+
+	summary, err := tx.rollback(client)
+	if err != nil {
+		t.Fatalf("rollback failed: %v", err)
+	}
+	if len(summary.Deleted) != 7 || len(summary.Failed) != 0 {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+
+	// The network interface is deleted first, in case ARM kept it although creating it failed, and
+	// the group last.
+	group := "/resourcegroups/createvm01/providers"
+	expected := []string{
+		"DELETE " + group + "/microsoft.network/networkinterfaces/nic01",
+		"DELETE " + group + "/microsoft.network/publicipaddresses/ip01",
+		"DELETE " + group + "/microsoft.network/virtualnetworks/createvm01vnet",
+		"DELETE " + group + "/microsoft.network/networksecuritygroups/createvm01nsg",
+		"DELETE " + group + "/microsoft.compute/availabilitysets/createvm01avset",
+		"DELETE " + group + "/microsoft.storage/storageaccounts/" + spec.StorageAccount.Name,
+		"DELETE /resourcegroups/createvm01",
+	}
+	if c := calls(srv)[before:]; strings.Join(c, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected requests:\n%s\nexpected:\n%s", strings.Join(c, "\n"), strings.Join(expected, "\n"))
+	}
+	if _, ok := srv.Resource("/subscriptions/" + srv.SubscriptionID + "/resourceGroups/createvm01"); ok {
+		t.Errorf("the resource group was not deleted")
+	}
+}
+
+func TestRollbackKeepsExistingResources(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+
+	spec := defaultSpec("createvm01", "West US", "vm001", "admin", "foobar1234")
+	if _, _, err := provisionSpec(spec, provisionOptions{parallel: 1}, client); err != nil {
+		t.Fatalf("provisionSpec failed: %v", err)
+	}
+	existing := srv.ResourceIDs()
+
+	// A second virtual machine is added to the spec, and its network interface fails to deploy.
+	spec.NetworkInterfaces = append(spec.NetworkInterfaces, nicSpec{Name: "nic02", Subnet: spec.NetworkInterfaces[0].Subnet, PublicIPAddress: "ip02"})
+	vm := spec.VirtualMachines[0]
+	vm.Name, vm.NetworkInterface, vm.OsDisk = "vm002", "nic02", "vm002osdisk"
+	spec.VirtualMachines = append(spec.VirtualMachines, vm)
+	srv.Fail("PUT", "/networkInterfaces/nic02", 400, "InvalidRequestFormat", "Cannot parse the request.", false)
+
+	summary, err := applyFailing(t, spec, srv).rollback(client)
+	if err != nil {
+		t.Fatalf("rollback failed: %v", err)
+	}
+	expected := []string{
+		nicType + " 'nic02'",
+		ipType + " 'ip02'",
+	}
+	if strings.Join(summary.Deleted, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected deletions:\n%s\nexpected:\n%s", strings.Join(summary.Deleted, "\n"), strings.Join(expected, "\n"))
+	}
+	if ids := srv.ResourceIDs(); strings.Join(ids, "\n") != strings.Join(existing, "\n") {
+		t.Errorf("unexpected resources:\n%s\nexpected:\n%s", strings.Join(ids, "\n"), strings.Join(existing, "\n"))
+	}
+}
+
+func TestRollbackReportsFailures(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+
+	// The group already exists, so that what fails to delete is left behind.
+	if _, err := createResourceGroup("createvm01", "West US", client); err != nil {
+		t.Fatal(err)
+	}
+	srv.Fail("PUT", "/networkInterfaces/", 400, "InvalidRequestFormat", "Cannot parse the request.", false)
+	srv.Fail("DELETE", "/publicIPAddresses/ip01", 409, "ScopeLocked", "The scope cannot perform delete operation because of a lock.", false)
+
+	spec := defaultSpec("createvm01", "West US", "vm001", "admin", "foobar1234")
+	summary, err := applyFailing(t, spec, srv).rollback(client)
+	if err == nil || !strings.Contains(err.Error(), "Failed to delete "+ipType+" 'ip01' in resource group 'createvm01'") {
+		t.Errorf("expected the rollback to fail, got %v", err)
+	}
+	if len(summary.Failed) != 1 || !strings.Contains(summary.Failed[0].Error, "409") {
+		t.Errorf("unexpected failures %+v", summary.Failed)
+	}
+
+	// The rollback goes on after the failure.
+	if len(summary.Deleted) != 5 || summary.Deleted[4] != storageType+" '"+spec.StorageAccount.Name+"'" {
+		t.Errorf("expected the other resources to be deleted, got %+v", summary.Deleted)
+	}
+	if _, ok := srv.Resource("/subscriptions/" + srv.SubscriptionID + "/resourceGroups/createvm01/providers/Microsoft.Network/publicIPAddresses/ip01"); !ok {
+		t.Errorf("the public IP address was deleted")
+	}
+}
+
+func TestInterruptedTransaction(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+
+	p, err := planProvisioning(defaultSpec("createvm01", "West US", "vm001", "admin", "foobar1234"), client)
+	if err != nil {
+		t.Fatalf("planProvisioning failed: %v", err)
+	}
+	tx := newTransaction("createvm01")
+	tx.interrupt()
+
+	_, err = applyPlan(p, tx, 1, client)
+	if err == nil || !strings.Contains(err.Error(), "interrupted before "+groupType+" 'createvm01'") {
+		t.Errorf("expected provisioning to stop, got %v", err)
+	}
+	if c := calls(srv); len(c) != 0 || len(tx.created) != 0 {
+		t.Errorf("expected nothing to be created, got:\n%s", strings.Join(c, "\n"))
+	}
+}
+
+func TestRollbackLoadBalancer(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+	srv.Fail("PUT", "/networkInterfaces/nic02", 400, "InvalidRequestFormat", "Cannot parse the request.", false)
+
+	os.Setenv("CREATEVM_PASSWORD", "foobar1234")
+	defer os.Unsetenv("CREATEVM_PASSWORD")
+	spec, err := readSpec("specs/balanced.yaml")
+	if err != nil {
+		t.Fatalf("readSpec failed: %v", err)
+	}
+	tx := applyFailing(t, spec, srv)
+	before := len(calls(srv))
+
+	summary, err := tx.rollback(client)
+	if err != nil {
+		t.Fatalf("rollback failed: %v", err)
+	}
+	if len(summary.Failed) != 0 {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+
+	// ARM refuses to delete a load balancer with network interfaces behind it, or its public IP
+	// address while the load balancer uses it.
+	position := make(map[string]int)
+	for i, c := range calls(srv)[before:] {
+		position[c[strings.LastIndex(c, "/")+1:]] = i
+	}
+	for _, name := range []string{"nic01", "nic02", "webip"} {
+		if _, ok := position[name]; !ok {
+			t.Errorf("%s was not deleted", name)
+		}
+	}
+	if position["weblb"] <= position["nic01"] || position["weblb"] <= position["nic02"] || position["webip"] <= position["weblb"] {
+		t.Errorf("unexpected requests:\n%s", strings.Join(calls(srv)[before:], "\n"))
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/Godeps/_workspace/src/github.com/Azure/go-autorest/autorest/to"
+	"github.com/Azure/azure-sdk-for-go/arm"
+	"github.com/Azure/azure-sdk-for-go/arm/network"
+)
+
+// effectiveRule is a security rule filtering the traffic of a virtual machine, from the network
+// security group of its network interface or of its subnet. Default rules are the ones Azure adds
+// to every network security group.
+type effectiveRule struct {
+	VirtualMachine       string `json:"virtualMachine"`
+	AppliesTo            string `json:"appliesTo"`
+	NetworkSecurityGroup string `json:"networkSecurityGroup"`
+	Name                 string `json:"name"`
+	Priority             int    `json:"priority"`
+	Direction            string `json:"direction"`
+	Access               string `json:"access"`
+	Protocol             string `json:"protocol"`
+	Source               string `json:"source"`
+	SourcePorts          string `json:"sourcePorts"`
+	Destination          string `json:"destination"`
+	DestinationPorts     string `json:"destinationPorts"`
+	Default              bool   `json:"default"`
+}
+
+// The levels a network security group filters traffic at.
+const (
+	subnetLevel = "subnet"
+	nicLevel    = "networkInterface"
+)
+
+// effectiveRules reads back the network security groups filtering the traffic of the virtual
+// machines of the spec, and returns their rules in the order Azure evaluates them: inbound traffic
+// goes through the rules of the subnet then of the network interface, outbound traffic the other
+// way round, each by priority. Traffic must be allowed at both levels to get through.
+func effectiveRules(spec *vmSpec, arm arm.Client) ([]effectiveRule, error) {
+	group := spec.ResourceGroup.Name
+
+	vnet, err := arm.VirtualNetworks().Get(group, spec.VirtualNetwork.Name)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read virtual network '%s': '%s'\n", spec.VirtualNetwork.Name, err.Error())
+	}
+	subnets := subnetsByName(vnet)
+
+	nsgs := map[string]network.SecurityGroup{}
+	readNSG := func(ref *network.SecurityGroup) (*network.SecurityGroup, error) {
+		if ref == nil || ref.ID == nil {
+			return nil, nil
+		}
+		name := resourceName(*ref.ID)
+		if nsg, ok := nsgs[name]; ok {
+			return &nsg, nil
+		}
+		nsg, err := arm.NetworkSecurityGroups().Get(group, name)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read network security group '%s': '%s'\n", name, err.Error())
+		}
+		nsgs[name] = nsg
+		return &nsg, nil
+	}
+
+	var rules []effectiveRule
+	for _, m := range spec.VirtualMachines {
+		nic, err := arm.NetworkInterfaces().Get(group, m.NetworkInterface, "")
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read network interface '%s': '%s'\n", m.NetworkInterface, err.Error())
+		}
+
+		var subnetRef, nicRef *network.SecurityGroup
+		if nic.Properties != nil {
+			nicRef = nic.Properties.NetworkSecurityGroup
+			if configs := nic.Properties.IPConfigurations; configs != nil && len(*configs) > 0 {
+				if p := (*configs)[0].Properties; p != nil && p.Subnet != nil && p.Subnet.ID != nil {
+					subnet := subnets[resourceName(*p.Subnet.ID)]
+					if subnet.Properties != nil {
+						subnetRef = subnet.Properties.NetworkSecurityGroup
+					}
+				}
+			}
+		}
+
+		subnetNSG, err := readNSG(subnetRef)
+		if err != nil {
+			return nil, err
+		}
+		nicNSG, err := readNSG(nicRef)
+		if err != nil {
+			return nil, err
+		}
+		subnetRules := securityRules(m.Name, subnetLevel, subnetNSG)
+		nicRules := securityRules(m.Name, nicLevel, nicNSG)
+
+		rules = append(rules, inDirection(subnetRules, "Inbound")...)
+		rules = append(rules, inDirection(nicRules, "Inbound")...)
+		rules = append(rules, inDirection(nicRules, "Outbound")...)
+		rules = append(rules, inDirection(subnetRules, "Outbound")...)
+	}
+	return rules, nil
+}
+
+// securityRules returns the rules of a network security group, including the default ones, by
+// priority.
+func securityRules(vmName, level string, nsg *network.SecurityGroup) []effectiveRule {
+	if nsg == nil || nsg.Properties == nil {
+		return nil
+	}
+
+	var rules []effectiveRule
+	for i, list := range []*[]network.SecurityRule{nsg.Properties.SecurityRules, nsg.Properties.DefaultSecurityRules} {
+		if list == nil {
+			continue
+		}
+		for _, r := range *list {
+			if r.Properties == nil {
+				continue
+			}
+			p := r.Properties
+			rules = append(rules, effectiveRule{
+				VirtualMachine:       vmName,
+				AppliesTo:            level,
+				NetworkSecurityGroup: to.String(nsg.Name),
+				Name:                 to.String(r.Name),
+				Priority:             to.Int(p.Priority),
+				Direction:            string(p.Direction),
+				Access:               string(p.Access),
+				Protocol:             string(p.Protocol),
+				Source:               to.String(p.SourceAddressPrefix),
+				SourcePorts:          to.String(p.SourcePortRange),
+				Destination:          to.String(p.DestinationAddressPrefix),
+				DestinationPorts:     to.String(p.DestinationPortRange),
+				Default:              i == 1,
+			})
+		}
+	}
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].Priority < rules[j].Priority })
+	return rules
+}
+
+func inDirection(rules []effectiveRule, direction string) []effectiveRule {
+	var in []effectiveRule
+	for _, r := range rules {
+		if strings.EqualFold(r.Direction, direction) {
+			in = append(in, r)
+		}
+	}
+	return in
+}
+
+// resourceName returns the name at the end of a resource ID.
+func resourceName(id string) string {
+	return id[strings.LastIndex(id, "/")+1:]
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/Azure/azure-go-samples/fakes/armfake"
+	"github.com/Azure/azure-go-samples/helpers"
+)
+
+func TestEffectiveRules(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+
+	var doc map[string]interface{}
+	json.Unmarshal([]byte(`{"resourceGroup": {"name": "g", "location": "West US"},
+	  "virtualNetwork": {"subnets": [{"name": "front", "addressPrefix": "10.0.0.0/24", "networkSecurityGroup": "web"}]},
+	  "networkSecurityGroups": [
+	    {"name": "web", "rules": [
+	      {"name": "https", "priority": 200, "sourceAddressPrefix": "Internet", "destinationPortRange": "443"},
+	      {"name": "rdp", "priority": 100, "sourceAddressPrefix": "caller", "destinationPortRange": "3389"}
+	    ]},
+	    {"name": "vm", "rules": [{"name": "no-smtp", "priority": 100, "direction": "Outbound", "access": "Deny", "sourceAddressPrefix": "*", "destinationPortRange": "25"}]}
+	  ],
+	  "networkInterfaces": [{"name": "nic", "subnet": "front", "publicIPAddress": "ip", "networkSecurityGroup": "vm"}],
+	  "virtualMachines": [{"name": "web01", "adminUsername": "a", "adminPassword": "p"}]}`), &doc)
+	spec, err := parseSpec(doc)
+	if err != nil {
+		t.Fatalf("parseSpec failed: %v", err)
+	}
+	if _, _, err := provisionSpec(spec, provisionOptions{parallel: 1}, client); err != nil {
+		t.Fatalf("provisionSpec failed: %v", err)
+	}
+
+	rules, err := effectiveRules(spec, client)
+	if err != nil {
+		t.Fatalf("effectiveRules failed: %v", err)
+	}
+	var got []string
+	for _, r := range rules {
+		got = append(got, fmt.Sprintf("%s %s %s/%s %d %s %s %s:%s", r.VirtualMachine, r.Direction, r.AppliesTo, r.NetworkSecurityGroup, r.Priority, r.Name, r.Access, r.Source, r.DestinationPorts))
+	}
+	expected := []string{
+		"web01 Inbound subnet/web 100 rdp Allow 203.0.113.10:3389",
+		"web01 Inbound subnet/web 200 https Allow Internet:443",
+		"web01 Inbound subnet/web 65000 AllowVnetInBound Allow VirtualNetwork:*",
+		"web01 Inbound subnet/web 65001 AllowAzureLoadBalancerInBound Allow AzureLoadBalancer:*",
+		"web01 Inbound subnet/web 65500 DenyAllInBound Deny *:*",
+		"web01 Inbound networkInterface/vm 65000 AllowVnetInBound Allow VirtualNetwork:*",
+		"web01 Inbound networkInterface/vm 65001 AllowAzureLoadBalancerInBound Allow AzureLoadBalancer:*",
+		"web01 Inbound networkInterface/vm 65500 DenyAllInBound Deny *:*",
+		"web01 Outbound networkInterface/vm 100 no-smtp Deny *:25",
+		"web01 Outbound networkInterface/vm 65000 AllowVnetOutBound Allow VirtualNetwork:*",
+		"web01 Outbound networkInterface/vm 65001 AllowInternetOutBound Allow *:*",
+		"web01 Outbound networkInterface/vm 65500 DenyAllOutBound Deny *:*",
+		"web01 Outbound subnet/web 65000 AllowVnetOutBound Allow VirtualNetwork:*",
+		"web01 Outbound subnet/web 65001 AllowInternetOutBound Allow *:*",
+		"web01 Outbound subnet/web 65500 DenyAllOutBound Deny *:*",
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d rules, got:\n%s", len(expected), helpers.ToJSON(got))
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("rule %d: expected '%s', got '%s'", i, expected[i], got[i])
+		}
+	}
+	if !rules[2].Default || rules[1].Default {
+		t.Errorf("expected only the rules Azure adds to be marked as default")
+	}
+}
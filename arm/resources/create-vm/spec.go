@@ -0,0 +1,1240 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-go-samples/helpers"
+	"github.com/Azure/azure-sdk-for-go/Godeps/_workspace/src/github.com/Azure/go-autorest/autorest/to"
+	"github.com/Azure/azure-sdk-for-go/arm/resources/resources"
+)
+
+// vmSpec describes the resources the sample provisions: a resource group holding a storage
+// account for the disks, an availability set, a virtual network with its subnets, network security
+// groups filtering their traffic, optionally a load balancer in front of the availability set, and
+// any number of network interfaces and virtual machines. It is read from a YAML or JSON file, see
+// specs/createvm01.yaml; everything but the group, its location and the virtual machines'
+// credentials has a default.
+type vmSpec struct {
+	ResourceGroup         groupSpec     `json:"resourceGroup"`
+	StorageAccount        storageSpec   `json:"storageAccount"`
+	AvailabilitySet       avsetSpec     `json:"availabilitySet"`
+	VirtualNetwork        vnetSpec      `json:"virtualNetwork"`
+	NetworkSecurityGroups []nsgSpec     `json:"networkSecurityGroups"`
+	CallerIP              string        `json:"callerIP"`
+	LoadBalancer          *lbSpec       `json:"loadBalancer"`
+	NetworkInterfaces     []nicSpec     `json:"networkInterfaces"`
+	VirtualMachines       []machineSpec `json:"virtualMachines"`
+}
+
+type groupSpec struct {
+	Name     string `json:"name"`
+	Location string `json:"location"`
+}
+
+// storageSpec describes the storage account holding the disks. Its name defaults to one derived
+// from the group name, see storageAccountName.
+type storageSpec struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type avsetSpec struct {
+	Name string `json:"name"`
+}
+
+type vnetSpec struct {
+	Name            string       `json:"name"`
+	AddressPrefixes []string     `json:"addressPrefixes"`
+	Subnets         []subnetSpec `json:"subnets"`
+}
+
+// subnetSpec describes a subnet, with the network security group filtering the traffic of all
+// its network interfaces, if any.
+type subnetSpec struct {
+	Name                 string `json:"name"`
+	AddressPrefix        string `json:"addressPrefix"`
+	NetworkSecurityGroup string `json:"networkSecurityGroup"`
+}
+
+// nsgSpec describes a network security group, whose rules filter the traffic of the subnets and
+// network interfaces associated with it. Network interfaces with a public IP address get the
+// default one, see defaultNSG, unless they or their subnet are associated with another.
+type nsgSpec struct {
+	Name  string     `json:"name"`
+	Rules []ruleSpec `json:"rules"`
+
+	// generated marks the default network security group.
+	generated bool
+}
+
+// ruleSpec describes a security rule, which allows or denies the traffic to a range of ports from
+// an address prefix. Rules are evaluated in the order of their priority, from 100 to 4096, until
+// one matches; the traffic no rule matches is denied when inbound, except from the virtual network
+// and the load balancer, and allowed when outbound. The source can be "caller", the public IP
+// address of the machine running the sample.
+type ruleSpec struct {
+	Name                     string `json:"name"`
+	Priority                 int    `json:"priority"`
+	Direction                string `json:"direction"`
+	Access                   string `json:"access"`
+	Protocol                 string `json:"protocol"`
+	SourceAddressPrefix      string `json:"sourceAddressPrefix"`
+	DestinationAddressPrefix string `json:"destinationAddressPrefix"`
+	DestinationPortRange     string `json:"destinationPortRange"`
+}
+
+// lbSpec describes a public load balancer in front of the virtual machines of the availability set.
+// Its frontend is a public IP address, and its backend pool holds the network interfaces of all the
+// virtual machines. Rules spread the connections to a frontend port among the virtual machines the
+// probes find healthy, and NAT rules forward a frontend port to a single one.
+type lbSpec struct {
+	Name            string        `json:"name"`
+	PublicIPAddress string        `json:"publicIPAddress"`
+	Probes          []probeSpec   `json:"probes"`
+	Rules           []lbRuleSpec  `json:"rules"`
+	NatRules        []natRuleSpec `json:"natRules"`
+}
+
+// probeSpec describes a health probe, which checks every IntervalInSeconds that each virtual
+// machine accepts connections on a port or, with Http, answers 200 to a GET of RequestPath. A
+// virtual machine failing NumberOfProbes probes in a row gets no new connections.
+type probeSpec struct {
+	Name              string `json:"name"`
+	Protocol          string `json:"protocol"`
+	Port              int    `json:"port"`
+	RequestPath       string `json:"requestPath"`
+	IntervalInSeconds int    `json:"intervalInSeconds"`
+	NumberOfProbes    int    `json:"numberOfProbes"`
+}
+
+// lbRuleSpec describes a load-balancing rule, spreading the connections to a frontend port among
+// the healthy virtual machines, on the backend port, which defaults to the frontend one. The
+// probe defaults to the first one.
+type lbRuleSpec struct {
+	Name                 string `json:"name"`
+	Protocol             string `json:"protocol"`
+	FrontendPort         int    `json:"frontendPort"`
+	BackendPort          int    `json:"backendPort"`
+	Probe                string `json:"probe"`
+	LoadDistribution     string `json:"loadDistribution"`
+	IdleTimeoutInMinutes int    `json:"idleTimeoutInMinutes"`
+}
+
+// natRuleSpec describes an inbound NAT rule, forwarding a frontend port to the backend port of one
+// network interface. Without NAT rules, each virtual machine gets one to log in, see
+// defaultNatRules.
+type natRuleSpec struct {
+	Name             string `json:"name"`
+	Protocol         string `json:"protocol"`
+	FrontendPort     int    `json:"frontendPort"`
+	BackendPort      int    `json:"backendPort"`
+	NetworkInterface string `json:"networkInterface"`
+
+	// generated marks the default NAT rules.
+	generated bool
+}
+
+// nicSpec describes a network interface in one of the subnets. The public IP address is only
+// created when named.
+type nicSpec struct {
+	Name                 string `json:"name"`
+	Subnet               string `json:"subnet"`
+	PublicIPAddress      string `json:"publicIPAddress"`
+	NetworkSecurityGroup string `json:"networkSecurityGroup"`
+}
+
+// machineSpec describes a virtual machine, running Windows or Linux. The admin of a Windows virtual
+// machine logs in with a password, given either in the spec or, better, in the environment
+// variable named by AdminPasswordEnv; Password holds the one in use. The admin of a Linux virtual
+// machine logs in with an SSH key instead: the public key is read from SSHPublicKeyFile or, when
+// there is none, taken from the key pair saved in SSHPrivateKeyFile, which is generated the first
+// time. CloudInit names a cloud-init file, passed to a Linux virtual machine as its custom data.
+// The paths are relative to the directory of the spec; SSHPublicKey and CustomData hold what
+// loadFiles reads from them. A key pair that doesn't exist yet is only generated when the spec is
+// applied, see generateKeys: until then, SSHPublicKey is pendingSSHKey and keyToGenerate the path
+// of the private key.
+type machineSpec struct {
+	Name              string    `json:"name"`
+	OS                string    `json:"os"`
+	Size              string    `json:"size"`
+	NetworkInterface  string    `json:"networkInterface"`
+	AdminUsername     string    `json:"adminUsername"`
+	AdminPassword     string    `json:"adminPassword"`
+	AdminPasswordEnv  string    `json:"adminPasswordEnv"`
+	SSHPublicKeyFile  string    `json:"sshPublicKeyFile"`
+	SSHPrivateKeyFile string    `json:"sshPrivateKeyFile"`
+	CloudInit         string    `json:"cloudInit"`
+	Image             imageSpec `json:"image"`
+	OsDisk            string    `json:"osDisk"`
+
+	Password      string `json:"-"`
+	SSHPublicKey  string `json:"-"`
+	CustomData    string `json:"-"`
+	keyToGenerate string `json:"-"`
+}
+
+// pendingSSHKey stands for the public key of a key pair generateKeys has yet to create, so that
+// planning doesn't write any file.
+const pendingSSHKey = "(generated on apply)"
+
+type imageSpec struct {
+	Publisher string `json:"publisher"`
+	Offer     string `json:"offer"`
+	Sku       string `json:"sku"`
+	Version   string `json:"version"`
+}
+
+// The defaults of the spec, which are what the sample always created before it took one.
+var (
+	defaultStorageType   = "Standard_LRS"
+	defaultAddressPrefix = "10.0.0.0/16"
+	defaultSubnetPrefix  = "10.0.0.0/24"
+	defaultVMSize        = "Standard_A0"
+	defaultImage         = imageSpec{Publisher: "MicrosoftWindowsServer", Offer: "WindowsServer", Sku: "2012-R2-Datacenter", Version: "latest"}
+	defaultLinuxImage    = imageSpec{Publisher: "Canonical", Offer: "UbuntuServer", Sku: "14.04.3-LTS", Version: "latest"}
+)
+
+// The operating systems a virtual machine can run.
+const (
+	windowsOS = "Windows"
+	linuxOS   = "Linux"
+)
+
+// reservedLinuxUsers are the admin user names Azure refuses for Linux virtual machines.
+var reservedLinuxUsers = []string{"admin", "administrator", "root", "guest", "user", "test", "sys", "daemon", "bin", "nobody"}
+
+// maxCustomData is the most custom data Azure passes to a virtual machine, before encoding.
+const maxCustomData = 64 * 1024
+
+// The values of the fields of a security rule, the first of each being the default.
+var (
+	ruleDirections = []string{"Inbound", "Outbound"}
+	ruleAccesses   = []string{"Allow", "Deny"}
+	ruleProtocols  = []string{"Tcp", "Udp", "*"}
+)
+
+// callerSource stands for the public IP address of the machine running the sample, in the source
+// of a security rule. addressTags are the names Azure gives to groups of addresses.
+var (
+	callerSource = "caller"
+	addressTags  = []string{"*", "Internet", "VirtualNetwork", "AzureLoadBalancer"}
+)
+
+// remoteAccessRules are the rules of the default network security group, which only let the
+// caller log in to the virtual machines, with Remote Desktop on Windows and SSH on Linux.
+var remoteAccessRules = map[string]ruleSpec{
+	windowsOS: {Name: "allow-rdp", Priority: 1000, DestinationPortRange: "3389"},
+	linuxOS:   {Name: "allow-ssh", Priority: 1010, DestinationPortRange: "22"},
+}
+
+// The values of the fields of the load balancer, the first of each being the default, and the
+// defaults of the numbers. The default NAT rules forward the ports from natPortBase+1 on.
+var (
+	lbProtocols       = []string{"Tcp", "Udp"}
+	probeProtocols    = []string{"Tcp", "Http"}
+	loadDistributions = []string{"Default", "SourceIP", "SourceIPProtocol"}
+
+	defaultProbeInterval = 15
+	defaultProbeCount    = 2
+	defaultIdleTimeout   = 4
+	natPortBase          = 50000
+)
+
+// storageTypes are the replication types a storage account can be created with.
+var storageTypes = []string{"Standard_LRS", "Standard_ZRS", "Standard_GRS", "Standard_RAGRS", "Premium_LRS"}
+
+// defaultSpec returns the spec of a group with one virtual machine, with the names the sample
+// has always used.
+func defaultSpec(groupName, groupLocation, vmName, adminName, adminPassword string) *vmSpec {
+	spec := &vmSpec{
+		ResourceGroup: groupSpec{Name: groupName, Location: groupLocation},
+		VirtualMachines: []machineSpec{{
+			Name:          vmName,
+			AdminUsername: adminName,
+			AdminPassword: adminPassword,
+			OsDisk:        "mytestod1",
+		}},
+	}
+	spec.complete()
+	return spec
+}
+
+// specError lists every problem found in a spec, each prefixed with the path of the field.
+type specError []string
+
+func (e specError) Error() string {
+	return strings.Join(e, "\n")
+}
+
+// readSpec reads a spec from a YAML or JSON file, fills in its defaults and validates it.
+func readSpec(fileName string) (*vmSpec, error) {
+	doc, err := helpers.ReadDocument(fileName)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := parseSpec(doc)
+	if err == nil {
+		err = spec.loadFiles(filepath.Dir(fileName))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: %s is not a valid spec:\n%v", fileName, err)
+	}
+	return spec, nil
+}
+
+// parseSpec turns a document into a spec, fills in its defaults and validates it. The error is a
+// specError.
+func parseSpec(doc map[string]interface{}) (*vmSpec, error) {
+	var problems specError
+	checkFields(doc, reflect.TypeOf(vmSpec{}), "", &problems)
+	if len(problems) > 0 {
+		return nil, problems
+	}
+
+	// The document only holds the types checkFields allows, which always decode.
+	var spec vmSpec
+	b, err := json.Marshal(doc)
+	if err == nil {
+		err = json.Unmarshal(b, &spec)
+	}
+	if err != nil {
+		return nil, specError{err.Error()}
+	}
+
+	spec.complete()
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+	return &spec, nil
+}
+
+// loadFiles reads the SSH public keys and the cloud-init files of the Linux virtual machines, with
+// paths relative to dir, and leaves the SSH keys that don't exist yet to generateKeys. The error is
+// a specError.
+func (s *vmSpec) loadFiles(dir string) error {
+	var problems specError
+	report := func(path string, err error) {
+		problems = append(problems, path+": "+strings.TrimPrefix(err.Error(), "ERROR: "))
+	}
+
+	for i := range s.VirtualMachines {
+		vm := &s.VirtualMachines[i]
+		path := fmt.Sprintf("virtualMachines[%d]", i)
+		if vm.OS != linuxOS {
+			continue
+		}
+
+		if vm.SSHPublicKeyFile != "" {
+			key, err := helpers.ReadSSHPublicKey(relativeTo(dir, vm.SSHPublicKeyFile))
+			if err != nil {
+				report(path+".sshPublicKeyFile", err)
+			}
+			vm.SSHPublicKey = key
+		} else {
+			file := relativeTo(dir, vm.SSHPrivateKeyFile)
+			if _, err := os.Stat(file); os.IsNotExist(err) {
+				vm.SSHPublicKey, vm.keyToGenerate = pendingSSHKey, file
+			} else {
+				key, _, err := helpers.GenerateSSHKey(file)
+				if err != nil {
+					report(path+".sshPrivateKeyFile", err)
+				}
+				vm.SSHPublicKey = key
+			}
+		}
+
+		if vm.CloudInit != "" {
+			b, err := ioutil.ReadFile(relativeTo(dir, vm.CloudInit))
+			if err != nil {
+				report(path+".cloudInit", err)
+				continue
+			}
+			if len(b) > maxCustomData {
+				report(path+".cloudInit", fmt.Errorf("%s has %d bytes, more than the %d Azure passes to a virtual machine", vm.CloudInit, len(b), maxCustomData))
+				continue
+			}
+			vm.CustomData = base64.StdEncoding.EncodeToString(b)
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return problems
+}
+
+// generateKeys creates the key pairs loadFiles found missing, and gives their public keys to the
+// virtual machines.
+func (s *vmSpec) generateKeys() error {
+	for i := range s.VirtualMachines {
+		vm := &s.VirtualMachines[i]
+		if vm.keyToGenerate == "" {
+			continue
+		}
+		key, generated, err := helpers.GenerateSSHKey(vm.keyToGenerate)
+		if err != nil {
+			return fmt.Errorf("Failed to create the SSH key of virtual machine '%s': '%s'\n", vm.Name, err.Error())
+		}
+		if generated {
+			helpers.Logf("Generated an SSH key for virtual machine '%s', the private key is saved in %s\n", vm.Name, vm.keyToGenerate)
+		}
+		vm.SSHPublicKey, vm.keyToGenerate = key, ""
+	}
+	return nil
+}
+
+func relativeTo(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// withOS makes the virtual machines of a spec built by the sample, such as the default one, run
+// another operating system, with the default image for it. Linux virtual machines get the admin
+// azureuser, since the usual admin names are reserved on Linux, and log in with the SSH public key
+// in sshKeyFile or, without one, a key generated in the current directory. The cloud-init file, if
+// any, is passed to them.
+func (s *vmSpec) withOS(osName, sshKeyFile, cloudInit string) error {
+	if osName == "" {
+		osName = windowsOS
+	}
+	for i := range s.VirtualMachines {
+		vm := &s.VirtualMachines[i]
+		vm.OS, vm.Image = osName, imageSpec{}
+		vm.SSHPublicKeyFile, vm.SSHPrivateKeyFile, vm.CloudInit = sshKeyFile, "", cloudInit
+		if strings.EqualFold(osName, linuxOS) {
+			vm.AdminUsername, vm.AdminPassword, vm.AdminPasswordEnv = "azureuser", "", ""
+		}
+	}
+
+	// The default network security group lets the admin in for the previous OS.
+	var nsgs []nsgSpec
+	for _, nsg := range s.NetworkSecurityGroups {
+		if !nsg.generated {
+			nsgs = append(nsgs, nsg)
+			continue
+		}
+		for i := range s.NetworkInterfaces {
+			if s.NetworkInterfaces[i].NetworkSecurityGroup == nsg.Name {
+				s.NetworkInterfaces[i].NetworkSecurityGroup = ""
+			}
+		}
+	}
+	s.NetworkSecurityGroups = nsgs
+
+	s.complete()
+	err := s.validate()
+	if err == nil {
+		err = s.loadFiles("")
+	}
+	if err != nil {
+		return fmt.Errorf("ERROR: The virtual machines can't run %s:\n%v", osName, err)
+	}
+	return nil
+}
+
+// withCount replaces the virtual machine of a spec with 'count' copies named by a pattern, such as
+// "vm%03d", which is given the numbers from 1. Each copy gets its own network interface, named
+// nic01, nic02..., in the subnet of the original, with a public IP address, named ip01, ip02...,
+// if the original has one, in the network security group of the original, and its own OS disk.
+// Behind a load balancer, the copies join its backend pool, and get default NAT rules like the
+// original.
+// The copy the pattern gives the name of the original is the original, with its network interface
+// and disk, so that adding virtual machines leaves the existing one as it is. A pattern that doesn't
+// give any copy that name is rejected, since the original would be dropped.
+func (s *vmSpec) withCount(count int, pattern string) error {
+	if len(s.VirtualMachines) != 1 {
+		return fmt.Errorf("ERROR: --count copies the virtual machine of a spec, which has %d\n", len(s.VirtualMachines))
+	}
+	if count < 1 {
+		return fmt.Errorf("ERROR: --count must be at least 1, not %d\n", count)
+	}
+	if first, second := fmt.Sprintf(pattern, 1), fmt.Sprintf(pattern, 2); first == second || strings.Contains(first, "%!") {
+		return fmt.Errorf("ERROR: The name pattern '%s' must contain one number verb, such as %%03d\n", pattern)
+	}
+
+	original := s.VirtualMachines[0]
+	kept := false
+	for i := 1; i <= count && !kept; i++ {
+		kept = strings.EqualFold(fmt.Sprintf(pattern, i), original.Name)
+	}
+	if !kept {
+		return fmt.Errorf("ERROR: None of the %d names given by '%s' is '%s', the name of the existing virtual machine; use a --name-pattern that includes it\n", count, pattern, original.Name)
+	}
+
+	var nics []nicSpec
+	var template nicSpec
+	for _, n := range s.NetworkInterfaces {
+		if n.Name == original.NetworkInterface {
+			template = n
+		} else {
+			nics = append(nics, n)
+		}
+	}
+
+	var vms []machineSpec
+	for i := 1; i <= count; i++ {
+		vm := original
+		vm.Name = fmt.Sprintf(pattern, i)
+		if !strings.EqualFold(vm.Name, original.Name) {
+			nic := nicSpec{Name: fmt.Sprintf("nic%02d", i), Subnet: template.Subnet, NetworkSecurityGroup: template.NetworkSecurityGroup}
+			if template.PublicIPAddress != "" {
+				nic.PublicIPAddress = fmt.Sprintf("ip%02d", i)
+			}
+			nics = append(nics, nic)
+			vm.NetworkInterface = nic.Name
+			vm.OsDisk = vm.Name + "osdisk"
+		} else {
+			nics = append(nics, template)
+		}
+		vms = append(vms, vm)
+	}
+
+	s.NetworkInterfaces, s.VirtualMachines = nics, vms
+	if s.LoadBalancer != nil {
+		s.defaultNatRules()
+	}
+	if err := s.validate(); err != nil {
+		return fmt.Errorf("ERROR: The virtual machines named by '%s' are not valid:\n%v", pattern, err)
+	}
+	return nil
+}
+
+// checkFields reports the fields of a document that aren't part of the spec, or don't have the
+// type the spec expects. Values that YAML reads as numbers or booleans need quoting where the
+// spec expects a string, which is reported rather than silently converted: a password of 0123
+// would become 123.
+func checkFields(v interface{}, t reflect.Type, path string, problems *specError) {
+	if v == nil {
+		return
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		checkFields(v, t.Elem(), path, problems)
+	case reflect.Struct:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			*problems = append(*problems, fmt.Sprintf("%s: expected a mapping", specPath(path, "")))
+			return
+		}
+		fields := map[string]reflect.StructField{}
+		for i := 0; i < t.NumField(); i++ {
+			if tag := t.Field(i).Tag.Get("json"); tag != "-" {
+				fields[tag] = t.Field(i)
+			}
+		}
+		for _, key := range sortedKeys(m) {
+			f, ok := fields[key]
+			if !ok {
+				*problems = append(*problems, fmt.Sprintf("%s: unknown field", specPath(path, key)))
+				continue
+			}
+			checkFields(m[key], f.Type, specPath(path, key), problems)
+		}
+	case reflect.Slice:
+		items, ok := v.([]interface{})
+		if !ok {
+			*problems = append(*problems, fmt.Sprintf("%s: expected a list", path))
+			return
+		}
+		for i, item := range items {
+			checkFields(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), problems)
+		}
+	case reflect.String:
+		if _, ok := v.(string); !ok {
+			*problems = append(*problems, fmt.Sprintf("%s: expected a string, quote %v", path, v))
+		}
+	case reflect.Int:
+		switch n := v.(type) {
+		case int64:
+		case float64:
+			if n != float64(int64(n)) {
+				*problems = append(*problems, fmt.Sprintf("%s: expected a whole number, not %v", path, v))
+			}
+		default:
+			*problems = append(*problems, fmt.Sprintf("%s: expected a number, not %v", path, v))
+		}
+	}
+}
+
+func specPath(path, key string) string {
+	switch {
+	case path == "":
+		if key == "" {
+			return "spec"
+		}
+		return key
+	case key == "":
+		return path
+	}
+	return path + "." + key
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// complete fills in the defaults of the spec. The network interfaces default to one per virtual
+// machine, named like those of the original sample, with a public IP address unless the virtual
+// machines are behind a load balancer, and each virtual machine defaults to the network interface
+// at its position.
+func (s *vmSpec) complete() {
+	group := s.ResourceGroup.Name
+
+	if s.StorageAccount.Type == "" {
+		s.StorageAccount.Type = defaultStorageType
+	}
+	if s.StorageAccount.Name == "" && group != "" {
+		// An invalid group name is reported by validate.
+		s.StorageAccount.Name, _ = storageAccountName(resources.ResourceGroup{Name: to.StringPtr(group)})
+	}
+	if s.AvailabilitySet.Name == "" {
+		s.AvailabilitySet.Name = group + "avset"
+	}
+
+	vnet := &s.VirtualNetwork
+	if vnet.Name == "" {
+		vnet.Name = group + "vnet"
+	}
+	if len(vnet.AddressPrefixes) == 0 {
+		vnet.AddressPrefixes = []string{defaultAddressPrefix}
+	}
+	if len(vnet.Subnets) == 0 {
+		vnet.Subnets = []subnetSpec{{Name: group + "subnet", AddressPrefix: defaultSubnetPrefix}}
+	}
+
+	if len(s.NetworkInterfaces) == 0 {
+		for i := range s.VirtualMachines {
+			nic := nicSpec{Name: fmt.Sprintf("nic%02d", i+1)}
+			if s.LoadBalancer == nil {
+				nic.PublicIPAddress = fmt.Sprintf("ip%02d", i+1)
+			}
+			s.NetworkInterfaces = append(s.NetworkInterfaces, nic)
+		}
+	}
+	for i := range s.NetworkInterfaces {
+		if s.NetworkInterfaces[i].Subnet == "" {
+			s.NetworkInterfaces[i].Subnet = vnet.Subnets[0].Name
+		}
+	}
+
+	for i := range s.VirtualMachines {
+		vm := &s.VirtualMachines[i]
+		switch {
+		case vm.OS == "":
+			vm.OS = windowsOS
+		case strings.EqualFold(vm.OS, windowsOS):
+			vm.OS = windowsOS
+		case strings.EqualFold(vm.OS, linuxOS):
+			vm.OS = linuxOS
+		}
+		if vm.Size == "" {
+			vm.Size = defaultVMSize
+		}
+		if vm.NetworkInterface == "" && i < len(s.NetworkInterfaces) {
+			vm.NetworkInterface = s.NetworkInterfaces[i].Name
+		}
+		if vm.Image == (imageSpec{}) {
+			vm.Image = defaultImage
+			if vm.OS == linuxOS {
+				vm.Image = defaultLinuxImage
+			}
+		}
+		if vm.OS == linuxOS && vm.SSHPublicKeyFile == "" && vm.SSHPrivateKeyFile == "" {
+			vm.SSHPrivateKeyFile = vm.Name + "_id_rsa"
+		}
+		if vm.OsDisk == "" {
+			vm.OsDisk = vm.Name + "osdisk"
+		}
+		vm.Password = vm.AdminPassword
+		if vm.AdminPasswordEnv != "" {
+			vm.Password = os.Getenv(vm.AdminPasswordEnv)
+		}
+	}
+
+	if lb := s.LoadBalancer; lb != nil {
+		if lb.Name == "" {
+			lb.Name = group + "lb"
+		}
+		if lb.PublicIPAddress == "" {
+			lb.PublicIPAddress = group + "lbip"
+		}
+		for i := range lb.Probes {
+			p := &lb.Probes[i]
+			if p.Protocol == "" && p.RequestPath != "" {
+				p.Protocol = "Http"
+			}
+			p.Protocol = canonical(probeProtocols, p.Protocol)
+			if p.IntervalInSeconds == 0 {
+				p.IntervalInSeconds = defaultProbeInterval
+			}
+			if p.NumberOfProbes == 0 {
+				p.NumberOfProbes = defaultProbeCount
+			}
+		}
+		for i := range lb.Rules {
+			r := &lb.Rules[i]
+			r.Protocol = canonical(lbProtocols, r.Protocol)
+			r.LoadDistribution = canonical(loadDistributions, r.LoadDistribution)
+			if r.BackendPort == 0 {
+				r.BackendPort = r.FrontendPort
+			}
+			if r.Probe == "" && len(lb.Probes) > 0 {
+				r.Probe = lb.Probes[0].Name
+			}
+			if r.IdleTimeoutInMinutes == 0 {
+				r.IdleTimeoutInMinutes = defaultIdleTimeout
+			}
+		}
+		for i := range lb.NatRules {
+			lb.NatRules[i].Protocol = canonical(lbProtocols, lb.NatRules[i].Protocol)
+		}
+		s.defaultNatRules()
+	}
+
+	for i := range s.NetworkSecurityGroups {
+		for j := range s.NetworkSecurityGroups[i].Rules {
+			r := &s.NetworkSecurityGroups[i].Rules[j]
+			r.Direction = canonical(ruleDirections, r.Direction)
+			r.Access = canonical(ruleAccesses, r.Access)
+			r.Protocol = canonical(ruleProtocols, r.Protocol)
+			if r.DestinationAddressPrefix == "" {
+				r.DestinationAddressPrefix = "*"
+			}
+		}
+	}
+	s.defaultNSG()
+}
+
+// allowsCaller reports whether some security rule has the caller as its source.
+func (s *vmSpec) allowsCaller() bool {
+	for _, nsg := range s.NetworkSecurityGroups {
+		for _, r := range nsg.Rules {
+			if strings.EqualFold(r.SourceAddressPrefix, callerSource) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// canonical returns the value of a list matching s, ignoring case, or the first value when s is
+// empty. Values that don't match are returned as they are, for validate to report.
+func canonical(values []string, s string) string {
+	if s == "" {
+		return values[0]
+	}
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return v
+		}
+	}
+	return s
+}
+
+// defaultNatRules lets the caller log in to each virtual machine behind the load balancer, unless
+// the spec has NAT rules of its own: the ports from natPortBase+1 on are forwarded to Remote
+// Desktop on Windows and SSH on Linux. The default rules are replaced, so that they follow the
+// virtual machines and their OS.
+func (s *vmSpec) defaultNatRules() {
+	lb := s.LoadBalancer
+	var rules []natRuleSpec
+	for _, r := range lb.NatRules {
+		if !r.generated {
+			rules = append(rules, r)
+		}
+	}
+	if len(rules) == 0 {
+		for i, vm := range s.VirtualMachines {
+			access := remoteAccessRules[windowsOS]
+			if vm.OS == linuxOS {
+				access = remoteAccessRules[linuxOS]
+			}
+			port, _ := strconv.Atoi(access.DestinationPortRange)
+			rules = append(rules, natRuleSpec{
+				Name:             vm.Name + strings.TrimPrefix(access.Name, "allow"),
+				Protocol:         lbProtocols[0],
+				FrontendPort:     natPortBase + i + 1,
+				BackendPort:      port,
+				NetworkInterface: vm.NetworkInterface,
+				generated:        true,
+			})
+		}
+	}
+	lb.NatRules = rules
+}
+
+// behindLoadBalancer reports whether a network interface is in the backend pool of the load
+// balancer, which holds those of all the virtual machines.
+func (s *vmSpec) behindLoadBalancer(nic string) bool {
+	if s.LoadBalancer == nil {
+		return false
+	}
+	for _, vm := range s.VirtualMachines {
+		if vm.NetworkInterface == nic {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultNSG associates the network interfaces reachable from the Internet, through a public IP
+// address or the load balancer, which neither they nor their subnet have a network security group
+// for, with the default one, named after the group. It only lets the caller log in to the virtual
+// machines of those network interfaces: a rule allows Remote Desktop when some run Windows, and
+// another SSH when some run Linux. Behind the load balancer, a rule also lets the Internet in on
+// the backend port of each load-balancing rule.
+func (s *vmSpec) defaultNSG() {
+	subnetNSGs := map[string]string{}
+	for _, snet := range s.VirtualNetwork.Subnets {
+		subnetNSGs[snet.Name] = snet.NetworkSecurityGroup
+	}
+	osOf := map[string]string{}
+	for _, vm := range s.VirtualMachines {
+		osOf[vm.NetworkInterface] = vm.OS
+	}
+
+	nsg := nsgSpec{Name: s.ResourceGroup.Name + "nsg", generated: true}
+	needed := map[string]bool{}
+	for i := range s.NetworkInterfaces {
+		nic := &s.NetworkInterfaces[i]
+		if nic.PublicIPAddress == "" && !s.behindLoadBalancer(nic.Name) || nic.NetworkSecurityGroup != "" || subnetNSGs[nic.Subnet] != "" {
+			continue
+		}
+		nic.NetworkSecurityGroup = nsg.Name
+		if osName := osOf[nic.Name]; osName == linuxOS {
+			needed[linuxOS] = true
+		} else {
+			needed[windowsOS] = true
+		}
+	}
+	if len(needed) == 0 {
+		return
+	}
+
+	for _, osName := range []string{windowsOS, linuxOS} {
+		if needed[osName] {
+			r := remoteAccessRules[osName]
+			r.Direction, r.Access, r.Protocol = ruleDirections[0], ruleAccesses[0], ruleProtocols[0]
+			r.SourceAddressPrefix, r.DestinationAddressPrefix = callerSource, "*"
+			nsg.Rules = append(nsg.Rules, r)
+		}
+	}
+	if lb := s.LoadBalancer; lb != nil {
+		// Invalid rules are reported on the load balancer rather than here.
+		names := map[string]bool{}
+		for _, r := range nsg.Rules {
+			names[r.Name] = true
+		}
+		priority := 1100
+		for _, lr := range lb.Rules {
+			name := "allow-" + strings.ToLower(lr.Name)
+			if !containsString(lbProtocols, lr.Protocol) || lr.BackendPort < 1 || lr.BackendPort > 65535 || names[name] {
+				continue
+			}
+			names[name] = true
+			nsg.Rules = append(nsg.Rules, ruleSpec{
+				Name:                     name,
+				Priority:                 priority,
+				Direction:                ruleDirections[0],
+				Access:                   ruleAccesses[0],
+				Protocol:                 lr.Protocol,
+				SourceAddressPrefix:      "Internet",
+				DestinationAddressPrefix: "*",
+				DestinationPortRange:     strconv.Itoa(lr.BackendPort),
+			})
+			priority += 10
+		}
+	}
+	s.NetworkSecurityGroups = append(s.NetworkSecurityGroups, nsg)
+}
+
+// validate checks a completed spec, and returns a specError listing every problem found, or nil.
+// Only what can be checked without calling Azure is validated; the location is checked by
+// provision before anything is created.
+func (s *vmSpec) validate() error {
+	var problems specError
+	report := func(path, format string, args ...interface{}) {
+		problems = append(problems, path+": "+fmt.Sprintf(format, args...))
+	}
+	checkName := func(path string, rule helpers.NameRule, name string) {
+		if err := rule.Validate(name); err != nil {
+			report(path, "%s", strings.TrimPrefix(err.Error(), "ERROR: "))
+		}
+	}
+	required := func(path, value string) bool {
+		if value == "" {
+			report(path, "is required")
+			return false
+		}
+		return true
+	}
+
+	if required("resourceGroup.name", s.ResourceGroup.Name) {
+		checkName("resourceGroup.name", helpers.ResourceGroupName, s.ResourceGroup.Name)
+	}
+	required("resourceGroup.location", s.ResourceGroup.Location)
+
+	if s.ResourceGroup.Name != "" {
+		checkName("storageAccount.name", helpers.StorageAccountName, s.StorageAccount.Name)
+	}
+	if !containsString(storageTypes, s.StorageAccount.Type) {
+		report("storageAccount.type", "'%s' is not a storage account type, use one of '%s'", s.StorageAccount.Type, strings.Join(storageTypes, "', '"))
+	}
+
+	// The address space of the network, and that of each subnet, which must lie in it without
+	// overlapping another one.
+	var space []*net.IPNet
+	for i, prefix := range s.VirtualNetwork.AddressPrefixes {
+		if n := parsePrefix(fmt.Sprintf("virtualNetwork.addressPrefixes[%d]", i), prefix, report); n != nil {
+			space = append(space, n)
+		}
+	}
+	subnets := map[string]*net.IPNet{}
+	for i, snet := range s.VirtualNetwork.Subnets {
+		path := fmt.Sprintf("virtualNetwork.subnets[%d]", i)
+		if required(path+".name", snet.Name) {
+			if _, dup := subnets[snet.Name]; dup {
+				report(path+".name", "the subnet '%s' is defined more than once", snet.Name)
+			}
+		}
+		n := parsePrefix(path+".addressPrefix", snet.AddressPrefix, report)
+		if n == nil {
+			subnets[snet.Name] = nil
+			continue
+		}
+		inside := false
+		for _, outer := range space {
+			inside = inside || containsNetwork(outer, n)
+		}
+		if !inside && len(space) > 0 {
+			report(path+".addressPrefix", "%s is outside of the address space of the virtual network", snet.AddressPrefix)
+		}
+		for _, other := range s.VirtualNetwork.Subnets[:i] {
+			if o := subnets[other.Name]; o != nil && (o.Contains(n.IP) || n.Contains(o.IP)) {
+				report(path+".addressPrefix", "%s overlaps with the subnet '%s'", snet.AddressPrefix, other.Name)
+			}
+		}
+		subnets[snet.Name] = n
+	}
+
+	// The network security groups, their rules, and the subnets associated with them.
+	if ip := net.ParseIP(s.CallerIP); s.CallerIP != "" && (ip == nil || ip.To4() == nil) {
+		report("callerIP", "'%s' is not an IPv4 address", s.CallerIP)
+	}
+	nsgs := map[string]bool{}
+	for i, nsg := range s.NetworkSecurityGroups {
+		path := fmt.Sprintf("networkSecurityGroups[%d]", i)
+		if required(path+".name", nsg.Name) {
+			if nsgs[strings.ToLower(nsg.Name)] {
+				report(path+".name", "the network security group '%s' is defined more than once", nsg.Name)
+			}
+			nsgs[strings.ToLower(nsg.Name)] = true
+		}
+		rules := map[string]bool{}
+		priorities := map[string]string{}
+		for j, r := range nsg.Rules {
+			path := fmt.Sprintf("%s.rules[%d]", path, j)
+			if required(path+".name", r.Name) {
+				if rules[strings.ToLower(r.Name)] {
+					report(path+".name", "the rule '%s' is defined more than once", r.Name)
+				}
+				rules[strings.ToLower(r.Name)] = true
+			}
+			if r.Priority < 100 || r.Priority > 4096 {
+				report(path+".priority", "%d is not a priority, use 100 to 4096", r.Priority)
+			} else if other, ok := priorities[fmt.Sprintf("%s/%d", r.Direction, r.Priority)]; ok {
+				report(path+".priority", "%d is already the priority of the %s rule '%s'", r.Priority, strings.ToLower(r.Direction), other)
+			}
+			priorities[fmt.Sprintf("%s/%d", r.Direction, r.Priority)] = r.Name
+			for _, f := range []struct {
+				field, value, what string
+				values             []string
+			}{
+				{"direction", r.Direction, "a direction", ruleDirections},
+				{"access", r.Access, "an access", ruleAccesses},
+				{"protocol", r.Protocol, "a protocol", ruleProtocols},
+			} {
+				if !containsString(f.values, f.value) {
+					report(path+"."+f.field, "'%s' is not %s, use %s", f.value, f.what, strings.Join(f.values, ", "))
+				}
+			}
+			if r.SourceAddressPrefix == "" {
+				report(path+".sourceAddressPrefix", "is required, use * for any address or %s for the address of this machine", callerSource)
+			} else if !strings.EqualFold(r.SourceAddressPrefix, callerSource) && !validAddressPrefix(r.SourceAddressPrefix) {
+				report(path+".sourceAddressPrefix", "'%s' is not an address, an address prefix such as 203.0.113.0/24, one of %s, or %s", r.SourceAddressPrefix, strings.Join(addressTags, ", "), callerSource)
+			}
+			if !validAddressPrefix(r.DestinationAddressPrefix) {
+				report(path+".destinationAddressPrefix", "'%s' is not an address, an address prefix such as 10.0.0.0/24, or one of %s", r.DestinationAddressPrefix, strings.Join(addressTags, ", "))
+			}
+			if required(path+".destinationPortRange", r.DestinationPortRange) && !validPortRange(r.DestinationPortRange) {
+				report(path+".destinationPortRange", "'%s' is not a port or a range of ports, such as 80 or 8000-8100, use * for any port", r.DestinationPortRange)
+			}
+		}
+	}
+	for i, snet := range s.VirtualNetwork.Subnets {
+		if snet.NetworkSecurityGroup != "" && !nsgs[strings.ToLower(snet.NetworkSecurityGroup)] {
+			report(fmt.Sprintf("virtualNetwork.subnets[%d].networkSecurityGroup", i), "there is no network security group '%s'", snet.NetworkSecurityGroup)
+		}
+	}
+
+	nics := map[string]bool{}
+	ips := map[string]bool{}
+	for i, nic := range s.NetworkInterfaces {
+		path := fmt.Sprintf("networkInterfaces[%d]", i)
+		if required(path+".name", nic.Name) && nics[nic.Name] {
+			report(path+".name", "the network interface '%s' is defined more than once", nic.Name)
+		}
+		nics[nic.Name] = true
+		if _, ok := subnets[nic.Subnet]; !ok {
+			report(path+".subnet", "there is no subnet '%s' in the virtual network", nic.Subnet)
+		}
+		if nic.PublicIPAddress != "" {
+			if ips[nic.PublicIPAddress] {
+				report(path+".publicIPAddress", "the public IP address '%s' is used by another network interface", nic.PublicIPAddress)
+			}
+			ips[nic.PublicIPAddress] = true
+		}
+		if nic.NetworkSecurityGroup != "" && !nsgs[strings.ToLower(nic.NetworkSecurityGroup)] {
+			report(path+".networkSecurityGroup", "there is no network security group '%s'", nic.NetworkSecurityGroup)
+		}
+	}
+
+	if len(s.VirtualMachines) == 0 {
+		report("virtualMachines", "at least one virtual machine is required")
+	}
+	vms := map[string]bool{}
+	usedNics := map[string]string{}
+	disks := map[string]bool{}
+	for i, vm := range s.VirtualMachines {
+		path := fmt.Sprintf("virtualMachines[%d]", i)
+		if required(path+".name", vm.Name) {
+			checkName(path+".name", helpers.VirtualMachineName, vm.Name)
+			if vms[strings.ToLower(vm.Name)] {
+				report(path+".name", "the virtual machine '%s' is defined more than once", vm.Name)
+			}
+			vms[strings.ToLower(vm.Name)] = true
+		}
+		if required(path+".networkInterface", vm.NetworkInterface) {
+			if !nics[vm.NetworkInterface] {
+				report(path+".networkInterface", "there is no network interface '%s'", vm.NetworkInterface)
+			} else if other, used := usedNics[vm.NetworkInterface]; used {
+				report(path+".networkInterface", "the network interface '%s' is already used by '%s'", vm.NetworkInterface, other)
+			}
+			usedNics[vm.NetworkInterface] = vm.Name
+		}
+		required(path+".adminUsername", vm.AdminUsername)
+		switch vm.OS {
+		case windowsOS:
+			switch {
+			case vm.AdminPassword != "" && vm.AdminPasswordEnv != "":
+				report(path, "only one of adminPassword and adminPasswordEnv can be given")
+			case vm.AdminPasswordEnv != "" && vm.Password == "":
+				report(path+".adminPasswordEnv", "the environment variable %s is not set", vm.AdminPasswordEnv)
+			case vm.AdminPasswordEnv == "":
+				required(path+".adminPassword", vm.AdminPassword)
+			}
+			for _, f := range []struct{ field, value string }{
+				{"sshPublicKeyFile", vm.SSHPublicKeyFile},
+				{"sshPrivateKeyFile", vm.SSHPrivateKeyFile},
+				{"cloudInit", vm.CloudInit},
+			} {
+				if f.value != "" {
+					report(path+"."+f.field, "only Linux virtual machines can have one")
+				}
+			}
+		case linuxOS:
+			if vm.AdminPassword != "" || vm.AdminPasswordEnv != "" {
+				report(path, "Linux virtual machines log in with SSH keys, password authentication is disabled")
+			}
+			if vm.SSHPublicKeyFile != "" && vm.SSHPrivateKeyFile != "" {
+				report(path, "only one of sshPublicKeyFile and sshPrivateKeyFile can be given")
+			}
+			if containsString(reservedLinuxUsers, strings.ToLower(vm.AdminUsername)) {
+				report(path+".adminUsername", "'%s' is reserved on Linux, use another name", vm.AdminUsername)
+			}
+		default:
+			report(path+".os", "'%s' is not an operating system, use %s or %s", vm.OS, windowsOS, linuxOS)
+		}
+		required(path+".image.publisher", vm.Image.Publisher)
+		required(path+".image.offer", vm.Image.Offer)
+		required(path+".image.sku", vm.Image.Sku)
+		required(path+".image.version", vm.Image.Version)
+		if disks[vm.OsDisk] {
+			report(path+".osDisk", "the disk '%s' is used by another virtual machine", vm.OsDisk)
+		}
+		disks[vm.OsDisk] = true
+	}
+
+	// The load balancer, whose backend pool holds the network interfaces of the virtual machines.
+	// A frontend port can only be used by one rule.
+	if lb := s.LoadBalancer; lb != nil {
+		required("loadBalancer.name", lb.Name)
+		if required("loadBalancer.publicIPAddress", lb.PublicIPAddress) && ips[lb.PublicIPAddress] {
+			report("loadBalancer.publicIPAddress", "the public IP address '%s' is used by a network interface", lb.PublicIPAddress)
+		}
+		port := func(path string, n int) {
+			if n < 1 || n > 65535 {
+				report(path, "%d is not a port, use 1 to 65535", n)
+			}
+		}
+		oneOf := func(path, value, what string, values []string) {
+			if !containsString(values, value) {
+				report(path, "'%s' is not %s, use %s", value, what, strings.Join(values, ", "))
+			}
+		}
+		unique := func(path, name, what string, names map[string]bool) {
+			if required(path, name) {
+				if names[strings.ToLower(name)] {
+					report(path, "the %s '%s' is defined more than once", what, name)
+				}
+				names[strings.ToLower(name)] = true
+			}
+		}
+
+		probes := map[string]bool{}
+		for i, p := range lb.Probes {
+			path := fmt.Sprintf("loadBalancer.probes[%d]", i)
+			unique(path+".name", p.Name, "probe", probes)
+			oneOf(path+".protocol", p.Protocol, "a probe protocol", probeProtocols)
+			port(path+".port", p.Port)
+			switch {
+			case p.Protocol == "Http" && !strings.HasPrefix(p.RequestPath, "/"):
+				report(path+".requestPath", "is required for Http probes, and starts with /, such as /health")
+			case p.Protocol != "Http" && p.RequestPath != "":
+				report(path+".requestPath", "only Http probes can have one")
+			}
+			if p.IntervalInSeconds < 5 {
+				report(path+".intervalInSeconds", "%d is too short, use at least 5 seconds", p.IntervalInSeconds)
+			}
+			if p.NumberOfProbes < 1 {
+				report(path+".numberOfProbes", "%d is not a number of probes, use at least 1", p.NumberOfProbes)
+			}
+		}
+
+		rules := map[string]bool{}
+		frontendPorts := map[string]string{}
+		usePort := func(path, protocol string, n int, name string) {
+			key := fmt.Sprintf("%s/%d", protocol, n)
+			if other, used := frontendPorts[key]; used {
+				report(path, "port %d is already used by the rule '%s'", n, other)
+			}
+			frontendPorts[key] = name
+		}
+		for i, r := range lb.Rules {
+			path := fmt.Sprintf("loadBalancer.rules[%d]", i)
+			unique(path+".name", r.Name, "rule", rules)
+			oneOf(path+".protocol", r.Protocol, "a protocol", lbProtocols)
+			port(path+".frontendPort", r.FrontendPort)
+			port(path+".backendPort", r.BackendPort)
+			usePort(path+".frontendPort", r.Protocol, r.FrontendPort, r.Name)
+			if r.Probe != "" && !probes[strings.ToLower(r.Probe)] {
+				report(path+".probe", "there is no probe '%s'", r.Probe)
+			}
+			oneOf(path+".loadDistribution", r.LoadDistribution, "a load distribution", loadDistributions)
+			if r.IdleTimeoutInMinutes < 4 || r.IdleTimeoutInMinutes > 30 {
+				report(path+".idleTimeoutInMinutes", "%d is not an idle timeout, use 4 to 30 minutes", r.IdleTimeoutInMinutes)
+			}
+		}
+
+		natRules := map[string]bool{}
+		for i, r := range lb.NatRules {
+			path := fmt.Sprintf("loadBalancer.natRules[%d]", i)
+			unique(path+".name", r.Name, "NAT rule", natRules)
+			oneOf(path+".protocol", r.Protocol, "a protocol", lbProtocols)
+			port(path+".frontendPort", r.FrontendPort)
+			port(path+".backendPort", r.BackendPort)
+			usePort(path+".frontendPort", r.Protocol, r.FrontendPort, r.Name)
+			if required(path+".networkInterface", r.NetworkInterface) {
+				if _, ok := usedNics[r.NetworkInterface]; !ok {
+					report(path+".networkInterface", "there is no virtual machine behind the load balancer with the network interface '%s'", r.NetworkInterface)
+				}
+			}
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return problems
+}
+
+// parsePrefix parses an address prefix in CIDR notation, reporting it when invalid.
+func parsePrefix(path, prefix string, report func(path, format string, args ...interface{})) *net.IPNet {
+	if prefix == "" {
+		report(path, "is required")
+		return nil
+	}
+	ip, n, err := net.ParseCIDR(prefix)
+	if err != nil || ip.To4() == nil {
+		report(path, "'%s' is not an IPv4 address prefix, such as 10.0.0.0/16", prefix)
+		return nil
+	}
+	if !ip.Equal(n.IP) {
+		report(path, "'%s' has bits set outside of its prefix, use %s", prefix, n.String())
+		return nil
+	}
+	return n
+}
+
+// validAddressPrefix reports whether a security rule can match addresses with prefix: an IPv4
+// address, an address prefix in CIDR notation, or one of the addressTags.
+func validAddressPrefix(prefix string) bool {
+	if containsString(addressTags, prefix) {
+		return true
+	}
+	if ip := net.ParseIP(prefix); ip != nil {
+		return ip.To4() != nil
+	}
+	ip, n, err := net.ParseCIDR(prefix)
+	return err == nil && ip.To4() != nil && ip.Equal(n.IP)
+}
+
+// validPortRange reports whether ports is *, a port, or a range of ports such as 8000-8100.
+func validPortRange(ports string) bool {
+	if ports == "*" {
+		return true
+	}
+	bounds := strings.SplitN(ports, "-", 2)
+	numbers := make([]int, len(bounds))
+	for i, b := range bounds {
+		n, err := strconv.Atoi(b)
+		if err != nil || n < 1 || n > 65535 {
+			return false
+		}
+		numbers[i] = n
+	}
+	return numbers[0] <= numbers[len(numbers)-1]
+}
+
+// containsNetwork reports whether the network inner lies within outer.
+func containsNetwork(outer, inner *net.IPNet) bool {
+	outerBits, _ := outer.Mask.Size()
+	innerBits, _ := inner.Mask.Size()
+	return outer.Contains(inner.IP) && innerBits >= outerBits
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,522 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-go-samples/fakes/armfake"
+	"github.com/Azure/azure-go-samples/helpers"
+	"github.com/Azure/azure-sdk-for-go/Godeps/_workspace/src/github.com/Azure/go-autorest/autorest/to"
+)
+
+func TestExampleSpecs(t *testing.T) {
+	os.Setenv("CREATEVM_PASSWORD", "foobar1234")
+	defer os.Unsetenv("CREATEVM_PASSWORD")
+
+	// The example spec spells out the defaults, which are what the sample creates without one.
+	spec, err := readSpec("specs/createvm01.yaml")
+	if err != nil {
+		t.Fatalf("readSpec failed: %v", err)
+	}
+	expected := defaultSpec("createvm01", "West US", "vm001", "admin", "foobar1234")
+	expected.VirtualMachines[0].AdminPassword = ""
+	expected.VirtualMachines[0].AdminPasswordEnv = "CREATEVM_PASSWORD"
+	if !reflect.DeepEqual(spec, expected) {
+		t.Errorf("unexpected spec:\n%s\nexpected:\n%s", helpers.ToJSON(spec), helpers.ToJSON(expected))
+	}
+
+	spec, err = readSpec("specs/staging.json")
+	if err != nil {
+		t.Fatalf("readSpec failed: %v", err)
+	}
+	if spec.AvailabilitySet.Name != "createvmstagingavset" || spec.VirtualMachines[1].OsDisk != "db01osdisk" || spec.VirtualMachines[1].Password != "foobar1234" {
+		t.Errorf("the defaults were not filled in:\n%s", helpers.ToJSON(spec))
+	}
+}
+
+func TestProvisionSpec(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+
+	os.Setenv("CREATEVM_PASSWORD", "foobar1234")
+	defer os.Unsetenv("CREATEVM_PASSWORD")
+	spec, err := readSpec("specs/staging.json")
+	if err != nil {
+		t.Fatalf("readSpec failed: %v", err)
+	}
+
+	_, vms, err := provisionSpec(spec, provisionOptions{parallel: 1}, helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL))
+	if err != nil {
+		t.Fatalf("provisionSpec failed: %v", err)
+	}
+	if len(vms) != 2 || to.String(vms[0].Name) != "web01" || to.String(vms[1].Name) != "db01" {
+		t.Fatalf("unexpected virtual machines %+v", vms)
+	}
+
+	group := "/resourcegroups/createvmstaging/providers"
+	var created []string
+	for _, c := range calls(srv) {
+		if strings.HasPrefix(c, "PUT "+group) {
+			created = append(created, strings.TrimPrefix(c, "PUT "+group))
+		}
+	}
+	expected := []string{
+		"/microsoft.storage/storageaccounts/" + spec.StorageAccount.Name,
+		"/microsoft.compute/availabilitysets/createvmstagingavset",
+		"/microsoft.network/networksecuritygroups/createvmstagingnsg",
+		"/microsoft.network/virtualnetworks/createvmstagingvnet",
+		"/microsoft.network/virtualnetworks/createvmstagingvnet/subnets/frontend",
+		"/microsoft.network/virtualnetworks/createvmstagingvnet/subnets/backend",
+		"/microsoft.network/publicipaddresses/webip",
+		"/microsoft.network/networkinterfaces/webnic",
+		"/microsoft.network/networkinterfaces/dbnic",
+		"/microsoft.compute/virtualmachines/web01",
+		"/microsoft.compute/virtualmachines/db01",
+	}
+	if strings.Join(created, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected resources:\n%s\nexpected:\n%s", strings.Join(created, "\n"), strings.Join(expected, "\n"))
+	}
+
+	for suffix, refs := range map[string][]string{
+		"/networkInterfaces/dbnic": {"/subnets/backend"},
+		"/virtualMachines/db01":    {"/networkInterfaces/dbnic", "Standard_A2", "dbadmin", "/vhds/db01osdisk.vhd"},
+	} {
+		r := lastRequest(srv, "PUT", suffix)
+		if r == nil {
+			t.Fatalf("%s was not created", suffix)
+		}
+		body := helpers.ToJSON(r.Body)
+		for _, ref := range refs {
+			if !strings.Contains(body, ref) {
+				t.Errorf("%s doesn't refer to '%s':\n%s", suffix, ref, body)
+			}
+		}
+		if strings.Contains(body, "publicIPAddress") {
+			t.Errorf("%s unexpectedly has a public IP address:\n%s", suffix, body)
+		}
+	}
+}
+
+func TestSpecValidation(t *testing.T) {
+	os.Unsetenv("CREATEVM_UNSET")
+
+	for _, c := range []struct {
+		doc      string
+		problems []string
+	}{
+		{
+			`{"resourceGroup": {"name": "g", "location": "West US", "region": "x"}, "virtualMachines": [{"name": "vm", "adminUsername": "a", "adminPassword": 1234}]}`,
+			[]string{
+				"resourceGroup.region: unknown field",
+				"virtualMachines[0].adminPassword: expected a string, quote 1234",
+			},
+		},
+		{
+			`{"resourceGroup": {"location": "West US"}, "virtualMachines": {"name": "vm"}}`,
+			[]string{"virtualMachines: expected a list"},
+		},
+		{
+			`{"resourceGroup": {"name": "g"}}`,
+			[]string{
+				"resourceGroup.location: is required",
+				"virtualMachines: at least one virtual machine is required",
+			},
+		},
+		{
+			`{"resourceGroup": {"name": "g", "location": "West US"},
+			  "storageAccount": {"name": "Invalid_Name", "type": "Standard_XRS"},
+			  "virtualNetwork": {
+			    "addressPrefixes": ["10.0.0.0/16", "10.1.0.1/16"],
+			    "subnets": [
+			      {"name": "a", "addressPrefix": "10.0.0.0/24"},
+			      {"name": "b", "addressPrefix": "10.0.0.128/25"},
+			      {"name": "a", "addressPrefix": "192.168.0.0/24"}
+			    ]
+			  },
+			  "networkInterfaces": [{"name": "nic", "subnet": "c", "publicIPAddress": "ip"}, {"name": "nic2", "publicIPAddress": "ip"}],
+			  "virtualMachines": [
+			    {"name": "this-name-is-too-long", "networkInterface": "nic", "adminUsername": "a", "adminPasswordEnv": "CREATEVM_UNSET"},
+			    {"name": "vm", "networkInterface": "nic", "adminPassword": "p", "adminPasswordEnv": "CREATEVM_UNSET"},
+			    {"name": "VM", "networkInterface": "nic3", "adminUsername": "a",
+			     "image": {"publisher": "Canonical"}, "osDisk": "vmosdisk"}
+			  ]}`,
+			[]string{
+				"storageAccount.name: The storage account name 'Invalid_Name' contains the invalid character 'I'",
+				"storageAccount.type: 'Standard_XRS' is not a storage account type, use one of 'Standard_LRS', 'Standard_ZRS', 'Standard_GRS', 'Standard_RAGRS', 'Premium_LRS'",
+				"virtualNetwork.addressPrefixes[1]: '10.1.0.1/16' has bits set outside of its prefix, use 10.1.0.0/16",
+				"virtualNetwork.subnets[1].addressPrefix: 10.0.0.128/25 overlaps with the subnet 'a'",
+				"virtualNetwork.subnets[2].name: the subnet 'a' is defined more than once",
+				"virtualNetwork.subnets[2].addressPrefix: 192.168.0.0/24 is outside of the address space of the virtual network",
+				"networkInterfaces[0].subnet: there is no subnet 'c' in the virtual network",
+				"networkInterfaces[1].publicIPAddress: the public IP address 'ip' is used by another network interface",
+				"virtualMachines[0].name: The virtual machine name 'this-name-is-too-long' must be between 1 and 15 characters long",
+				"virtualMachines[0].adminPasswordEnv: the environment variable CREATEVM_UNSET is not set",
+				"virtualMachines[1].networkInterface: the network interface 'nic' is already used by 'this-name-is-too-long'",
+				"virtualMachines[1].adminUsername: is required",
+				"virtualMachines[1]: only one of adminPassword and adminPasswordEnv can be given",
+				"virtualMachines[2].name: the virtual machine 'VM' is defined more than once",
+				"virtualMachines[2].networkInterface: there is no network interface 'nic3'",
+				"virtualMachines[2].adminPassword: is required",
+				"virtualMachines[2].image.offer: is required",
+				"virtualMachines[2].image.sku: is required",
+				"virtualMachines[2].image.version: is required",
+				"virtualMachines[2].osDisk: the disk 'vmosdisk' is used by another virtual machine",
+			},
+		},
+		{
+			`{"resourceGroup": {"name": "g", "location": "West US"},
+			  "networkInterfaces": [{"name": "nic1"}, {"name": "nic2"}, {"name": "nic3"}],
+			  "virtualMachines": [
+			    {"name": "win", "networkInterface": "nic1", "adminUsername": "a", "adminPassword": "p", "sshPublicKeyFile": "id_rsa.pub", "cloudInit": "init.yaml"},
+			    {"name": "lin", "os": "linux", "networkInterface": "nic2", "adminUsername": "Admin", "adminPassword": "p",
+			     "sshPublicKeyFile": "id_rsa.pub", "sshPrivateKeyFile": "id_rsa"},
+			    {"name": "mac", "os": "macOS", "networkInterface": "nic3", "adminUsername": "a"}
+			  ]}`,
+			[]string{
+				"virtualMachines[0].sshPublicKeyFile: only Linux virtual machines can have one",
+				"virtualMachines[0].cloudInit: only Linux virtual machines can have one",
+				"virtualMachines[1]: Linux virtual machines log in with SSH keys, password authentication is disabled",
+				"virtualMachines[1]: only one of sshPublicKeyFile and sshPrivateKeyFile can be given",
+				"virtualMachines[1].adminUsername: 'Admin' is reserved on Linux, use another name",
+				"virtualMachines[2].os: 'macOS' is not an operating system, use Windows or Linux",
+			},
+		},
+		{
+			`{"resourceGroup": {"name": "g", "location": "West US"},
+			  "virtualNetwork": {"subnets": [{"name": "s", "addressPrefix": "10.0.0.0/24", "networkSecurityGroup": "missing"}]},
+			  "callerIP": "2001:db8::1",
+			  "networkSecurityGroups": [
+			    {"name": "web", "rules": [
+			      {"name": "http", "priority": 100, "sourceAddressPrefix": "*", "destinationPortRange": "80"},
+			      {"name": "http", "priority": 100, "direction": "inbound", "sourceAddressPrefix": "10.0.0.0/33", "destinationPortRange": "80-70"},
+			      {"name": "out", "priority": 50, "direction": "Sideways", "access": "Maybe", "protocol": "Icmp", "destinationAddressPrefix": "somewhere"},
+			      {"name": "ssh", "priority": 5000, "sourceAddressPrefix": "Caller", "destinationPortRange": "22"}
+			    ]},
+			    {"name": "web"},
+			    {"rules": []}
+			  ],
+			  "networkInterfaces": [{"name": "nic", "networkSecurityGroup": "other"}],
+			  "virtualMachines": [{"name": "vm", "adminUsername": "a", "adminPassword": "p"}]}`,
+			[]string{
+				"callerIP: '2001:db8::1' is not an IPv4 address",
+				"networkSecurityGroups[0].rules[1].name: the rule 'http' is defined more than once",
+				"networkSecurityGroups[0].rules[1].priority: 100 is already the priority of the inbound rule 'http'",
+				"networkSecurityGroups[0].rules[1].sourceAddressPrefix: '10.0.0.0/33' is not an address, an address prefix such as 203.0.113.0/24, one of *, Internet, VirtualNetwork, AzureLoadBalancer, or caller",
+				"networkSecurityGroups[0].rules[1].destinationPortRange: '80-70' is not a port or a range of ports, such as 80 or 8000-8100, use * for any port",
+				"networkSecurityGroups[0].rules[2].priority: 50 is not a priority, use 100 to 4096",
+				"networkSecurityGroups[0].rules[2].direction: 'Sideways' is not a direction, use Inbound, Outbound",
+				"networkSecurityGroups[0].rules[2].access: 'Maybe' is not an access, use Allow, Deny",
+				"networkSecurityGroups[0].rules[2].protocol: 'Icmp' is not a protocol, use Tcp, Udp, *",
+				"networkSecurityGroups[0].rules[2].sourceAddressPrefix: is required, use * for any address or caller for the address of this machine",
+				"networkSecurityGroups[0].rules[2].destinationAddressPrefix: 'somewhere' is not an address, an address prefix such as 10.0.0.0/24, or one of *, Internet, VirtualNetwork, AzureLoadBalancer",
+				"networkSecurityGroups[0].rules[2].destinationPortRange: is required",
+				"networkSecurityGroups[0].rules[3].priority: 5000 is not a priority, use 100 to 4096",
+				"networkSecurityGroups[1].name: the network security group 'web' is defined more than once",
+				"networkSecurityGroups[2].name: is required",
+				"virtualNetwork.subnets[0].networkSecurityGroup: there is no network security group 'missing'",
+				"networkInterfaces[0].networkSecurityGroup: there is no network security group 'other'",
+			},
+		},
+		{
+			`{"resourceGroup": {"name": "g", "location": "West US"},
+			  "loadBalancer": {
+			    "publicIPAddress": "ip01",
+			    "probes": [
+			      {"name": "web", "protocol": "Http", "port": 80},
+			      {"name": "web", "protocol": "Tcp", "port": 70000, "requestPath": "/", "intervalInSeconds": 1, "numberOfProbes": -1},
+			      {"name": "ping", "protocol": "Icmp", "port": 1}
+			    ],
+			    "rules": [
+			      {"name": "http", "frontendPort": 80, "probe": "missing"},
+			      {"name": "http", "protocol": "Any", "frontendPort": 0, "loadDistribution": "RoundRobin", "idleTimeoutInMinutes": 60}
+			    ],
+			    "natRules": [
+			      {"name": "rdp", "frontendPort": 80, "backendPort": 3389, "networkInterface": "nic02"},
+			      {"name": "rdp", "frontendPort": 50001}
+			    ]
+			  },
+			  "networkInterfaces": [{"name": "nic01", "publicIPAddress": "ip01"}, {"name": "nic02"}],
+			  "virtualMachines": [{"name": "vm", "adminUsername": "a", "adminPassword": "p"}]}`,
+			[]string{
+				"loadBalancer.publicIPAddress: the public IP address 'ip01' is used by a network interface",
+				"loadBalancer.probes[0].requestPath: is required for Http probes, and starts with /, such as /health",
+				"loadBalancer.probes[1].name: the probe 'web' is defined more than once",
+				"loadBalancer.probes[1].port: 70000 is not a port, use 1 to 65535",
+				"loadBalancer.probes[1].requestPath: only Http probes can have one",
+				"loadBalancer.probes[1].intervalInSeconds: 1 is too short, use at least 5 seconds",
+				"loadBalancer.probes[1].numberOfProbes: -1 is not a number of probes, use at least 1",
+				"loadBalancer.probes[2].protocol: 'Icmp' is not a probe protocol, use Tcp, Http",
+				"loadBalancer.rules[0].probe: there is no probe 'missing'",
+				"loadBalancer.rules[1].name: the rule 'http' is defined more than once",
+				"loadBalancer.rules[1].protocol: 'Any' is not a protocol, use Tcp, Udp",
+				"loadBalancer.rules[1].frontendPort: 0 is not a port, use 1 to 65535",
+				"loadBalancer.rules[1].backendPort: 0 is not a port, use 1 to 65535",
+				"loadBalancer.rules[1].loadDistribution: 'RoundRobin' is not a load distribution, use Default, SourceIP, SourceIPProtocol",
+				"loadBalancer.rules[1].idleTimeoutInMinutes: 60 is not an idle timeout, use 4 to 30 minutes",
+				"loadBalancer.natRules[0].frontendPort: port 80 is already used by the rule 'http'",
+				"loadBalancer.natRules[0].networkInterface: there is no virtual machine behind the load balancer with the network interface 'nic02'",
+				"loadBalancer.natRules[1].name: the NAT rule 'rdp' is defined more than once",
+				"loadBalancer.natRules[1].backendPort: 0 is not a port, use 1 to 65535",
+				"loadBalancer.natRules[1].networkInterface: is required",
+			},
+		},
+		{
+			`{"resourceGroup": {"name": "g", "location": "West US"},
+			  "networkSecurityGroups": [{"name": "web", "rules": [{"name": "http", "priority": 200.5}]}],
+			  "virtualMachines": [{"name": "vm", "adminUsername": "a", "adminPassword": "p"}]}`,
+			[]string{"networkSecurityGroups[0].rules[0].priority: expected a whole number, not 200.5"},
+		},
+	} {
+		var doc map[string]interface{}
+		if err := json.Unmarshal([]byte(c.doc), &doc); err != nil {
+			t.Fatal(err)
+		}
+		_, err := parseSpec(doc)
+		if err == nil {
+			t.Errorf("expected %s to be rejected", c.doc)
+			continue
+		}
+		if err.Error() != strings.Join(c.problems, "\n") {
+			t.Errorf("unexpected problems:\n%v\nexpected:\n%s", err, strings.Join(c.problems, "\n"))
+		}
+	}
+}
+
+func TestSpecWithCount(t *testing.T) {
+	spec := defaultSpec("createvm01", "West US", "vm001", "admin", "foobar1234")
+	if err := spec.withCount(3, "vm%03d"); err != nil {
+		t.Fatalf("withCount failed: %v", err)
+	}
+
+	// The original keeps its network interface and disk, the copies get their own.
+	var vms, nics []string
+	for _, vm := range spec.VirtualMachines {
+		vms = append(vms, vm.Name+" "+vm.NetworkInterface+" "+vm.OsDisk+" "+vm.Password)
+	}
+	for _, n := range spec.NetworkInterfaces {
+		nics = append(nics, n.Name+" "+n.Subnet+" "+n.PublicIPAddress)
+	}
+	expected := []string{"vm001 nic01 mytestod1 foobar1234", "vm002 nic02 vm002osdisk foobar1234", "vm003 nic03 vm003osdisk foobar1234"}
+	if strings.Join(vms, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected virtual machines:\n%s\nexpected:\n%s", strings.Join(vms, "\n"), strings.Join(expected, "\n"))
+	}
+	expected = []string{"nic01 createvm01subnet ip01", "nic02 createvm01subnet ip02", "nic03 createvm01subnet ip03"}
+	if strings.Join(nics, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected network interfaces:\n%s\nexpected:\n%s", strings.Join(nics, "\n"), strings.Join(expected, "\n"))
+	}
+
+	for _, c := range []struct {
+		spec    *vmSpec
+		count   int
+		pattern string
+		err     string
+	}{
+		{defaultSpec("g", "West US", "vm001", "a", "p"), 2, "vm", "The name pattern 'vm' must contain one number verb"},
+		{defaultSpec("g", "West US", "vm001", "a", "p"), 2, "vm%s", "The name pattern 'vm%s' must contain one number verb"},
+		{defaultSpec("g", "West US", "vm001", "a", "p"), 0, "vm%03d", "--count must be at least 1"},
+		{defaultSpec("g", "West US", "this-name-is-long001", "a", "p"), 2, "this-name-is-long%03d", "virtualMachines[0].name: The virtual machine name 'this-name-is-long001' must be between 1 and 15 characters long"},
+		{&vmSpec{VirtualMachines: []machineSpec{{Name: "a"}, {Name: "b"}}}, 2, "vm%03d", "--count copies the virtual machine of a spec, which has 2"},
+		{defaultSpec("g", "West US", "vm001", "a", "p"), 2, "web%02d", "None of the 2 names given by 'web%02d' is 'vm001', the name of the existing virtual machine"},
+		{defaultSpec("g", "West US", "vm003", "a", "p"), 2, "vm%03d", "None of the 2 names given by 'vm%03d' is 'vm003'"},
+	} {
+		if err := c.spec.withCount(c.count, c.pattern); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("expected '%s', got %v", c.err, err)
+		}
+	}
+}
+
+func TestLinuxSpec(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+
+	// The key pair is generated next to the spec, so read a copy of it.
+	dir, err := ioutil.TempDir("", "createvm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"linux.yaml", "cloud-init.yaml"} {
+		b, err := ioutil.ReadFile(filepath.Join("specs", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.WriteFile(filepath.Join(dir, name), b, 0644)
+	}
+
+	spec, err := readSpec(filepath.Join(dir, "linux.yaml"))
+	if err != nil {
+		t.Fatalf("readSpec failed: %v", err)
+	}
+	vm := spec.VirtualMachines[0]
+	if vm.Image != defaultLinuxImage || vm.SSHPublicKey != pendingSSHKey {
+		t.Errorf("unexpected virtual machine:\n%s", helpers.ToJSON(vm))
+	}
+
+	// Planning doesn't generate the key, applying the plan does.
+	p, err := planProvisioning(spec, client)
+	if err != nil {
+		t.Fatalf("planProvisioning failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "web01_id_rsa")); !os.IsNotExist(err) {
+		t.Errorf("expected no key to be generated when planning, got %v", err)
+	}
+	if _, err := applyPlan(p, newTransaction(spec.ResourceGroup.Name), 1, client); err != nil {
+		t.Fatalf("applyPlan failed: %v", err)
+	}
+	vm = spec.VirtualMachines[0]
+	if !strings.HasPrefix(vm.SSHPublicKey, "ssh-rsa ") {
+		t.Errorf("unexpected public key '%s'", vm.SSHPublicKey)
+	}
+	if pub, err := helpers.ReadSSHPublicKey(filepath.Join(dir, "web01_id_rsa.pub")); err != nil || pub != vm.SSHPublicKey {
+		t.Errorf("expected the generated key to be saved next to the spec, got '%s' (%v)", pub, err)
+	}
+	r := lastRequest(srv, "PUT", "/virtualmachines/web01")
+	if r == nil {
+		t.Fatalf("the virtual machine was not created")
+	}
+	profile := r.Body["properties"].(map[string]interface{})["osProfile"].(map[string]interface{})
+	linux := profile["linuxConfiguration"].(map[string]interface{})
+	keys := linux["ssh"].(map[string]interface{})["publicKeys"].([]interface{})
+	key := keys[0].(map[string]interface{})
+	data, _ := base64.StdEncoding.DecodeString(fmt.Sprint(profile["customData"]))
+	if _, ok := profile["adminPassword"]; ok || linux["disablePasswordAuthentication"] != true || len(keys) != 1 ||
+		key["path"] != "/home/azureuser/.ssh/authorized_keys" || key["keyData"] != vm.SSHPublicKey ||
+		!strings.HasPrefix(string(data), "#cloud-config") {
+		t.Errorf("unexpected OS profile:\n%s", helpers.ToJSON(profile))
+	}
+
+	// Reading the spec again reuses the key, so the virtual machine is unchanged.
+	if spec, err = readSpec(filepath.Join(dir, "linux.yaml")); err != nil {
+		t.Fatalf("readSpec failed: %v", err)
+	}
+	if p, err = planProvisioning(spec, client); err != nil {
+		t.Fatalf("planProvisioning failed: %v", err)
+	}
+	if a := actions(p); len(a) != 0 {
+		t.Errorf("expected nothing to change, got:\n%s", strings.Join(a, "\n"))
+	}
+}
+
+func TestSpecWithOS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "createvm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "id_rsa.pub")
+	ioutil.WriteFile(keyFile, []byte("ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQC7 user@host\n"), 0644)
+
+	spec := defaultSpec("createvm01", "West US", "vm001", "admin", "foobar1234")
+	if err := spec.withOS("linux", keyFile, ""); err != nil {
+		t.Fatalf("withOS failed: %v", err)
+	}
+	vm := spec.VirtualMachines[0]
+	if vm.OS != linuxOS || vm.AdminUsername != "azureuser" || vm.Password != "" || vm.Image != defaultLinuxImage ||
+		vm.SSHPublicKey != "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQC7 user@host" {
+		t.Errorf("unexpected virtual machine:\n%s", helpers.ToJSON(vm))
+	}
+
+	spec = defaultSpec("createvm01", "West US", "vm001", "admin", "foobar1234")
+	if err := spec.withOS("Windows", keyFile, ""); err == nil || !strings.Contains(err.Error(), "virtualMachines[0].sshPublicKeyFile: only Linux virtual machines can have one") {
+		t.Errorf("expected an SSH key to be rejected on Windows, got %v", err)
+	}
+	spec = defaultSpec("createvm01", "West US", "vm001", "admin", "foobar1234")
+	if err := spec.withOS("Linux", filepath.Join(dir, "missing.pub"), ""); err == nil || !strings.Contains(err.Error(), "virtualMachines[0].sshPublicKeyFile: Unable to read the SSH public key") {
+		t.Errorf("expected a missing key to be reported, got %v", err)
+	}
+}
+
+func TestDefaultNSG(t *testing.T) {
+	spec := defaultSpec("createvm01", "West US", "vm001", "admin", "foobar1234")
+	if len(spec.NetworkSecurityGroups) != 1 || spec.NetworkInterfaces[0].NetworkSecurityGroup != "createvm01nsg" {
+		t.Fatalf("expected the network interface to get the default network security group:\n%s", helpers.ToJSON(spec))
+	}
+	rules := spec.NetworkSecurityGroups[0].Rules
+	expected := ruleSpec{Name: "allow-rdp", Priority: 1000, Direction: "Inbound", Access: "Allow", Protocol: "Tcp",
+		SourceAddressPrefix: "caller", DestinationAddressPrefix: "*", DestinationPortRange: "3389"}
+	if len(rules) != 1 || rules[0] != expected {
+		t.Errorf("unexpected rules of a Windows virtual machine:\n%s", helpers.ToJSON(rules))
+	}
+
+	if err := spec.withOS("Linux", "", ""); err != nil {
+		t.Fatalf("withOS failed: %v", err)
+	}
+	rules = spec.NetworkSecurityGroups[0].Rules
+	if len(spec.NetworkSecurityGroups) != 1 || len(rules) != 1 || rules[0].Name != "allow-ssh" || rules[0].DestinationPortRange != "22" {
+		t.Errorf("unexpected rules of a Linux virtual machine:\n%s", helpers.ToJSON(spec.NetworkSecurityGroups))
+	}
+
+	// The network interfaces of a subnet with a network security group, or without a public IP
+	// address, are left alone.
+	var doc map[string]interface{}
+	json.Unmarshal([]byte(`{"resourceGroup": {"name": "g", "location": "West US"},
+	  "virtualNetwork": {"subnets": [
+	    {"name": "front", "addressPrefix": "10.0.0.0/24", "networkSecurityGroup": "web"},
+	    {"name": "back", "addressPrefix": "10.0.1.0/24"}
+	  ]},
+	  "networkSecurityGroups": [{"name": "web", "rules": [{"name": "https", "priority": 100, "sourceAddressPrefix": "Internet", "destinationPortRange": "443"}]}],
+	  "networkInterfaces": [{"name": "webnic", "subnet": "front", "publicIPAddress": "webip"}, {"name": "dbnic", "subnet": "back"}],
+	  "virtualMachines": [{"name": "web", "adminUsername": "a", "adminPassword": "p"}, {"name": "db", "adminUsername": "a", "adminPassword": "p"}]}`), &doc)
+	spec, err := parseSpec(doc)
+	if err != nil {
+		t.Fatalf("parseSpec failed: %v", err)
+	}
+	if len(spec.NetworkSecurityGroups) != 1 || spec.NetworkInterfaces[0].NetworkSecurityGroup != "" || spec.NetworkInterfaces[1].NetworkSecurityGroup != "" {
+		t.Errorf("expected no default network security group:\n%s", helpers.ToJSON(spec))
+	}
+	if r := spec.NetworkSecurityGroups[0].Rules[0]; r.Direction != "Inbound" || r.Access != "Allow" || r.Protocol != "Tcp" || r.DestinationAddressPrefix != "*" {
+		t.Errorf("expected the defaults of the rule to be filled in, got %+v", r)
+	}
+}
+
+func TestLoadBalancerSpec(t *testing.T) {
+	os.Setenv("CREATEVM_PASSWORD", "foobar1234")
+	defer os.Unsetenv("CREATEVM_PASSWORD")
+
+	spec, err := readSpec("specs/balanced.yaml")
+	if err != nil {
+		t.Fatalf("readSpec failed: %v", err)
+	}
+	for _, nic := range spec.NetworkInterfaces {
+		if nic.PublicIPAddress != "" || nic.NetworkSecurityGroup != "createvmbalancednsg" {
+			t.Errorf("expected %s to be reached through the load balancer and its network security group:\n%s", nic.Name, helpers.ToJSON(nic))
+		}
+	}
+	nat := spec.LoadBalancer.NatRules
+	if len(nat) != 2 || nat[0] != (natRuleSpec{"web01-rdp", "Tcp", 50001, 3389, "nic01", true}) || nat[1] != (natRuleSpec{"web02-rdp", "Tcp", 50002, 3389, "nic02", true}) {
+		t.Errorf("unexpected NAT rules:\n%+v", nat)
+	}
+	rules := spec.NetworkSecurityGroups[0].Rules
+	expected := ruleSpec{Name: "allow-http", Priority: 1100, Direction: "Inbound", Access: "Allow", Protocol: "Tcp",
+		SourceAddressPrefix: "Internet", DestinationAddressPrefix: "*", DestinationPortRange: "80"}
+	if len(rules) != 2 || rules[0].Name != "allow-rdp" || rules[1] != expected {
+		t.Errorf("unexpected security rules:\n%s", helpers.ToJSON(rules))
+	}
+
+	// The default NAT rules follow the OS and the copies of the virtual machine.
+	if err := spec.withOS("Linux", "", ""); err != nil {
+		t.Fatalf("withOS failed: %v", err)
+	}
+	if nat := spec.LoadBalancer.NatRules; len(nat) != 2 || nat[0].Name != "web01-ssh" || nat[0].BackendPort != 22 {
+		t.Errorf("unexpected NAT rules on Linux:\n%+v", nat)
+	}
+	spec.VirtualMachines, spec.NetworkInterfaces = spec.VirtualMachines[:1], spec.NetworkInterfaces[:1]
+	if err := spec.withCount(3, "web%02d"); err != nil {
+		t.Fatalf("withCount failed: %v", err)
+	}
+	nat = spec.LoadBalancer.NatRules
+	if len(nat) != 3 || nat[2] != (natRuleSpec{"web03-ssh", "Tcp", 50003, 22, "nic03", true}) {
+		t.Errorf("unexpected NAT rules of the copies:\n%+v", nat)
+	}
+	if nic := spec.NetworkInterfaces[2]; nic.PublicIPAddress != "" || !spec.behindLoadBalancer(nic.Name) {
+		t.Errorf("expected the copies to be behind the load balancer:\n%s", helpers.ToJSON(spec.NetworkInterfaces))
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-go-samples/helpers"
+	"github.com/Azure/azure-sdk-for-go/Godeps/_workspace/src/github.com/Azure/go-autorest/autorest/to"
+	"github.com/Azure/azure-sdk-for-go/arm"
+	"github.com/Azure/azure-sdk-for-go/arm/resources/resources"
+)
+
+// commands are the operations of the sample, run by helpers.RunCommands.
+var commands = map[string]helpers.Command{
+	"create":    {Summary: "create a resource group, or update the tags of an existing one, optionally locking it", Setup: setupCreate},
+	"show":      {Summary: "show a resource group", Setup: setupShow},
+	"list":      {Summary: "list the resource groups of the subscription, optionally filtered by tags", Setup: setupList},
+	"update":    {Summary: "add, change or remove the tags of a resource group", Setup: setupUpdate},
+	"delete":    {Summary: "delete a resource group and all the resources in it, waiting for the deletion unless --no-wait is set", Setup: setupDelete},
+	"inventory": {Summary: "list the resources in a resource group by type, optionally exporting them to a JSON or CSV file", Setup: setupInventory},
+}
+
+func main() {
+	helpers.RunCommands(commands)
+}
+
+func setupCreate(fs *flag.FlagSet) func(arm.Client) (interface{}, error) {
+	name := fs.String("name", "", "name of the resource group")
+	location := fs.String("location", "", "location of the resource group, e.g. 'West US'")
+	tags := fs.String("tags", "", "tags of the resource group, as comma-separated name=value pairs")
+	lock := fs.String("lock", "", "lock the group once created, with level CanNotDelete or ReadOnly")
+
+	return func(client arm.Client) (interface{}, error) {
+		t, err := parseTags(*tags)
+		if err != nil {
+			return nil, err
+		}
+		if *lock != "" {
+			if _, err := helpers.ValidateLockLevel(*lock); err != nil {
+				return nil, err
+			}
+		}
+		group, err := createGroup(client, *name, *location, t)
+		if err != nil || *lock == "" {
+			return group, err
+		}
+		if _, err := helpers.LockGroup(client, *name, *lock); err != nil {
+			return nil, err
+		}
+		return group, nil
+	}
+}
+
+func setupShow(fs *flag.FlagSet) func(arm.Client) (interface{}, error) {
+	name := fs.String("name", "", "name of the resource group")
+
+	return func(client arm.Client) (interface{}, error) {
+		return showGroup(client, *name)
+	}
+}
+
+func setupList(fs *flag.FlagSet) func(arm.Client) (interface{}, error) {
+	var filters tagFilters
+	fs.Var(&filters, "tag", "only list groups with this tag, given as name or name=value; may be repeated")
+
+	return func(client arm.Client) (interface{}, error) {
+		return listGroups(client, filters)
+	}
+}
+
+func setupUpdate(fs *flag.FlagSet) func(arm.Client) (interface{}, error) {
+	name := fs.String("name", "", "name of the resource group")
+	tags := fs.String("tags", "", "tags to add or change, as comma-separated name=value pairs")
+	remove := fs.String("remove", "", "comma-separated names of the tags to remove")
+	replace := fs.Bool("replace", false, "replace all the tags of the group with those passed to --tags")
+
+	return func(client arm.Client) (interface{}, error) {
+		t, err := parseTags(*tags)
+		if err != nil {
+			return nil, err
+		}
+		var r []string
+		if *remove != "" {
+			r = strings.Split(*remove, ",")
+		}
+		return updateTags(client, *name, t, r, *replace)
+	}
+}
+
+func setupDelete(fs *flag.FlagSet) func(arm.Client) (interface{}, error) {
+	name := fs.String("name", "", "name of the resource group")
+	noWait := fs.Bool("no-wait", false, "return as soon as ARM has accepted the deletion")
+	timeout := fs.Duration("timeout", helpers.DefaultOperationPoller.Timeout, "maximum time to wait for the deletion to finish")
+	interval := fs.Duration("interval", helpers.DefaultOperationPoller.Delay, "initial interval between polls, doubled after each poll")
+
+	return func(client arm.Client) (interface{}, error) {
+		poller := helpers.DefaultOperationPoller
+		poller.Delay = *interval
+		poller.Timeout = *timeout
+		poller.Progress = func(op *helpers.Operation) {
+			helpers.Logf("Deleting resource group '%s': %s after %.0f seconds\n", *name, op.Status, op.Elapsed().Seconds())
+		}
+		return deleteGroup(client, *name, poller, !*noWait)
+	}
+}
+
+func setupInventory(fs *flag.FlagSet) func(arm.Client) (interface{}, error) {
+	name := fs.String("name", "", "name of the resource group")
+	resourceType := fs.String("type", "", "only list resources of this type, e.g. 'Microsoft.Compute/virtualMachines'")
+	summary := fs.Bool("summary", false, "only count the resources of each type")
+	exportFile := fs.String("export", "", "also write the result to this file, as JSON or CSV depending on its extension")
+
+	return func(client arm.Client) (interface{}, error) {
+		if *exportFile != "" {
+			if _, err := exportFormat(*exportFile); err != nil {
+				return nil, err
+			}
+		}
+
+		items, err := inventory(client, *name, *resourceType)
+		if err != nil {
+			return nil, err
+		}
+
+		var result interface{} = items
+		if *summary {
+			result = summarize(items)
+		}
+
+		if *exportFile != "" {
+			if err := export(*exportFile, result); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	}
+}
+
+// deleteResult is printed after a group has been deleted, or its deletion started, so that scripts
+// get a result from every command. Operation is the URL at which a deletion still in progress can
+// be followed.
+type deleteResult struct {
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	Elapsed   string `json:"elapsed"`
+	Operation string `json:"operation,omitempty"`
+}
+
+// createGroup creates a resource group with the passed tags. If the group exists in the same
+// location, its tags are replaced. The location must be one the subscription can use.
+func createGroup(client arm.Client, name, location string, tags map[string]*string) (group resources.ResourceGroup, err error) {
+
+	if err = helpers.ResourceGroupName.Validate(name); err != nil {
+		return
+	}
+	if location == "" {
+		err = fmt.Errorf("A location is required to create resource group '%s'\n", name)
+		return
+	}
+	if _, err = helpers.ValidateLocation(client, location, "Microsoft.Resources/resourceGroups"); err != nil {
+		return
+	}
+
+	params := resources.ResourceGroup{Name: &name, Location: &location}
+	if len(tags) > 0 {
+		params.Tags = &tags
+	}
+
+	group, err = client.ResourceGroups().CreateOrUpdate(name, params)
+	if err != nil {
+		err = fmt.Errorf("Failed to create resource group '%s' in location '%s': '%s'\n", name, location, err.Error())
+		return
+	}
+
+	helpers.Logf("Created resource group '%s'\n", *group.Name)
+
+	return
+}
+
+// showGroup gets a resource group by name.
+func showGroup(client arm.Client, name string) (group resources.ResourceGroup, err error) {
+
+	if err = helpers.ResourceGroupName.Validate(name); err != nil {
+		return
+	}
+
+	group, err = client.ResourceGroups().Get(name)
+	if err != nil {
+		err = fmt.Errorf("Failed to get resource group '%s': '%s'\n", name, err.Error())
+	}
+	return
+}
+
+// listGroups lists all the resource groups with the passed tags, following the next links of the
+// result. ARM filters by a single tag only, so the first filter is sent to the service and the
+// others are applied here.
+func listGroups(client arm.Client, filters tagFilters) ([]resources.ResourceGroup, error) {
+
+	rgc := client.ResourceGroups()
+
+	odata := ""
+	if len(filters) > 0 {
+		odata = filters[0].odata()
+	}
+
+	groups := []resources.ResourceGroup{}
+
+	page, err := rgc.List(odata, nil)
+	for {
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list resource groups: '%s'\n", err.Error())
+		}
+		if page.Value != nil {
+			for _, g := range *page.Value {
+				if filters.match(g.Tags) {
+					groups = append(groups, g)
+				}
+			}
+		}
+		if page.NextLink == nil || *page.NextLink == "" {
+			break
+		}
+		page, err = rgc.ListNextResults(page)
+	}
+
+	helpers.Logf("Found %d resource groups\n", len(groups))
+
+	return groups, nil
+}
+
+// updateTags adds and changes the passed tags of a resource group and removes those named in
+// 'remove'. With 'replace', the existing tags are dropped first.
+func updateTags(client arm.Client, name string, set map[string]*string, remove []string, replace bool) (group resources.ResourceGroup, err error) {
+
+	group, err = showGroup(client, name)
+	if err != nil {
+		return
+	}
+
+	tags := map[string]*string{}
+	if group.Tags != nil && !replace {
+		for k, v := range *group.Tags {
+			tags[k] = v
+		}
+	}
+	for k, v := range set {
+		deleteTag(tags, k)
+		tags[k] = v
+	}
+	for _, k := range remove {
+		deleteTag(tags, strings.TrimSpace(k))
+	}
+	if len(tags) > maxTags {
+		err = fmt.Errorf("Resource group '%s' can't have more than %d tags\n", name, maxTags)
+		return
+	}
+
+	group, err = client.ResourceGroups().Patch(name, resources.ResourceGroup{Tags: &tags})
+	if err != nil {
+		err = fmt.Errorf("Failed to update the tags of resource group '%s': '%s'\n", name, err.Error())
+		return
+	}
+
+	helpers.Logf("Updated the tags of resource group '%s'\n", name)
+
+	return
+}
+
+// deleteGroup deletes a resource group. Deleting a group is a long-running operation, which
+// the SDK would wait for without telling how it goes, so the request is sent without polling and
+// the operation followed using the poller. Unless 'wait' is set, the function returns as soon as
+// ARM has accepted the request, with the status "Accepted".
+func deleteGroup(client arm.Client, name string, poller helpers.OperationPoller, wait bool) (result deleteResult, err error) {
+
+	if err = helpers.ResourceGroupName.Validate(name); err != nil {
+		return
+	}
+
+	rgc := client.ResourceGroups()
+
+	req, err := rgc.DeletePreparer(name)
+	if err != nil {
+		err = fmt.Errorf("Failed to delete resource group '%s': '%s'\n", name, err.Error())
+		return
+	}
+	op, err := helpers.StartOperation(rgc.Client, req)
+	if err != nil {
+		err = fmt.Errorf("Failed to delete resource group '%s': '%s'\n", name, err.Error())
+		return
+	}
+
+	result = deleteResult{Name: name, Status: "Accepted", Operation: op.URL}
+
+	if !wait && !op.Done() {
+		helpers.Logf("Started deleting resource group '%s'\n", name)
+		result.Elapsed = fmt.Sprintf("%.0fs", op.Elapsed().Seconds())
+		return
+	}
+
+	err = poller.Wait(op)
+	result.Elapsed = fmt.Sprintf("%.0fs", op.Elapsed().Seconds())
+	if err != nil {
+		err = fmt.Errorf("Failed to delete resource group '%s': '%s'\n", name, err.Error())
+		return
+	}
+
+	helpers.Logf("Deleted resource group '%s' in %s\n", name, result.Elapsed)
+
+	return deleteResult{Name: name, Status: "Deleted", Elapsed: result.Elapsed}, nil
+}
+
+// deleteTag removes a tag regardless of case, since ARM treats tag names as case-insensitive.
+func deleteTag(tags map[string]*string, name string) {
+	for k := range tags {
+		if strings.EqualFold(k, name) {
+			delete(tags, k)
+		}
+	}
+}
+
+// tagValue returns the value of a tag regardless of the case of its name.
+func tagValue(tags *map[string]*string, name string) (string, bool) {
+	if tags == nil {
+		return "", false
+	}
+	for k, v := range *tags {
+		if strings.EqualFold(k, name) {
+			return to.String(v), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-go-samples/fakes/armfake"
+	"github.com/Azure/azure-go-samples/helpers"
+	"github.com/Azure/azure-sdk-for-go/Godeps/_workspace/src/github.com/Azure/go-autorest/autorest/to"
+)
+
+func tags(pairs string) map[string]*string {
+	t, err := parseTags(pairs)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestCreateAndShowGroup(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+
+	if _, err := createGroup(client, "samplesgroup", "West US", tags("env=test,owner=samples")); err != nil {
+		t.Fatalf("createGroup failed: %v", err)
+	}
+
+	group, err := showGroup(client, "samplesgroup")
+	if err != nil {
+		t.Fatalf("showGroup failed: %v", err)
+	}
+	if *group.Location != "westus" {
+		t.Errorf("unexpected location '%s'", *group.Location)
+	}
+	if v, _ := tagValue(group.Tags, "env"); v != "test" {
+		t.Errorf("unexpected tags %v", group.Tags)
+	}
+
+	if _, err := showGroup(client, "missinggroup"); err == nil || !strings.Contains(err.Error(), "Failed to get resource group 'missinggroup'") {
+		t.Errorf("expected showing a missing group to fail, got %v", err)
+	}
+}
+
+func TestCreateGroupValidation(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+
+	if _, err := createGroup(client, "samples group", "West US", nil); err == nil {
+		t.Errorf("expected an invalid name to be rejected")
+	}
+	if _, err := createGroup(client, "samplesgroup", "", nil); err == nil {
+		t.Errorf("expected a missing location to be rejected")
+	}
+	if n := len(srv.Requests()); n != 0 {
+		t.Errorf("expected invalid groups to be rejected locally, got %d requests", n)
+	}
+}
+
+func TestListGroups(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	srv.PageSize = 2
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+
+	for name, pairs := range map[string]string{
+		"group1": "env=test,owner=web",
+		"group2": "env=prod,owner=web",
+		"group3": "env=test,owner=db",
+		"group4": "",
+		"group5": "Env=test",
+	} {
+		if _, err := createGroup(client, name, "West US", tags(pairs)); err != nil {
+			t.Fatalf("createGroup failed: %v", err)
+		}
+	}
+
+	for _, c := range []struct {
+		filters  []string
+		expected string
+	}{
+		{nil, "group1 group2 group3 group4 group5"},
+		{[]string{"env"}, "group1 group2 group3 group5"},
+		{[]string{"env=test"}, "group1 group3 group5"},
+		{[]string{"env=test", "owner=web"}, "group1"},
+		{[]string{"owner", "env=prod"}, "group2"},
+		{[]string{"cost"}, ""},
+	} {
+		var filters tagFilters
+		for _, f := range c.filters {
+			filters.Set(f)
+		}
+		groups, err := listGroups(client, filters)
+		if err != nil {
+			t.Fatalf("listGroups(%v) failed: %v", c.filters, err)
+		}
+		var n []string
+		for _, g := range groups {
+			n = append(n, *g.Name)
+		}
+		if strings.Join(n, " ") != c.expected {
+			t.Errorf("listGroups(%v): expected '%s', got '%s'", c.filters, c.expected, strings.Join(n, " "))
+		}
+	}
+
+	// Only the first filter is sent to ARM.
+	last := srv.Requests()[len(srv.Requests())-1]
+	if !strings.Contains(last.Query, "tagname") || strings.Contains(last.Query, "env") {
+		t.Errorf("unexpected query '%s'", last.Query)
+	}
+}
+
+func TestUpdateTags(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+
+	if _, err := createGroup(client, "samplesgroup", "West US", tags("env=test,owner=samples,cost=1")); err != nil {
+		t.Fatalf("createGroup failed: %v", err)
+	}
+
+	group, err := updateTags(client, "samplesgroup", tags("ENV=prod,team=go"), []string{"cost"}, false)
+	if err != nil {
+		t.Fatalf("updateTags failed: %v", err)
+	}
+	expected := map[string]string{"ENV": "prod", "owner": "samples", "team": "go"}
+	if len(*group.Tags) != len(expected) {
+		t.Errorf("expected tags %v, got %d tags", expected, len(*group.Tags))
+	}
+	for k, v := range expected {
+		if got, ok := (*group.Tags)[k]; !ok || to.String(got) != v {
+			t.Errorf("expected tag %s=%s, got %v", k, v, got)
+		}
+	}
+
+	group, err = updateTags(client, "samplesgroup", tags("env=dev"), nil, true)
+	if err != nil {
+		t.Fatalf("updateTags failed: %v", err)
+	}
+	if len(*group.Tags) != 1 || to.String((*group.Tags)["env"]) != "dev" {
+		t.Errorf("expected the tags to be replaced, got %v", *group.Tags)
+	}
+
+	last := srv.Requests()[len(srv.Requests())-1]
+	if last.Method != "PATCH" {
+		t.Errorf("expected the tags to be patched, got %s %s", last.Method, last.Path)
+	}
+	if _, ok := last.Body["location"]; ok {
+		t.Errorf("unexpected location in the patch %v", last.Body)
+	}
+}
+
+// fastPoller keeps the tests from waiting, the fake server doesn't ask for delays.
+var fastPoller = helpers.OperationPoller{Delay: time.Millisecond, MaxDelay: 4 * time.Millisecond, Timeout: 5 * time.Second}
+
+func TestDeleteGroup(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	srv.PollCount = 3
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+
+	if _, err := createGroup(client, "samplesgroup", "West US", nil); err != nil {
+		t.Fatalf("createGroup failed: %v", err)
+	}
+
+	var progress []string
+	poller := fastPoller
+	poller.Progress = func(op *helpers.Operation) { progress = append(progress, op.Status) }
+
+	result, err := deleteGroup(client, "samplesgroup", poller, true)
+	if err != nil {
+		t.Fatalf("deleteGroup failed: %v", err)
+	}
+	if result.Name != "samplesgroup" || result.Status != "Deleted" || result.Elapsed == "" || result.Operation != "" {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if _, ok := srv.Resource("/subscriptions/" + srv.SubscriptionID + "/resourceGroups/samplesgroup"); ok {
+		t.Errorf("the group was not deleted")
+	}
+	if strings.Join(progress, " ") != "InProgress InProgress InProgress Succeeded" {
+		t.Errorf("unexpected progress %v", progress)
+	}
+
+	// The SDK isn't left to poll on its own: only the operation URL is polled.
+	for _, r := range srv.Requests() {
+		if r.Method == "GET" && strings.Contains(strings.ToLower(r.Path), "/resourcegroups/") {
+			t.Errorf("unexpected request %s %s", r.Method, r.Path)
+		}
+	}
+
+	if _, err := deleteGroup(client, "samplesgroup", poller, true); err == nil {
+		t.Errorf("expected deleting a missing group to fail")
+	}
+}
+
+func TestDeleteGroupNoWait(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+
+	if _, err := createGroup(client, "samplesgroup", "West US", nil); err != nil {
+		t.Fatalf("createGroup failed: %v", err)
+	}
+
+	result, err := deleteGroup(client, "samplesgroup", fastPoller, false)
+	if err != nil {
+		t.Fatalf("deleteGroup failed: %v", err)
+	}
+	if result.Status != "Accepted" || !strings.HasPrefix(result.Operation, srv.URL) {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	group, ok := srv.Resource("/subscriptions/" + srv.SubscriptionID + "/resourceGroups/samplesgroup")
+	if !ok || group["properties"].(map[string]interface{})["provisioningState"] != "Deleting" {
+		t.Errorf("expected the group to be deleting, got %v", group)
+	}
+}
+
+func TestDeleteGroupFailure(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+
+	if _, err := createGroup(client, "samplesgroup", "West US", nil); err != nil {
+		t.Fatalf("createGroup failed: %v", err)
+	}
+	srv.Fail("DELETE", "/resourceGroups/samplesgroup$", 409, "ScopeLocked", "The scope is locked.", false)
+
+	_, err := deleteGroup(client, "samplesgroup", fastPoller, true)
+	if err == nil || !strings.Contains(err.Error(), "ScopeLocked: The scope is locked.") {
+		t.Errorf("expected the deletion to fail, got %v", err)
+	}
+}
+
+func TestCreateLockedGroup(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+
+	fs := flag.NewFlagSet("create", flag.ContinueOnError)
+	run := setupCreate(fs)
+	if err := fs.Parse([]string{"--name", "samplesgroup", "--location", "West US", "--lock", "cannotdelete"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run(client); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+
+	_, err := deleteGroup(client, "samplesgroup", fastPoller, true)
+	if err == nil || !strings.Contains(err.Error(), "ScopeLocked") {
+		t.Errorf("expected the deletion to fail, got %v", err)
+	}
+
+	if err := helpers.DeleteLock(client, helpers.LockScope{ResourceGroup: "samplesgroup"}, "samplesgroup-lock"); err != nil {
+		t.Fatalf("DeleteLock failed: %v", err)
+	}
+	if _, err := deleteGroup(client, "samplesgroup", fastPoller, true); err != nil {
+		t.Errorf("deleteGroup failed once unlocked: %v", err)
+	}
+}
+
+func TestCreateGroupInvalidLock(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+
+	fs := flag.NewFlagSet("create", flag.ContinueOnError)
+	run := setupCreate(fs)
+	if err := fs.Parse([]string{"--name", "samplesgroup", "--location", "West US", "--lock", "forever"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run(helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)); err == nil || !strings.Contains(err.Error(), "Invalid lock level 'forever'") {
+		t.Errorf("expected the lock level to be rejected, got %v", err)
+	}
+	if n := len(srv.Requests()); n != 0 {
+		t.Errorf("expected no requests, got %d", n)
+	}
+}
+
+func TestDeleteGroupTimeout(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	srv.PollCount = 1000
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+
+	if _, err := createGroup(client, "samplesgroup", "West US", nil); err != nil {
+		t.Fatalf("createGroup failed: %v", err)
+	}
+
+	poller := fastPoller
+	poller.Timeout = 50 * time.Millisecond
+
+	_, err := deleteGroup(client, "samplesgroup", poller, true)
+	if err == nil || !strings.Contains(err.Error(), "didn't finish within 50ms") || !strings.Contains(err.Error(), srv.URL+"/fake/operations/") {
+		t.Errorf("expected the deletion to time out, got %v", err)
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	parsed, err := parseTags(" env = test ,flag, owner=a=b")
+	if err != nil {
+		t.Fatalf("parseTags failed: %v", err)
+	}
+	expected := map[string]string{"env": "test", "flag": "", "owner": "a=b"}
+	if len(parsed) != len(expected) {
+		t.Errorf("expected %v, got %d tags", expected, len(parsed))
+	}
+	for k, v := range expected {
+		if got, ok := parsed[k]; !ok || *got != v {
+			t.Errorf("expected tag %s=%s, got %v", k, v, got)
+		}
+	}
+
+	for _, s := range []string{"=value", "a/b=c", "env=1,ENV=2", strings.Repeat("x", 513), "n=" + strings.Repeat("x", 257)} {
+		if _, err := parseTags(s); err == nil {
+			t.Errorf("expected parseTags(%.20s) to fail", s)
+		}
+	}
+
+	many := make([]string, maxTags+1)
+	for i := range many {
+		many[i] = string('a'+rune(i)) + "=1"
+	}
+	if _, err := parseTags(strings.Join(many, ",")); err == nil {
+		t.Errorf("expected more than %d tags to be rejected", maxTags)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-go-samples/helpers"
+	"github.com/Azure/azure-sdk-for-go/Godeps/_workspace/src/github.com/Azure/go-autorest/autorest/to"
+	"github.com/Azure/azure-sdk-for-go/arm"
+	"github.com/Azure/azure-sdk-for-go/arm/resources/resources"
+)
+
+// inventoryItem is one resource of a group, with the fields needed to tell what it is and
+// whether it was provisioned.
+type inventoryItem struct {
+	Type              string            `json:"type"`
+	Name              string            `json:"name"`
+	Location          string            `json:"location"`
+	ProvisioningState string            `json:"provisioningState"`
+	Tags              map[string]string `json:"tags,omitempty"`
+	ID                string            `json:"id"`
+}
+
+// typeSummary counts the resources of one type in a group.
+type typeSummary struct {
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+}
+
+// inventory lists all the resources in a resource group, optionally of a single type, following
+// the next links of the result. The resources are sorted by type, then by name, so that resources
+// of the same type are listed together.
+func inventory(client arm.Client, name, resourceType string) ([]inventoryItem, error) {
+
+	if err := helpers.ResourceGroupName.Validate(name); err != nil {
+		return nil, err
+	}
+
+	rgc := client.ResourceGroups()
+
+	filter := ""
+	if resourceType != "" {
+		filter = fmt.Sprintf("resourceType eq '%s'", strings.Replace(resourceType, "'", "''", -1))
+	}
+
+	items := []inventoryItem{}
+
+	page, err := rgc.ListResources(name, filter, nil)
+	for {
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list the resources of resource group '%s': '%s'\n", name, err.Error())
+		}
+		if page.Value != nil {
+			for _, r := range *page.Value {
+				items = append(items, newInventoryItem(r))
+			}
+		}
+		if page.NextLink == nil || *page.NextLink == "" {
+			break
+		}
+		page, err = rgc.ListResourcesNextResults(page)
+	}
+
+	sort.Sort(byTypeAndName(items))
+
+	helpers.Logf("Found %d resources of %d types in resource group '%s'\n", len(items), len(summarize(items)), name)
+
+	return items, nil
+}
+
+func newInventoryItem(r resources.GenericResource) inventoryItem {
+	item := inventoryItem{
+		Type:     to.String(r.Type),
+		Name:     to.String(r.Name),
+		Location: to.String(r.Location),
+		ID:       to.String(r.ID),
+	}
+	if r.Properties != nil {
+		if state, ok := (*r.Properties)["provisioningState"].(string); ok {
+			item.ProvisioningState = state
+		}
+	}
+	if r.Tags != nil && len(*r.Tags) > 0 {
+		item.Tags = map[string]string{}
+		for k, v := range *r.Tags {
+			item.Tags[k] = to.String(v)
+		}
+	}
+	return item
+}
+
+// summarize counts the resources of each type, in the order of the types in the inventory.
+func summarize(items []inventoryItem) []typeSummary {
+	summary := []typeSummary{}
+	for _, item := range items {
+		if n := len(summary); n > 0 && strings.EqualFold(summary[n-1].Type, item.Type) {
+			summary[n-1].Count++
+			continue
+		}
+		summary = append(summary, typeSummary{Type: item.Type, Count: 1})
+	}
+	return summary
+}
+
+// exportFormat picks the format of an export file from its extension, .json or .csv.
+func exportFormat(fileName string) (string, error) {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".json":
+		return helpers.FormatJSON, nil
+	case ".csv":
+		return helpers.FormatCSV, nil
+	}
+	return "", fmt.Errorf("Unable to export to '%s', use a file name ending in .json or .csv\n", fileName)
+}
+
+// export writes a result to a file, in the format given by its extension.
+func export(fileName string, v interface{}) error {
+	format, err := exportFormat(fileName)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("Failed to create export file '%s': '%s'\n", fileName, err.Error())
+	}
+
+	err = (&helpers.Output{Format: format, Writer: f}).Print(v)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to write export file '%s': '%s'\n", fileName, err.Error())
+	}
+
+	helpers.Logf("Exported the inventory to '%s'\n", fileName)
+
+	return nil
+}
+
+type byTypeAndName []inventoryItem
+
+func (l byTypeAndName) Len() int      { return len(l) }
+func (l byTypeAndName) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l byTypeAndName) Less(i, j int) bool {
+	ti, tj := strings.ToLower(l[i].Type), strings.ToLower(l[j].Type)
+	if ti != tj {
+		return ti < tj
+	}
+	return strings.ToLower(l[i].Name) < strings.ToLower(l[j].Name)
+}
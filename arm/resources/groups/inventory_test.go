@@ -0,0 +1,146 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-go-samples/fakes/armfake"
+	"github.com/Azure/azure-go-samples/helpers"
+	"github.com/Azure/azure-sdk-for-go/Godeps/_workspace/src/github.com/Azure/go-autorest/autorest/to"
+	"github.com/Azure/azure-sdk-for-go/arm"
+	"github.com/Azure/azure-sdk-for-go/arm/compute"
+	"github.com/Azure/azure-sdk-for-go/arm/network"
+)
+
+// populate creates a group holding two availability sets, one of them tagged, and two public IP
+// addresses.
+func populate(t *testing.T, client arm.Client) {
+	if _, err := createGroup(client, "samplesgroup", "West US", nil); err != nil {
+		t.Fatalf("createGroup failed: %v", err)
+	}
+	location := to.StringPtr("West US")
+	for _, name := range []string{"web", "db"} {
+		set := compute.AvailabilitySet{Location: location}
+		if name == "db" {
+			set.Tags = &map[string]*string{"tier": to.StringPtr("data")}
+		}
+		if _, err := client.AvailabilitySets().CreateOrUpdate("samplesgroup", name, set); err != nil {
+			t.Fatalf("unable to create availability set '%s': %v", name, err)
+		}
+	}
+	for _, name := range []string{"ip02", "ip01"} {
+		ip := network.PublicIPAddress{
+			Location:   location,
+			Properties: &network.PublicIPAddressPropertiesFormat{PublicIPAllocationMethod: network.Dynamic},
+		}
+		if _, err := client.PublicIPAddresses().CreateOrUpdate("samplesgroup", name, ip); err != nil {
+			t.Fatalf("unable to create public IP address '%s': %v", name, err)
+		}
+	}
+}
+
+func TestInventory(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	srv.PageSize = 1
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+	populate(t, client)
+
+	items, err := inventory(client, "samplesgroup", "")
+	if err != nil {
+		t.Fatalf("inventory failed: %v", err)
+	}
+
+	var names []string
+	for _, item := range items {
+		names = append(names, item.Type+"/"+item.Name)
+		if item.Location != "westus" || item.ProvisioningState != "Succeeded" || item.ID == "" {
+			t.Errorf("unexpected item %+v", item)
+		}
+	}
+	expected := "Microsoft.Compute/availabilitySets/db Microsoft.Compute/availabilitySets/web Microsoft.Network/publicIPAddresses/ip01 Microsoft.Network/publicIPAddresses/ip02"
+	if strings.Join(names, " ") != expected {
+		t.Errorf("expected '%s', got '%s'", expected, strings.Join(names, " "))
+	}
+	if items[0].Tags["tier"] != "data" || items[1].Tags != nil {
+		t.Errorf("unexpected tags %v and %v", items[0].Tags, items[1].Tags)
+	}
+
+	// One resource per page: the inventory followed the next links.
+	lists := 0
+	for _, r := range srv.Requests() {
+		if r.Method == "GET" && strings.HasSuffix(strings.ToLower(r.Path), "/resources") {
+			lists++
+		}
+	}
+	if lists != 4 {
+		t.Errorf("expected 4 pages, got %d", lists)
+	}
+
+	summary := summarize(items)
+	if len(summary) != 2 || summary[0].Count != 2 || summary[1].Type != "Microsoft.Network/publicIPAddresses" || summary[1].Count != 2 {
+		t.Errorf("unexpected summary %v", summary)
+	}
+
+	items, err = inventory(client, "samplesgroup", "Microsoft.Network/publicIPAddresses")
+	if err != nil {
+		t.Fatalf("inventory failed: %v", err)
+	}
+	if len(items) != 2 || items[0].Name != "ip01" {
+		t.Errorf("expected the public IP addresses only, got %v", items)
+	}
+
+	if _, err := inventory(client, "missinggroup", ""); err == nil || !strings.Contains(err.Error(), "Failed to list the resources of resource group 'missinggroup'") {
+		t.Errorf("expected listing a missing group to fail, got %v", err)
+	}
+}
+
+func TestExportInventory(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+	populate(t, client)
+
+	items, err := inventory(client, "samplesgroup", "")
+	if err != nil {
+		t.Fatalf("inventory failed: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	csvFile := filepath.Join(dir, "inventory.CSV")
+	if err := export(csvFile, items); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	b, err := ioutil.ReadFile(csvFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 5 || lines[0] != "type,name,location,provisioningState,tags.tier,id" || !strings.HasPrefix(lines[1], "Microsoft.Compute/availabilitySets,db,westus,Succeeded,data,/subscriptions/") {
+		t.Errorf("unexpected CSV:\n%s", b)
+	}
+
+	jsonFile := filepath.Join(dir, "inventory.json")
+	if err := export(jsonFile, summarize(items)); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	b, err = ioutil.ReadFile(jsonFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"type": "Microsoft.Network/publicIPAddresses",`) || !strings.Contains(string(b), `"count": 2`) {
+		t.Errorf("unexpected JSON:\n%s", b)
+	}
+
+	if err := export(filepath.Join(dir, "inventory.xml"), items); err == nil {
+		t.Errorf("expected an unknown extension to be rejected")
+	}
+}
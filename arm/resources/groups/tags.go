@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The limits ARM places on the tags of a resource or resource group.
+const (
+	maxTags           = 15
+	maxTagNameLength  = 512
+	maxTagValueLength = 256
+	invalidTagChars   = "<>%&\\?/"
+)
+
+// parseTags parses tags given as comma-separated name=value pairs, such as "env=test,owner=ops".
+// A name without '=' gets an empty value.
+func parseTags(s string) (map[string]*string, error) {
+	tags := map[string]*string{}
+	if strings.TrimSpace(s) == "" {
+		return tags, nil
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		name, value := pair, ""
+		if i := strings.Index(pair, "="); i >= 0 {
+			name, value = pair[:i], pair[i+1:]
+		}
+		name = strings.TrimSpace(name)
+		value = strings.TrimSpace(value)
+
+		switch {
+		case name == "":
+			return nil, fmt.Errorf("Invalid tag '%s', the name is missing\n", pair)
+		case len(name) > maxTagNameLength:
+			return nil, fmt.Errorf("Invalid tag '%s', the name is longer than %d characters\n", name, maxTagNameLength)
+		case strings.ContainsAny(name, invalidTagChars):
+			return nil, fmt.Errorf("Invalid tag '%s', the name cannot contain any of %s\n", name, invalidTagChars)
+		case len(value) > maxTagValueLength:
+			return nil, fmt.Errorf("Invalid tag '%s', the value is longer than %d characters\n", name, maxTagValueLength)
+		}
+		if _, ok := tagValue(&tags, name); ok {
+			return nil, fmt.Errorf("The tag '%s' is given more than once\n", name)
+		}
+
+		v := value
+		tags[name] = &v
+	}
+
+	if len(tags) > maxTags {
+		return nil, fmt.Errorf("At most %d tags are allowed, got %d\n", maxTags, len(tags))
+	}
+	return tags, nil
+}
+
+// tagFilter selects groups that have a tag, with any value when Value is nil.
+type tagFilter struct {
+	Name  string
+	Value *string
+}
+
+// odata returns the filter in the form of the $filter query parameter.
+func (f tagFilter) odata() string {
+	quote := func(s string) string { return strings.Replace(s, "'", "''", -1) }
+	if f.Value == nil {
+		return fmt.Sprintf("tagname eq '%s'", quote(f.Name))
+	}
+	return fmt.Sprintf("tagname eq '%s' and tagvalue eq '%s'", quote(f.Name), quote(*f.Value))
+}
+
+// tagFilters implements flag.Value, so that --tag can be given several times.
+type tagFilters []tagFilter
+
+func (fs *tagFilters) String() string {
+	var s []string
+	for _, f := range *fs {
+		if f.Value == nil {
+			s = append(s, f.Name)
+		} else {
+			s = append(s, f.Name+"="+*f.Value)
+		}
+	}
+	return strings.Join(s, ",")
+}
+
+func (fs *tagFilters) Set(s string) error {
+	f := tagFilter{Name: s}
+	if i := strings.Index(s, "="); i >= 0 {
+		v := s[i+1:]
+		f = tagFilter{Name: s[:i], Value: &v}
+	}
+	if strings.TrimSpace(f.Name) == "" {
+		return fmt.Errorf("the tag name is missing in '%s'", s)
+	}
+	*fs = append(*fs, f)
+	return nil
+}
+
+// match reports whether a set of tags passes all the filters.
+func (fs tagFilters) match(tags *map[string]*string) bool {
+	for _, f := range fs {
+		v, ok := tagValue(tags, f.Name)
+		if !ok || f.Value != nil && v != *f.Value {
+			return false
+		}
+	}
+	return true
+}
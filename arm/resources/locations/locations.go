@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-go-samples/helpers"
+	"github.com/Azure/azure-sdk-for-go/arm"
+)
+
+func main() {
+
+	types := flag.String("types", "", "comma-separated resource types to show the availability of, e.g. 'Microsoft.Compute/virtualMachines'")
+	location := flag.String("location", "", "validate this location for the resource types and show it only")
+
+	output := helpers.OutputFlags(nil)
+	flag.Lookup("output").DefValue = helpers.FormatTable
+	output.Format = helpers.FormatTable
+	flag.Parse()
+
+	client, err := helpers.AuthenticateForARM()
+	if err != nil {
+		helpers.Logf("Failed to authenticate: '%s'\n", err.Error())
+		os.Exit(1)
+	}
+
+	client.RequestInspector = helpers.WithInspection()
+	client.ResponseInspector = helpers.ByInspecting()
+
+	var resourceTypes []string
+	for _, t := range strings.Split(*types, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			resourceTypes = append(resourceTypes, t)
+		}
+	}
+
+	result, err := listLocations(client, *location, resourceTypes)
+	if err != nil {
+		helpers.Logf("%s\n", err.Error())
+		os.Exit(1)
+	}
+
+	if err := output.Print(result); err != nil {
+		helpers.Logf("%s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+// locationResult is a location of the subscription, with the resource types asked about and
+// whether each can be created there.
+type locationResult struct {
+	Name          string          `json:"name"`
+	DisplayName   string          `json:"displayName"`
+	ResourceTypes map[string]bool `json:"resourceTypes,omitempty"`
+}
+
+// listLocations lists the locations available to the subscription and, for each, which of the
+// passed resource types can be created in it. When a location is passed, it is validated for the
+// resource types and returned alone.
+func listLocations(client arm.Client, location string, resourceTypes []string) ([]locationResult, error) {
+
+	l, err := helpers.LoadLocations(client)
+	if err != nil {
+		return nil, err
+	}
+
+	all := l.All()
+	if location != "" {
+		if _, err := l.Validate(location, resourceTypes...); err != nil {
+			return nil, err
+		}
+		loc, _ := l.Find(location)
+		all = []helpers.Location{loc}
+	}
+
+	available := map[string]map[string]bool{}
+	for _, t := range resourceTypes {
+		locations, err := l.ResourceTypeLocations(t)
+		if err != nil {
+			return nil, err
+		}
+		available[t] = map[string]bool{}
+		for _, loc := range locations {
+			available[t][loc.Name] = true
+		}
+	}
+
+	result := []locationResult{}
+	for _, loc := range all {
+		r := locationResult{Name: loc.Name, DisplayName: loc.DisplayName}
+		if len(resourceTypes) > 0 {
+			r.ResourceTypes = map[string]bool{}
+			for _, t := range resourceTypes {
+				r.ResourceTypes[t] = available[t][loc.Name]
+			}
+		}
+		result = append(result, r)
+	}
+
+	helpers.Logf("Found %d locations\n", len(result))
+
+	return result, nil
+}
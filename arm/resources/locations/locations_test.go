@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-go-samples/fakes/armfake"
+	"github.com/Azure/azure-go-samples/helpers"
+)
+
+func TestListLocations(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	srv.LimitResourceType("Microsoft.Web/sites", "West US")
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+
+	result, err := listLocations(client, "", []string{"Microsoft.Web/sites", "Microsoft.Storage/storageAccounts"})
+	if err != nil {
+		t.Fatalf("listLocations failed: %v", err)
+	}
+	if len(result) != 4 {
+		t.Fatalf("expected 4 locations, got %d", len(result))
+	}
+	for _, r := range result {
+		if r.ResourceTypes["Microsoft.Web/sites"] != (r.Name == "westus") || !r.ResourceTypes["Microsoft.Storage/storageAccounts"] {
+			t.Errorf("unexpected availability in %s: %v", r.Name, r.ResourceTypes)
+		}
+	}
+
+	result, err = listLocations(client, "West US", []string{"Microsoft.Web/sites"})
+	if err != nil {
+		t.Fatalf("listLocations failed: %v", err)
+	}
+	if len(result) != 1 || result[0].Name != "westus" || result[0].DisplayName != "West US" {
+		t.Errorf("expected West US only, got %v", result)
+	}
+
+	if _, err := listLocations(client, "East US", []string{"Microsoft.Web/sites"}); err == nil || !strings.Contains(err.Error(), "use one of 'West US'") {
+		t.Errorf("expected East US to be rejected for web apps, got %v", err)
+	}
+}
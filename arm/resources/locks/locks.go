@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/Azure/azure-go-samples/helpers"
+	"github.com/Azure/azure-sdk-for-go/arm"
+)
+
+// commands are the operations of the sample, run by helpers.RunCommands.
+var commands = map[string]helpers.Command{
+	"create": {Summary: "lock the subscription, a resource group or a resource, or change the level of an existing lock", Setup: setupCreate},
+	"list":   {Summary: "list the locks that apply to the subscription, a resource group or a resource", Setup: setupList},
+	"delete": {Summary: "remove a lock", Setup: setupDelete},
+}
+
+func main() {
+	helpers.RunCommands(commands)
+}
+
+// scopeFlags registers the flags selecting the scope of a lock.
+func scopeFlags(fs *flag.FlagSet) *helpers.LockScope {
+	scope := &helpers.LockScope{}
+	fs.StringVar(&scope.ResourceGroup, "group", "", "resource group to lock, or holding the resource to lock; without it, the subscription is locked")
+	fs.StringVar(&scope.Resource, "resource", "", "resource to lock within the group, as type and name, e.g. 'Microsoft.Network/publicIPAddresses/ip01'")
+	return scope
+}
+
+func setupCreate(fs *flag.FlagSet) func(arm.Client) (interface{}, error) {
+	scope := scopeFlags(fs)
+	name := fs.String("name", "", "name of the lock")
+	level := fs.String("level", helpers.LockCanNotDelete, "level of the lock, CanNotDelete or ReadOnly")
+	notes := fs.String("notes", "", "why the lock is there")
+
+	return func(client arm.Client) (interface{}, error) {
+		return helpers.CreateLock(client, *scope, *name, *level, *notes)
+	}
+}
+
+func setupList(fs *flag.FlagSet) func(arm.Client) (interface{}, error) {
+	scope := scopeFlags(fs)
+
+	return func(client arm.Client) (interface{}, error) {
+		locks, err := helpers.ListLocks(client, *scope)
+		if err != nil {
+			return nil, err
+		}
+		helpers.Logf("Found %d locks for %s\n", len(locks), scope)
+		return locks, nil
+	}
+}
+
+func setupDelete(fs *flag.FlagSet) func(arm.Client) (interface{}, error) {
+	scope := scopeFlags(fs)
+	name := fs.String("name", "", "name of the lock")
+
+	return func(client arm.Client) (interface{}, error) {
+		if err := helpers.DeleteLock(client, *scope, *name); err != nil {
+			return nil, err
+		}
+		return deleteResult{Name: *name, Scope: scope.String(), Status: "Deleted"}, nil
+	}
+}
+
+// deleteResult is printed after a lock has been removed, so that scripts get a result from every
+// command.
+type deleteResult struct {
+	Name   string `json:"name"`
+	Scope  string `json:"scope"`
+	Status string `json:"status"`
+}
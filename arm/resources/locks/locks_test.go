@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-go-samples/fakes/armfake"
+	"github.com/Azure/azure-go-samples/helpers"
+	"github.com/Azure/azure-sdk-for-go/Godeps/_workspace/src/github.com/Azure/go-autorest/autorest/to"
+	"github.com/Azure/azure-sdk-for-go/arm"
+	"github.com/Azure/azure-sdk-for-go/arm/resources/resources"
+)
+
+// run parses the flags of a command and runs it.
+func run(t *testing.T, client arm.Client, name string, args ...string) (interface{}, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	r := commands[name].Setup(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return r(client)
+}
+
+func TestLockCommands(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+
+	if _, err := client.ResourceGroups().CreateOrUpdate("shared", resources.ResourceGroup{Location: to.StringPtr("West US")}); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := run(t, client, "create", "--group", "shared", "--name", "keep", "--notes", "used by every team")
+	if err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	if lock := result.(helpers.Lock); lock.Level != helpers.LockCanNotDelete || !strings.HasSuffix(lock.Scope, "/resourceGroups/shared") {
+		t.Errorf("unexpected lock %+v", lock)
+	}
+	if _, err := client.ResourceGroups().Delete("shared"); err == nil {
+		t.Errorf("expected the deletion of the locked group to fail")
+	}
+
+	result, err = run(t, client, "list")
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if locks := result.([]helpers.Lock); len(locks) != 1 || locks[0].Name != "keep" || locks[0].Notes != "used by every team" {
+		t.Errorf("unexpected locks %+v", locks)
+	}
+
+	result, err = run(t, client, "delete", "--group", "shared", "--name", "keep")
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if r := result.(deleteResult); r.Status != "Deleted" || r.Scope != "resource group 'shared'" {
+		t.Errorf("unexpected result %+v", r)
+	}
+
+	result, err = run(t, client, "list", "--group", "shared")
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if locks := result.([]helpers.Lock); len(locks) != 0 {
+		t.Errorf("unexpected locks %+v", locks)
+	}
+}
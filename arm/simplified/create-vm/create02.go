@@ -1,7 +1,7 @@
 package main
 
 import (
-	"fmt"
+	"flag"
 
 	"github.com/Azure/azure-go-samples/helpers"
 
@@ -12,15 +12,32 @@ import (
 
 func main() {
 
+	output := helpers.OutputFlags(nil)
+	flag.Parse()
+
 	client, err := helpers.AuthenticateForARM()
 	if err != nil {
-		fmt.Printf("Failed to authenticate: '%s'\n", err.Error())
+		helpers.Logf("Failed to authenticate: '%s'\n", err.Error())
 		return
 	}
 
 	client.RequestInspector = helpers.WithInspection()
 	client.ResponseInspector = helpers.ByInspecting()
 
+	vms, err := createVMs(client)
+	if err != nil {
+		helpers.Logf("Failed to create virtual machine: %s\n", err.Error())
+		return
+	}
+
+	if err := output.Print(vms); err != nil {
+		helpers.Logf("%s\n", err.Error())
+	}
+}
+
+// createVMs creates a Windows and an Ubuntu virtual machine, letting CreateSimpleVM take care of
+// the resource group, storage account, network and availability set each of them needs.
+func createVMs(client arm.Client) ([]compute.VirtualMachine, error) {
 	vm1, err :=
 		client.CreateSimpleVM(
 			to.StringPtr("vmgroup02"),
@@ -40,11 +57,10 @@ func main() {
 		)
 
 	if err != nil {
-		fmt.Printf("Failed to create virtual machine: %s\n", err.Error())
-		return
+		return nil, err
 	}
 
-	fmt.Printf("Created vm '%s'\n", *vm1.Name)
+	helpers.Logf("Created vm '%s'\n", *vm1.Name)
 
 	vm2, err :=
 		client.CreateSimpleVM(
@@ -65,9 +81,10 @@ func main() {
 		)
 
 	if err != nil {
-		fmt.Printf("Failed to create virtual machine: %s\n", err.Error())
-		return
+		return nil, err
 	}
 
-	fmt.Printf("Created vm '%s'\n", *vm2.Name)
+	helpers.Logf("Created vm '%s'\n", *vm2.Name)
+
+	return []compute.VirtualMachine{vm1, vm2}, nil
 }
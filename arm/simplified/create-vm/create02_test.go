@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-go-samples/fakes/armfake"
+	"github.com/Azure/azure-go-samples/helpers"
+)
+
+// The simplified API decides for itself which supporting resources to create, so these tests only
+// look at the virtual machines and at the order in which they are created.
+
+func TestCreateVMs(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	srv.TakeStorageAccountName("vmgroup01accnta44c9ea1")
+
+	vms, err := createVMs(helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL))
+	if err != nil {
+		t.Fatalf("createVMs failed: %v", err)
+	}
+	if len(vms) != 2 {
+		t.Fatalf("expected two virtual machines, got %d", len(vms))
+	}
+
+	for i, group := range []string{"vmgroup02", "vmgroup01"} {
+		if vms[i].ID == nil || !strings.Contains(strings.ToLower(*vms[i].ID), "/resourcegroups/"+group+"/") {
+			t.Errorf("virtual machine %d was not created in '%s'", i+1, group)
+		}
+	}
+
+	n := 0
+	for _, id := range srv.ResourceIDs() {
+		if strings.Contains(strings.ToLower(id), "/microsoft.compute/virtualmachines/") {
+			n++
+		}
+	}
+	if n != 2 {
+		t.Errorf("expected two virtual machines on the server, got %d", n)
+	}
+}
+
+func TestCreateVMsStopsAtFirstError(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	srv.TakeStorageAccountName("vmgroup01accnta44c9ea1")
+	srv.Fail("PUT", "/networkInterfaces/", 400, "InvalidRequestFormat", "Cannot parse the request.", false)
+
+	if _, err := createVMs(helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)); err == nil {
+		t.Fatalf("expected createVMs to fail")
+	}
+	for _, r := range srv.Requests() {
+		if strings.Contains(strings.ToLower(r.Path), "/resourcegroups/vmgroup01") {
+			t.Errorf("unexpected request for the second virtual machine: %s %s", r.Method, r.Path)
+		}
+	}
+}
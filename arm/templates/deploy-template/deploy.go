@@ -1,8 +1,8 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"os"
 	
 	"github.com/Azure/azure-sdk-for-go/arm"
 	"github.com/Azure/azure-sdk-for-go/arm/resources/resources"
@@ -13,10 +13,14 @@ import (
 
 func main() {
 
-	if len(os.Args) == 2 && os.Args[1] == "--help" {
-		fmt.Println("usage: deploy [parameter-file-name [template-file-name]]")
-		return
+	flag.Usage = func() {
+		helpers.Logf("usage: deploy [--output format] [--query expression] [parameter-file-name [template-file-name]]\n")
+		flag.PrintDefaults()
 	}
+
+	output := helpers.OutputFlags(nil)
+	flag.Parse()
+	args := flag.Args()
 	
 	deploymentName := "simplelinux"
 	groupName := "templatetests"
@@ -24,37 +28,53 @@ func main() {
 		
 	arm,err := helpers.AuthenticateForARM()
 	if err != nil {
-		fmt.Printf("Failed to authenticate: '%s'\n", err.Error())
+		helpers.Logf("Failed to authenticate: '%s'\n", err.Error())
 		return
 	}
 	
 	arm.RequestInspector = helpers.WithInspection()
 	arm.ResponseInspector = helpers.ByInspecting()
 	
-	_,err = createResourceGroup(groupName, groupLocation, arm)
+	deploymentProps,err := deploymentProperties(args)
+	if err != nil {
+		helpers.Logf("%s", err.Error())
+		return
+	}
+
+	deployment,err := deploy(groupName, groupLocation, deploymentName, deploymentProps, arm)
 	if err != nil {
-		fmt.Printf("Failed to create resource group '%s': '%s'\n", groupName, err.Error())
+		helpers.Logf("%s", err.Error())
 		return
 	}
+	
+	if err := output.Print(deployment); err != nil {
+		helpers.Logf("%s\n", err.Error())
+	}
+}
+
+// deploymentProperties builds the properties of the deployment from the command line arguments:
+// an optional parameter file and an optional template file. Without a template file, the
+// deployment links to template01.json on GitHub.
+func deploymentProperties(args []string) (deploymentProps resources.DeploymentProperties, err error) {
 
 	var parameterLink *string
 	var parameters map[string]interface{}
 
 	var templateLink *string
 	
-	if len(os.Args) >= 2 { 
-		pl := os.Args[1]
+	if len(args) >= 1 { 
+		pl := args[0]
 		parameterLink = &pl
 	}
-	if len(os.Args) >= 3 { 
-		tl := os.Args[2]
+	if len(args) >= 2 { 
+		tl := args[1]
 		templateLink = &tl
 	}
 	
 	if parameterLink != nil {
 		parameters,err = helpers.ReadMap(*parameterLink)
 		if err != nil {
-			fmt.Printf("Failed to read parameter file '%s': '%s'\n", *parameterLink, err.Error())
+			err = fmt.Errorf("Failed to read parameter file '%s': '%s'\n", *parameterLink, err.Error())
 			return
 		}
 		if p,ok := parameters["parameters"]; ok {
@@ -69,13 +89,11 @@ func main() {
 		}
 	}
 	
-	var deploymentProps resources.DeploymentProperties
-	
 	if templateLink != nil {
 
-		template,err := helpers.ReadMap(*templateLink)
-		if err != nil {
-			fmt.Printf("Failed to read template file '%s': '%s'\n", *templateLink, err.Error())
+		template,err1 := helpers.ReadMap(*templateLink)
+		if err1 != nil {
+			err = fmt.Errorf("Failed to read template file '%s': '%s'\n", *templateLink, err1.Error())
 			return
 		}
 
@@ -97,18 +115,37 @@ func main() {
 		}
 
 	}
+	
+	return
+}
+
+// deploy creates the resource group, if necessary, and deploys the template into it. The location
+// of the group is validated before either is attempted.
+func deploy(groupName, groupLocation, deploymentName string, deploymentProps resources.DeploymentProperties, arm arm.Client) (deployment resources.DeploymentExtended, err error) {
+
+	_,err = helpers.ValidateLocation(arm, groupLocation, "Microsoft.Resources/resourceGroups", "Microsoft.Resources/deployments")
+	if err != nil {
+		return
+	}
+
+	_,err = createResourceGroup(groupName, groupLocation, arm)
+	if err != nil {
+		return
+	}
 
-	deployment,err := arm.Deployments().CreateOrUpdate(groupName, deploymentName, resources.Deployment { Properties: &deploymentProps  })
+	deployment,err = arm.Deployments().CreateOrUpdate(groupName, deploymentName, resources.Deployment { Properties: &deploymentProps  })
 	if err != nil {
 		if aerr,ok := err.(autorest.Error); ok {
-			fmt.Printf("Failed to create resource deployment details: '%s'\n", aerr.Message());
+			err = fmt.Errorf("Failed to create resource deployment details: '%s'\n", aerr.Message())
 		} else {
-			fmt.Printf("Failed to create resource deployment: '%s'\n", err.Error())		
+			err = fmt.Errorf("Failed to create resource deployment: '%s'\n", err.Error())
 		}
 		return
 	}
 	
-	fmt.Printf("Created resource deployment '%s'\n", *deployment.Name)	
+	helpers.Logf("Created resource deployment '%s'\n", *deployment.Name)	
+	
+	return
 }
 
 func createResourceGroup(name, location string, arm arm.Client) (group resources.ResourceGroup, err error) {
@@ -123,7 +160,7 @@ func createResourceGroup(name, location string, arm arm.Client) (group resources
 		return
 	}
 	
-	fmt.Printf("Created resource group '%s'\n", *group.Name)
+	helpers.Logf("Created resource group '%s'\n", *group.Name)
 	
 	return
 }
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-go-samples/fakes/armfake"
+	"github.com/Azure/azure-go-samples/helpers"
+)
+
+func TestDeploymentPropertiesDefault(t *testing.T) {
+	props, err := deploymentProperties(nil)
+	if err != nil {
+		t.Fatalf("deploymentProperties failed: %v", err)
+	}
+	if props.Template != nil || props.TemplateLink == nil {
+		t.Fatalf("expected a linked template")
+	}
+	if !strings.HasSuffix(*props.TemplateLink.URI, "/arm/templates/deploy-template/template01.json") {
+		t.Errorf("unexpected template link '%s'", *props.TemplateLink.URI)
+	}
+	for _, name := range []string{"adminUsername", "adminPassword", "dnsLabelPrefix", "ubuntuOSVersion"} {
+		if _, ok := (*props.Parameters)[name]; !ok {
+			t.Errorf("parameter '%s' is missing", name)
+		}
+	}
+}
+
+func TestDeploymentPropertiesMissingFile(t *testing.T) {
+	_, err := deploymentProperties([]string{"template01.parameters.json", "missing.json"})
+	if err == nil || !strings.Contains(err.Error(), "Failed to read template file 'missing.json'") {
+		t.Errorf("expected reading the template to fail, got %v", err)
+	}
+}
+
+func TestDeployTemplateFile(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+
+	props, err := deploymentProperties([]string{"template01.parameters.json", "template01.json"})
+	if err != nil {
+		t.Fatalf("deploymentProperties failed: %v", err)
+	}
+	deployment, err := deploy("templatetests", "West US", "simplelinux", props, helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL))
+	if err != nil {
+		t.Fatalf("deploy failed: %v", err)
+	}
+	if *deployment.Name != "simplelinux" {
+		t.Errorf("unexpected deployment name '%s'", *deployment.Name)
+	}
+
+	var put *armfake.Request
+	for _, r := range srv.Requests() {
+		r := r
+		if r.Method == "PUT" && strings.HasSuffix(strings.ToLower(r.Path), "/deployments/simplelinux") {
+			put = &r
+		}
+	}
+	if put == nil {
+		t.Fatalf("the deployment was not created")
+	}
+	body := put.Body["properties"].(map[string]interface{})
+	if body["mode"] != "Incremental" {
+		t.Errorf("unexpected deployment mode %v", body["mode"])
+	}
+	// The parameters are passed without the parameter file's envelope.
+	params := body["parameters"].(map[string]interface{})
+	if _, ok := params["parameters"]; ok {
+		t.Errorf("the parameters were passed with their envelope")
+	}
+	if _, ok := params["adminUsername"]; !ok {
+		t.Errorf("parameter 'adminUsername' is missing: %v", params)
+	}
+}
+
+func TestDeployMissingParameter(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "deploy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	parameterFile := filepath.Join(dir, "parameters.json")
+	if err := ioutil.WriteFile(parameterFile, []byte(`{"parameters": {"adminUsername": {"value": "tmpltest"}}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	props, err := deploymentProperties([]string{parameterFile, "template01.json"})
+	if err != nil {
+		t.Fatalf("deploymentProperties failed: %v", err)
+	}
+	_, err = deploy("templatetests", "West US", "simplelinux", props, helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL))
+	if err == nil || !strings.Contains(err.Error(), "Failed to create resource deployment") {
+		t.Errorf("expected the deployment to fail, got %v", err)
+	}
+	if _, ok := srv.Resource("/subscriptions/" + srv.SubscriptionID + "/resourceGroups/templatetests"); !ok {
+		t.Errorf("the group was not created before the deployment")
+	}
+}
+
+func TestDeployInvalidLocation(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+
+	props, err := deploymentProperties(nil)
+	if err != nil {
+		t.Fatalf("deploymentProperties failed: %v", err)
+	}
+	_, err = deploy("templatetests", "West Us 2", "simplelinux", props, helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL))
+	if err == nil || !strings.Contains(err.Error(), "Unknown location 'West Us 2'") {
+		t.Errorf("expected the location to be rejected, got %v", err)
+	}
+	for _, r := range srv.Requests() {
+		if r.Method != "GET" {
+			t.Errorf("unexpected request %s %s", r.Method, r.Path)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-go-samples/helpers"
+	"github.com/Azure/azure-sdk-for-go/Godeps/_workspace/src/github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/azure-sdk-for-go/arm"
+)
+
+// exportAPIVersion is the first version of the Resources API with the export-template operation,
+// which the SDK doesn't provide yet.
+const exportAPIVersion = "2015-11-01"
+
+func main() {
+
+	name := flag.String("name", "createvm01", "name of the resource group to export")
+	templateFile := flag.String("template-file", "", "file to write the template to, <name>.json by default")
+	parametersFile := flag.String("parameters-file", "", "file to write the parameter values to, <name>.parameters.json by default")
+
+	output := helpers.OutputFlags(nil)
+	flag.Parse()
+
+	if *templateFile == "" {
+		*templateFile = *name + ".json"
+	}
+	if *parametersFile == "" {
+		*parametersFile = *name + ".parameters.json"
+	}
+
+	client, err := helpers.AuthenticateForARM()
+	if err != nil {
+		helpers.Logf("Failed to authenticate: '%s'\n", err.Error())
+		os.Exit(1)
+	}
+
+	client.RequestInspector = helpers.WithInspection()
+	client.ResponseInspector = helpers.ByInspecting()
+
+	result, err := exportGroup(client, *name, *templateFile, *parametersFile, helpers.DefaultOperationPoller)
+	if err != nil {
+		helpers.Logf("%s", err.Error())
+		os.Exit(1)
+	}
+
+	if err := output.Print(result); err != nil {
+		helpers.Logf("%s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+// exportResult tells what was exported and where it was written. ToEdit names the parameters whose
+// values are placeholders, such as passwords.
+type exportResult struct {
+	Group          string   `json:"group"`
+	Resources      int      `json:"resources"`
+	Parameters     []string `json:"parameters"`
+	ToEdit         []string `json:"toEdit,omitempty"`
+	TemplateFile   string   `json:"templateFile"`
+	ParametersFile string   `json:"parametersFile"`
+}
+
+// exportGroup exports a resource group as a template, turns it into one that can be deployed again
+// using codify, and writes the template and its parameter values in the files read by the
+// deploy-template sample.
+func exportGroup(client arm.Client, name, templateFile, parametersFile string, poller helpers.OperationPoller) (result exportResult, err error) {
+
+	if err = helpers.ResourceGroupName.Validate(name); err != nil {
+		return
+	}
+
+	exported, err := exportTemplate(client, name, poller)
+	if err != nil {
+		return
+	}
+
+	rgc := client.ResourceGroups()
+	template, values, err := codify(exported, "/subscriptions/"+rgc.SubscriptionID+"/resourceGroups/"+name)
+	if err != nil {
+		return
+	}
+
+	parameters := map[string]interface{}{
+		"$schema":        "https://schema.management.azure.com/schemas/2015-01-01/deploymentParameters.json#",
+		"contentVersion": "1.0.0.0",
+		"parameters":     values,
+	}
+
+	if err = writeJSON(templateFile, template, 0644); err != nil {
+		return
+	}
+	// The parameters will hold passwords once edited.
+	if err = writeJSON(parametersFile, parameters, 0600); err != nil {
+		return
+	}
+
+	result = exportResult{
+		Group:          name,
+		Resources:      len(template["resources"].([]interface{})),
+		TemplateFile:   templateFile,
+		ParametersFile: parametersFile,
+	}
+	for p, v := range values {
+		result.Parameters = append(result.Parameters, p)
+		if v.(map[string]interface{})["value"] == passwordPlaceholder {
+			result.ToEdit = append(result.ToEdit, p)
+		}
+	}
+	sort.Strings(result.Parameters)
+	sort.Strings(result.ToEdit)
+
+	helpers.Logf("Exported %d resources of resource group '%s' to '%s' and '%s'\n", result.Resources, name, templateFile, parametersFile)
+	for _, p := range result.ToEdit {
+		helpers.Logf("Edit the value of parameter '%s' in '%s' before deploying\n", p, parametersFile)
+	}
+
+	return
+}
+
+// exportTemplate calls the export-template operation of a resource group, which may complete
+// right away or be followed through the Location header, and returns the exported template.
+// Resources ARM couldn't export are reported, but don't make the export fail.
+func exportTemplate(client arm.Client, name string, poller helpers.OperationPoller) (map[string]interface{}, error) {
+
+	rgc := client.ResourceGroups()
+
+	pathParameters := map[string]interface{}{
+		"resourceGroupName": url.QueryEscape(name),
+		"subscriptionId":    url.QueryEscape(rgc.SubscriptionID),
+	}
+	queryParameters := map[string]interface{}{
+		"api-version": exportAPIVersion,
+	}
+	body := map[string]interface{}{
+		"resources": []string{"*"},
+	}
+
+	req, err := autorest.Prepare(&http.Request{},
+		autorest.AsJSON(),
+		autorest.AsPost(),
+		autorest.WithBaseURL(rgc.BaseURI),
+		autorest.WithPath("/subscriptions/{subscriptionId}/resourcegroups/{resourceGroupName}/exportTemplate"),
+		autorest.WithPathParameters(pathParameters),
+		autorest.WithJSON(body),
+		autorest.WithQueryParameters(queryParameters))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to export resource group '%s': '%s'\n", name, err.Error())
+	}
+
+	op, err := helpers.StartOperation(rgc.Client, req)
+	if err == nil {
+		err = poller.Wait(op)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to export resource group '%s': '%s'\n", name, err.Error())
+	}
+
+	var result struct {
+		Template map[string]interface{} `json:"template"`
+		Error    *struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := op.Decode(&result); err != nil {
+		return nil, fmt.Errorf("Failed to export resource group '%s': '%s'\n", name, err.Error())
+	}
+	if result.Template == nil {
+		return nil, fmt.Errorf("Failed to export resource group '%s': the response holds no template\n", name)
+	}
+	if result.Error != nil {
+		helpers.Logf("Some resources of resource group '%s' were not exported: %s: %s\n", name, result.Error.Code, result.Error.Message)
+	}
+
+	return result.Template, nil
+}
+
+func writeJSON(fileName string, v interface{}, perm os.FileMode) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Failed to format '%s': '%s'\n", fileName, err.Error())
+	}
+	// encoding/json escapes <, > and & for HTML; undo it, the files are meant to be edited by hand.
+	s := strings.NewReplacer(`\u003c`, "<", `\u003e`, ">", `\u0026`, "&").Replace(string(b))
+	if err := ioutil.WriteFile(fileName, []byte(s+"\n"), perm); err != nil {
+		return fmt.Errorf("Failed to write '%s': '%s'\n", fileName, err.Error())
+	}
+	return nil
+}
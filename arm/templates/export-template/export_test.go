@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-go-samples/fakes/armfake"
+	"github.com/Azure/azure-go-samples/helpers"
+	"github.com/Azure/azure-sdk-for-go/arm/resources/resources"
+)
+
+var fastPoller = helpers.OperationPoller{Delay: time.Millisecond, MaxDelay: 4 * time.Millisecond, Timeout: 5 * time.Second}
+
+// put creates a resource of the fake server from a JSON document, the way the create-vm samples
+// would.
+func put(t *testing.T, srv *armfake.Server, path, doc string) {
+	req, err := http.NewRequest("PUT", srv.URL+"/subscriptions/"+srv.SubscriptionID+path+"?api-version=2015-06-15", strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		t.Fatalf("PUT %s failed with status %d", path, resp.StatusCode)
+	}
+}
+
+// populate creates a virtual machine in resource group createvm01, with the resources it needs.
+func populate(t *testing.T, srv *armfake.Server) {
+	group := "/subscriptions/" + srv.SubscriptionID + "/resourceGroups/createvm01/providers"
+	put(t, srv, "/resourcegroups/createvm01", `{"location": "West US"}`)
+	put(t, srv, "/resourceGroups/createvm01/providers/Microsoft.Storage/storageAccounts/createvm01accnt",
+		`{"location": "West US", "properties": {"accountType": "Standard_LRS"}}`)
+	put(t, srv, "/resourceGroups/createvm01/providers/Microsoft.Network/virtualNetworks/createvm01vnet",
+		`{"location": "West US", "properties": {"addressSpace": {"addressPrefixes": ["10.0.0.0/16"]},
+		  "subnets": [{"name": "createvm01subnet", "properties": {"addressPrefix": "10.0.0.0/24"}}]}}`)
+	put(t, srv, "/resourceGroups/createvm01/providers/Microsoft.Network/publicIPAddresses/ip01",
+		`{"location": "West US", "properties": {"publicIPAllocationMethod": "Dynamic"}}`)
+	put(t, srv, "/resourceGroups/createvm01/providers/Microsoft.Network/networkInterfaces/nic01",
+		`{"location": "West US", "properties": {"ipConfigurations": [{"name": "ipconfig01", "properties": {
+		  "subnet": {"id": "`+group+`/Microsoft.Network/virtualNetworks/createvm01vnet/subnets/createvm01subnet"},
+		  "publicIPAddress": {"id": "`+group+`/Microsoft.Network/publicIPAddresses/ip01"}}}]}}`)
+	put(t, srv, "/resourceGroups/createvm01/providers/Microsoft.Compute/virtualMachines/vm001",
+		`{"location": "West US", "properties": {
+		  "hardwareProfile": {"vmSize": "Standard_A0"},
+		  "networkProfile": {"networkInterfaces": [{"id": "`+group+`/Microsoft.Network/networkInterfaces/nic01"}]},
+		  "storageProfile": {"osDisk": {"name": "vm001", "createOption": "FromImage",
+		    "vhd": {"uri": "https://createvm01accnt.blob.core.windows.net/vhds/vm001.vhd"}}},
+		  "osProfile": {"computerName": "vm001", "adminUsername": "admin", "adminPassword": "foobar1234"}}}`)
+}
+
+func TestExportGroup(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	populate(t, srv)
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	templateFile := filepath.Join(dir, "createvm01.json")
+	parametersFile := filepath.Join(dir, "createvm01.parameters.json")
+
+	result, err := exportGroup(client, "createvm01", templateFile, parametersFile, fastPoller)
+	if err != nil {
+		t.Fatalf("exportGroup failed: %v", err)
+	}
+	if result.Resources != 5 {
+		t.Errorf("expected 5 resources, got %d", result.Resources)
+	}
+	if strings.Join(result.ToEdit, ",") != "virtualMachines_vm001_adminPassword" {
+		t.Errorf("unexpected parameters to edit %v", result.ToEdit)
+	}
+
+	b, err := ioutil.ReadFile(templateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := string(b)
+	for _, computed := range []string{"provisioningState", "macAddress", "vmId", "primaryEndpoints", "privateIPAddress\"", "ipConfiguration\"", srv.SubscriptionID} {
+		if strings.Contains(text, computed) {
+			t.Errorf("the template holds '%s'", computed)
+		}
+	}
+	for _, expr := range []string{
+		`"[parameters('location')]"`,
+		`"[resourceId('Microsoft.Network/networkInterfaces', parameters('networkInterfaces_nic01_name'))]"`,
+		`"[concat(resourceId('Microsoft.Network/virtualNetworks', parameters('virtualNetworks_createvm01vnet_name')), '/subnets/createvm01subnet')]"`,
+		`"[concat('https://', parameters('storageAccounts_createvm01accnt_name'), '.blob.core.windows.net/vhds/vm001.vhd')]"`,
+	} {
+		if !strings.Contains(text, expr) {
+			t.Errorf("the template doesn't hold %s", expr)
+		}
+	}
+
+	b, err = ioutil.ReadFile(parametersFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b, []byte(`"<<PLEASE EDIT>>"`)) {
+		t.Errorf("the password placeholder is missing or escaped:\n%s", b)
+	}
+
+	// The files deploy once the password is edited.
+	template, err := helpers.ReadMap(templateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parameters struct {
+		Parameters map[string]interface{} `json:"parameters"`
+	}
+	if err := json.Unmarshal(b, &parameters); err != nil {
+		t.Fatal(err)
+	}
+	parameters.Parameters["virtualMachines_vm001_adminPassword"] = map[string]interface{}{"value": "foobar1234"}
+	props := resources.DeploymentProperties{Template: &template, Parameters: &parameters.Parameters, Mode: resources.Incremental}
+	if _, err := client.Deployments().CreateOrUpdate("createvm01", "exported", resources.Deployment{Properties: &props}); err != nil {
+		t.Errorf("unable to deploy the exported template: %v", err)
+	}
+}
+
+func TestExportGroupNotFound(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	templateFile := filepath.Join(dir, "missing.json")
+
+	_, err = exportGroup(helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL), "missing", templateFile, filepath.Join(dir, "missing.parameters.json"), fastPoller)
+	if err == nil || !strings.Contains(err.Error(), "Failed to export resource group 'missing'") {
+		t.Errorf("expected the export to fail, got %v", err)
+	}
+	if _, err := os.Stat(templateFile); !os.IsNotExist(err) {
+		t.Errorf("the template file was written")
+	}
+}
+
+func TestCodify(t *testing.T) {
+	var exported map[string]interface{}
+	err := json.Unmarshal([]byte(`{
+	  "parameters": {
+		"publicIPAddresses_ip_01_name": {"defaultValue": "ip-01", "type": "String"},
+		"networkInterfaces_nic01_name": {"defaultValue": "nic01", "type": "String"},
+		"certificate": {"type": "SecureString"}
+	  },
+	  "resources": [
+		{"type": "Microsoft.Network/publicIPAddresses", "name": "[parameters('publicIPAddresses_ip_01_name')]", "location": "East US", "id": "ignored",
+		 "properties": {"publicIPAllocationMethod": "Dynamic", "ipAddress": "1.2.3.4", "provisioningState": "Succeeded",
+		   "dnsSettings": {"domainNameLabel": "[[label]", "fqdn": "label.eastus.cloudapp.azure.com"}}},
+		{"type": "Microsoft.Network/networkInterfaces", "name": "[parameters('networkInterfaces_nic01_name')]", "location": "eastus",
+		 "properties": {"ipConfigurations": [{"name": "ipconfig01", "properties": {
+		   "publicIPAddress": {"id": "[resourceId('Microsoft.Network/publicIPAddresses', parameters('publicIPAddresses_ip_01_name'))]"}}}]},
+		 "dependsOn": ["[resourceId('Microsoft.Network/publicIPAddresses', parameters('publicIPAddresses_ip_01_name'))]"]},
+		{"type": "Microsoft.Compute/availabilitySets", "name": "avset", "location": "westus",
+		 "properties": {"platformFaultDomainCount": 3, "virtualMachines": [{"id": "/vm"}]},
+		 "dependsOn": ["/subscriptions/sid/resourceGroups/g/providers/Microsoft.Network/networkInterfaces/nic01"]}
+	]}`), &exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template, values, err := codify(exported, "/subscriptions/sid/resourceGroups/g")
+	if err != nil {
+		t.Fatalf("codify failed: %v", err)
+	}
+	list := template["resources"].([]interface{})
+	ip := list[0].(map[string]interface{})
+	nic := list[1].(map[string]interface{})
+	avset := list[2].(map[string]interface{})
+
+	if _, ok := ip["id"]; ok {
+		t.Errorf("the ID was kept")
+	}
+	// Exported parameters are reused, literal names get one.
+	if ip["name"] != "[parameters('publicIPAddresses_ip_01_name')]" || avset["name"] != "[parameters('availabilitySets_avset_name')]" {
+		t.Errorf("unexpected names %v and %v", ip["name"], avset["name"])
+	}
+	parameters := template["parameters"].(map[string]interface{})
+	for p, expected := range map[string]interface{}{
+		"publicIPAddresses_ip_01_name": "ip-01",
+		"availabilitySets_avset_name":  "avset",
+		"certificate":                  passwordPlaceholder,
+		"location":                     "eastus",
+	} {
+		definition, ok := parameters[p].(map[string]interface{})
+		if !ok {
+			t.Errorf("parameter '%s' is missing", p)
+			continue
+		}
+		if _, ok := definition["defaultValue"]; ok {
+			t.Errorf("the default value of parameter '%s' was kept", p)
+		}
+		if v, _ := values[p].(map[string]interface{}); v["value"] != expected {
+			t.Errorf("expected the value '%v' for parameter '%s', got %v", expected, p, values[p])
+		}
+	}
+	if len(parameters) != len(values) || len(parameters) != 5 {
+		t.Errorf("unexpected parameters %v with values %v", parameters, values)
+	}
+	// Two of the three resources are in East US.
+	if ip["location"] != "[parameters('location')]" || nic["location"] != "[parameters('location')]" || avset["location"] != "westus" {
+		t.Errorf("unexpected locations %v, %v and %v", ip["location"], nic["location"], avset["location"])
+	}
+
+	props := ip["properties"].(map[string]interface{})
+	for _, p := range []string{"ipAddress", "provisioningState"} {
+		if _, ok := props[p]; ok {
+			t.Errorf("read-only property '%s' was kept", p)
+		}
+	}
+	dns := props["dnsSettings"].(map[string]interface{})
+	if _, ok := dns["fqdn"]; ok {
+		t.Errorf("the FQDN was kept")
+	}
+	if dns["domainNameLabel"] != "[[label]" {
+		t.Errorf("the escaped label was changed: %v", dns["domainNameLabel"])
+	}
+
+	// Exported expressions are kept as they are, and literal IDs become expressions.
+	ipID := "[resourceId('Microsoft.Network/publicIPAddresses', parameters('publicIPAddresses_ip_01_name'))]"
+	config := nic["properties"].(map[string]interface{})["ipConfigurations"].([]interface{})[0].(map[string]interface{})
+	if id := refID(config["properties"].(map[string]interface{})["publicIPAddress"]); id != ipID {
+		t.Errorf("unexpected public IP address reference %s", id)
+	}
+	if deps := fmt.Sprint(nic["dependsOn"]); deps != "["+ipID+"]" {
+		t.Errorf("unexpected dependencies %s", deps)
+	}
+	if deps := fmt.Sprint(avset["dependsOn"]); deps != "[[resourceId('Microsoft.Network/networkInterfaces', parameters('networkInterfaces_nic01_name'))]]" {
+		t.Errorf("unexpected dependencies %s", deps)
+	}
+	if _, ok := avset["properties"].(map[string]interface{})["virtualMachines"]; ok {
+		t.Errorf("the virtual machines of the availability set were kept")
+	}
+
+	// Names given by other expressions can't be turned into parameters.
+	exported["resources"] = []interface{}{map[string]interface{}{"type": "Microsoft.Compute/availabilitySets", "name": "[concat('a', 'b')]"}}
+	if _, _, err := codify(exported, "/subscriptions/sid/resourceGroups/g"); err == nil || !strings.Contains(err.Error(), "can't be codified") {
+		t.Errorf("expected codify to fail, got %v", err)
+	}
+}
+
+func refID(v interface{}) string {
+	m, _ := v.(map[string]interface{})
+	id, _ := m["id"].(string)
+	return id
+}
+
+func TestCodifyEmptyGroup(t *testing.T) {
+	template, _, err := codify(map[string]interface{}{"resources": []interface{}{}}, "/subscriptions/sid/resourceGroups/g")
+	if err != nil {
+		t.Fatalf("codify failed: %v", err)
+	}
+	b, err := json.Marshal(template["resources"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "[]" {
+		t.Errorf("expected an empty list of resources, got %s", b)
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-go-samples/helpers"
+)
+
+const (
+	locationParameter   = "location"
+	passwordPlaceholder = "<<PLEASE EDIT>>"
+)
+
+// readOnlyProperties lists, per resource type, the properties ARM computes itself and a template
+// can't set, as paths below 'properties'. A "[]" suffix steps into every element of an array.
+// provisioningState, resourceGuid and etag are removed from every resource, see stripReadOnly.
+var readOnlyProperties = map[string][]string{
+	"microsoft.storage/storageaccounts":   {"primaryEndpoints", "primaryLocation", "statusOfPrimary", "secondaryEndpoints", "secondaryLocation", "statusOfSecondary", "creationTime", "lastGeoFailoverTime"},
+	"microsoft.compute/availabilitysets":  {"virtualMachines", "statuses"},
+	"microsoft.network/virtualnetworks":   {"subnets[].properties.ipConfigurations"},
+	"microsoft.network/publicipaddresses": {"ipConfiguration", "ipAddress", "dnsSettings.fqdn"},
+	"microsoft.network/networkinterfaces": {"virtualMachine", "macAddress"},
+	"microsoft.compute/virtualmachines":   {"vmId", "instanceView"},
+}
+
+// templateFields are the fields of a resource a template may hold; the others, such as its ID,
+// are dropped.
+var templateFields = map[string]bool{
+	"type": true, "name": true, "apiVersion": true, "location": true, "tags": true,
+	"properties": true, "dependsOn": true, "sku": true, "kind": true, "plan": true,
+}
+
+var nonIdentifier = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// exportedResource is a resource of the exported template, with what's needed to refer to it.
+type exportedResource struct {
+	doc       map[string]interface{}
+	typ       string
+	name      string
+	id        string
+	parameter string
+}
+
+// resourceID returns the template expression giving the ID of the resource.
+func (r *exportedResource) resourceID() string {
+	return fmt.Sprintf("resourceId('%s', parameters('%s'))", r.typ, r.parameter)
+}
+
+// parameterReference matches the names exported templates give resources, such as
+// "[parameters('virtualNetworks_createvm01vnet_name')]".
+var parameterReference = regexp.MustCompile(`^\[parameters\('([^']+)'\)\]$`)
+
+// codify turns a template exported from the resource group with the passed ID into one that can
+// be deployed again, to the same group or another one:
+//
+//   - the parameters ARM exported are kept, with their default values moved to the parameter
+//     values; resources ARM left with literal names get a name parameter as well, and the location
+//     most resources use becomes a parameter;
+//   - properties ARM computes, such as provisioning states and back-references, are removed;
+//   - references between the resources that ARM left as literal IDs become resourceId()
+//     expressions, with dependsOn entries, while the expressions ARM exported are kept as they are;
+//   - passwords, which ARM doesn't export, become secure parameters.
+//
+// It returns the template and the values of its parameters. Passwords, and exported parameters
+// without a default value, get a placeholder value, which has to be edited before deploying.
+func codify(exported map[string]interface{}, groupID string) (template, values map[string]interface{}, err error) {
+
+	parameters := map[string]interface{}{}
+	values = map[string]interface{}{}
+	defaults := map[string]string{}
+	if declared, ok := exported["parameters"].(map[string]interface{}); ok {
+		for p, v := range declared {
+			definition := map[string]interface{}{}
+			if d, ok := v.(map[string]interface{}); ok {
+				for k, field := range d {
+					definition[k] = field
+				}
+			}
+			value, ok := definition["defaultValue"]
+			if !ok {
+				value = passwordPlaceholder
+			}
+			delete(definition, "defaultValue")
+			parameters[p] = definition
+			values[p] = map[string]interface{}{"value": value}
+			if s, ok := value.(string); ok {
+				defaults[p] = s
+			}
+		}
+	}
+
+	list, _ := exported["resources"].([]interface{})
+
+	var resources []*exportedResource
+	for _, v := range list {
+		doc, _ := v.(map[string]interface{})
+		typ, _ := doc["type"].(string)
+		name, _ := doc["name"].(string)
+		if typ == "" || name == "" {
+			return nil, nil, fmt.Errorf("The exported template holds a resource without a type or name\n")
+		}
+		r := &exportedResource{doc: doc, typ: typ, name: name}
+		if m := parameterReference.FindStringSubmatch(name); m != nil {
+			if r.name = defaults[m[1]]; r.name == "" {
+				return nil, nil, fmt.Errorf("The exported template names a resource of type '%s' with parameter '%s', which has no default value\n", typ, m[1])
+			}
+			r.parameter = m[1]
+		} else if isExpression(name) {
+			return nil, nil, fmt.Errorf("The exported template names a resource of type '%s' with the expression '%s', which can't be codified\n", typ, name)
+		} else {
+			r.parameter = nameParameter(typ, name)
+		}
+		r.id = groupID + "/providers/" + typ + "/" + r.name
+		resources = append(resources, r)
+	}
+
+	location := commonLocation(resources)
+	if location != "" {
+		if _, ok := parameters[locationParameter]; !ok {
+			parameters[locationParameter] = map[string]interface{}{"type": "string"}
+			values[locationParameter] = map[string]interface{}{"value": location}
+		}
+	}
+
+	for _, r := range resources {
+		stripReadOnly(r)
+	}
+
+	for _, r := range resources {
+		deps := map[string]bool{}
+		if props, ok := r.doc["properties"]; ok {
+			r.doc["properties"] = rewrite(props, r, resources, deps)
+		}
+		exportedDeps, _ := r.doc["dependsOn"].([]interface{})
+		for _, d := range exportedDeps {
+			s, _ := d.(string)
+			if e, _ := rewrite(s, r, resources, deps).(string); isExpression(e) && e != "["+r.resourceID()+"]" {
+				deps[e] = true
+			}
+		}
+
+		if _, ok := parameters[r.parameter]; !ok {
+			parameters[r.parameter] = map[string]interface{}{"type": "string"}
+			values[r.parameter] = map[string]interface{}{"value": r.name}
+		}
+		r.doc["name"] = "[parameters('" + r.parameter + "')]"
+
+		if l, _ := r.doc["location"].(string); l != "" && !isExpression(l) && helpers.NormalizeLocation(l) == location {
+			r.doc["location"] = "[parameters('" + locationParameter + "')]"
+		}
+
+		if p := passwordParameter(r); p != "" {
+			parameters[p] = map[string]interface{}{"type": "securestring"}
+			values[p] = map[string]interface{}{"value": passwordPlaceholder}
+		}
+
+		dependsOn := []string{}
+		for d := range deps {
+			dependsOn = append(dependsOn, d)
+		}
+		sort.Strings(dependsOn)
+		r.doc["dependsOn"] = dependsOn
+	}
+
+	docs := []interface{}{}
+	for _, r := range resources {
+		docs = append(docs, r.doc)
+	}
+
+	template = map[string]interface{}{
+		"$schema":        "https://schema.management.azure.com/schemas/2015-01-01/deploymentTemplate.json#",
+		"contentVersion": "1.0.0.0",
+		"parameters":     parameters,
+		"variables":      map[string]interface{}{},
+		"resources":      docs,
+	}
+	return template, values, nil
+}
+
+// isExpression tells whether a template string is an expression, rather than a literal, which
+// starts with '[[' when it would otherwise look like one.
+func isExpression(s string) bool {
+	return strings.HasPrefix(s, "[") && !strings.HasPrefix(s, "[[")
+}
+
+// nameParameter returns the name of the parameter holding the literal name of a resource, formed
+// like those of exported templates, such as 'virtualNetworks_createvm01vnet_name'.
+func nameParameter(typ, name string) string {
+	t := typ[strings.LastIndex(typ, "/")+1:]
+	return t + "_" + nonIdentifier.ReplaceAllString(name, "_") + "_name"
+}
+
+// commonLocation returns the location of most resources, in normalized form. Ties go to the
+// location that comes first by name.
+func commonLocation(resources []*exportedResource) string {
+	counts := map[string]int{}
+	for _, r := range resources {
+		if l, _ := r.doc["location"].(string); l != "" && !isExpression(l) {
+			counts[helpers.NormalizeLocation(l)]++
+		}
+	}
+	best := ""
+	for l, n := range counts {
+		if n > counts[best] || n == counts[best] && l < best {
+			best = l
+		}
+	}
+	return best
+}
+
+// stripReadOnly removes the fields a template can't set from a resource.
+func stripReadOnly(r *exportedResource) {
+	for k := range r.doc {
+		if !templateFields[k] {
+			delete(r.doc, k)
+		}
+	}
+
+	props, ok := r.doc["properties"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	stripComputed(props)
+	for _, path := range readOnlyProperties[strings.ToLower(r.typ)] {
+		removePath(props, strings.Split(path, "."))
+	}
+
+	// Private IP addresses are only set by templates when they are static.
+	if strings.EqualFold(r.typ, "Microsoft.Network/networkInterfaces") {
+		configs, _ := props["ipConfigurations"].([]interface{})
+		for _, c := range configs {
+			config, _ := c.(map[string]interface{})
+			cp, _ := config["properties"].(map[string]interface{})
+			if method, _ := cp["privateIPAllocationMethod"].(string); !strings.EqualFold(method, "Static") {
+				delete(cp, "privateIPAddress")
+			}
+		}
+	}
+}
+
+// stripComputed removes provisioning states, GUIDs and etags at any depth, as well as the IDs of
+// sub-resources defined in place, such as subnets. References to other resources, which are
+// objects holding an ID only, are kept.
+func stripComputed(v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		delete(v, "provisioningState")
+		delete(v, "resourceGuid")
+		delete(v, "etag")
+		if _, named := v["name"]; named {
+			delete(v, "id")
+		}
+		for _, child := range v {
+			stripComputed(child)
+		}
+	case []interface{}:
+		for _, child := range v {
+			stripComputed(child)
+		}
+	}
+}
+
+// removePath removes the property at a path, stepping into every element of the arrays marked
+// with "[]".
+func removePath(v interface{}, path []string) {
+	m, ok := v.(map[string]interface{})
+	if !ok || len(path) == 0 {
+		return
+	}
+	key := path[0]
+	if !strings.HasSuffix(key, "[]") {
+		if len(path) == 1 {
+			delete(m, key)
+			return
+		}
+		removePath(m[key], path[1:])
+		return
+	}
+	items, _ := m[strings.TrimSuffix(key, "[]")].([]interface{})
+	for _, item := range items {
+		removePath(item, path[1:])
+	}
+}
+
+// rewrite replaces the literal resource IDs and blob endpoints of the exported resources found in
+// a value by template expressions, and records the resources referred to in deps. Expressions,
+// and literals escaped as such, are left alone.
+func rewrite(v interface{}, self *exportedResource, resources []*exportedResource, deps map[string]bool) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			v[k] = rewrite(child, self, resources, deps)
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = rewrite(child, self, resources, deps)
+		}
+		return v
+	case string:
+		if strings.HasPrefix(v, "[") {
+			return v
+		}
+		s := strings.ToLower(v)
+		for _, r := range resources {
+			id := strings.ToLower(r.id)
+			switch {
+			case s == id:
+				addDependency(deps, self, r)
+				return "[" + r.resourceID() + "]"
+			case strings.HasPrefix(s, id+"/"):
+				addDependency(deps, self, r)
+				return fmt.Sprintf("[concat(%s, %s)]", r.resourceID(), quote(v[len(id):]))
+			}
+			if strings.EqualFold(r.typ, "Microsoft.Storage/storageAccounts") {
+				if i := strings.Index(s, "//"+strings.ToLower(r.name)+".blob."); i >= 0 {
+					addDependency(deps, self, r)
+					start := i + 2
+					end := start + len(r.name)
+					return fmt.Sprintf("[concat(%s, parameters('%s'), %s)]", quote(v[:start]), r.parameter, quote(v[end:]))
+				}
+			}
+		}
+		return v
+	}
+	return v
+}
+
+func addDependency(deps map[string]bool, self, r *exportedResource) {
+	if r != self {
+		deps["["+r.resourceID()+"]"] = true
+	}
+}
+
+// quote returns a string literal for a template expression.
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// passwordParameter adds a secure parameter for the admin password of a virtual machine, which
+// ARM doesn't export, and returns its name. Linux machines that only allow SSH keys need none.
+func passwordParameter(r *exportedResource) string {
+	if !strings.EqualFold(r.typ, "Microsoft.Compute/virtualMachines") {
+		return ""
+	}
+	props, _ := r.doc["properties"].(map[string]interface{})
+	osProfile, ok := props["osProfile"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	if linux, ok := osProfile["linuxConfiguration"].(map[string]interface{}); ok {
+		if disabled, _ := linux["disablePasswordAuthentication"].(bool); disabled {
+			return ""
+		}
+	}
+	if _, ok := osProfile["adminPassword"]; ok {
+		return ""
+	}
+
+	p := strings.TrimSuffix(r.parameter, "_name") + "_adminPassword"
+	osProfile["adminPassword"] = "[parameters('" + p + "')]"
+	return p
+}
@@ -0,0 +1,427 @@
+// Package armfake provides a local, in-memory stand-in for the Azure Resource Manager, so that the
+// ARM samples can be exercised without a subscription.
+//
+// The server implements subscription locations, resource groups, resource providers, storage
+// accounts, name availability checks for storage accounts, web apps and public IP DNS labels,
+// availability sets, virtual networks, subnets, public IP addresses, network interfaces, virtual
+// machines, template deployments, the export of a group as a template, and management locks, which
+// it enforces. Creating storage accounts, virtual machines and deployments, and deleting resource
+// groups, are long-running operations: the server answers with 201 or 202 and an operation URL, and
+// the resource only reaches its final state after the operation has been polled PollCount times.
+//
+// Point an arm.Client at it using helpers.ARMClientForEndpoint.
+package armfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultSubscriptionID is the subscription served when none is given to NewServer.
+const DefaultSubscriptionID = "00000000-0000-0000-0000-000000000000"
+
+// Request is a record of one request received by the server.
+type Request struct {
+	Method string
+	Path   string
+	Query  string
+	Body   map[string]interface{}
+}
+
+// Server is a fake Azure Resource Manager endpoint with in-memory state.
+type Server struct {
+	*httptest.Server
+
+	SubscriptionID string
+
+	// PollCount is the number of times a long-running operation reports that it is still in
+	// progress before it completes.
+	PollCount int
+
+	// RunningStatus is the status the document of an Azure-AsyncOperation reports while the
+	// operation is in progress. ARM uses InProgress, the default, but also Running, Accepted or
+	// Deleting, depending on the resource provider.
+	RunningStatus string
+
+	// PageSize limits the number of items returned per page by list operations; the rest is
+	// returned through nextLink.
+	PageSize int
+
+	// RequireRegistration makes resource creation fail with MissingSubscriptionRegistration
+	// unless the resource provider has been registered first, as ARM does.
+	RequireRegistration bool
+
+	// Locations are the regions resources may be created in, in normalized form.
+	Locations []string
+
+	mu         sync.Mutex
+	groups     map[string]*entity
+	resources  map[string]*entity
+	providers  map[string]string
+	operations map[string]*operation
+	taken      map[string]bool
+	sites      map[string]bool
+	labels     map[string]bool
+	limits     map[string][]string
+	locks      map[string]*entity
+	failures   []*failure
+	requests   []Request
+	nextID     int
+}
+
+type entity struct {
+	key   string
+	group string
+	doc   map[string]interface{}
+	op    *operation
+}
+
+type operation struct {
+	id        string
+	remaining int
+	status    string
+	failed    *failure
+	done      func()
+	result    func() (int, interface{})
+}
+
+type failure struct {
+	method  string
+	pattern *regexp.Regexp
+	status  int
+	code    string
+	message string
+	once    bool
+}
+
+// NewServer starts a fake ARM server for the passed subscription, or DefaultSubscriptionID if it
+// is empty. Close it when done.
+func NewServer(subscriptionID string) *Server {
+	if subscriptionID == "" {
+		subscriptionID = DefaultSubscriptionID
+	}
+	s := &Server{
+		SubscriptionID: subscriptionID,
+		PollCount:      1,
+		PageSize:       100,
+		Locations:      []string{"westus", "eastus", "northeurope", "westeurope"},
+		groups:         map[string]*entity{},
+		resources:      map[string]*entity{},
+		providers:      map[string]string{"microsoft.resources": "Registered"},
+		operations:     map[string]*operation{},
+		taken:          map[string]bool{},
+		sites:          map[string]bool{},
+		labels:         map[string]bool{},
+		limits:         map[string][]string{},
+		locks:          map[string]*entity{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// LimitResourceType makes a resource type, such as "Microsoft.Compute/virtualMachines", available
+// in the passed locations only, instead of in all of Locations. The provider documents list those
+// locations for the type, and creating a resource of the type anywhere else fails.
+func (s *Server) LimitResourceType(resourceType string, locations ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var l []string
+	for _, location := range locations {
+		l = append(l, NormalizeLocation(location))
+	}
+	s.limits[strings.ToLower(resourceType)] = l
+}
+
+// TakeStorageAccountName marks a storage account name as used by someone outside the subscription,
+// so that checking its availability reports AlreadyExists.
+func (s *Server) TakeStorageAccountName(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.taken[strings.ToLower(name)] = true
+}
+
+// TakeSiteName marks a web app name as used by someone outside the subscription, so that checking
+// its availability reports AlreadyExists.
+func (s *Server) TakeSiteName(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sites[strings.ToLower(name)] = true
+}
+
+// TakeDNSLabel marks a public IP DNS label as used in a location by someone outside the
+// subscription, so that checking its availability, or creating a public IP address with it, fails.
+func (s *Server) TakeDNSLabel(location, label string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.labels[NormalizeLocation(location)+"/"+strings.ToLower(label)] = true
+}
+
+// Fail makes requests with the passed method and a path matching the regular expression fail with
+// the given status and ARM error code. When 'once' is set, only the first matching request fails.
+// Long-running operations started by a matching request fail asynchronously instead, which is how
+// ARM reports most provisioning errors.
+func (s *Server) Fail(method, pathPattern string, status int, code, message string, once bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, &failure{
+		method:  strings.ToUpper(method),
+		pattern: regexp.MustCompile("(?i)" + pathPattern),
+		status:  status,
+		code:    code,
+		message: message,
+		once:    once,
+	})
+}
+
+// Requests returns the requests received so far, in order.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Resource returns the JSON document of a resource or resource group by its ID.
+func (s *Server) Resource(id string) (map[string]interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := strings.ToLower(strings.TrimSuffix(id, "/"))
+	if e, ok := s.resources[key]; ok {
+		return copyDoc(e.doc), true
+	}
+	if e, ok := s.groups[key]; ok {
+		return copyDoc(e.doc), true
+	}
+	return nil, false
+}
+
+// ResourceIDs returns the IDs of all resources, not including resource groups, in sorted order.
+func (s *Server) ResourceIDs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	for _, e := range s.resources {
+		ids = append(ids, e.doc["id"].(string))
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// ProviderState returns the registration state of a resource provider namespace.
+func (s *Server) ProviderState(namespace string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if st, ok := s.providers[strings.ToLower(namespace)]; ok {
+		return st
+	}
+	return "NotRegistered"
+}
+
+type armError struct {
+	status  int
+	code    string
+	message string
+}
+
+func errorf(status int, code, format string, args ...interface{}) *armError {
+	return &armError{status: status, code: code, message: fmt.Sprintf(format, args...)}
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	var body map[string]interface{}
+	if r.Body != nil {
+		b, _ := ioutil.ReadAll(r.Body)
+		if len(strings.TrimSpace(string(b))) > 0 {
+			if err := json.Unmarshal(b, &body); err != nil {
+				writeError(w, errorf(http.StatusBadRequest, "InvalidRequestContent", "The request content was invalid and could not be deserialized: '%v'", err))
+				return
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, Request{Method: r.Method, Path: r.URL.Path, Query: r.URL.RawQuery, Body: body})
+
+	if !s.isLongRunning(r) {
+		if f := s.failureFor(r); f != nil {
+			writeError(w, errorf(f.status, f.code, "%s", f.message))
+			return
+		}
+	}
+
+	status, result, headers, aerr := s.route(r, body)
+	if aerr != nil {
+		writeError(w, aerr)
+		return
+	}
+	for k, v := range headers {
+		w.Header().Set(k, v)
+	}
+	writeJSON(w, status, result)
+}
+
+func (s *Server) failureFor(r *http.Request) *failure {
+	for i, f := range s.failures {
+		if f.method != "" && f.method != r.Method {
+			continue
+		}
+		if !f.pattern.MatchString(r.URL.Path) {
+			continue
+		}
+		if f.once {
+			s.failures = append(s.failures[:i], s.failures[i+1:]...)
+		}
+		return f
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	if v == nil {
+		w.WriteHeader(status)
+		return
+	}
+	b, _ := json.Marshal(v)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+func writeError(w http.ResponseWriter, e *armError) {
+	writeJSON(w, e.status, map[string]interface{}{
+		"error": map[string]interface{}{"code": e.code, "message": e.message},
+	})
+}
+
+func (s *Server) newID() string {
+	s.nextID++
+	return fmt.Sprintf("%08d-0000-0000-0000-000000000000", s.nextID)
+}
+
+func copyDoc(doc map[string]interface{}) map[string]interface{} {
+	b, _ := json.Marshal(doc)
+	var c map[string]interface{}
+	json.Unmarshal(b, &c)
+	return c
+}
+
+// NormalizeLocation turns a display name such as "West US" into the form ARM uses in IDs and
+// responses, "westus".
+func NormalizeLocation(location string) string {
+	return strings.ToLower(strings.Replace(location, " ", "", -1))
+}
+
+func (s *Server) validLocation(location string) bool {
+	l := NormalizeLocation(location)
+	for _, known := range s.Locations {
+		if known == l {
+			return true
+		}
+	}
+	return false
+}
+
+// typeLocations returns the locations a resource type is available in, in normalized form.
+func (s *Server) typeLocations(resourceType string) []string {
+	if l, ok := s.limits[strings.ToLower(resourceType)]; ok {
+		return l
+	}
+	return s.Locations
+}
+
+// availableFor reports whether a resource type may be created in a location.
+func (s *Server) availableFor(resourceType, location string) bool {
+	l := NormalizeLocation(location)
+	for _, known := range s.typeLocations(resourceType) {
+		if known == l {
+			return s.validLocation(l)
+		}
+	}
+	return false
+}
+
+func segments(path string) []string {
+	var parts []string
+	for _, p := range strings.Split(strings.Trim(path, "/"), "/") {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return parts
+}
+
+func (s *Server) route(r *http.Request, body map[string]interface{}) (int, interface{}, map[string]string, *armError) {
+	parts := segments(r.URL.Path)
+
+	if len(parts) >= 2 && strings.EqualFold(parts[0], "fake") && strings.EqualFold(parts[1], "operations") && len(parts) == 4 {
+		return s.pollOperation(parts[2], parts[3])
+	}
+
+	if len(parts) < 2 || !strings.EqualFold(parts[0], "subscriptions") {
+		return 0, nil, nil, errorf(http.StatusNotFound, "NotFound", "The requested path '%s' was not found", r.URL.Path)
+	}
+	if !strings.EqualFold(parts[1], s.SubscriptionID) {
+		return 0, nil, nil, errorf(http.StatusNotFound, "SubscriptionNotFound", "The subscription '%s' could not be found", parts[1])
+	}
+	rest := parts[2:]
+
+	if i := lockPath(rest); i >= 0 {
+		return s.routeLocks(r, parts[:2+i], rest[i+3:], body)
+	}
+	if len(rest) > 0 {
+		if aerr := s.checkLocks(r, parts); aerr != nil {
+			return 0, nil, nil, aerr
+		}
+	}
+
+	switch {
+	case len(rest) == 0:
+		return http.StatusOK, map[string]interface{}{
+			"id":             "/subscriptions/" + s.SubscriptionID,
+			"subscriptionId": s.SubscriptionID,
+			"displayName":    "Fake Subscription",
+			"state":          "Enabled",
+		}, nil, nil
+	case strings.EqualFold(rest[0], "locations") && len(rest) == 1:
+		return s.listLocations()
+	case strings.EqualFold(rest[0], "providers"):
+		return s.routeProviders(r, rest[1:], body)
+	case strings.EqualFold(rest[0], "resourcegroups"):
+		return s.routeGroups(r, rest[1:], body)
+	}
+
+	return 0, nil, nil, errorf(http.StatusNotFound, "NotFound", "The requested path '%s' was not found", r.URL.Path)
+}
+
+func (s *Server) listLocations() (int, interface{}, map[string]string, *armError) {
+	var value []interface{}
+	for _, l := range s.Locations {
+		value = append(value, map[string]interface{}{
+			"id":          "/subscriptions/" + s.SubscriptionID + "/locations/" + l,
+			"name":        l,
+			"displayName": displayName(l),
+		})
+	}
+	return http.StatusOK, map[string]interface{}{"value": value}, nil, nil
+}
+
+func displayName(location string) string {
+	names := map[string]string{
+		"westus":      "West US",
+		"eastus":      "East US",
+		"northeurope": "North Europe",
+		"westeurope":  "West Europe",
+	}
+	if n, ok := names[location]; ok {
+		return n
+	}
+	return location
+}
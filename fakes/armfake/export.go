@@ -0,0 +1,155 @@
+package armfake
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var nonIdentifier = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// exported is a resource of a group being exported, with the parameter holding its name.
+type exported struct {
+	doc       map[string]interface{}
+	typ       string
+	name      string
+	id        string
+	parameter string
+}
+
+// resourceID returns the template expression giving the ID of the resource.
+func (r *exported) resourceID() string {
+	return fmt.Sprintf("resourceId('%s', parameters('%s'))", r.typ, r.parameter)
+}
+
+// exportTemplate answers the export-template operation of a resource group the way ARM does. Each
+// resource is named by a parameter, such as 'virtualNetworks_createvm01vnet_name', whose default
+// value is its name; references between the exported resources become resourceId() expressions,
+// with dependsOn entries, and literal strings that look like expressions are escaped. Locations
+// stay literal, and read-only properties are kept. Secrets, such as the admin passwords of virtual
+// machines, are left out. Subnets are exported as part of their virtual networks.
+func (s *Server) exportTemplate(g *entity, body map[string]interface{}) (int, interface{}, map[string]string, *armError) {
+	requested, _ := body["resources"].([]interface{})
+	if len(requested) == 0 {
+		return 0, nil, nil, errorf(http.StatusBadRequest, "InvalidRequestContent", "The resources to export are missing.")
+	}
+	all := false
+	ids := map[string]bool{}
+	for _, r := range requested {
+		id, _ := r.(string)
+		if id == "*" {
+			all = true
+		}
+		ids[strings.ToLower(id)] = true
+	}
+
+	var keys []string
+	for k, e := range s.resources {
+		typ := e.doc["type"].(string)
+		if e.group != g.key || isChildType(typ) || strings.EqualFold(typ, "Microsoft.Resources/deployments") {
+			continue
+		}
+		if all || ids[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var list []*exported
+	parameters := map[string]interface{}{}
+	for _, k := range keys {
+		doc := s.view(s.resources[k].doc)
+		r := &exported{doc: doc, typ: doc["type"].(string), name: doc["name"].(string), id: doc["id"].(string)}
+		r.parameter = r.typ[strings.LastIndex(r.typ, "/")+1:] + "_" + nonIdentifier.ReplaceAllString(r.name, "_") + "_name"
+		parameters[r.parameter] = map[string]interface{}{"defaultValue": r.name, "type": "String"}
+		list = append(list, r)
+	}
+
+	resources := []interface{}{}
+	for _, r := range list {
+		deps := map[string]bool{}
+		doc := map[string]interface{}{
+			"type":       r.typ,
+			"name":       "[parameters('" + r.parameter + "')]",
+			"apiVersion": "2015-06-15",
+			"location":   NormalizeLocation(r.doc["location"].(string)),
+			"properties": exportValue(r.doc["properties"], r, list, deps),
+		}
+		if tags, ok := r.doc["tags"]; ok {
+			doc["tags"] = tags
+		}
+		dependsOn := []interface{}{}
+		var sorted []string
+		for d := range deps {
+			sorted = append(sorted, d)
+		}
+		sort.Strings(sorted)
+		for _, d := range sorted {
+			dependsOn = append(dependsOn, d)
+		}
+		doc["dependsOn"] = dependsOn
+		resources = append(resources, doc)
+	}
+
+	return http.StatusOK, map[string]interface{}{
+		"template": map[string]interface{}{
+			"$schema":        "https://schema.management.azure.com/schemas/2015-01-01/deploymentTemplate.json#",
+			"contentVersion": "1.0.0.0",
+			"parameters":     parameters,
+			"variables":      map[string]interface{}{},
+			"resources":      resources,
+		},
+	}, nil, nil
+}
+
+// exportValue replaces the IDs and blob endpoints of the exported resources found in a value by
+// template expressions, recording the resources referred to in deps, and escapes the literal
+// strings that start with '['.
+func exportValue(v interface{}, self *exported, list []*exported, deps map[string]bool) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			v[k] = exportValue(child, self, list, deps)
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = exportValue(child, self, list, deps)
+		}
+		return v
+	case string:
+		s := strings.ToLower(v)
+		for _, r := range list {
+			id := strings.ToLower(r.id)
+			var expr string
+			switch {
+			case s == id:
+				expr = "[" + r.resourceID() + "]"
+			case strings.HasPrefix(s, id+"/"):
+				expr = fmt.Sprintf("[concat(%s, %s)]", r.resourceID(), quote(v[len(id):]))
+			case strings.EqualFold(r.typ, "Microsoft.Storage/storageAccounts") && strings.Contains(s, "//"+strings.ToLower(r.name)+".blob."):
+				start := strings.Index(s, "//"+strings.ToLower(r.name)+".blob.") + 2
+				end := start + len(r.name)
+				expr = fmt.Sprintf("[concat(%s, parameters('%s'), %s)]", quote(v[:start]), r.parameter, quote(v[end:]))
+			default:
+				continue
+			}
+			if r != self {
+				deps["["+r.resourceID()+"]"] = true
+			}
+			return expr
+		}
+		if strings.HasPrefix(v, "[") {
+			return "[" + v
+		}
+		return v
+	}
+	return v
+}
+
+// quote returns a string literal for a template expression.
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
@@ -0,0 +1,433 @@
+package armfake
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// providerTypes lists the resource types the fake knows about, per provider namespace.
+var providerTypes = map[string][]string{
+	"Microsoft.Compute":   {"availabilitySets", "virtualMachines"},
+	"Microsoft.Network":   {"virtualNetworks", "virtualNetworks/subnets", "publicIPAddresses", "networkInterfaces", "networkSecurityGroups", "loadBalancers"},
+	"Microsoft.Resources": {"deployments", "resourceGroups"},
+	"Microsoft.Storage":   {"storageAccounts"},
+	"Microsoft.Web":       {"sites"},
+}
+
+// globalTypes are the resource types that don't live in a location, which provider documents list
+// without any.
+var globalTypes = map[string]bool{
+	"microsoft.resources/deployments": true,
+}
+
+func canonicalNamespace(ns string) (string, bool) {
+	for known := range providerTypes {
+		if strings.EqualFold(known, ns) {
+			return known, true
+		}
+	}
+	return ns, false
+}
+
+func (s *Server) providerDoc(ns string) map[string]interface{} {
+	state, ok := s.providers[strings.ToLower(ns)]
+	if !ok {
+		state = "NotRegistered"
+	}
+
+	var types []interface{}
+	for _, t := range providerTypes[ns] {
+		locations := []interface{}{}
+		if !globalTypes[strings.ToLower(ns+"/"+t)] {
+			for _, l := range s.typeLocations(ns + "/" + t) {
+				locations = append(locations, displayName(l))
+			}
+		}
+		types = append(types, map[string]interface{}{
+			"resourceType": t,
+			"locations":    locations,
+			"apiVersions":  []interface{}{"2015-06-15", "2015-05-01-preview"},
+		})
+	}
+
+	return map[string]interface{}{
+		"id":                "/subscriptions/" + s.SubscriptionID + "/providers/" + ns,
+		"namespace":         ns,
+		"registrationState": state,
+		"resourceTypes":     types,
+	}
+}
+
+func (s *Server) routeProviders(r *http.Request, rest []string, body map[string]interface{}) (int, interface{}, map[string]string, *armError) {
+	if len(rest) == 0 {
+		if r.Method != "GET" {
+			return 0, nil, nil, errorf(http.StatusMethodNotAllowed, "MethodNotAllowed", "%s is not supported", r.Method)
+		}
+		var names []string
+		for ns := range providerTypes {
+			names = append(names, ns)
+		}
+		sort.Strings(names)
+		var value []interface{}
+		for _, ns := range names {
+			value = append(value, s.providerDoc(ns))
+		}
+		return http.StatusOK, map[string]interface{}{"value": value}, nil, nil
+	}
+
+	ns, known := canonicalNamespace(rest[0])
+	if !known {
+		return 0, nil, nil, errorf(http.StatusNotFound, "InvalidResourceNamespace", "The resource namespace '%s' is invalid", rest[0])
+	}
+
+	switch {
+	case len(rest) == 1 && r.Method == "GET":
+		return http.StatusOK, s.providerDoc(ns), nil, nil
+	case len(rest) == 2 && strings.EqualFold(rest[1], "register") && r.Method == "POST":
+		s.providers[strings.ToLower(ns)] = "Registered"
+		return http.StatusOK, s.providerDoc(ns), nil, nil
+	case len(rest) == 2 && strings.EqualFold(rest[1], "unregister") && r.Method == "POST":
+		s.providers[strings.ToLower(ns)] = "Unregistered"
+		return http.StatusOK, s.providerDoc(ns), nil, nil
+	case len(rest) == 2 && strings.EqualFold(rest[1], "checkNameAvailability") && ns == "Microsoft.Storage" && r.Method == "POST":
+		return s.checkStorageName(body)
+	case len(rest) == 2 && strings.EqualFold(rest[1], "checkNameAvailability") && ns == "Microsoft.Web" && r.Method == "POST":
+		return s.checkSiteName(body)
+	case len(rest) == 4 && strings.EqualFold(rest[1], "locations") && strings.EqualFold(rest[3], "CheckDnsNameAvailability") && ns == "Microsoft.Network" && r.Method == "GET":
+		return s.checkDNSLabel(rest[2], r.URL.Query().Get("domainNameLabel"))
+	}
+
+	return 0, nil, nil, errorf(http.StatusNotFound, "NotFound", "The requested path '%s' was not found", r.URL.Path)
+}
+
+var storageNamePattern = regexp.MustCompile(`^[a-z0-9]{3,24}$`)
+
+func (s *Server) checkStorageName(body map[string]interface{}) (int, interface{}, map[string]string, *armError) {
+	name, _ := body["name"].(string)
+	typ, _ := body["type"].(string)
+	if !strings.EqualFold(typ, "Microsoft.Storage/storageAccounts") {
+		return 0, nil, nil, errorf(http.StatusBadRequest, "InvalidResourceType", "The resource type '%s' is not supported", typ)
+	}
+
+	if !storageNamePattern.MatchString(name) {
+		return http.StatusOK, map[string]interface{}{
+			"nameAvailable": false,
+			"reason":        "AccountNameInvalid",
+			"message":       name + " is not a valid storage account name. Storage account name must be between 3 and 24 characters in length and use numbers and lower-case letters only.",
+		}, nil, nil
+	}
+	if s.storageNameTaken(name) {
+		return http.StatusOK, map[string]interface{}{
+			"nameAvailable": false,
+			"reason":        "AlreadyExists",
+			"message":       "The storage account named " + name + " is already taken.",
+		}, nil, nil
+	}
+	return http.StatusOK, map[string]interface{}{"nameAvailable": true}, nil, nil
+}
+
+var siteNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9-]{0,58}[a-zA-Z0-9]$`)
+
+func (s *Server) checkSiteName(body map[string]interface{}) (int, interface{}, map[string]string, *armError) {
+	name, _ := body["name"].(string)
+	typ, _ := body["type"].(string)
+	if !strings.EqualFold(typ, "Site") {
+		return 0, nil, nil, errorf(http.StatusBadRequest, "InvalidResourceType", "The resource type '%s' is not supported", typ)
+	}
+
+	if !siteNamePattern.MatchString(name) {
+		return http.StatusOK, map[string]interface{}{
+			"nameAvailable": false,
+			"reason":        "Invalid",
+			"message":       "The name '" + name + "' is invalid. The name can contain only letters, numbers and hyphens, must be between 2 and 60 characters long, and cannot start or end with a hyphen.",
+		}, nil, nil
+	}
+	if s.sites[strings.ToLower(name)] {
+		return http.StatusOK, map[string]interface{}{
+			"nameAvailable": false,
+			"reason":        "AlreadyExists",
+			"message":       "Hostname '" + name + "' already exists. Please select a different name.",
+		}, nil, nil
+	}
+	return http.StatusOK, map[string]interface{}{"nameAvailable": true}, nil, nil
+}
+
+func (s *Server) checkDNSLabel(location, label string) (int, interface{}, map[string]string, *armError) {
+	if !s.validLocation(location) {
+		return 0, nil, nil, errorf(http.StatusBadRequest, "LocationNotAvailableForResourceType", "The provided location '%s' is not available for resource type 'Microsoft.Network/locations/CheckDnsNameAvailability'.", location)
+	}
+	if !dnsLabelPattern.MatchString(label) {
+		return 0, nil, nil, errorf(http.StatusBadRequest, "InvalidDomainNameLabel", "The domain name label %s is invalid. It must conform to the following regular expression: ^[a-z][a-z0-9-]{1,61}[a-z0-9]$.", label)
+	}
+	return http.StatusOK, map[string]interface{}{"available": s.dnsLabelOwner(location, label) == ""}, nil, nil
+}
+
+func (s *Server) storageNameTaken(name string) bool {
+	if s.taken[strings.ToLower(name)] {
+		return true
+	}
+	return s.findStorageAccount(name) != nil
+}
+
+func (s *Server) findStorageAccount(name string) *entity {
+	for _, e := range s.resources {
+		if strings.EqualFold(e.doc["type"].(string), "Microsoft.Storage/storageAccounts") && strings.EqualFold(e.doc["name"].(string), name) {
+			return e
+		}
+	}
+	return nil
+}
+
+func (s *Server) groupKey(name string) string {
+	return strings.ToLower("/subscriptions/" + s.SubscriptionID + "/resourceGroups/" + name)
+}
+
+func (s *Server) routeGroups(r *http.Request, rest []string, body map[string]interface{}) (int, interface{}, map[string]string, *armError) {
+	if len(rest) == 0 {
+		if r.Method != "GET" {
+			return 0, nil, nil, errorf(http.StatusMethodNotAllowed, "MethodNotAllowed", "%s is not supported", r.Method)
+		}
+		return s.listGroups(r)
+	}
+
+	name := rest[0]
+	if len(rest) == 1 {
+		switch r.Method {
+		case "PUT":
+			return s.putGroup(name, body)
+		case "GET":
+			g, ok := s.groups[s.groupKey(name)]
+			if !ok {
+				return 0, nil, nil, errorf(http.StatusNotFound, "ResourceGroupNotFound", "Resource group '%s' could not be found.", name)
+			}
+			return http.StatusOK, g.doc, nil, nil
+		case "HEAD":
+			if _, ok := s.groups[s.groupKey(name)]; !ok {
+				return http.StatusNotFound, nil, nil, nil
+			}
+			return http.StatusNoContent, nil, nil, nil
+		case "PATCH":
+			return s.patchGroup(name, body)
+		case "DELETE":
+			return s.deleteGroup(r, name)
+		}
+		return 0, nil, nil, errorf(http.StatusMethodNotAllowed, "MethodNotAllowed", "%s is not supported", r.Method)
+	}
+
+	g, ok := s.groups[s.groupKey(name)]
+	if !ok {
+		return 0, nil, nil, errorf(http.StatusNotFound, "ResourceGroupNotFound", "Resource group '%s' could not be found.", name)
+	}
+
+	switch {
+	case len(rest) == 2 && strings.EqualFold(rest[1], "resources") && r.Method == "GET":
+		return s.listResources(r, g)
+	case len(rest) == 2 && strings.EqualFold(rest[1], "exportTemplate") && r.Method == "POST":
+		return s.exportTemplate(g, body)
+	case len(rest) >= 5 && strings.EqualFold(rest[1], "providers"):
+		return s.routeResource(r, g, rest[2:], body)
+	case len(rest) >= 3 && strings.EqualFold(rest[1], "deployments"):
+		// Older API versions address deployments directly under the group, without the provider.
+		return s.routeResource(r, g, append([]string{"Microsoft.Resources"}, rest[1:]...), body)
+	}
+
+	return 0, nil, nil, errorf(http.StatusNotFound, "NotFound", "The requested path '%s' was not found", r.URL.Path)
+}
+
+func (s *Server) putGroup(name string, body map[string]interface{}) (int, interface{}, map[string]string, *armError) {
+	location, _ := body["location"].(string)
+	if location == "" {
+		return 0, nil, nil, errorf(http.StatusBadRequest, "LocationRequired", "The location property is required for this definition.")
+	}
+	if !s.availableFor("Microsoft.Resources/resourceGroups", location) {
+		return 0, nil, nil, errorf(http.StatusBadRequest, "LocationNotAvailableForResourceGroup", "The provided location '%s' is not available for resource group.", location)
+	}
+
+	key := s.groupKey(name)
+	if g, ok := s.groups[key]; ok {
+		if g.doc["location"] != NormalizeLocation(location) {
+			return 0, nil, nil, errorf(http.StatusConflict, "InvalidResourceGroupLocation", "Invalid resource group location '%s'. The Resource group already exists in location '%s'.", location, g.doc["location"])
+		}
+		if state := provisioningState(g.doc); state == "Deleting" {
+			return 0, nil, nil, errorf(http.StatusConflict, "ResourceGroupBeingDeleted", "The resource group '%s' is in deprovisioning state and cannot perform this operation.", name)
+		}
+		if tags, ok := body["tags"]; ok {
+			g.doc["tags"] = tags
+		}
+		return http.StatusOK, g.doc, nil, nil
+	}
+
+	doc := map[string]interface{}{
+		"id":         "/subscriptions/" + s.SubscriptionID + "/resourceGroups/" + name,
+		"name":       name,
+		"location":   NormalizeLocation(location),
+		"properties": map[string]interface{}{"provisioningState": "Succeeded"},
+	}
+	if tags, ok := body["tags"]; ok {
+		doc["tags"] = tags
+	}
+	s.groups[key] = &entity{key: key, group: key, doc: doc}
+	return http.StatusCreated, doc, nil, nil
+}
+
+func (s *Server) patchGroup(name string, body map[string]interface{}) (int, interface{}, map[string]string, *armError) {
+	g, ok := s.groups[s.groupKey(name)]
+	if !ok {
+		return 0, nil, nil, errorf(http.StatusNotFound, "ResourceGroupNotFound", "Resource group '%s' could not be found.", name)
+	}
+	if tags, ok := body["tags"]; ok {
+		g.doc["tags"] = tags
+	}
+	return http.StatusOK, g.doc, nil, nil
+}
+
+func (s *Server) deleteGroup(r *http.Request, name string) (int, interface{}, map[string]string, *armError) {
+	key := s.groupKey(name)
+	g, ok := s.groups[key]
+	if !ok {
+		return 0, nil, nil, errorf(http.StatusNotFound, "ResourceGroupNotFound", "Resource group '%s' could not be found.", name)
+	}
+	if provisioningState(g.doc) == "Deleting" {
+		return http.StatusAccepted, nil, map[string]string{"Location": s.operationURL("location", g.op), "Retry-After": "0"}, nil
+	}
+
+	setProvisioningState(g.doc, "Deleting")
+	op := s.startOperation(r, func() {
+		for k, e := range s.resources {
+			if e.group == key {
+				delete(s.resources, k)
+			}
+		}
+		delete(s.groups, key)
+		s.removeLocks(key)
+	}, nil)
+	op.result = func() (int, interface{}) { return http.StatusOK, nil }
+	g.op = op
+
+	return http.StatusAccepted, nil, map[string]string{"Location": s.operationURL("location", op), "Retry-After": "0"}, nil
+}
+
+var tagFilter = regexp.MustCompile(`(?i)^\s*tagname\s+eq\s+'([^']*)'(?:\s+and\s+tagvalue\s+eq\s+'([^']*)')?\s*$`)
+var typeFilter = regexp.MustCompile(`(?i)^\s*resourcetype\s+eq\s+'([^']*)'\s*$`)
+
+// matchesFilter applies the subset of OData filters ARM supports on lists: by tag name, by tag
+// name and value, and, for resources, by type.
+func matchesFilter(doc map[string]interface{}, filter string) (bool, *armError) {
+	if filter == "" {
+		return true, nil
+	}
+	if m := tagFilter.FindStringSubmatch(filter); m != nil {
+		tags, _ := doc["tags"].(map[string]interface{})
+		for k, v := range tags {
+			if strings.EqualFold(k, m[1]) {
+				return m[2] == "" || v == m[2], nil
+			}
+		}
+		return false, nil
+	}
+	if m := typeFilter.FindStringSubmatch(filter); m != nil {
+		return strings.EqualFold(doc["type"].(string), m[1]), nil
+	}
+	return false, errorf(http.StatusBadRequest, "InvalidFilterInQueryString", "The filter '%s' is not supported.", filter)
+}
+
+func (s *Server) listGroups(r *http.Request) (int, interface{}, map[string]string, *armError) {
+	var keys []string
+	for k := range s.groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var items []interface{}
+	for _, k := range keys {
+		doc := s.groups[k].doc
+		ok, aerr := matchesFilter(doc, r.URL.Query().Get("$filter"))
+		if aerr != nil {
+			return 0, nil, nil, aerr
+		}
+		if ok {
+			items = append(items, doc)
+		}
+	}
+	return s.page(r, items)
+}
+
+func (s *Server) listResources(r *http.Request, g *entity) (int, interface{}, map[string]string, *armError) {
+	var keys []string
+	for k, e := range s.resources {
+		if e.group == g.key && !isChildType(e.doc["type"].(string)) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var items []interface{}
+	for _, k := range keys {
+		doc := s.resources[k].doc
+		ok, aerr := matchesFilter(doc, r.URL.Query().Get("$filter"))
+		if aerr != nil {
+			return 0, nil, nil, aerr
+		}
+		if !ok {
+			continue
+		}
+		item := map[string]interface{}{}
+		for _, f := range []string{"id", "name", "type", "location", "tags"} {
+			if v, ok := doc[f]; ok {
+				item[f] = v
+			}
+		}
+		item["properties"] = map[string]interface{}{"provisioningState": provisioningState(doc)}
+		items = append(items, item)
+	}
+	return s.page(r, items)
+}
+
+// page returns one page of a list, honoring $top and continuing through a nextLink carrying a
+// $skiptoken.
+func (s *Server) page(r *http.Request, items []interface{}) (int, interface{}, map[string]string, *armError) {
+	q := r.URL.Query()
+
+	if top := q.Get("$top"); top != "" {
+		n, err := strconv.Atoi(top)
+		if err != nil || n < 0 {
+			return 0, nil, nil, errorf(http.StatusBadRequest, "InvalidTopInQueryString", "The value '%s' of $top is invalid.", top)
+		}
+		if n < len(items) {
+			items = items[:n]
+		}
+	}
+
+	skip := 0
+	if token := q.Get("$skiptoken"); token != "" {
+		n, err := strconv.Atoi(token)
+		if err != nil || n < 0 {
+			return 0, nil, nil, errorf(http.StatusBadRequest, "InvalidSkipToken", "The skip token '%s' is invalid.", token)
+		}
+		skip = n
+	}
+	if skip > len(items) {
+		skip = len(items)
+	}
+
+	end := len(items)
+	if s.PageSize > 0 && skip+s.PageSize < end {
+		end = skip + s.PageSize
+	}
+
+	result := map[string]interface{}{"value": append([]interface{}{}, items[skip:end]...)}
+	if end < len(items) {
+		next := url.Values{}
+		for k, v := range q {
+			next[k] = v
+		}
+		next.Set("$skiptoken", strconv.Itoa(end))
+		result["nextLink"] = fmt.Sprintf("%s%s?%s", s.URL, r.URL.Path, next.Encode())
+	}
+	return http.StatusOK, result, nil, nil
+}
@@ -0,0 +1,271 @@
+package armfake
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// lbCollections are the children of a load balancer, which refer to each other by ID.
+var lbCollections = []string{"frontendIPConfigurations", "backendAddressPools", "probes", "loadBalancingRules", "inboundNatRules"}
+
+func (s *Server) prepareLoadBalancer(doc map[string]interface{}) *armError {
+	id := doc["id"].(string)
+	props := properties(doc)
+
+	children := map[string]bool{}
+	for _, c := range lbCollections {
+		items, _ := props[c].([]interface{})
+		names := map[string]bool{}
+		for _, item := range items {
+			m, _ := item.(map[string]interface{})
+			name, _ := m["name"].(string)
+			if name == "" {
+				return errorf(http.StatusBadRequest, "InvalidRequestFormat", "The %s of load balancer '%s' must have a name.", c, doc["name"])
+			}
+			if names[strings.ToLower(name)] {
+				return errorf(http.StatusBadRequest, "InvalidRequestFormat", "'%s' is defined more than once in the %s of load balancer '%s'.", name, c, doc["name"])
+			}
+			names[strings.ToLower(name)] = true
+			m["id"] = id + "/" + c + "/" + name
+			children[strings.ToLower(m["id"].(string))] = true
+			setProvisioningState(m, "Succeeded")
+		}
+		if items == nil {
+			props[c] = []interface{}{}
+		}
+	}
+
+	frontends := props["frontendIPConfigurations"].([]interface{})
+	if len(frontends) == 0 {
+		return errorf(http.StatusBadRequest, "LoadBalancerMustHaveAtLeastOneFrontendIPConfiguration", "Load balancer '%s' must have at least one frontend IP configuration.", doc["name"])
+	}
+	for _, f := range frontends {
+		m := f.(map[string]interface{})
+		fp := properties(m)
+		pip, subnet := refID(fp["publicIPAddress"]), refID(fp["subnet"])
+		if (pip == "") == (subnet == "") {
+			return errorf(http.StatusBadRequest, "InvalidRequestFormat", "Frontend IP configuration '%s' of load balancer '%s' must reference either a public IP address or a subnet.", m["name"], doc["name"])
+		}
+		ref, ok := s.resources[strings.ToLower(pip+subnet)]
+		if !ok {
+			return errorf(http.StatusBadRequest, "InvalidResourceReference", "Resource %s referenced by resource %s was not found.", pip+subnet, id)
+		}
+		if cfg := refID(properties(ref.doc)["ipConfiguration"]); pip != "" && cfg != "" && !strings.EqualFold(cfg, m["id"].(string)) {
+			return errorf(http.StatusBadRequest, "PublicIPAddressInUse", "Public IP address %s is already used by %s.", pip, cfg)
+		}
+	}
+
+	for _, p := range props["probes"].([]interface{}) {
+		m := p.(map[string]interface{})
+		pp := properties(m)
+		protocol, _ := pp["protocol"].(string)
+		if !containsFold([]string{"Tcp", "Http"}, protocol) {
+			return errorf(http.StatusBadRequest, "InvalidRequestFormat", "The protocol '%v' of probe '%s' is not valid.", pp["protocol"], m["name"])
+		}
+		if aerr := checkPort(pp, "port", m["name"]); aerr != nil {
+			return aerr
+		}
+		if path, _ := pp["requestPath"].(string); strings.EqualFold(protocol, "Http") != (path != "") {
+			return errorf(http.StatusBadRequest, "InvalidRequestFormat", "Probe '%s' must have a request path if and only if its protocol is Http.", m["name"])
+		}
+		setDefault(pp, "intervalInSeconds", 15)
+		setDefault(pp, "numberOfProbes", 2)
+	}
+
+	// A frontend port can only be used once per frontend IP configuration and protocol.
+	ports := map[string]interface{}{}
+	for _, c := range []string{"loadBalancingRules", "inboundNatRules"} {
+		for _, r := range props[c].([]interface{}) {
+			m := r.(map[string]interface{})
+			rp := properties(m)
+			if protocol, _ := rp["protocol"].(string); !containsFold([]string{"Tcp", "Udp"}, protocol) {
+				return errorf(http.StatusBadRequest, "InvalidRequestFormat", "The protocol '%v' of rule '%s' is not valid.", rp["protocol"], m["name"])
+			}
+			for _, field := range []string{"frontendPort", "backendPort"} {
+				if aerr := checkPort(rp, field, m["name"]); aerr != nil {
+					return aerr
+				}
+			}
+
+			refs := []string{"frontendIPConfiguration"}
+			if c == "loadBalancingRules" {
+				refs = append(refs, "backendAddressPool")
+				setDefault(rp, "loadDistribution", "Default")
+			}
+			for _, field := range refs {
+				if !children[strings.ToLower(refID(rp[field]))] {
+					return errorf(http.StatusBadRequest, "InvalidResourceReference", "The %s '%s' of rule '%s' is not part of load balancer '%s'.", field, refID(rp[field]), m["name"], doc["name"])
+				}
+			}
+			if probe := refID(rp["probe"]); probe != "" && !children[strings.ToLower(probe)] {
+				return errorf(http.StatusBadRequest, "InvalidResourceReference", "The probe '%s' of rule '%s' is not part of load balancer '%s'.", probe, m["name"], doc["name"])
+			}
+			setDefault(rp, "idleTimeoutInMinutes", 4)
+			setDefault(rp, "enableFloatingIP", false)
+
+			key := strings.ToLower(fmt.Sprintf("%s/%v/%v", refID(rp["frontendIPConfiguration"]), rp["protocol"], rp["frontendPort"]))
+			if other, ok := ports[key]; ok {
+				return errorf(http.StatusBadRequest, "LoadBalancerRuleConflict", "Rules %s and %s of load balancer '%s' use the same frontend IP configuration, protocol and port %v.", other, m["name"], doc["name"], rp["frontendPort"])
+			}
+			ports[key] = m["name"]
+		}
+	}
+	return nil
+}
+
+func checkPort(props map[string]interface{}, field string, name interface{}) *armError {
+	port, _ := props[field].(float64)
+	if port < 1 || port > 65535 || port != float64(int(port)) {
+		return errorf(http.StatusBadRequest, "InvalidRequestFormat", "The %s '%v' of '%s' is not a valid port.", field, props[field], name)
+	}
+	return nil
+}
+
+func setDefault(props map[string]interface{}, field string, value interface{}) {
+	if _, ok := props[field]; !ok {
+		props[field] = value
+	}
+}
+
+// loadBalancerChild returns the frontend IP configuration, backend pool, probe or rule with an ID,
+// or nil if there is no such child of a load balancer.
+func (s *Server) loadBalancerChild(id string) map[string]interface{} {
+	parts := strings.Split(id, "/")
+	if len(parts) < 2 {
+		return nil
+	}
+	lb, ok := s.resources[strings.ToLower(strings.Join(parts[:len(parts)-2], "/"))]
+	if !ok || !strings.EqualFold(lb.doc["type"].(string), "Microsoft.Network/loadBalancers") {
+		return nil
+	}
+	for _, c := range lbCollections {
+		if !strings.EqualFold(c, parts[len(parts)-2]) {
+			continue
+		}
+		items, _ := properties(lb.doc)[c].([]interface{})
+		for _, item := range items {
+			if m := item.(map[string]interface{}); strings.EqualFold(m["id"].(string), id) {
+				return m
+			}
+		}
+	}
+	return nil
+}
+
+// checkLoadBalancerRefs verifies that the backend pools and NAT rules an IP configuration of a
+// network interface refers to exist, and that the NAT rules don't forward to another one.
+func (s *Server) checkLoadBalancerRefs(config map[string]interface{}, referrer string) *armError {
+	props := properties(config)
+	for _, field := range []string{"loadBalancerBackendAddressPools", "loadBalancerInboundNatRules"} {
+		refs, _ := props[field].([]interface{})
+		for _, r := range refs {
+			child := s.loadBalancerChild(refID(r))
+			if child == nil {
+				return errorf(http.StatusBadRequest, "InvalidResourceReference", "Resource %s referenced by resource %s was not found.", refID(r), referrer)
+			}
+			if other := refID(properties(child)["backendIPConfiguration"]); other != "" && !strings.EqualFold(other, config["id"].(string)) {
+				return errorf(http.StatusBadRequest, "InboundNatRuleInUse", "Inbound NAT rule %s already forwards to IP configuration %s.", refID(r), other)
+			}
+		}
+	}
+	return nil
+}
+
+// linkLoadBalancers rebuilds the references ARM maintains within load balancers: the rules using
+// each frontend IP configuration, backend pool and probe, and the IP configurations of the network
+// interfaces in each backend pool or behind each NAT rule.
+func (s *Server) linkLoadBalancers() {
+	backends := map[string][]interface{}{}
+	for _, e := range s.resources {
+		if !strings.EqualFold(e.doc["type"].(string), "Microsoft.Network/networkInterfaces") {
+			continue
+		}
+		configs, _ := properties(e.doc)["ipConfigurations"].([]interface{})
+		for _, c := range configs {
+			m := c.(map[string]interface{})
+			for _, field := range []string{"loadBalancerBackendAddressPools", "loadBalancerInboundNatRules"} {
+				refs, _ := properties(m)[field].([]interface{})
+				for _, r := range refs {
+					k := strings.ToLower(refID(r))
+					backends[k] = append(backends[k], map[string]interface{}{"id": m["id"]})
+				}
+			}
+		}
+	}
+	for _, refs := range backends {
+		sort.Slice(refs, func(i, j int) bool { return refID(refs[i]) < refID(refs[j]) })
+	}
+
+	for _, e := range s.resources {
+		if !strings.EqualFold(e.doc["type"].(string), "Microsoft.Network/loadBalancers") {
+			continue
+		}
+		props := properties(e.doc)
+
+		users := map[string][]interface{}{}
+		for _, c := range []string{"loadBalancingRules", "inboundNatRules"} {
+			rules, _ := props[c].([]interface{})
+			for _, r := range rules {
+				m := r.(map[string]interface{})
+				for _, field := range []string{"frontendIPConfiguration", "backendAddressPool", "probe"} {
+					if id := refID(properties(m)[field]); id != "" {
+						k := strings.ToLower(id) + " " + c
+						users[k] = append(users[k], map[string]interface{}{"id": m["id"]})
+					}
+				}
+			}
+		}
+
+		for _, c := range []string{"frontendIPConfigurations", "backendAddressPools", "probes", "inboundNatRules"} {
+			items, _ := props[c].([]interface{})
+			for _, item := range items {
+				m := item.(map[string]interface{})
+				ip := properties(m)
+				key := strings.ToLower(m["id"].(string))
+				switch c {
+				case "frontendIPConfigurations":
+					setRefs(ip, "loadBalancingRules", users[key+" loadBalancingRules"])
+					setRefs(ip, "inboundNatRules", users[key+" inboundNatRules"])
+				case "backendAddressPools":
+					setRefs(ip, "loadBalancingRules", users[key+" loadBalancingRules"])
+					setRefs(ip, "backendIPConfigurations", backends[key])
+				case "probes":
+					setRefs(ip, "loadBalancingRules", users[key+" loadBalancingRules"])
+				case "inboundNatRules":
+					delete(ip, "backendIPConfiguration")
+					if refs := backends[key]; len(refs) > 0 {
+						ip["backendIPConfiguration"] = refs[0]
+					}
+				}
+			}
+		}
+	}
+}
+
+func setRefs(props map[string]interface{}, field string, refs []interface{}) {
+	if len(refs) == 0 {
+		delete(props, field)
+		return
+	}
+	props[field] = refs
+}
+
+// loadBalancerInUse returns the ID of an IP configuration of a network interface in a backend pool
+// or behind a NAT rule of a load balancer, if any.
+func loadBalancerInUse(props map[string]interface{}) string {
+	pools, _ := props["backendAddressPools"].([]interface{})
+	for _, p := range pools {
+		if refs, _ := properties(p.(map[string]interface{}))["backendIPConfigurations"].([]interface{}); len(refs) > 0 {
+			return refID(refs[0])
+		}
+	}
+	rules, _ := props["inboundNatRules"].([]interface{})
+	for _, r := range rules {
+		if ref := refID(properties(r.(map[string]interface{}))["backendIPConfiguration"]); ref != "" {
+			return ref
+		}
+	}
+	return ""
+}
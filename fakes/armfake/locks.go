@@ -0,0 +1,151 @@
+package armfake
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// lockPath returns the index in a path, relative to the subscription, at which the management
+// locks of a scope are addressed, as in ".../providers/Microsoft.Authorization/locks/{name}", or
+// -1 if the path isn't about locks.
+func lockPath(rest []string) int {
+	for i := 0; i+2 < len(rest); i++ {
+		if len(rest) <= i+4 && strings.EqualFold(rest[i], "providers") && strings.EqualFold(rest[i+1], "Microsoft.Authorization") && strings.EqualFold(rest[i+2], "locks") {
+			return i
+		}
+	}
+	return -1
+}
+
+// scopeID returns the ID of the subscription, resource group or resource at a path, which must
+// exist.
+func (s *Server) scopeID(parts []string) (string, *armError) {
+	key := strings.ToLower("/" + strings.Join(parts, "/"))
+	if key == strings.ToLower("/subscriptions/"+s.SubscriptionID) {
+		return "/subscriptions/" + s.SubscriptionID, nil
+	}
+	if g, ok := s.groups[key]; ok {
+		return g.doc["id"].(string), nil
+	}
+	if e, ok := s.resources[key]; ok {
+		return e.doc["id"].(string), nil
+	}
+	return "", errorf(http.StatusNotFound, "ResourceNotFound", "The scope '%s' was not found.", "/"+strings.Join(parts, "/"))
+}
+
+// routeLocks serves the management locks of the scope at the passed path: PUT, GET and DELETE of
+// a lock by name, and the list of the locks that apply to the scope.
+func (s *Server) routeLocks(r *http.Request, scope []string, rest []string, body map[string]interface{}) (int, interface{}, map[string]string, *armError) {
+	scopeID, aerr := s.scopeID(scope)
+	if aerr != nil {
+		return 0, nil, nil, aerr
+	}
+	scopeKey := strings.ToLower(scopeID)
+
+	if len(rest) == 0 {
+		if r.Method != "GET" {
+			return 0, nil, nil, errorf(http.StatusMethodNotAllowed, "MethodNotAllowed", "%s is not supported", r.Method)
+		}
+		// The list holds the locks inherited from above the scope, and those of the scopes below.
+		var keys []string
+		for k, l := range s.locks {
+			if l.group == scopeKey || strings.HasPrefix(scopeKey, l.group+"/") || strings.HasPrefix(l.group, scopeKey+"/") {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		var items []interface{}
+		for _, k := range keys {
+			items = append(items, s.locks[k].doc)
+		}
+		return s.page(r, items)
+	}
+
+	name := rest[0]
+	id := scopeID + "/providers/Microsoft.Authorization/locks/" + name
+	key := strings.ToLower(id)
+
+	switch r.Method {
+	case "GET":
+		l, ok := s.locks[key]
+		if !ok {
+			return 0, nil, nil, errorf(http.StatusNotFound, "LockNotFound", "The lock '%s' could not be found.", name)
+		}
+		return http.StatusOK, l.doc, nil, nil
+	case "PUT":
+		// The SDK sends the properties of the lock without their envelope; ARM accepts both.
+		props, ok := body["properties"].(map[string]interface{})
+		if !ok {
+			props = body
+		}
+		level, _ := props["level"].(string)
+		if level != "CanNotDelete" && level != "ReadOnly" {
+			return 0, nil, nil, errorf(http.StatusBadRequest, "LockLevelInvalid", "The lock level '%s' is not valid. Use 'CanNotDelete' or 'ReadOnly'.", level)
+		}
+		doc := map[string]interface{}{
+			"id":         id,
+			"type":       "Microsoft.Authorization/locks",
+			"name":       name,
+			"properties": map[string]interface{}{"level": level},
+		}
+		if notes, ok := props["notes"]; ok {
+			doc["properties"].(map[string]interface{})["notes"] = notes
+		}
+		status := http.StatusCreated
+		if _, exists := s.locks[key]; exists {
+			status = http.StatusOK
+		}
+		s.locks[key] = &entity{key: key, group: scopeKey, doc: doc}
+		return status, doc, nil, nil
+	case "DELETE":
+		if _, ok := s.locks[key]; !ok {
+			return http.StatusNoContent, nil, nil, nil
+		}
+		delete(s.locks, key)
+		return http.StatusOK, nil, nil, nil
+	}
+	return 0, nil, nil, errorf(http.StatusMethodNotAllowed, "MethodNotAllowed", "%s is not supported", r.Method)
+}
+
+// checkLocks refuses changes to locked scopes, the way ARM does: CanNotDelete locks prevent
+// deleting their scope and everything below it, ReadOnly locks also prevent changing them. A
+// resource group can't be deleted either while a resource in it is locked.
+func (s *Server) checkLocks(r *http.Request, parts []string) *armError {
+	var operation string
+	switch r.Method {
+	case "DELETE":
+		operation = "delete"
+	case "PUT", "PATCH":
+		operation = "write"
+	default:
+		return nil
+	}
+
+	target := strings.ToLower("/" + strings.Join(parts, "/"))
+	var locked []string
+	for _, l := range s.locks {
+		applies := l.group == target || strings.HasPrefix(target, l.group+"/")
+		if operation == "delete" && strings.HasPrefix(l.group, target+"/") {
+			applies = true
+		}
+		level, _ := properties(l.doc)["level"].(string)
+		if applies && (operation == "delete" || level == "ReadOnly") {
+			locked = append(locked, l.group)
+		}
+	}
+	if len(locked) == 0 {
+		return nil
+	}
+	sort.Strings(locked)
+	return errorf(http.StatusConflict, "ScopeLocked", "The scope '%s' cannot perform %s operation because following scope(s) are locked: '%s'. Please remove the lock and try again.", r.URL.Path, operation, strings.Join(locked, "', '"))
+}
+
+// removeLocks drops the locks of a scope that was deleted, and those of the scopes below it.
+func (s *Server) removeLocks(scopeKey string) {
+	for k, l := range s.locks {
+		if l.group == scopeKey || strings.HasPrefix(l.group, scopeKey+"/") {
+			delete(s.locks, k)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package armfake
+
+import (
+	"net/http"
+)
+
+// startOperation registers a long-running operation, which completes by calling 'done' after it
+// has been polled PollCount times. When 'failed' is set, the operation fails with its error.
+func (s *Server) startOperation(r *http.Request, done func(), failed *failure) *operation {
+	op := &operation{
+		id:        s.newID(),
+		remaining: s.PollCount,
+		status:    "InProgress",
+		failed:    failed,
+		done:      done,
+	}
+	s.operations[op.id] = op
+	return op
+}
+
+// operationURL returns the URL at which an operation is polled. ARM reports operations in two
+// ways: 'async' operations are polled through the Azure-AsyncOperation header and return a status
+// document, 'location' operations are polled through the Location header and return 202 until
+// they are done.
+func (s *Server) operationURL(kind string, op *operation) string {
+	return s.URL + "/fake/operations/" + kind + "/" + op.id
+}
+
+// advance moves an operation one step closer to completion.
+func (s *Server) advance(op *operation) {
+	if op == nil || op.status != "InProgress" {
+		return
+	}
+	if op.remaining > 0 {
+		op.remaining--
+		return
+	}
+	if op.failed != nil {
+		op.status = "Failed"
+	} else {
+		op.status = "Succeeded"
+	}
+	if op.done != nil {
+		op.done()
+	}
+}
+
+func (s *Server) pollOperation(kind, id string) (int, interface{}, map[string]string, *armError) {
+	op, ok := s.operations[id]
+	if !ok {
+		return 0, nil, nil, errorf(http.StatusNotFound, "OperationNotFound", "The operation '%s' could not be found.", id)
+	}
+
+	s.advance(op)
+
+	if kind == "async" {
+		doc := map[string]interface{}{"status": op.status}
+		if op.status == "InProgress" && s.RunningStatus != "" {
+			doc["status"] = s.RunningStatus
+		}
+		if op.status == "Failed" {
+			doc["error"] = map[string]interface{}{"code": op.failed.code, "message": op.failed.message}
+		}
+		return http.StatusOK, doc, nil, nil
+	}
+
+	switch op.status {
+	case "InProgress":
+		return http.StatusAccepted, nil, map[string]string{"Location": s.operationURL(kind, op), "Retry-After": "0"}, nil
+	case "Failed":
+		return 0, nil, nil, errorf(op.failed.status, op.failed.code, "%s", op.failed.message)
+	}
+	if op.result != nil {
+		status, body := op.result()
+		return status, body, nil, nil
+	}
+	return http.StatusOK, nil, nil, nil
+}
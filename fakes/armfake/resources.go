@@ -0,0 +1,773 @@
+package armfake
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// resourceID builds the ID of a resource from its path segments following '/providers/'.
+func (s *Server) resourceID(g *entity, path []string) string {
+	return g.doc["id"].(string) + "/providers/" + strings.Join(path, "/")
+}
+
+// resourceType derives the type, such as 'Microsoft.Network/virtualNetworks/subnets', from the
+// path segments following '/providers/'.
+func resourceType(path []string) string {
+	ns, _ := canonicalNamespace(path[0])
+	t := ns
+	for i := 1; i < len(path); i += 2 {
+		t += "/" + path[i]
+	}
+	return t
+}
+
+func isChildType(t string) bool {
+	return strings.Count(t, "/") > 1
+}
+
+func provisioningState(doc map[string]interface{}) string {
+	props, _ := doc["properties"].(map[string]interface{})
+	state, _ := props["provisioningState"].(string)
+	return state
+}
+
+func setProvisioningState(doc map[string]interface{}, state string) {
+	props, ok := doc["properties"].(map[string]interface{})
+	if !ok {
+		props = map[string]interface{}{}
+		doc["properties"] = props
+	}
+	props["provisioningState"] = state
+}
+
+func properties(doc map[string]interface{}) map[string]interface{} {
+	props, ok := doc["properties"].(map[string]interface{})
+	if !ok {
+		props = map[string]interface{}{}
+		doc["properties"] = props
+	}
+	return props
+}
+
+func refID(v interface{}) string {
+	m, _ := v.(map[string]interface{})
+	id, _ := m["id"].(string)
+	return id
+}
+
+// The resource types whose creation is a long-running operation, and the way each reports it.
+var asyncCreation = map[string]string{
+	"microsoft.storage/storageaccounts": "location",
+	"microsoft.compute/virtualmachines": "async",
+	"microsoft.resources/deployments":   "async",
+	"microsoft.network/virtualnetworks": "async",
+}
+
+func (s *Server) isLongRunning(r *http.Request) bool {
+	if r.Method != "PUT" {
+		return false
+	}
+	parts := segments(r.URL.Path)
+	for i, p := range parts {
+		if strings.EqualFold(p, "providers") && i > 2 && len(parts) >= i+4 {
+			return asyncCreation[strings.ToLower(resourceType(parts[i+1:]))] != ""
+		}
+		if strings.EqualFold(p, "deployments") && i == 4 && len(parts) == 6 {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) routeResource(r *http.Request, g *entity, path []string, body map[string]interface{}) (int, interface{}, map[string]string, *armError) {
+	if len(path)%2 == 0 {
+		if len(path) == 4 && strings.EqualFold(path[1], "deployments") && strings.EqualFold(path[3], "validate") && r.Method == "POST" {
+			return s.validateDeployment(body)
+		}
+		return 0, nil, nil, errorf(http.StatusNotFound, "NotFound", "The requested path '%s' was not found", r.URL.Path)
+	}
+
+	ns, known := canonicalNamespace(path[0])
+	if !known {
+		return 0, nil, nil, errorf(http.StatusNotFound, "InvalidResourceNamespace", "The resource namespace '%s' is invalid.", path[0])
+	}
+	path[0] = ns
+
+	id := s.resourceID(g, path)
+	key := strings.ToLower(id)
+	typ := resourceType(path)
+
+	switch r.Method {
+	case "GET":
+		e, ok := s.resources[key]
+		if !ok {
+			return 0, nil, nil, errorf(http.StatusNotFound, "ResourceNotFound", "The Resource '%s' under resource group '%s' was not found.", typ+"/"+path[len(path)-1], g.doc["name"])
+		}
+		s.advance(e.op)
+		return http.StatusOK, s.view(e.doc), nil, nil
+	case "PUT":
+		return s.putResource(r, g, path, id, typ, body)
+	case "DELETE":
+		return s.deleteResource(r, key, typ)
+	}
+	return 0, nil, nil, errorf(http.StatusMethodNotAllowed, "MethodNotAllowed", "%s is not supported", r.Method)
+}
+
+func (s *Server) putResource(r *http.Request, g *entity, path []string, id, typ string, body map[string]interface{}) (int, interface{}, map[string]string, *armError) {
+	if body == nil {
+		return 0, nil, nil, errorf(http.StatusBadRequest, "InvalidRequestContent", "The request content is missing.")
+	}
+	if provisioningState(g.doc) == "Deleting" {
+		return 0, nil, nil, errorf(http.StatusConflict, "ResourceGroupBeingDeleted", "The resource group '%s' is in deprovisioning state and cannot perform this operation.", g.doc["name"])
+	}
+	if s.RequireRegistration && s.providers[strings.ToLower(path[0])] != "Registered" {
+		return 0, nil, nil, errorf(http.StatusConflict, "MissingSubscriptionRegistration", "The subscription is not registered to use namespace '%s'.", path[0])
+	}
+
+	ltype := strings.ToLower(typ)
+	name := path[len(path)-1]
+
+	doc := copyDoc(body)
+	doc["id"] = id
+	doc["name"] = name
+	doc["type"] = typ
+
+	if !isChildType(typ) && ltype != "microsoft.resources/deployments" {
+		location, _ := doc["location"].(string)
+		if location == "" {
+			return 0, nil, nil, errorf(http.StatusBadRequest, "LocationRequired", "The location property is required for this definition.")
+		}
+		if !s.availableFor(typ, location) {
+			return 0, nil, nil, errorf(http.StatusBadRequest, "LocationNotAvailableForResourceType", "The provided location '%s' is not available for resource type '%s'.", location, typ)
+		}
+		doc["location"] = NormalizeLocation(location)
+	}
+
+	key := strings.ToLower(id)
+	existing, exists := s.resources[key]
+
+	var aerr *armError
+	switch ltype {
+	case "microsoft.storage/storageaccounts":
+		aerr = s.prepareStorageAccount(doc, exists)
+	case "microsoft.compute/availabilitysets":
+		aerr = s.prepareAvailabilitySet(doc, existing)
+	case "microsoft.network/virtualnetworks":
+		aerr = s.prepareVirtualNetwork(doc)
+	case "microsoft.network/virtualnetworks/subnets":
+		aerr = s.prepareSubnet(g, path, doc)
+	case "microsoft.network/publicipaddresses":
+		aerr = s.preparePublicIP(doc, existing)
+	case "microsoft.network/networkinterfaces":
+		aerr = s.prepareNetworkInterface(doc)
+	case "microsoft.network/networksecuritygroups":
+		aerr = s.prepareSecurityGroup(doc)
+	case "microsoft.network/loadbalancers":
+		aerr = s.prepareLoadBalancer(doc)
+	case "microsoft.compute/virtualmachines":
+		aerr = s.prepareVirtualMachine(doc)
+	case "microsoft.resources/deployments":
+		aerr = s.prepareDeployment(doc)
+	}
+	if aerr != nil {
+		return 0, nil, nil, aerr
+	}
+
+	// Availability sets answer 200 whether they were created or updated.
+	status := http.StatusCreated
+	if exists || ltype == "microsoft.compute/availabilitysets" {
+		status = http.StatusOK
+	}
+
+	e := &entity{key: key, group: g.key, doc: doc}
+	s.resources[key] = e
+	s.afterPut(e, ltype)
+
+	kind := asyncCreation[ltype]
+	if kind == "" {
+		setProvisioningState(doc, "Succeeded")
+		return status, s.view(doc), nil, nil
+	}
+
+	initial := "Creating"
+	if exists {
+		initial = "Updating"
+	}
+	if ltype == "microsoft.resources/deployments" {
+		initial = "Accepted"
+	}
+	setProvisioningState(doc, initial)
+
+	op := s.startOperation(r, func() { setProvisioningState(doc, "Succeeded") }, s.failureFor(r))
+	if op.failed != nil {
+		op.done = func() { setProvisioningState(doc, "Failed") }
+	}
+	e.op = op
+
+	if kind == "location" {
+		op.result = func() (int, interface{}) { return http.StatusOK, s.view(doc) }
+		return http.StatusAccepted, nil, map[string]string{"Location": s.operationURL("location", op), "Retry-After": "0"}, nil
+	}
+	return status, s.view(doc), map[string]string{"Azure-AsyncOperation": s.operationURL("async", op)}, nil
+}
+
+// view returns the document as ARM returns it, without secrets.
+func (s *Server) view(doc map[string]interface{}) map[string]interface{} {
+	v := copyDoc(doc)
+	if props, ok := v["properties"].(map[string]interface{}); ok {
+		if os, ok := props["osProfile"].(map[string]interface{}); ok {
+			delete(os, "adminPassword")
+			delete(os, "customData")
+		}
+	}
+	return v
+}
+
+func (s *Server) prepareStorageAccount(doc map[string]interface{}, exists bool) *armError {
+	name := doc["name"].(string)
+	if !storageNamePattern.MatchString(name) {
+		return errorf(http.StatusBadRequest, "AccountNameInvalid", "%s is not a valid storage account name. Storage account name must be between 3 and 24 characters in length and use numbers and lower-case letters only.", name)
+	}
+	if !exists && s.storageNameTaken(name) {
+		return errorf(http.StatusConflict, "StorageAccountAlreadyTaken", "The storage account named %s is already taken.", name)
+	}
+	props := properties(doc)
+	accountType, _ := props["accountType"].(string)
+	switch accountType {
+	case "Standard_LRS", "Standard_GRS", "Standard_RAGRS", "Standard_ZRS", "Premium_LRS":
+	default:
+		return errorf(http.StatusBadRequest, "AccountTypeInvalid", "The account type '%s' is not valid.", accountType)
+	}
+	props["primaryEndpoints"] = map[string]interface{}{
+		"blob":  "https://" + name + ".blob.core.windows.net/",
+		"queue": "https://" + name + ".queue.core.windows.net/",
+		"table": "https://" + name + ".table.core.windows.net/",
+	}
+	props["primaryLocation"] = doc["location"]
+	props["statusOfPrimary"] = "Available"
+	return nil
+}
+
+func (s *Server) prepareAvailabilitySet(doc map[string]interface{}, existing *entity) *armError {
+	props := properties(doc)
+	if _, ok := props["platformFaultDomainCount"]; !ok {
+		props["platformFaultDomainCount"] = 3
+	}
+	if _, ok := props["platformUpdateDomainCount"]; !ok {
+		props["platformUpdateDomainCount"] = 5
+	}
+	vms := []interface{}{}
+	if existing != nil {
+		if v, ok := properties(existing.doc)["virtualMachines"].([]interface{}); ok {
+			vms = v
+		}
+	}
+	props["virtualMachines"] = vms
+	return nil
+}
+
+var cidrPattern = regexp.MustCompile(`^\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3}/\d{1,2}$`)
+
+func (s *Server) prepareVirtualNetwork(doc map[string]interface{}) *armError {
+	props := properties(doc)
+	space, _ := props["addressSpace"].(map[string]interface{})
+	prefixes, _ := space["addressPrefixes"].([]interface{})
+	if len(prefixes) == 0 {
+		return errorf(http.StatusBadRequest, "InvalidAddressSpace", "The virtual network '%s' must have at least one address prefix.", doc["name"])
+	}
+	for _, p := range prefixes {
+		if ps, _ := p.(string); !cidrPattern.MatchString(ps) {
+			return errorf(http.StatusBadRequest, "InvalidAddressPrefixFormat", "Address prefix '%v' does not have a valid format.", p)
+		}
+	}
+
+	subnets, _ := props["subnets"].([]interface{})
+	for _, sn := range subnets {
+		m, _ := sn.(map[string]interface{})
+		name, _ := m["name"].(string)
+		if name == "" {
+			return errorf(http.StatusBadRequest, "InvalidRequestFormat", "Subnets of virtual network '%s' must have a name.", doc["name"])
+		}
+		prefix, _ := properties(m)["addressPrefix"].(string)
+		if !cidrPattern.MatchString(prefix) {
+			return errorf(http.StatusBadRequest, "InvalidAddressPrefixFormat", "Address prefix '%s' of subnet '%s' does not have a valid format.", prefix, name)
+		}
+		m["id"] = doc["id"].(string) + "/subnets/" + name
+		if aerr := s.checkSecurityGroupRef(properties(m), m["id"].(string)); aerr != nil {
+			return aerr
+		}
+		setProvisioningState(m, "Succeeded")
+	}
+	if subnets == nil {
+		props["subnets"] = []interface{}{}
+	}
+	return nil
+}
+
+func (s *Server) prepareSubnet(g *entity, path []string, doc map[string]interface{}) *armError {
+	vnetID := s.resourceID(g, path[:3])
+	if _, ok := s.resources[strings.ToLower(vnetID)]; !ok {
+		return errorf(http.StatusNotFound, "ResourceNotFound", "The Resource 'Microsoft.Network/virtualNetworks/%s' under resource group '%s' was not found.", path[2], g.doc["name"])
+	}
+	prefix, _ := properties(doc)["addressPrefix"].(string)
+	if !cidrPattern.MatchString(prefix) {
+		return errorf(http.StatusBadRequest, "InvalidAddressPrefixFormat", "Address prefix '%s' of subnet '%s' does not have a valid format.", prefix, doc["name"])
+	}
+	delete(doc, "location")
+	return s.checkSecurityGroupRef(properties(doc), doc["id"].(string))
+}
+
+func (s *Server) preparePublicIP(doc map[string]interface{}, existing *entity) *armError {
+	props := properties(doc)
+	method, _ := props["publicIPAllocationMethod"].(string)
+	if method == "" {
+		props["publicIPAllocationMethod"] = "Dynamic"
+	} else if method != "Dynamic" && method != "Static" {
+		return errorf(http.StatusBadRequest, "InvalidRequestFormat", "The allocation method '%s' is not valid.", method)
+	}
+	if dns, ok := props["dnsSettings"].(map[string]interface{}); ok {
+		label, _ := dns["domainNameLabel"].(string)
+		if label != "" {
+			if !dnsLabelPattern.MatchString(label) {
+				return errorf(http.StatusBadRequest, "InvalidDomainNameLabel", "The domain name label %s is invalid. It must conform to the following regular expression: ^[a-z][a-z0-9-]{1,61}[a-z0-9]$.", label)
+			}
+			if other := s.dnsLabelOwner(doc["location"].(string), label); other != "" && other != strings.ToLower(doc["id"].(string)) {
+				return errorf(http.StatusBadRequest, "DnsRecordInUse", "DNS record %s.%s.cloudapp.azure.com is already used by another public IP.", label, doc["location"])
+			}
+			dns["fqdn"] = fmt.Sprintf("%s.%s.cloudapp.azure.com", label, doc["location"])
+		}
+	}
+	if existing != nil {
+		if cfg, ok := properties(existing.doc)["ipConfiguration"]; ok {
+			props["ipConfiguration"] = cfg
+		}
+	}
+	return nil
+}
+
+var dnsLabelPattern = regexp.MustCompile(`^[a-z][a-z0-9-]{1,61}[a-z0-9]$`)
+
+// dnsLabelOwner returns the key of the public IP address using a DNS label in a location, if any,
+// or "taken" for labels taken with TakeDNSLabel.
+func (s *Server) dnsLabelOwner(location, label string) string {
+	if s.labels[NormalizeLocation(location)+"/"+strings.ToLower(label)] {
+		return "taken"
+	}
+	for k, e := range s.resources {
+		if !strings.EqualFold(e.doc["type"].(string), "Microsoft.Network/publicIPAddresses") || e.doc["location"] != NormalizeLocation(location) {
+			continue
+		}
+		dns, _ := properties(e.doc)["dnsSettings"].(map[string]interface{})
+		if l, _ := dns["domainNameLabel"].(string); strings.EqualFold(l, label) {
+			return k
+		}
+	}
+	return ""
+}
+
+// DNSLabelAvailable reports whether a public IP DNS label is unused in a location.
+func (s *Server) DNSLabelAvailable(location, label string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dnsLabelOwner(location, label) == ""
+}
+
+func (s *Server) prepareNetworkInterface(doc map[string]interface{}) *armError {
+	props := properties(doc)
+	configs, _ := props["ipConfigurations"].([]interface{})
+	if len(configs) == 0 {
+		return errorf(http.StatusBadRequest, "NetworkInterfaceMustHaveAtLeastOneIpConfiguration", "Network interface '%s' must have at least one IP configuration.", doc["name"])
+	}
+	for i, c := range configs {
+		m, _ := c.(map[string]interface{})
+		name, _ := m["name"].(string)
+		if name == "" {
+			return errorf(http.StatusBadRequest, "InvalidRequestFormat", "IP configurations of network interface '%s' must have a name.", doc["name"])
+		}
+		m["id"] = doc["id"].(string) + "/ipConfigurations/" + name
+		cp := properties(m)
+
+		subnetID := refID(cp["subnet"])
+		if subnetID == "" {
+			return errorf(http.StatusBadRequest, "InvalidRequestFormat", "IP configuration '%s' of network interface '%s' must reference a subnet.", name, doc["name"])
+		}
+		if _, ok := s.resources[strings.ToLower(subnetID)]; !ok {
+			return errorf(http.StatusBadRequest, "InvalidResourceReference", "Resource %s referenced by resource %s was not found.", subnetID, doc["id"])
+		}
+		if pip := refID(cp["publicIPAddress"]); pip != "" {
+			if _, ok := s.resources[strings.ToLower(pip)]; !ok {
+				return errorf(http.StatusBadRequest, "InvalidResourceReference", "Resource %s referenced by resource %s was not found.", pip, doc["id"])
+			}
+		}
+		if aerr := s.checkLoadBalancerRefs(m, doc["id"].(string)); aerr != nil {
+			return aerr
+		}
+		if _, ok := cp["privateIPAllocationMethod"]; !ok {
+			cp["privateIPAllocationMethod"] = "Dynamic"
+		}
+		if _, ok := cp["privateIPAddress"]; !ok {
+			cp["privateIPAddress"] = fmt.Sprintf("10.0.0.%d", 4+i+s.nextID%200)
+		}
+		setProvisioningState(m, "Succeeded")
+	}
+	if aerr := s.checkSecurityGroupRef(props, doc["id"].(string)); aerr != nil {
+		return aerr
+	}
+	props["macAddress"] = fmt.Sprintf("00-0D-3A-00-%02X-%02X", s.nextID/256%256, s.nextID%256)
+	s.nextID++
+	return nil
+}
+
+func (s *Server) prepareVirtualMachine(doc map[string]interface{}) *armError {
+	props := properties(doc)
+	id := doc["id"].(string)
+
+	hw, _ := props["hardwareProfile"].(map[string]interface{})
+	if size, _ := hw["vmSize"].(string); size == "" {
+		return errorf(http.StatusBadRequest, "InvalidParameter", "Required parameter 'hardwareProfile.vmSize' is missing (null).")
+	}
+
+	network, _ := props["networkProfile"].(map[string]interface{})
+	nics, _ := network["networkInterfaces"].([]interface{})
+	if len(nics) == 0 {
+		return errorf(http.StatusBadRequest, "InvalidParameter", "Virtual machine '%s' must have at least one network interface.", doc["name"])
+	}
+	for _, n := range nics {
+		nicID := refID(n)
+		nic, ok := s.resources[strings.ToLower(nicID)]
+		if !ok {
+			return errorf(http.StatusNotFound, "NotFound", "Resource %s not found.", nicID)
+		}
+		if owner := refID(properties(nic.doc)["virtualMachine"]); owner != "" && !strings.EqualFold(owner, id) {
+			return errorf(http.StatusBadRequest, "NicInUse", "Network interface %s is used by existing VM %s.", nicID, owner)
+		}
+	}
+
+	if avset := refID(props["availabilitySet"]); avset != "" {
+		if _, ok := s.resources[strings.ToLower(avset)]; !ok {
+			return errorf(http.StatusNotFound, "NotFound", "Availability set %s not found.", avset)
+		}
+	}
+
+	storage, _ := props["storageProfile"].(map[string]interface{})
+	osDisk, _ := storage["osDisk"].(map[string]interface{})
+	vhd, _ := osDisk["vhd"].(map[string]interface{})
+	uri, _ := vhd["uri"].(string)
+	if uri == "" {
+		return errorf(http.StatusBadRequest, "InvalidParameter", "Required parameter 'osDisk.vhd.uri' is missing (null).")
+	}
+	account := uri[strings.Index(uri, "//")+2:]
+	if i := strings.Index(account, "."); i >= 0 {
+		account = account[:i]
+	}
+	if s.findStorageAccount(account) == nil && !s.taken[account] {
+		return errorf(http.StatusNotFound, "StorageAccountNotFound", "Storage account '%s' not found. Ensure storage account is not deleted and belongs to the same Azure location as the VM.", account)
+	}
+
+	osProfile, _ := props["osProfile"].(map[string]interface{})
+	if user, _ := osProfile["adminUsername"].(string); user == "" {
+		return errorf(http.StatusBadRequest, "InvalidParameter", "Required parameter 'adminUsername' is missing (null).")
+	}
+	if linux, ok := osProfile["linuxConfiguration"].(map[string]interface{}); ok {
+		if disabled, _ := linux["disablePasswordAuthentication"].(bool); disabled {
+			ssh, _ := linux["ssh"].(map[string]interface{})
+			if keys, _ := ssh["publicKeys"].([]interface{}); len(keys) == 0 {
+				return errorf(http.StatusBadRequest, "InvalidParameter", "Authentication using either SSH or by user name and password must be enabled in Linux profile.")
+			}
+		}
+	} else if pw, _ := osProfile["adminPassword"].(string); pw == "" {
+		return errorf(http.StatusBadRequest, "InvalidParameter", "Required parameter 'adminPassword' is missing (null).")
+	}
+
+	props["vmId"] = s.newID()
+	return nil
+}
+
+func (s *Server) prepareDeployment(doc map[string]interface{}) *armError {
+	props := properties(doc)
+	_, inline := props["template"]
+	_, linked := props["templateLink"]
+	if inline == linked {
+		return errorf(http.StatusBadRequest, "InvalidDeployment", "The deployment must have exactly one of 'template' and 'templateLink'.")
+	}
+	if mode, _ := props["mode"].(string); mode != "Incremental" && mode != "Complete" {
+		return errorf(http.StatusBadRequest, "InvalidDeploymentMode", "The deployment mode '%v' is not valid.", props["mode"])
+	}
+	if template, ok := props["template"].(map[string]interface{}); ok {
+		if aerr := checkTemplateParameters(template, props["parameters"]); aerr != nil {
+			return aerr
+		}
+	}
+	props["correlationId"] = s.newID()
+	props["outputs"] = map[string]interface{}{}
+	return nil
+}
+
+// checkTemplateParameters verifies that every template parameter without a default value was
+// given a value, and that no unknown parameters were passed.
+func checkTemplateParameters(template map[string]interface{}, parameters interface{}) *armError {
+	declared, _ := template["parameters"].(map[string]interface{})
+	given, _ := parameters.(map[string]interface{})
+	for name, def := range declared {
+		d, _ := def.(map[string]interface{})
+		if _, ok := d["defaultValue"]; ok {
+			continue
+		}
+		if _, ok := given[name]; !ok {
+			return errorf(http.StatusBadRequest, "InvalidTemplate", "Deployment template validation failed: 'The value for the template parameter '%s' is not provided.'", name)
+		}
+	}
+	for name := range given {
+		if _, ok := declared[name]; !ok {
+			return errorf(http.StatusBadRequest, "InvalidTemplate", "Deployment template validation failed: 'The template parameter '%s' is not valid.'", name)
+		}
+	}
+	return nil
+}
+
+func (s *Server) validateDeployment(body map[string]interface{}) (int, interface{}, map[string]string, *armError) {
+	doc := copyDoc(body)
+	if doc == nil {
+		doc = map[string]interface{}{}
+	}
+	if aerr := s.prepareDeployment(doc); aerr != nil {
+		return http.StatusBadRequest, map[string]interface{}{
+			"error": map[string]interface{}{"code": aerr.code, "message": aerr.message},
+		}, nil, nil
+	}
+	setProvisioningState(doc, "Succeeded")
+	return http.StatusOK, map[string]interface{}{"properties": doc["properties"]}, nil, nil
+}
+
+// afterPut maintains the back-references ARM keeps between resources, such as the subnets of a
+// virtual network and the virtual machines of an availability set.
+func (s *Server) afterPut(e *entity, ltype string) {
+	defer s.linkLoadBalancers()
+	defer s.linkSecurityGroups()
+	props := properties(e.doc)
+
+	switch ltype {
+	case "microsoft.network/virtualnetworks":
+		previous := map[string]*entity{}
+		for k, c := range s.resources {
+			if strings.HasPrefix(k, e.key+"/subnets/") {
+				previous[k] = c
+				delete(s.resources, k)
+			}
+		}
+		subnets, _ := props["subnets"].([]interface{})
+		for _, sn := range subnets {
+			m := sn.(map[string]interface{})
+			m["type"] = "Microsoft.Network/virtualNetworks/subnets"
+			key := strings.ToLower(m["id"].(string))
+			if old, ok := previous[key]; ok {
+				if refs, ok := properties(old.doc)["ipConfigurations"]; ok {
+					properties(m)["ipConfigurations"] = refs
+				}
+			}
+			s.resources[key] = &entity{key: key, group: e.group, doc: m}
+		}
+
+	case "microsoft.network/virtualnetworks/subnets":
+		vnetKey := e.key[:strings.LastIndex(e.key, "/subnets/")]
+		vnet := s.resources[vnetKey]
+		vprops := properties(vnet.doc)
+		subnets, _ := vprops["subnets"].([]interface{})
+		replaced := false
+		for i, sn := range subnets {
+			if strings.EqualFold(refID(sn), e.doc["id"].(string)) {
+				subnets[i] = e.doc
+				replaced = true
+			}
+		}
+		if !replaced {
+			subnets = append(subnets, e.doc)
+		}
+		vprops["subnets"] = subnets
+
+	case "microsoft.network/networkinterfaces":
+		configs, _ := props["ipConfigurations"].([]interface{})
+		for _, c := range configs {
+			cp := properties(c.(map[string]interface{}))
+			if pip, ok := s.resources[strings.ToLower(refID(cp["publicIPAddress"]))]; ok {
+				properties(pip.doc)["ipConfiguration"] = map[string]interface{}{"id": c.(map[string]interface{})["id"]}
+			}
+			if sn, ok := s.resources[strings.ToLower(refID(cp["subnet"]))]; ok {
+				sp := properties(sn.doc)
+				refs, _ := sp["ipConfigurations"].([]interface{})
+				sp["ipConfigurations"] = append(refs, map[string]interface{}{"id": c.(map[string]interface{})["id"]})
+			}
+		}
+
+	case "microsoft.network/loadbalancers":
+		frontends, _ := props["frontendIPConfigurations"].([]interface{})
+		for _, f := range frontends {
+			if pip, ok := s.resources[strings.ToLower(refID(properties(f.(map[string]interface{}))["publicIPAddress"]))]; ok {
+				properties(pip.doc)["ipConfiguration"] = map[string]interface{}{"id": f.(map[string]interface{})["id"]}
+			}
+		}
+
+	case "microsoft.compute/virtualmachines":
+		network, _ := props["networkProfile"].(map[string]interface{})
+		nics, _ := network["networkInterfaces"].([]interface{})
+		for _, n := range nics {
+			if nic, ok := s.resources[strings.ToLower(refID(n))]; ok {
+				properties(nic.doc)["virtualMachine"] = map[string]interface{}{"id": e.doc["id"]}
+			}
+		}
+		if avset, ok := s.resources[strings.ToLower(refID(props["availabilitySet"]))]; ok {
+			ap := properties(avset.doc)
+			vms, _ := ap["virtualMachines"].([]interface{})
+			for _, vm := range vms {
+				if strings.EqualFold(refID(vm), e.doc["id"].(string)) {
+					return
+				}
+			}
+			ap["virtualMachines"] = append(vms, map[string]interface{}{"id": e.doc["id"]})
+		}
+	}
+}
+
+func (s *Server) deleteResource(r *http.Request, key, typ string) (int, interface{}, map[string]string, *armError) {
+	e, ok := s.resources[key]
+	if !ok {
+		return http.StatusNoContent, nil, nil, nil
+	}
+	props := properties(e.doc)
+
+	switch strings.ToLower(typ) {
+	case "microsoft.network/virtualnetworks/subnets":
+		if refs, _ := props["ipConfigurations"].([]interface{}); len(refs) > 0 {
+			return 0, nil, nil, errorf(http.StatusBadRequest, "InUseSubnetCannotBeDeleted", "Subnet %s is in use by %s and cannot be deleted.", e.doc["name"], refID(refs[0]))
+		}
+	case "microsoft.network/virtualnetworks":
+		for k, c := range s.resources {
+			if strings.HasPrefix(k, key+"/subnets/") {
+				if refs, _ := properties(c.doc)["ipConfigurations"].([]interface{}); len(refs) > 0 {
+					return 0, nil, nil, errorf(http.StatusBadRequest, "InUseSubnetCannotBeDeleted", "Subnet %s is in use by %s and cannot be deleted.", c.doc["name"], refID(refs[0]))
+				}
+			}
+		}
+	case "microsoft.network/publicipaddresses":
+		if cfg := refID(props["ipConfiguration"]); cfg != "" {
+			return 0, nil, nil, errorf(http.StatusBadRequest, "PublicIPAddressInUse", "Public IP address %s can not be deleted since it is still allocated to resource %s.", e.doc["id"], cfg)
+		}
+	case "microsoft.network/networkinterfaces":
+		if vm := refID(props["virtualMachine"]); vm != "" {
+			return 0, nil, nil, errorf(http.StatusBadRequest, "NicInUse", "Network Interface %s is used by existing resource %s.", e.doc["id"], vm)
+		}
+	case "microsoft.network/loadbalancers":
+		if ref := loadBalancerInUse(props); ref != "" {
+			return 0, nil, nil, errorf(http.StatusBadRequest, "InUseLoadBalancerCannotBeDeleted", "Load balancer %s cannot be deleted because it is in use by the following resources: %s.", e.doc["id"], ref)
+		}
+	case "microsoft.network/networksecuritygroups":
+		if ref := securityGroupInUse(props); ref != "" {
+			return 0, nil, nil, errorf(http.StatusBadRequest, "InUseNetworkSecurityGroupCannotBeDeleted", "Network security group %s cannot be deleted because it is in use by the following resources: %s.", e.doc["id"], ref)
+		}
+	case "microsoft.compute/availabilitysets":
+		if vms, _ := props["virtualMachines"].([]interface{}); len(vms) > 0 {
+			return 0, nil, nil, errorf(http.StatusConflict, "AvailabilitySetInUse", "Availability set %s is in use by virtual machine %s.", e.doc["name"], refID(vms[0]))
+		}
+	case "microsoft.storage/storageaccounts":
+		for _, vm := range s.resources {
+			if strings.EqualFold(vm.doc["type"].(string), "Microsoft.Compute/virtualMachines") && strings.Contains(fmt.Sprint(vm.doc["properties"]), "//"+e.doc["name"].(string)+".blob.") {
+				return 0, nil, nil, errorf(http.StatusConflict, "StorageAccountInUse", "Storage account %s has disks in use by virtual machine %s.", e.doc["name"], vm.doc["name"])
+			}
+		}
+	}
+
+	remove := func() {
+		s.removeResource(e)
+	}
+
+	if strings.EqualFold(typ, "Microsoft.Compute/virtualMachines") {
+		setProvisioningState(e.doc, "Deleting")
+		op := s.startOperation(r, remove, nil)
+		op.result = func() (int, interface{}) { return http.StatusOK, nil }
+		e.op = op
+		return http.StatusAccepted, nil, map[string]string{"Location": s.operationURL("location", op), "Retry-After": "0"}, nil
+	}
+
+	remove()
+	return http.StatusOK, nil, nil, nil
+}
+
+// removeResource deletes a resource along with its children, and the back-references other
+// resources hold to it.
+func (s *Server) removeResource(e *entity) {
+	defer s.linkLoadBalancers()
+	defer s.linkSecurityGroups()
+	s.removeLocks(e.key)
+	for k := range s.resources {
+		if k == e.key || strings.HasPrefix(k, e.key+"/") {
+			delete(s.resources, k)
+		}
+	}
+
+	id := e.doc["id"].(string)
+	props := properties(e.doc)
+
+	switch strings.ToLower(e.doc["type"].(string)) {
+	case "microsoft.compute/virtualmachines":
+		for _, other := range s.resources {
+			op := properties(other.doc)
+			if strings.EqualFold(refID(op["virtualMachine"]), id) {
+				delete(op, "virtualMachine")
+			}
+			if vms, ok := op["virtualMachines"].([]interface{}); ok {
+				kept := []interface{}{}
+				for _, vm := range vms {
+					if !strings.EqualFold(refID(vm), id) {
+						kept = append(kept, vm)
+					}
+				}
+				op["virtualMachines"] = kept
+			}
+		}
+	case "microsoft.network/networkinterfaces":
+		configs, _ := props["ipConfigurations"].([]interface{})
+		for _, c := range configs {
+			cid, _ := c.(map[string]interface{})["id"].(string)
+			for _, other := range s.resources {
+				op := properties(other.doc)
+				if strings.EqualFold(refID(op["ipConfiguration"]), cid) {
+					delete(op, "ipConfiguration")
+				}
+				if refs, ok := op["ipConfigurations"].([]interface{}); ok {
+					kept := []interface{}{}
+					for _, ref := range refs {
+						if !strings.EqualFold(refID(ref), cid) {
+							kept = append(kept, ref)
+						}
+					}
+					op["ipConfigurations"] = kept
+				}
+			}
+		}
+	case "microsoft.network/loadbalancers":
+		frontends, _ := props["frontendIPConfigurations"].([]interface{})
+		for _, f := range frontends {
+			fid, _ := f.(map[string]interface{})["id"].(string)
+			for _, other := range s.resources {
+				if op := properties(other.doc); strings.EqualFold(refID(op["ipConfiguration"]), fid) {
+					delete(op, "ipConfiguration")
+				}
+			}
+		}
+	case "microsoft.network/virtualnetworks/subnets":
+		vnetKey := e.key[:strings.LastIndex(e.key, "/subnets/")]
+		if vnet, ok := s.resources[vnetKey]; ok {
+			vp := properties(vnet.doc)
+			subnets, _ := vp["subnets"].([]interface{})
+			kept := []interface{}{}
+			for _, sn := range subnets {
+				if !strings.EqualFold(refID(sn), id) {
+					kept = append(kept, sn)
+				}
+			}
+			vp["subnets"] = kept
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package armfake
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// defaultSecurityRules are the rules ARM adds to every network security group, below the user's:
+// traffic within the virtual network and from the load balancer is allowed, and the rest of the
+// inbound traffic denied.
+var defaultSecurityRules = []struct {
+	name, direction, access, source, destination string
+	priority                                     int
+}{
+	{"AllowVnetInBound", "Inbound", "Allow", "VirtualNetwork", "VirtualNetwork", 65000},
+	{"AllowAzureLoadBalancerInBound", "Inbound", "Allow", "AzureLoadBalancer", "*", 65001},
+	{"DenyAllInBound", "Inbound", "Deny", "*", "*", 65500},
+	{"AllowVnetOutBound", "Outbound", "Allow", "VirtualNetwork", "VirtualNetwork", 65000},
+	{"AllowInternetOutBound", "Outbound", "Allow", "*", "Internet", 65001},
+	{"DenyAllOutBound", "Outbound", "Deny", "*", "*", 65500},
+}
+
+func (s *Server) prepareSecurityGroup(doc map[string]interface{}) *armError {
+	id := doc["id"].(string)
+	props := properties(doc)
+
+	rules, _ := props["securityRules"].([]interface{})
+	names := map[string]bool{}
+	priorities := map[string]string{}
+	for _, r := range rules {
+		m, _ := r.(map[string]interface{})
+		name, _ := m["name"].(string)
+		if name == "" {
+			return errorf(http.StatusBadRequest, "InvalidRequestFormat", "Security rules of network security group '%s' must have a name.", doc["name"])
+		}
+		if names[strings.ToLower(name)] {
+			return errorf(http.StatusBadRequest, "InvalidRequestFormat", "Security rule '%s' is defined more than once in network security group '%s'.", name, doc["name"])
+		}
+		names[strings.ToLower(name)] = true
+
+		rp := properties(m)
+		for _, f := range []struct {
+			field   string
+			allowed []string
+		}{
+			{"protocol", []string{"Tcp", "Udp", "*"}},
+			{"access", []string{"Allow", "Deny"}},
+			{"direction", []string{"Inbound", "Outbound"}},
+		} {
+			if v, _ := rp[f.field].(string); !containsFold(f.allowed, v) {
+				return errorf(http.StatusBadRequest, "InvalidRequestFormat", "The %s '%v' of security rule '%s' is not valid.", f.field, rp[f.field], name)
+			}
+		}
+		for _, field := range []string{"sourcePortRange", "destinationPortRange", "sourceAddressPrefix", "destinationAddressPrefix"} {
+			if v, _ := rp[field].(string); v == "" {
+				return errorf(http.StatusBadRequest, "InvalidRequestFormat", "Required parameter '%s' of security rule '%s' is missing (null).", field, name)
+			}
+		}
+		priority, _ := rp["priority"].(float64)
+		if priority < 100 || priority > 4096 {
+			return errorf(http.StatusBadRequest, "SecurityRuleInvalidPriority", "Security rule %s has invalid Priority. Value provided: %v Allowed range 100-4096.", name, rp["priority"])
+		}
+		key := fmt.Sprintf("%s/%v", strings.ToLower(rp["direction"].(string)), priority)
+		if other, ok := priorities[key]; ok {
+			return errorf(http.StatusBadRequest, "SecurityRuleConflict", "Security rule %s conflicts with rule %s. Rules cannot have the same Priority and Direction.", name, other)
+		}
+		priorities[key] = name
+
+		m["id"] = id + "/securityRules/" + name
+		setProvisioningState(m, "Succeeded")
+	}
+	if rules == nil {
+		props["securityRules"] = []interface{}{}
+	}
+
+	var defaults []interface{}
+	for _, d := range defaultSecurityRules {
+		defaults = append(defaults, map[string]interface{}{
+			"id":   id + "/defaultSecurityRules/" + d.name,
+			"name": d.name,
+			"properties": map[string]interface{}{
+				"protocol":                 "*",
+				"sourcePortRange":          "*",
+				"destinationPortRange":     "*",
+				"sourceAddressPrefix":      d.source,
+				"destinationAddressPrefix": d.destination,
+				"access":                   d.access,
+				"priority":                 d.priority,
+				"direction":                d.direction,
+				"provisioningState":        "Succeeded",
+			},
+		})
+	}
+	props["defaultSecurityRules"] = defaults
+	return nil
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSecurityGroupRef verifies that the network security group a network interface or subnet
+// refers to, if any, exists.
+func (s *Server) checkSecurityGroupRef(props map[string]interface{}, referrer string) *armError {
+	if nsg := refID(props["networkSecurityGroup"]); nsg != "" {
+		if _, ok := s.resources[strings.ToLower(nsg)]; !ok {
+			return errorf(http.StatusBadRequest, "InvalidResourceReference", "Resource %s referenced by resource %s was not found.", nsg, referrer)
+		}
+	}
+	return nil
+}
+
+// linkSecurityGroups rebuilds the lists of the network interfaces and subnets each network
+// security group is associated with, which ARM maintains.
+func (s *Server) linkSecurityGroups() {
+	links := map[string]map[string][]interface{}{}
+	for _, e := range s.resources {
+		field := ""
+		switch strings.ToLower(e.doc["type"].(string)) {
+		case "microsoft.network/networkinterfaces":
+			field = "networkInterfaces"
+		case "microsoft.network/virtualnetworks/subnets":
+			field = "subnets"
+		default:
+			continue
+		}
+		nsg := strings.ToLower(refID(properties(e.doc)["networkSecurityGroup"]))
+		if nsg == "" {
+			continue
+		}
+		if links[nsg] == nil {
+			links[nsg] = map[string][]interface{}{}
+		}
+		links[nsg][field] = append(links[nsg][field], map[string]interface{}{"id": e.doc["id"]})
+	}
+
+	for k, e := range s.resources {
+		if !strings.EqualFold(e.doc["type"].(string), "Microsoft.Network/networkSecurityGroups") {
+			continue
+		}
+		props := properties(e.doc)
+		delete(props, "networkInterfaces")
+		delete(props, "subnets")
+		for field, refs := range links[k] {
+			sort.Slice(refs, func(i, j int) bool { return refID(refs[i]) < refID(refs[j]) })
+			props[field] = refs
+		}
+	}
+}
+
+// securityGroupInUse returns the ID of a network interface or subnet associated with a network
+// security group, if any.
+func securityGroupInUse(props map[string]interface{}) string {
+	for _, field := range []string{"networkInterfaces", "subnets"} {
+		if refs, _ := props[field].([]interface{}); len(refs) > 0 {
+			return refID(refs[0])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,487 @@
+// Package asmfake provides a local, in-memory stand-in for the classic Azure Service Management
+// API, so that the service_management samples can be exercised without a publish settings file.
+//
+// The server speaks the XML protocol of the management API: requests must carry an x-ms-version
+// header, every response carries an x-ms-request-id, and errors are reported as <Error> documents
+// with codes such as ConflictError and ResourceNotFound. Creating and deleting deployments, and
+// deleting hosted services with their media, are asynchronous: the server answers with 202 and
+// the request ID is polled as an operation, which completes after PollCount polls.
+//
+// Like the real API, the server authenticates clients by their management certificate. It serves
+// TLS with a self-signed server certificate, which clients created by the SDK verify against the
+// system roots; write it out with WriteRootCertificate and point SSL_CERT_FILE at it before the
+// process makes its first TLS connection. Use helpers.ManagementClientForEndpoint to create a
+// client for the server.
+package asmfake
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSubscriptionID is the subscription served when none is given to NewServer.
+const DefaultSubscriptionID = "00000000-0000-0000-0000-000000000000"
+
+// Request is a record of one request received by the server.
+type Request struct {
+	Method    string
+	Path      string
+	Query     string
+	RequestID string
+	Body      []byte
+}
+
+// Server is a fake classic Service Management endpoint with in-memory state.
+type Server struct {
+	*httptest.Server
+
+	SubscriptionID string
+
+	// ManagementCertificate holds a PEM encoded client certificate and private key which the
+	// server accepts for its subscription, in the form the SDK's management clients take.
+	ManagementCertificate []byte
+
+	// PollCount is the number of times an asynchronous operation reports that it is still in
+	// progress before it completes.
+	PollCount int
+
+	// Locations, RoleSizes and Images are the values accepted when creating hosted services and
+	// virtual machines.
+	Locations []string
+	RoleSizes []string
+	Images    []string
+
+	mu              sync.Mutex
+	certificates    map[string]bool
+	services        map[string]*hostedService
+	storageAccounts map[string]string
+	operations      map[string]*operation
+	failures        []*failure
+	requests        []Request
+	nextID          int
+}
+
+type operation struct {
+	id        string
+	remaining int
+	status    string
+	failed    *failure
+	done      func()
+	undo      func()
+}
+
+type failure struct {
+	method  string
+	pattern *regexp.Regexp
+	status  int
+	code    string
+	message string
+	once    bool
+}
+
+// NewServer starts a fake management endpoint for the passed subscription, or DefaultSubscriptionID
+// if it is empty, and creates a management certificate for it. Close it when done.
+func NewServer(subscriptionID string) (*Server, error) {
+	if subscriptionID == "" {
+		subscriptionID = DefaultSubscriptionID
+	}
+	s := &Server{
+		SubscriptionID: subscriptionID,
+		PollCount:      1,
+		Locations:      []string{"West US", "East US", "North Europe", "West Europe"},
+		RoleSizes: []string{
+			"ExtraSmall", "Small", "Medium", "Large", "ExtraLarge",
+			"Basic_A0", "Basic_A1", "Basic_A2", "Basic_A3", "Basic_A4",
+			"Standard_D1", "Standard_D2", "Standard_D3", "Standard_D4",
+		},
+		Images: []string{
+			"b39f27a8b8c64d52b05eac6a62ebad85__Ubuntu-14_04-LTS-amd64-server-20140724-en-us-30GB",
+			"b39f27a8b8c64d52b05eac6a62ebad85__Ubuntu-14_04_2-LTS-amd64-server-20150309-en-us-30GB",
+			"a699494373c04fc0bc8f2bb1389d6106__Windows-Server-2012-R2-201502.01-en.us-127GB.vhd",
+		},
+		certificates:    map[string]bool{},
+		services:        map[string]*hostedService{},
+		storageAccounts: map[string]string{},
+		operations:      map[string]*operation{},
+	}
+
+	cert, err := newCertificate("Fake Management Certificate")
+	if err != nil {
+		return nil, err
+	}
+	s.ManagementCertificate = cert
+	if err := s.AddManagementCertificate(cert); err != nil {
+		return nil, err
+	}
+
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(s.serveHTTP))
+	s.Server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	s.Server.StartTLS()
+	return s, nil
+}
+
+// newCertificate creates a self-signed certificate and returns it in PEM form together with its
+// private key.
+func newCertificate(name string) ([]byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: Failed to generate a certificate key (%v)", err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: Failed to create a certificate (%v)", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: Failed to encode a certificate key (%v)", err)
+	}
+
+	var b bytes.Buffer
+	pem.Encode(&b, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	pem.Encode(&b, &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return b.Bytes(), nil
+}
+
+func thumbprint(der []byte) string {
+	return fmt.Sprintf("%X", sha1.Sum(der))
+}
+
+// AddManagementCertificate makes the server accept the first certificate found in the passed PEM
+// data, as uploading a management certificate to a subscription does.
+func (s *Server) AddManagementCertificate(pemData []byte) error {
+	for {
+		var block *pem.Block
+		block, pemData = pem.Decode(pemData)
+		if block == nil {
+			return fmt.Errorf("ERROR: No certificate found in the PEM data")
+		}
+		if block.Type == "CERTIFICATE" {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.certificates[thumbprint(block.Bytes)] = true
+			return nil
+		}
+	}
+}
+
+// WriteRootCertificate writes the server's TLS certificate in PEM form to a file, which clients
+// can be made to trust by pointing SSL_CERT_FILE at it.
+func (s *Server) WriteRootCertificate(path string) error {
+	var b bytes.Buffer
+	pem.Encode(&b, &pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw})
+	return ioutil.WriteFile(path, b.Bytes(), 0644)
+}
+
+// AddStorageAccount creates a classic storage account in the passed location, which virtual
+// machine disks may then be placed in.
+func (s *Server) AddStorageAccount(name, location string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.storageAccounts[strings.ToLower(name)] = location
+}
+
+// Fail makes requests with the passed method and a path matching the regular expression fail with
+// the given status and error code. When 'once' is set, only the first matching request fails.
+// Asynchronous operations started by a matching request fail when they complete instead, which is
+// how the management API reports most provisioning errors.
+func (s *Server) Fail(method, pathPattern string, status int, code, message string, once bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, &failure{
+		method:  strings.ToUpper(method),
+		pattern: regexp.MustCompile("(?i)" + pathPattern),
+		status:  status,
+		code:    code,
+		message: message,
+		once:    once,
+	})
+}
+
+// Requests returns the requests received so far, in order.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// HostedServices returns the names of the hosted services, in sorted order.
+func (s *Server) HostedServices() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.serviceNames()
+}
+
+// DeploymentStatus returns the status of the deployment in a hosted service's production slot.
+func (s *Server) DeploymentStatus(serviceName string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if hs, ok := s.services[strings.ToLower(serviceName)]; ok {
+		if d, ok := hs.deployments["production"]; ok {
+			return d.status, true
+		}
+	}
+	return "", false
+}
+
+// managementError is an error response in the format of the management API.
+type managementError struct {
+	status  int
+	code    string
+	message string
+}
+
+func errorf(status int, code, format string, args ...interface{}) *managementError {
+	return &managementError{status: status, code: code, message: fmt.Sprintf(format, args...)}
+}
+
+type errorXML struct {
+	XMLName xml.Name `xml:"http://schemas.microsoft.com/windowsazure Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+// response is what a handler produces on success. Asynchronous handlers return the operation
+// that completes the request.
+type response struct {
+	status int
+	body   interface{}
+	op     *operation
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	requestID := fmt.Sprintf("%032x", s.nextID)
+	w.Header().Set("x-ms-request-id", requestID)
+	s.requests = append(s.requests, Request{
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Query:     r.URL.RawQuery,
+		RequestID: requestID,
+		Body:      body,
+	})
+
+	resp, merr := s.handle(r, requestID, body)
+	if merr != nil {
+		// Failed requests can be looked up as operations too, as with the real API.
+		if r.Method != "GET" {
+			s.operations[requestID] = &operation{id: requestID, status: "Failed", failed: &failure{status: merr.status, code: merr.code, message: merr.message}}
+		}
+		writeXML(w, merr.status, errorXML{Code: merr.code, Message: merr.message})
+		return
+	}
+	if r.Method != "GET" && resp.op == nil {
+		s.operations[requestID] = &operation{id: requestID, status: "Succeeded"}
+	}
+	writeXML(w, resp.status, resp.body)
+}
+
+func (s *Server) handle(r *http.Request, requestID string, body []byte) (*response, *managementError) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 || !s.certificates[thumbprint(r.TLS.PeerCertificates[0].Raw)] {
+		return nil, errorf(http.StatusForbidden, "ForbiddenError", "The server failed to authenticate the request. Verify that the certificate is valid and is associated with this subscription.")
+	}
+	if r.Header.Get("x-ms-version") == "" {
+		return nil, errorf(http.StatusBadRequest, "MissingOrIncorrectVersionHeader", "The versioning header is not specified or was specified incorrectly.")
+	}
+
+	parts := segments(r.URL.Path)
+	if len(parts) == 0 || parts[0] != s.SubscriptionID {
+		return nil, errorf(http.StatusForbidden, "ForbiddenError", "The server failed to authenticate the request. Verify that the certificate is valid and is associated with this subscription.")
+	}
+
+	f := s.failureFor(r)
+	if f != nil && !isAsync(r) {
+		return nil, errorf(f.status, f.code, "%s", f.message)
+	}
+
+	resp, merr := s.route(r, parts[1:], body, requestID)
+	if merr == nil && resp.op != nil {
+		resp.op.failed = f
+	}
+	return resp, merr
+}
+
+// isAsync reports whether a request starts an asynchronous operation.
+func isAsync(r *http.Request) bool {
+	path := strings.ToLower(r.URL.Path)
+	switch r.Method {
+	case "POST":
+		return strings.HasSuffix(path, "/deployments")
+	case "DELETE":
+		return strings.Contains(path, "/deployments/") || strings.Contains(path, "/deploymentslots/") || r.URL.Query().Get("comp") == "media"
+	}
+	return false
+}
+
+func (s *Server) failureFor(r *http.Request) *failure {
+	for i, f := range s.failures {
+		if f.method != "" && f.method != r.Method {
+			continue
+		}
+		if !f.pattern.MatchString(r.URL.Path) {
+			continue
+		}
+		if f.once {
+			s.failures = append(s.failures[:i], s.failures[i+1:]...)
+		}
+		return f
+	}
+	return nil
+}
+
+func writeXML(w http.ResponseWriter, status int, v interface{}) {
+	if v == nil {
+		w.WriteHeader(status)
+		return
+	}
+	b, _ := xml.Marshal(v)
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+func segments(path string) []string {
+	var parts []string
+	for _, p := range strings.Split(strings.Trim(path, "/"), "/") {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return parts
+}
+
+func (s *Server) route(r *http.Request, parts []string, body []byte, requestID string) (*response, *managementError) {
+	notFound := errorf(http.StatusNotFound, "ResourceNotFound", "The requested resource does not exist.")
+	if len(parts) == 0 {
+		return nil, notFound
+	}
+
+	switch strings.ToLower(parts[0]) {
+	case "operations":
+		if len(parts) == 2 && r.Method == "GET" {
+			return s.getOperation(parts[1])
+		}
+	case "locations":
+		if len(parts) == 1 && r.Method == "GET" {
+			return s.listLocations(), nil
+		}
+	case "services":
+		if len(parts) >= 2 && strings.EqualFold(parts[1], "hostedservices") {
+			return s.routeHostedServices(r, parts[2:], body, requestID)
+		}
+	}
+	return nil, notFound
+}
+
+// startOperation registers an asynchronous operation under the ID of the request that started it.
+// It completes by calling 'done' after it has been polled PollCount times; an operation made to
+// fail calls its 'undo' function instead, if it has one.
+func (s *Server) startOperation(requestID string, done func()) *operation {
+	op := &operation{id: requestID, remaining: s.PollCount, status: "InProgress", done: done}
+	s.operations[requestID] = op
+	return op
+}
+
+type operationXML struct {
+	XMLName        xml.Name  `xml:"http://schemas.microsoft.com/windowsazure Operation"`
+	ID             string    `xml:"ID"`
+	Status         string    `xml:"Status"`
+	HTTPStatusCode string    `xml:"HttpStatusCode,omitempty"`
+	Error          *errorXML `xml:"Error,omitempty"`
+}
+
+func (s *Server) getOperation(id string) (*response, *managementError) {
+	op, ok := s.operations[id]
+	if !ok {
+		return nil, errorf(http.StatusNotFound, "ResourceNotFound", "The operation request ID was not found.")
+	}
+
+	if op.status == "InProgress" {
+		if op.remaining > 0 {
+			op.remaining--
+		} else if op.failed != nil {
+			op.status = "Failed"
+			if op.undo != nil {
+				op.undo()
+			}
+		} else {
+			op.status = "Succeeded"
+			if op.done != nil {
+				op.done()
+			}
+		}
+	}
+
+	result := operationXML{ID: op.id, Status: op.status}
+	switch op.status {
+	case "Succeeded":
+		result.HTTPStatusCode = "200"
+	case "Failed":
+		result.HTTPStatusCode = fmt.Sprint(op.failed.status)
+		result.Error = &errorXML{XMLName: xml.Name{Local: "Error"}, Code: op.failed.code, Message: op.failed.message}
+	}
+	return &response{status: http.StatusOK, body: result}, nil
+}
+
+type locationXML struct {
+	Name              string   `xml:"Name"`
+	DisplayName       string   `xml:"DisplayName"`
+	AvailableServices []string `xml:"AvailableServices>AvailableService"`
+	RoleSizes         []string `xml:"ComputeCapabilities>VirtualMachinesRoleSizes>RoleSize"`
+}
+
+type locationsXML struct {
+	XMLName   xml.Name      `xml:"http://schemas.microsoft.com/windowsazure Locations"`
+	Locations []locationXML `xml:"Location"`
+}
+
+func (s *Server) listLocations() *response {
+	var result locationsXML
+	for _, l := range s.Locations {
+		result.Locations = append(result.Locations, locationXML{
+			Name:              l,
+			DisplayName:       l,
+			AvailableServices: []string{"Compute", "Storage", "PersistentVMRole"},
+			RoleSizes:         s.RoleSizes,
+		})
+	}
+	return &response{status: http.StatusOK, body: result}
+}
+
+func (s *Server) validLocation(location string) bool {
+	for _, l := range s.Locations {
+		if strings.EqualFold(l, location) {
+			return true
+		}
+	}
+	return false
+}
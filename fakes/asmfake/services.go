@@ -0,0 +1,530 @@
+package asmfake
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+type hostedService struct {
+	name         string
+	label        string
+	description  string
+	location     string
+	created      string
+	lastModified string
+	deployments  map[string]*deployment // By lower-case slot.
+}
+
+type deployment struct {
+	name    string
+	slot    string
+	label   string
+	status  string
+	privID  string
+	vnet    string
+	roles   []roleXML
+	created string
+}
+
+var slotNames = map[string]string{"production": "Production", "staging": "Staging"}
+
+var serviceNamePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9-]{1,61}[a-zA-Z0-9]$`)
+
+// TakeServiceName marks a hosted service name as used by someone outside the subscription, so
+// that it is reported unavailable and creating a service with it fails with ConflictError.
+func (s *Server) TakeServiceName(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.services[strings.ToLower(name)] = nil
+}
+
+func (s *Server) serviceNames() []string {
+	var names []string
+	for _, hs := range s.services {
+		if hs != nil {
+			names = append(names, hs.name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func timestamp() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+func badRequest(format string, args ...interface{}) *managementError {
+	return errorf(http.StatusBadRequest, "BadRequest", format, args...)
+}
+
+func decodeBody(body []byte, v interface{}) *managementError {
+	if err := xml.Unmarshal(body, v); err != nil {
+		return badRequest("The request body's XML was invalid or not correctly specified.")
+	}
+	return nil
+}
+
+func (s *Server) routeHostedServices(r *http.Request, parts []string, body []byte, requestID string) (*response, *managementError) {
+	switch {
+	case len(parts) == 0 && r.Method == "GET":
+		return s.listHostedServices(), nil
+	case len(parts) == 0 && r.Method == "POST":
+		return s.createHostedService(body)
+	case len(parts) == 3 && r.Method == "GET" && strings.EqualFold(parts[0], "operations") && strings.EqualFold(parts[1], "isavailable"):
+		return s.checkServiceName(parts[2]), nil
+	}
+
+	hs := s.services[strings.ToLower(parts[0])]
+	if hs == nil {
+		return nil, errorf(http.StatusNotFound, "ResourceNotFound", "The hosted service does not exist.")
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == "GET":
+		return &response{status: http.StatusOK, body: s.serviceXML(hs, r.URL.Query().Get("embed-detail") == "true")}, nil
+	case len(parts) == 1 && r.Method == "DELETE":
+		return s.deleteHostedService(r, hs, requestID)
+	case len(parts) == 2 && r.Method == "POST" && strings.EqualFold(parts[1], "deployments"):
+		return s.createDeployment(hs, body, requestID)
+	case len(parts) == 3 && strings.EqualFold(parts[1], "deployments"):
+		return s.routeDeployment(r, hs, findDeployment(hs, parts[2]), requestID)
+	case len(parts) == 3 && strings.EqualFold(parts[1], "deploymentslots"):
+		return s.routeDeployment(r, hs, hs.deployments[strings.ToLower(parts[2])], requestID)
+	}
+	return nil, errorf(http.StatusNotFound, "ResourceNotFound", "The requested resource does not exist.")
+}
+
+type availabilityXML struct {
+	XMLName xml.Name `xml:"http://schemas.microsoft.com/windowsazure AvailabilityResponse"`
+	Result  bool     `xml:"Result"`
+	Reason  string   `xml:"Reason,omitempty"`
+}
+
+func (s *Server) checkServiceName(name string) *response {
+	result := availabilityXML{Result: true}
+	if !serviceNamePattern.MatchString(name) {
+		result = availabilityXML{Reason: "The hosted service name is invalid."}
+	} else if _, taken := s.services[strings.ToLower(name)]; taken {
+		result = availabilityXML{Reason: "The specified DNS name is already taken."}
+	}
+	return &response{status: http.StatusOK, body: result}
+}
+
+type createHostedServiceXML struct {
+	ServiceName   string
+	Label         string
+	Description   string
+	Location      string
+	AffinityGroup string
+}
+
+func (s *Server) createHostedService(body []byte) (*response, *managementError) {
+	var req createHostedServiceXML
+	if merr := decodeBody(body, &req); merr != nil {
+		return nil, merr
+	}
+
+	if !serviceNamePattern.MatchString(req.ServiceName) {
+		return nil, badRequest("The hosted service name is invalid.")
+	}
+	label, err := base64.StdEncoding.DecodeString(req.Label)
+	if err != nil || len(label) == 0 {
+		return nil, badRequest("The label '%s' is not a valid base64 encoded string.", req.Label)
+	}
+	if req.AffinityGroup != "" {
+		return nil, badRequest("Affinity groups are not supported; specify a location instead.")
+	}
+	if !s.validLocation(req.Location) {
+		return nil, badRequest("The location constraint '%s' is not valid.", req.Location)
+	}
+	if _, taken := s.services[strings.ToLower(req.ServiceName)]; taken {
+		return nil, errorf(http.StatusConflict, "ConflictError", "The specified DNS name is already taken.")
+	}
+
+	now := timestamp()
+	s.services[strings.ToLower(req.ServiceName)] = &hostedService{
+		name:         req.ServiceName,
+		label:        req.Label,
+		description:  req.Description,
+		location:     req.Location,
+		created:      now,
+		lastModified: now,
+		deployments:  map[string]*deployment{},
+	}
+	return &response{status: http.StatusCreated}, nil
+}
+
+func (s *Server) deleteHostedService(r *http.Request, hs *hostedService, requestID string) (*response, *managementError) {
+	remove := func() {
+		delete(s.services, strings.ToLower(hs.name))
+	}
+
+	if r.URL.Query().Get("comp") == "media" {
+		// Deleting a service together with its deployments and disks is asynchronous.
+		previous := map[*deployment]string{}
+		for _, d := range hs.deployments {
+			previous[d] = d.status
+			d.status = "Deleting"
+		}
+		op := s.startOperation(requestID, remove)
+		op.undo = func() {
+			for d, status := range previous {
+				d.status = status
+			}
+		}
+		return &response{status: http.StatusAccepted, op: op}, nil
+	}
+	if len(hs.deployments) > 0 {
+		return nil, errorf(http.StatusConflict, "ConflictError", "The hosted service '%s' has deployments and cannot be deleted. Delete its deployments first.", hs.name)
+	}
+	remove()
+	return &response{status: http.StatusOK}, nil
+}
+
+type hostedServicePropertiesXML struct {
+	Description      string `xml:"Description,omitempty"`
+	Location         string `xml:"Location"`
+	Label            string `xml:"Label"`
+	Status           string `xml:"Status"`
+	DateCreated      string `xml:"DateCreated"`
+	DateLastModified string `xml:"DateLastModified"`
+}
+
+type hostedServiceXML struct {
+	XMLName     xml.Name                   `xml:"http://schemas.microsoft.com/windowsazure HostedService"`
+	URL         string                     `xml:"Url"`
+	ServiceName string                     `xml:"ServiceName"`
+	Properties  hostedServicePropertiesXML `xml:"HostedServiceProperties"`
+	Deployments []deploymentXML            `xml:"Deployments>Deployment,omitempty"`
+}
+
+type hostedServicesXML struct {
+	XMLName        xml.Name           `xml:"http://schemas.microsoft.com/windowsazure HostedServices"`
+	HostedServices []hostedServiceXML `xml:"HostedService"`
+}
+
+func (s *Server) listHostedServices() *response {
+	var result hostedServicesXML
+	for _, name := range s.serviceNames() {
+		result.HostedServices = append(result.HostedServices, s.serviceXML(s.services[strings.ToLower(name)], false))
+	}
+	return &response{status: http.StatusOK, body: result}
+}
+
+func (s *Server) serviceXML(hs *hostedService, detail bool) hostedServiceXML {
+	x := hostedServiceXML{
+		URL:         s.URL + "/" + s.SubscriptionID + "/services/hostedservices/" + url.PathEscape(hs.name),
+		ServiceName: hs.name,
+		Properties: hostedServicePropertiesXML{
+			Description:      hs.description,
+			Location:         hs.location,
+			Label:            hs.label,
+			Status:           "Created",
+			DateCreated:      hs.created,
+			DateLastModified: hs.lastModified,
+		},
+	}
+	if detail {
+		for _, slot := range []string{"production", "staging"} {
+			if d, ok := hs.deployments[slot]; ok {
+				x.Deployments = append(x.Deployments, deploymentDoc(hs, d))
+			}
+		}
+	}
+	return x
+}
+
+// The request and response forms of a deployment share their role definitions. Passwords are
+// removed before roles are stored.
+
+type inputEndpointXML struct {
+	Name      string `xml:"Name"`
+	Protocol  string `xml:"Protocol"`
+	Port      int    `xml:"Port"`
+	LocalPort int    `xml:"LocalPort"`
+}
+
+type configurationSetXML struct {
+	ConfigurationSetType             string             `xml:"ConfigurationSetType"`
+	ComputerName                     string             `xml:"ComputerName,omitempty"`
+	AdminUsername                    string             `xml:"AdminUsername,omitempty"`
+	AdminPassword                    string             `xml:"AdminPassword,omitempty"`
+	HostName                         string             `xml:"HostName,omitempty"`
+	UserName                         string             `xml:"UserName,omitempty"`
+	UserPassword                     string             `xml:"UserPassword,omitempty"`
+	DisableSSHPasswordAuthentication string             `xml:"DisableSshPasswordAuthentication,omitempty"`
+	InputEndpoints                   *inputEndpointsXML `xml:"InputEndpoints"`
+}
+
+type inputEndpointsXML struct {
+	InputEndpoints []inputEndpointXML `xml:"InputEndpoint"`
+}
+
+type osVirtualHardDiskXML struct {
+	MediaLink       string `xml:"MediaLink"`
+	SourceImageName string `xml:"SourceImageName"`
+	OS              string `xml:"OS,omitempty"`
+}
+
+type roleXML struct {
+	RoleName          string                `xml:"RoleName"`
+	RoleType          string                `xml:"RoleType"`
+	ConfigurationSets []configurationSetXML `xml:"ConfigurationSets>ConfigurationSet,omitempty"`
+	OSVirtualHardDisk *osVirtualHardDiskXML `xml:"OSVirtualHardDisk"`
+	RoleSize          string                `xml:"RoleSize"`
+}
+
+type deploymentRequestXML struct {
+	Name               string
+	DeploymentSlot     string
+	Label              string
+	Roles              []roleXML `xml:"RoleList>Role"`
+	VirtualNetworkName string
+}
+
+type roleInstanceXML struct {
+	RoleName       string `xml:"RoleName"`
+	InstanceName   string `xml:"InstanceName"`
+	InstanceStatus string `xml:"InstanceStatus"`
+	InstanceSize   string `xml:"InstanceSize"`
+	PowerState     string `xml:"PowerState"`
+	HostName       string `xml:"HostName,omitempty"`
+}
+
+type deploymentXML struct {
+	XMLName            xml.Name          `xml:"http://schemas.microsoft.com/windowsazure Deployment"`
+	Name               string            `xml:"Name"`
+	DeploymentSlot     string            `xml:"DeploymentSlot"`
+	PrivateID          string            `xml:"PrivateID"`
+	Status             string            `xml:"Status"`
+	Label              string            `xml:"Label"`
+	URL                string            `xml:"Url"`
+	CreatedTime        string            `xml:"CreatedTime"`
+	RoleInstances      []roleInstanceXML `xml:"RoleInstanceList>RoleInstance"`
+	Roles              []roleXML         `xml:"RoleList>Role"`
+	VirtualNetworkName string            `xml:"VirtualNetworkName,omitempty"`
+}
+
+func deploymentDoc(hs *hostedService, d *deployment) deploymentXML {
+	x := deploymentXML{
+		Name:               d.name,
+		DeploymentSlot:     d.slot,
+		PrivateID:          d.privID,
+		Status:             d.status,
+		Label:              d.label,
+		URL:                "http://" + strings.ToLower(hs.name) + ".cloudapp.net/",
+		CreatedTime:        d.created,
+		Roles:              d.roles,
+		VirtualNetworkName: d.vnet,
+	}
+
+	instanceStatus, powerState := "Provisioning", "Starting"
+	switch d.status {
+	case "Running":
+		instanceStatus, powerState = "ReadyRole", "Started"
+	case "Deleting":
+		instanceStatus, powerState = "StoppedVM", "Stopped"
+	}
+	for _, role := range d.roles {
+		x.RoleInstances = append(x.RoleInstances, roleInstanceXML{
+			RoleName:       role.RoleName,
+			InstanceName:   role.RoleName,
+			InstanceStatus: instanceStatus,
+			InstanceSize:   role.RoleSize,
+			PowerState:     powerState,
+			HostName:       role.RoleName,
+		})
+	}
+	return x
+}
+
+func findDeployment(hs *hostedService, name string) *deployment {
+	for _, d := range hs.deployments {
+		if strings.EqualFold(d.name, name) {
+			return d
+		}
+	}
+	return nil
+}
+
+func (s *Server) routeDeployment(r *http.Request, hs *hostedService, d *deployment, requestID string) (*response, *managementError) {
+	if d == nil {
+		return nil, errorf(http.StatusNotFound, "ResourceNotFound", "No deployments were found.")
+	}
+	switch r.Method {
+	case "GET":
+		return &response{status: http.StatusOK, body: deploymentDoc(hs, d)}, nil
+	case "DELETE":
+		if d.status == "Deleting" {
+			return nil, errorf(http.StatusConflict, "ConflictError", "Windows Azure is currently performing an operation on this deployment that requires exclusive access.")
+		}
+		previous := d.status
+		d.status = "Deleting"
+		op := s.startOperation(requestID, func() {
+			delete(hs.deployments, strings.ToLower(d.slot))
+		})
+		op.undo = func() { d.status = previous }
+		return &response{status: http.StatusAccepted, op: op}, nil
+	}
+	return nil, errorf(http.StatusNotFound, "ResourceNotFound", "The requested resource does not exist.")
+}
+
+func (s *Server) createDeployment(hs *hostedService, body []byte, requestID string) (*response, *managementError) {
+	var req deploymentRequestXML
+	if merr := decodeBody(body, &req); merr != nil {
+		return nil, merr
+	}
+
+	if req.Name == "" {
+		return nil, badRequest("The deployment name is empty or was not specified.")
+	}
+	slot := strings.ToLower(req.DeploymentSlot)
+	if _, ok := slotNames[slot]; !ok {
+		return nil, badRequest("The deployment slot '%s' is not valid.", req.DeploymentSlot)
+	}
+	if d, ok := hs.deployments[slot]; ok {
+		return nil, errorf(http.StatusConflict, "ConflictError", "The specified deployment slot %s is occupied by deployment '%s'.", req.DeploymentSlot, d.name)
+	}
+	if findDeployment(hs, req.Name) != nil {
+		return nil, errorf(http.StatusConflict, "ConflictError", "A deployment named '%s' already exists.", req.Name)
+	}
+	if len(req.Roles) == 0 {
+		return nil, badRequest("The deployment must contain at least one role.")
+	}
+
+	for i := range req.Roles {
+		if merr := s.validateRole(hs, &req.Roles[i]); merr != nil {
+			return nil, merr
+		}
+	}
+
+	d := &deployment{
+		name:    req.Name,
+		slot:    slotNames[slot],
+		label:   req.Label,
+		status:  "Deploying",
+		privID:  requestID[len(requestID)-16:],
+		vnet:    req.VirtualNetworkName,
+		roles:   req.Roles,
+		created: timestamp(),
+	}
+	hs.deployments[slot] = d
+	hs.lastModified = d.created
+
+	// A deployment that fails to provision is removed again.
+	op := s.startOperation(requestID, func() {
+		d.status = "Running"
+	})
+	op.undo = func() {
+		delete(hs.deployments, slot)
+	}
+	return &response{status: http.StatusAccepted, op: op}, nil
+}
+
+// validateRole checks a virtual machine role the way the management API does, and removes the
+// passwords from it.
+func (s *Server) validateRole(hs *hostedService, role *roleXML) *managementError {
+	if role.RoleName == "" {
+		return badRequest("The role name is empty or was not specified.")
+	}
+	if role.RoleType != "PersistentVMRole" {
+		return badRequest("The role type '%s' is not supported for virtual machines.", role.RoleType)
+	}
+	if !contains(s.RoleSizes, role.RoleSize) {
+		return badRequest("The value '%s' specified for parameter 'RoleSize' is invalid.", role.RoleSize)
+	}
+
+	disk := role.OSVirtualHardDisk
+	if disk == nil || disk.SourceImageName == "" {
+		return badRequest("The OS virtual hard disk and its source image must be specified.")
+	}
+	if !contains(s.Images, disk.SourceImageName) {
+		return errorf(http.StatusNotFound, "ResourceNotFound", "The image %s does not exist.", disk.SourceImageName)
+	}
+	if merr := s.validateMediaLink(hs, disk.MediaLink); merr != nil {
+		return merr
+	}
+
+	provisioned := false
+	for i := range role.ConfigurationSets {
+		cs := &role.ConfigurationSets[i]
+		switch cs.ConfigurationSetType {
+		case "LinuxProvisioningConfiguration":
+			if cs.HostName == "" || cs.UserName == "" {
+				return badRequest("The host name and user name must be specified for a Linux virtual machine.")
+			}
+			if cs.UserPassword == "" && cs.DisableSSHPasswordAuthentication != "true" {
+				return badRequest("A password must be specified unless SSH password authentication is disabled.")
+			}
+			provisioned = true
+		case "WindowsProvisioningConfiguration":
+			if cs.ComputerName == "" || cs.AdminUsername == "" || cs.AdminPassword == "" {
+				return badRequest("The computer name, admin user name and admin password must be specified for a Windows virtual machine.")
+			}
+			provisioned = true
+		case "NetworkConfiguration":
+			if cs.InputEndpoints == nil {
+				break
+			}
+			for _, ep := range cs.InputEndpoints.InputEndpoints {
+				if ep.Port < 1 || ep.Port > 65535 || ep.LocalPort < 1 || ep.LocalPort > 65535 {
+					return badRequest("The port of input endpoint '%s' is out of range.", ep.Name)
+				}
+			}
+		default:
+			return badRequest("The configuration set type '%s' is not valid.", cs.ConfigurationSetType)
+		}
+		cs.UserPassword = ""
+		cs.AdminPassword = ""
+	}
+	if !provisioned {
+		return badRequest("A provisioning configuration set is required when creating a virtual machine from an image.")
+	}
+	return nil
+}
+
+// validateMediaLink checks that a disk is placed in a storage account of the subscription in the
+// hosted service's location, and that no other disk uses the same blob.
+func (s *Server) validateMediaLink(hs *hostedService, mediaLink string) *managementError {
+	u, err := url.Parse(mediaLink)
+	if err != nil || u.Host == "" {
+		return badRequest("The media link '%s' is not a valid blob URL.", mediaLink)
+	}
+	account := strings.ToLower(strings.SplitN(u.Host, ".", 2)[0])
+	location, ok := s.storageAccounts[account]
+	if !ok {
+		return badRequest("The storage account '%s' referenced by media link '%s' was not found in the subscription.", account, mediaLink)
+	}
+	if !strings.EqualFold(location, hs.location) {
+		return badRequest("The location '%s' of storage account '%s' does not match the location '%s' of the hosted service.", location, account, hs.location)
+	}
+
+	for _, other := range s.services {
+		if other == nil {
+			continue
+		}
+		for _, d := range other.deployments {
+			for _, role := range d.roles {
+				if strings.EqualFold(role.OSVirtualHardDisk.MediaLink, mediaLink) {
+					return errorf(http.StatusConflict, "ConflictError", "The blob '%s' is already in use by the disk of role '%s'.", mediaLink, role.RoleName)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func contains(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
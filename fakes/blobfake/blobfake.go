@@ -0,0 +1,513 @@
+// Package blobfake provides a local, in-memory stand-in for the Azure Blob service, so that the
+// storage samples can be exercised without a storage account.
+//
+// The server verifies SharedKey signatures the way the service does, and implements containers with
+// their public access levels, block blobs (including PutBlock and PutBlockList), page blobs (PutPage
+// with update and clear, GetPageRanges), blob properties and metadata, container and blob listing,
+// and ranged reads. Anonymous requests are accepted only where the container's access level allows.
+//
+// The storage client addresses accounts as '{account}.blob.{baseURL}', so the server takes the
+// account name from the Host header. Use helpers.GetStorageClientForEndpoint to send a client's
+// requests to it, or NewClient in tests.
+package blobfake
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-go-samples/helpers"
+	"github.com/Azure/azure-sdk-for-go/storage"
+)
+
+// The account and key of the well-known development storage account, which is what NewServer
+// creates when no account is given.
+const (
+	DefaultAccountName = "devstoreaccount1"
+	DefaultAccountKey  = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+)
+
+// PageSize is the size of a page blob page; page writes must be aligned to it.
+const PageSize = 512
+
+// MaxBlockSize is the largest block PutBlock accepts.
+const MaxBlockSize = 4 * 1024 * 1024
+
+// Request is a record of one request received by the server.
+type Request struct {
+	Method  string
+	Account string
+	Path    string
+	Query   string
+	Header  http.Header
+	Body    []byte
+}
+
+// Server is a fake Azure Blob service endpoint with in-memory state.
+type Server struct {
+	*httptest.Server
+
+	// ClockSkew is how far the x-ms-date of an authenticated request may be from the server's
+	// clock. Zero disables the check.
+	ClockSkew time.Duration
+
+	mu       sync.Mutex
+	accounts map[string]*account
+	failures []*failure
+	requests []Request
+	nextID   int
+	nextTag  int64
+}
+
+type account struct {
+	name       string
+	key        []byte
+	containers map[string]*container
+}
+
+type failure struct {
+	method  string
+	pattern *regexp.Regexp
+	status  int
+	code    string
+	message string
+	once    bool
+}
+
+// NewServer starts a fake Blob service holding one account with the passed name and base64 key,
+// or the development storage account when they are empty. Close it when done.
+func NewServer(accountName, accountKey string) (*Server, error) {
+	if accountName == "" {
+		accountName, accountKey = DefaultAccountName, DefaultAccountKey
+	}
+	s := &Server{
+		ClockSkew: 15 * time.Minute,
+		accounts:  map[string]*account{},
+	}
+	if err := s.AddAccount(accountName, accountKey); err != nil {
+		return nil, err
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s, nil
+}
+
+// NewClient starts a fake Blob service with the development storage account, and returns it with
+// a blob client sending its requests to it. Call 'done' to restore the client and close the server.
+// It stops the test if either can't be created.
+func NewClient(t testing.TB) (srv *Server, cli storage.BlobStorageClient, done func()) {
+	srv, err := NewServer("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, restore, err := helpers.GetStorageClientForEndpoint(DefaultAccountName, DefaultAccountKey, srv.URL)
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	return srv, client.GetBlobService(), func() {
+		restore()
+		srv.Close()
+	}
+}
+
+// AddAccount adds a storage account with the passed name and base64 encoded key.
+func (s *Server) AddAccount(name, key string) error {
+	k, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return fmt.Errorf("ERROR: The key of account '%s' is not valid base64 (%v)", name, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accounts[name] = &account{name: name, key: k, containers: map[string]*container{}}
+	return nil
+}
+
+// Fail makes requests with the passed method and a path matching the regular expression fail with
+// the given status and storage error code. When 'once' is set, only the first matching request
+// fails. An empty method matches all methods.
+func (s *Server) Fail(method, pathPattern string, status int, code, message string, once bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, &failure{
+		method:  strings.ToUpper(method),
+		pattern: regexp.MustCompile(pathPattern),
+		status:  status,
+		code:    code,
+		message: message,
+		once:    once,
+	})
+}
+
+// Requests returns the requests received so far, in order.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Containers returns the names of the containers of an account, in sorted order.
+func (s *Server) Containers(accountName string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	if a, ok := s.accounts[accountName]; ok {
+		for name := range a.containers {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ContainerAccess returns the public access level of a container: "", "blob" or "container".
+func (s *Server) ContainerAccess(accountName, containerName string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if a, ok := s.accounts[accountName]; ok {
+		if c, ok := a.containers[containerName]; ok {
+			return c.access, true
+		}
+	}
+	return "", false
+}
+
+// Blob returns the committed contents of a blob.
+func (s *Server) Blob(accountName, containerName, blobName string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if a, ok := s.accounts[accountName]; ok {
+		if c, ok := a.containers[containerName]; ok {
+			if b, ok := c.blobs[blobName]; ok && b.committed {
+				return append([]byte(nil), b.data...), true
+			}
+		}
+	}
+	return nil, false
+}
+
+// storageError is an error response in the format of the storage services.
+type storageError struct {
+	status  int
+	code    string
+	message string
+	detail  string
+}
+
+func errorf(status int, code, format string, args ...interface{}) *storageError {
+	return &storageError{status: status, code: code, message: fmt.Sprintf(format, args...)}
+}
+
+var (
+	errContainerNotFound = errorf(http.StatusNotFound, "ContainerNotFound", "The specified container does not exist.")
+	errBlobNotFound      = errorf(http.StatusNotFound, "BlobNotFound", "The specified blob does not exist.")
+	errResourceNotFound  = errorf(http.StatusNotFound, "ResourceNotFound", "The specified resource does not exist.")
+)
+
+// response is what a handler produces on success.
+type response struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+func newResponse(status int) *response {
+	return &response{status: status, header: http.Header{}}
+}
+
+func (resp *response) xml(v interface{}) *response {
+	b, _ := xml.Marshal(v)
+	resp.header.Set("Content-Type", "application/xml")
+	resp.body = append([]byte(xml.Header), b...)
+	return resp
+}
+
+// call is the parsed form of a request, passed to the handlers.
+type call struct {
+	r         *http.Request
+	query     url.Values
+	body      []byte
+	account   *account
+	container string
+	blob      string
+	anonymous bool
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	requestID := fmt.Sprintf("%08x-0000-0000-0000-000000000000", s.nextID)
+	w.Header().Set("x-ms-request-id", requestID)
+	if v := r.Header.Get("x-ms-version"); v != "" {
+		w.Header().Set("x-ms-version", v)
+	}
+	w.Header().Set("Date", time.Now().UTC().Format(http.TimeFormat))
+
+	name := accountName(r.Host)
+	s.requests = append(s.requests, Request{
+		Method:  r.Method,
+		Account: name,
+		Path:    r.URL.Path,
+		Query:   r.URL.RawQuery,
+		Header:  r.Header,
+		Body:    body,
+	})
+
+	resp, serr := s.handle(r, name, body)
+	if serr != nil {
+		writeError(w, r, serr)
+		return
+	}
+	for k, v := range resp.header {
+		w.Header()[k] = v
+	}
+	if resp.body != nil && w.Header().Get("Content-Length") == "" {
+		w.Header().Set("Content-Length", fmt.Sprint(len(resp.body)))
+	}
+	w.WriteHeader(resp.status)
+	if r.Method != "HEAD" {
+		w.Write(resp.body)
+	}
+}
+
+func (s *Server) handle(r *http.Request, name string, body []byte) (*response, *storageError) {
+	a, ok := s.accounts[name]
+	if !ok {
+		return nil, errorf(http.StatusBadRequest, "InvalidUri", "The requested URI does not represent any resource on the server.")
+	}
+
+	c := &call{r: r, query: r.URL.Query(), body: body, account: a}
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if i := strings.Index(path, "/"); i >= 0 {
+		c.container, c.blob = path[:i], path[i+1:]
+	} else {
+		c.container = path
+	}
+
+	if r.Header.Get("Authorization") == "" {
+		c.anonymous = true
+	} else if serr := s.authenticate(r, a); serr != nil {
+		return nil, serr
+	}
+
+	if f := s.failureFor(r); f != nil {
+		return nil, errorf(f.status, f.code, "%s", f.message)
+	}
+
+	switch {
+	case c.container == "":
+		return s.routeService(c)
+	case c.blob == "":
+		return s.routeContainer(c)
+	}
+	return s.routeBlob(c)
+}
+
+func (s *Server) failureFor(r *http.Request) *failure {
+	for i, f := range s.failures {
+		if f.method != "" && f.method != r.Method {
+			continue
+		}
+		if !f.pattern.MatchString(r.URL.Path) {
+			continue
+		}
+		if f.once {
+			s.failures = append(s.failures[:i], s.failures[i+1:]...)
+		}
+		return f
+	}
+	return nil
+}
+
+// accountName takes the account from a host name of the form '{account}.blob.{baseURL}'.
+func accountName(host string) string {
+	if i := strings.Index(host, ".blob."); i > 0 {
+		return host[:i]
+	}
+	return ""
+}
+
+type errorBody struct {
+	XMLName                   xml.Name `xml:"Error"`
+	Code                      string   `xml:"Code"`
+	Message                   string   `xml:"Message"`
+	AuthenticationErrorDetail string   `xml:"AuthenticationErrorDetail,omitempty"`
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, e *storageError) {
+	msg := e.message + "\nRequestId:" + w.Header().Get("x-ms-request-id") + "\nTime:" + time.Now().UTC().Format("2006-01-02T15:04:05.0000000Z")
+	b, _ := xml.Marshal(errorBody{Code: e.code, Message: msg, AuthenticationErrorDetail: e.detail})
+	w.Header().Set("x-ms-error-code", e.code)
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(e.status)
+	if r.Method != "HEAD" {
+		w.Write(append([]byte(xml.Header), b...))
+	}
+}
+
+// authenticate verifies the SharedKey signature of a request.
+func (s *Server) authenticate(r *http.Request, a *account) *storageError {
+	fail := func(detail string) *storageError {
+		e := errorf(http.StatusForbidden, "AuthenticationFailed", "Server failed to authenticate the request. Make sure the value of Authorization header is formed correctly including the signature.")
+		e.detail = detail
+		return e
+	}
+
+	scheme, credential := splitOnce(r.Header.Get("Authorization"), " ")
+	if scheme != "SharedKey" {
+		return fail(fmt.Sprintf("The authentication scheme '%s' is not supported.", scheme))
+	}
+	signer, signature := splitOnce(credential, ":")
+	if signer != a.name {
+		return fail(fmt.Sprintf("The account '%s' in the Authorization header does not match the account '%s' of the URI.", signer, a.name))
+	}
+
+	if r.Header.Get("x-ms-version") == "" {
+		return errorf(http.StatusBadRequest, "MissingRequiredHeader", "An HTTP header that's mandatory for this request is not specified.")
+	}
+
+	date := r.Header.Get("x-ms-date")
+	if date == "" {
+		date = r.Header.Get("Date")
+	}
+	t, err := http.ParseTime(date)
+	if err != nil {
+		return fail("Request date header not specified or not valid.")
+	}
+	if s.ClockSkew > 0 {
+		if d := time.Since(t); d > s.ClockSkew || d < -s.ClockSkew {
+			return fail(fmt.Sprintf("Request date header too old or too new: '%s'.", date))
+		}
+	}
+
+	// Service versions before 2015-02-21 sign a zero Content-Length as "0", later ones as an
+	// empty string; accept either, so that the fake works with old and new clients alike.
+	var stringToSign string
+	for _, zeroLength := range []string{"0", ""} {
+		stringToSign = StringToSign(r, a.name, zeroLength)
+		if hmac.Equal([]byte(sign(a.key, stringToSign)), []byte(signature)) {
+			return nil
+		}
+	}
+	return fail(fmt.Sprintf("The MAC signature found in the HTTP request '%s' is not the same as any computed signature. Server used following string to sign: '%s'.", signature, stringToSign))
+}
+
+func splitOnce(s, sep string) (string, string) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):]
+	}
+	return s, ""
+}
+
+func sign(key []byte, stringToSign string) string {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// StringToSign builds the string a SharedKey signature is computed over, as described in
+// "Authentication for the Azure Storage Services". A zero Content-Length is represented by
+// 'zeroLength'.
+func StringToSign(r *http.Request, accountName, zeroLength string) string {
+	length := r.Header.Get("Content-Length")
+	if length == "" && r.ContentLength > 0 {
+		length = fmt.Sprint(r.ContentLength)
+	}
+	if length == "0" || length == "" && (r.Method == "PUT" || r.Method == "POST") {
+		length = zeroLength
+	}
+
+	var b bytes.Buffer
+	b.WriteString(r.Method + "\n")
+	for _, h := range []string{"Content-Encoding", "Content-Language"} {
+		b.WriteString(r.Header.Get(h) + "\n")
+	}
+	b.WriteString(length + "\n")
+	for _, h := range []string{"Content-MD5", "Content-Type", "Date", "If-Modified-Since", "If-Match", "If-None-Match", "If-Unmodified-Since", "Range"} {
+		b.WriteString(r.Header.Get(h) + "\n")
+	}
+
+	var names []string
+	for name := range r.Header {
+		if l := strings.ToLower(name); strings.HasPrefix(l, "x-ms-") {
+			names = append(names, l)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b.WriteString(name + ":" + strings.TrimSpace(r.Header.Get(name)) + "\n")
+	}
+
+	b.WriteString("/" + accountName + r.URL.Path)
+	query := r.URL.Query()
+	var keys []string
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		values := query[k]
+		sort.Strings(values)
+		b.WriteString("\n" + strings.ToLower(k) + ":" + strings.Join(values, ","))
+	}
+	return b.String()
+}
+
+// etag returns a new entity tag, which changes each time a resource is modified.
+func (s *Server) etag() string {
+	s.nextTag++
+	return fmt.Sprintf("\"0x8D2%012X\"", s.nextTag)
+}
+
+func now() string {
+	return time.Now().UTC().Format(http.TimeFormat)
+}
+
+// metadata collects the x-ms-meta-* headers of a request.
+func metadata(h http.Header) map[string]string {
+	meta := map[string]string{}
+	for name := range h {
+		if l := strings.ToLower(name); strings.HasPrefix(l, "x-ms-meta-") {
+			meta[strings.TrimPrefix(l, "x-ms-meta-")] = h.Get(name)
+		}
+	}
+	return meta
+}
+
+func setMetadata(h http.Header, meta map[string]string) {
+	for k, v := range meta {
+		h.Set("x-ms-meta-"+k, v)
+	}
+}
+
+type metadataXML map[string]string
+
+// MarshalXML writes metadata as elements named after the keys, as the listing operations do.
+func (m metadataXML) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	e.EncodeToken(start)
+	for _, k := range keys {
+		e.EncodeElement(m[k], xml.StartElement{Name: xml.Name{Local: k}})
+	}
+	return e.EncodeToken(start.End())
+}
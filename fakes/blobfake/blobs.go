@@ -0,0 +1,681 @@
+package blobfake
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	blockBlob = "BlockBlob"
+	pageBlob  = "PageBlob"
+)
+
+type block struct {
+	id   string
+	data []byte
+}
+
+type blob struct {
+	name      string
+	typ       string
+	committed bool
+
+	data  []byte
+	pages []bool // Whether each page of a page blob holds data.
+
+	blocks      []block // The committed blocks of a block blob.
+	uncommitted []block
+
+	contentType     string
+	contentEncoding string
+	contentLanguage string
+	cacheControl    string
+	contentMD5      string
+	meta            map[string]string
+	sequenceNumber  int64
+	etag            string
+	lastModified    string
+}
+
+func (s *Server) routeBlob(c *call) (*response, *storageError) {
+	cnt, ok := c.account.containers[c.container]
+	comp := c.query.Get("comp")
+
+	if c.anonymous {
+		if !ok || cnt.access == "" || (c.r.Method != "GET" && c.r.Method != "HEAD") || (comp != "" && comp != "metadata") {
+			return nil, errResourceNotFound
+		}
+	}
+	if !ok {
+		return nil, errContainerNotFound
+	}
+
+	if c.r.Method == "PUT" {
+		switch comp {
+		case "":
+			return s.putBlob(c, cnt)
+		case "block":
+			return s.putBlock(c, cnt)
+		case "blocklist":
+			return s.putBlockList(c, cnt)
+		}
+	}
+
+	b, ok := cnt.blobs[c.blob]
+	if !ok || !b.committed {
+		return nil, errBlobNotFound
+	}
+
+	switch {
+	case c.r.Method == "GET" && comp == "":
+		return s.getBlob(c, b)
+	case c.r.Method == "HEAD" && comp == "":
+		resp := newResponse(http.StatusOK)
+		b.writeProperties(resp.header)
+		resp.header.Set("Content-Length", fmt.Sprint(len(b.data)))
+		return resp, nil
+	case c.r.Method == "DELETE" && comp == "":
+		delete(cnt.blobs, c.blob)
+		return newResponse(http.StatusAccepted), nil
+	case c.r.Method == "PUT" && comp == "properties":
+		return s.setProperties(c, b)
+	case (c.r.Method == "GET" || c.r.Method == "HEAD") && comp == "metadata":
+		resp := newResponse(http.StatusOK)
+		resp.header.Set("ETag", b.etag)
+		resp.header.Set("Last-Modified", b.lastModified)
+		setMetadata(resp.header, b.meta)
+		return resp, nil
+	case c.r.Method == "PUT" && comp == "metadata":
+		b.meta = metadata(c.r.Header)
+		return s.touch(b, http.StatusOK), nil
+	case c.r.Method == "PUT" && comp == "page":
+		return s.putPage(c, b)
+	case c.r.Method == "GET" && comp == "pagelist":
+		return s.getPageRanges(c, b)
+	case c.r.Method == "GET" && comp == "blocklist":
+		return getBlockList(c, b)
+	}
+	return nil, errorf(http.StatusBadRequest, "UnsupportedHttpVerb", "The resource doesn't support specified Http Verb.")
+}
+
+// touch gives a blob a new ETag and modification time, and returns a response reporting them.
+func (s *Server) touch(b *blob, status int) *response {
+	b.etag = s.etag()
+	b.lastModified = now()
+	resp := newResponse(status)
+	resp.header.Set("ETag", b.etag)
+	resp.header.Set("Last-Modified", b.lastModified)
+	return resp
+}
+
+func md5Of(data []byte) string {
+	sum := md5.Sum(data)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func (s *Server) putBlob(c *call, cnt *container) (*response, *storageError) {
+	if md5 := c.r.Header.Get("Content-MD5"); md5 != "" && md5 != md5Of(c.body) {
+		return nil, errorf(http.StatusBadRequest, "Md5Mismatch", "The MD5 value specified in the request did not match with the MD5 value calculated by the server.")
+	}
+
+	b := &blob{name: c.blob, typ: c.r.Header.Get("x-ms-blob-type"), committed: true}
+	switch b.typ {
+	case blockBlob:
+		b.data = c.body
+		b.contentMD5 = md5Of(c.body)
+	case pageBlob:
+		if len(c.body) != 0 {
+			return nil, errorf(http.StatusBadRequest, "InvalidHeaderValue", "The value for one of the HTTP headers is not in the correct format.")
+		}
+		size, err := strconv.ParseInt(c.r.Header.Get("x-ms-blob-content-length"), 10, 64)
+		if err != nil || size < 0 || size%PageSize != 0 {
+			return nil, errorf(http.StatusBadRequest, "InvalidHeaderValue", "The value for one of the HTTP headers is not in the correct format.")
+		}
+		b.data = make([]byte, size)
+		b.pages = make([]bool, size/PageSize)
+		if v := c.r.Header.Get("x-ms-blob-sequence-number"); v != "" {
+			if b.sequenceNumber, err = strconv.ParseInt(v, 10, 64); err != nil {
+				return nil, errorf(http.StatusBadRequest, "InvalidHeaderValue", "The value for one of the HTTP headers is not in the correct format.")
+			}
+		}
+	case "":
+		return nil, errorf(http.StatusBadRequest, "MissingRequiredHeader", "An HTTP header that's mandatory for this request is not specified.")
+	default:
+		return nil, errorf(http.StatusBadRequest, "InvalidHeaderValue", "The value for one of the HTTP headers is not in the correct format.")
+	}
+
+	b.readContentHeaders(c.r.Header, true)
+	b.meta = metadata(c.r.Header)
+	cnt.blobs[c.blob] = b
+
+	resp := s.touch(b, http.StatusCreated)
+	if b.contentMD5 != "" {
+		resp.header.Set("Content-MD5", b.contentMD5)
+	}
+	return resp, nil
+}
+
+// readContentHeaders takes the content properties of a blob from the x-ms-blob-content-*
+// headers, falling back on the standard headers when 'standard' is set, as Put Blob does.
+func (b *blob) readContentHeaders(h http.Header, standard bool) {
+	get := func(name string) string {
+		if v := h.Get("x-ms-blob-" + name); v != "" || !standard {
+			return v
+		}
+		if b.typ == pageBlob {
+			return ""
+		}
+		return h.Get(name)
+	}
+	b.contentType = get("Content-Type")
+	b.contentEncoding = get("Content-Encoding")
+	b.contentLanguage = get("Content-Language")
+	b.cacheControl = get("Cache-Control")
+	if v := h.Get("x-ms-blob-content-md5"); v != "" {
+		b.contentMD5 = v
+	} else if !standard {
+		b.contentMD5 = ""
+	}
+}
+
+func (b *blob) writeProperties(h http.Header) {
+	contentType := b.contentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	h.Set("Content-Type", contentType)
+	if b.contentEncoding != "" {
+		h.Set("Content-Encoding", b.contentEncoding)
+	}
+	if b.contentLanguage != "" {
+		h.Set("Content-Language", b.contentLanguage)
+	}
+	if b.cacheControl != "" {
+		h.Set("Cache-Control", b.cacheControl)
+	}
+	if b.contentMD5 != "" {
+		h.Set("Content-MD5", b.contentMD5)
+	}
+	h.Set("ETag", b.etag)
+	h.Set("Last-Modified", b.lastModified)
+	h.Set("x-ms-blob-type", b.typ)
+	if b.typ == pageBlob {
+		h.Set("x-ms-blob-sequence-number", fmt.Sprint(b.sequenceNumber))
+	}
+	h.Set("x-ms-lease-status", "unlocked")
+	h.Set("x-ms-lease-state", "available")
+	h.Set("Accept-Ranges", "bytes")
+	setMetadata(h, b.meta)
+}
+
+func (s *Server) setProperties(c *call, b *blob) (*response, *storageError) {
+	// Set Blob Properties replaces all content properties; the ones not passed are cleared.
+	b.readContentHeaders(c.r.Header, false)
+
+	if v := c.r.Header.Get("x-ms-blob-content-length"); v != "" {
+		size, err := strconv.ParseInt(v, 10, 64)
+		if b.typ != pageBlob || err != nil || size < 0 || size%PageSize != 0 {
+			return nil, errorf(http.StatusBadRequest, "InvalidHeaderValue", "The value for one of the HTTP headers is not in the correct format.")
+		}
+		data := make([]byte, size)
+		copy(data, b.data)
+		pages := make([]bool, size/PageSize)
+		copy(pages, b.pages)
+		b.data, b.pages = data, pages
+	}
+
+	resp := s.touch(b, http.StatusOK)
+	if b.typ == pageBlob {
+		resp.header.Set("x-ms-blob-sequence-number", fmt.Sprint(b.sequenceNumber))
+	}
+	return resp, nil
+}
+
+// byteRange parses a range header of the form 'bytes=start-end' or 'bytes=start-'. It returns
+// ok == false when there is no range header.
+func byteRange(h http.Header) (start, end int64, ok bool, serr *storageError) {
+	v := h.Get("x-ms-range")
+	if v == "" {
+		v = h.Get("Range")
+	}
+	if v == "" {
+		return 0, 0, false, nil
+	}
+
+	invalid := errorf(http.StatusBadRequest, "InvalidHeaderValue", "The value for one of the HTTP headers is not in the correct format.")
+	if !strings.HasPrefix(v, "bytes=") {
+		return 0, 0, false, invalid
+	}
+	first, last := splitOnce(strings.TrimPrefix(v, "bytes="), "-")
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false, invalid
+	}
+	end = -1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false, invalid
+		}
+	}
+	return start, end, true, nil
+}
+
+func (s *Server) getBlob(c *call, b *blob) (*response, *storageError) {
+	start, end, ranged, serr := byteRange(c.r.Header)
+	if serr != nil {
+		return nil, serr
+	}
+
+	resp := newResponse(http.StatusOK)
+	b.writeProperties(resp.header)
+	size := int64(len(b.data))
+
+	if !ranged {
+		resp.body = append([]byte(nil), b.data...)
+		resp.header.Set("Content-Length", fmt.Sprint(size))
+		return resp, nil
+	}
+
+	if start >= size {
+		return nil, errorf(http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The range specified is invalid for the current size of the resource.")
+	}
+	if end < 0 || end >= size {
+		end = size - 1
+	}
+	resp.status = http.StatusPartialContent
+	resp.body = append([]byte(nil), b.data[start:end+1]...)
+	resp.header.Set("Content-Length", fmt.Sprint(len(resp.body)))
+	resp.header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+
+	// The MD5 of the whole blob doesn't describe a range; it is only returned for ranges when
+	// explicitly asked for, and then computed over the range.
+	resp.header.Del("Content-MD5")
+	if c.r.Header.Get("x-ms-range-get-content-md5") == "true" {
+		if len(resp.body) > MaxBlockSize {
+			return nil, errorf(http.StatusBadRequest, "OutOfRangeInput", "One of the request inputs is out of range.")
+		}
+		resp.header.Set("Content-MD5", md5Of(resp.body))
+	}
+	return resp, nil
+}
+
+func (s *Server) putPage(c *call, b *blob) (*response, *storageError) {
+	if b.typ != pageBlob {
+		return nil, errorf(http.StatusConflict, "InvalidBlobType", "The blob type is invalid for this operation.")
+	}
+	start, end, ranged, serr := byteRange(c.r.Header)
+	if serr != nil {
+		return nil, serr
+	}
+	if !ranged || end < 0 {
+		return nil, errorf(http.StatusBadRequest, "MissingRequiredHeader", "An HTTP header that's mandatory for this request is not specified.")
+	}
+	if start%PageSize != 0 || (end+1)%PageSize != 0 || end >= int64(len(b.data)) {
+		return nil, errorf(http.StatusRequestedRangeNotSatisfiable, "InvalidPageRange", "The page range specified is invalid.")
+	}
+
+	switch c.r.Header.Get("x-ms-page-write") {
+	case "update":
+		if int64(len(c.body)) != end-start+1 {
+			return nil, errorf(http.StatusBadRequest, "InvalidHeaderValue", "The value for one of the HTTP headers is not in the correct format.")
+		}
+		if md5 := c.r.Header.Get("Content-MD5"); md5 != "" && md5 != md5Of(c.body) {
+			return nil, errorf(http.StatusBadRequest, "Md5Mismatch", "The MD5 value specified in the request did not match with the MD5 value calculated by the server.")
+		}
+		copy(b.data[start:end+1], c.body)
+		for p := start / PageSize; p <= end/PageSize; p++ {
+			b.pages[p] = true
+		}
+	case "clear":
+		if len(c.body) != 0 {
+			return nil, errorf(http.StatusBadRequest, "InvalidHeaderValue", "The value for one of the HTTP headers is not in the correct format.")
+		}
+		for i := start; i <= end; i++ {
+			b.data[i] = 0
+		}
+		for p := start / PageSize; p <= end/PageSize; p++ {
+			b.pages[p] = false
+		}
+	default:
+		return nil, errorf(http.StatusBadRequest, "InvalidHeaderValue", "The value for one of the HTTP headers is not in the correct format.")
+	}
+
+	resp := s.touch(b, http.StatusCreated)
+	resp.header.Set("x-ms-blob-sequence-number", fmt.Sprint(b.sequenceNumber))
+	if len(c.body) > 0 {
+		resp.header.Set("Content-MD5", md5Of(c.body))
+	}
+	return resp, nil
+}
+
+type pageRangeXML struct {
+	Start int64 `xml:"Start"`
+	End   int64 `xml:"End"`
+}
+
+type pageListXML struct {
+	XMLName    xml.Name       `xml:"PageList"`
+	PageRanges []pageRangeXML `xml:"PageRange"`
+}
+
+func (s *Server) getPageRanges(c *call, b *blob) (*response, *storageError) {
+	if b.typ != pageBlob {
+		return nil, errorf(http.StatusConflict, "InvalidBlobType", "The blob type is invalid for this operation.")
+	}
+	start, end, ranged, serr := byteRange(c.r.Header)
+	if serr != nil {
+		return nil, serr
+	}
+	if !ranged || end < 0 || end >= int64(len(b.data)) {
+		end = int64(len(b.data)) - 1
+	}
+
+	// Adjacent pages holding data are reported as a single range.
+	var list pageListXML
+	for p := start / PageSize; p <= end/PageSize && p < int64(len(b.pages)); p++ {
+		if !b.pages[p] {
+			continue
+		}
+		n := len(list.PageRanges)
+		if n > 0 && list.PageRanges[n-1].End == p*PageSize-1 {
+			list.PageRanges[n-1].End = (p+1)*PageSize - 1
+		} else {
+			list.PageRanges = append(list.PageRanges, pageRangeXML{Start: p * PageSize, End: (p+1)*PageSize - 1})
+		}
+	}
+
+	resp := newResponse(http.StatusOK).xml(list)
+	resp.header.Set("ETag", b.etag)
+	resp.header.Set("Last-Modified", b.lastModified)
+	resp.header.Set("x-ms-blob-content-length", fmt.Sprint(len(b.data)))
+	return resp, nil
+}
+
+func (s *Server) putBlock(c *call, cnt *container) (*response, *storageError) {
+	id := c.query.Get("blockid")
+	decoded, err := base64.StdEncoding.DecodeString(id)
+	if err != nil || len(decoded) == 0 || len(decoded) > 64 {
+		return nil, errorf(http.StatusBadRequest, "InvalidQueryParameterValue", "Value for one of the query parameters specified in the request URI is invalid.")
+	}
+	if len(c.body) > MaxBlockSize {
+		return nil, errorf(http.StatusRequestEntityTooLarge, "RequestBodyTooLarge", "The request body is too large and exceeds the maximum permissible limit.")
+	}
+	if md5 := c.r.Header.Get("Content-MD5"); md5 != "" && md5 != md5Of(c.body) {
+		return nil, errorf(http.StatusBadRequest, "Md5Mismatch", "The MD5 value specified in the request did not match with the MD5 value calculated by the server.")
+	}
+
+	b, ok := cnt.blobs[c.blob]
+	if !ok {
+		// Uncommitted blocks create a blob that can't be read until its block list is committed.
+		b = &blob{name: c.blob, typ: blockBlob}
+		cnt.blobs[c.blob] = b
+	}
+	if b.typ != blockBlob {
+		return nil, errorf(http.StatusConflict, "InvalidBlobType", "The blob type is invalid for this operation.")
+	}
+
+	// All blocks of a blob must have IDs of the same length.
+	for _, other := range append(b.blocks, b.uncommitted...) {
+		if len(other.id) != len(id) {
+			return nil, errorf(http.StatusBadRequest, "InvalidBlobOrBlock", "The specified blob or block content is invalid.")
+		}
+	}
+
+	// Putting a block again replaces the uncommitted block with the same ID.
+	for i, other := range b.uncommitted {
+		if other.id == id {
+			b.uncommitted = append(b.uncommitted[:i], b.uncommitted[i+1:]...)
+			break
+		}
+	}
+	b.uncommitted = append(b.uncommitted, block{id: id, data: c.body})
+
+	resp := newResponse(http.StatusCreated)
+	resp.header.Set("Content-MD5", md5Of(c.body))
+	return resp, nil
+}
+
+func (s *Server) putBlockList(c *call, cnt *container) (*response, *storageError) {
+	invalid := errorf(http.StatusBadRequest, "InvalidBlockList", "The specified block list is invalid.")
+
+	b, ok := cnt.blobs[c.blob]
+	if !ok {
+		b = &blob{name: c.blob, typ: blockBlob}
+	}
+	if b.typ != blockBlob {
+		return nil, errorf(http.StatusConflict, "InvalidBlobType", "The blob type is invalid for this operation.")
+	}
+
+	find := func(list []block, id string) (block, bool) {
+		for _, blk := range list {
+			if blk.id == id {
+				return blk, true
+			}
+		}
+		return block{}, false
+	}
+
+	// The block list is a sequence of Committed, Uncommitted and Latest elements, whose order
+	// determines the order of the blocks in the blob.
+	var blocks []block
+	d := xml.NewDecoder(bytes.NewReader(c.body))
+	depth := 0
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errorf(http.StatusBadRequest, "InvalidXmlDocument", "XML specified is not syntactically valid.")
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			if _, ok := tok.(xml.EndElement); ok {
+				depth--
+			}
+			continue
+		}
+		depth++
+		if depth == 1 {
+			if start.Name.Local != "BlockList" {
+				return nil, errorf(http.StatusBadRequest, "InvalidXmlDocument", "XML specified is not syntactically valid.")
+			}
+			continue
+		}
+
+		var id string
+		if err := d.DecodeElement(&id, &start); err != nil {
+			return nil, errorf(http.StatusBadRequest, "InvalidXmlDocument", "XML specified is not syntactically valid.")
+		}
+		depth--
+
+		var blk block
+		var found bool
+		switch start.Name.Local {
+		case "Committed":
+			blk, found = find(b.blocks, id)
+		case "Uncommitted":
+			blk, found = find(b.uncommitted, id)
+		case "Latest":
+			if blk, found = find(b.uncommitted, id); !found {
+				blk, found = find(b.blocks, id)
+			}
+		default:
+			return nil, invalid
+		}
+		if !found {
+			return nil, invalid
+		}
+		blocks = append(blocks, blk)
+	}
+
+	var data []byte
+	for _, blk := range blocks {
+		data = append(data, blk.data...)
+	}
+	b.data = data
+	b.blocks = blocks
+	b.uncommitted = nil
+	b.committed = true
+	b.readContentHeaders(c.r.Header, false)
+	b.meta = metadata(c.r.Header)
+	cnt.blobs[c.blob] = b
+
+	return s.touch(b, http.StatusCreated), nil
+}
+
+type blockXML struct {
+	Name string `xml:"Name"`
+	Size int    `xml:"Size"`
+}
+
+type blockListXML struct {
+	XMLName           xml.Name   `xml:"BlockList"`
+	CommittedBlocks   []blockXML `xml:"CommittedBlocks>Block"`
+	UncommittedBlocks []blockXML `xml:"UncommittedBlocks>Block"`
+}
+
+func getBlockList(c *call, b *blob) (*response, *storageError) {
+	if b.typ != blockBlob {
+		return nil, errorf(http.StatusConflict, "InvalidBlobType", "The blob type is invalid for this operation.")
+	}
+	listType := c.query.Get("blocklisttype")
+	if listType == "" {
+		listType = "committed"
+	}
+
+	var list blockListXML
+	if listType == "committed" || listType == "all" {
+		for _, blk := range b.blocks {
+			list.CommittedBlocks = append(list.CommittedBlocks, blockXML{Name: blk.id, Size: len(blk.data)})
+		}
+	}
+	if listType == "uncommitted" || listType == "all" {
+		for _, blk := range b.uncommitted {
+			list.UncommittedBlocks = append(list.UncommittedBlocks, blockXML{Name: blk.id, Size: len(blk.data)})
+		}
+	}
+
+	resp := newResponse(http.StatusOK).xml(list)
+	resp.header.Set("ETag", b.etag)
+	resp.header.Set("Last-Modified", b.lastModified)
+	resp.header.Set("x-ms-blob-content-length", fmt.Sprint(len(b.data)))
+	return resp, nil
+}
+
+type propertiesXML struct {
+	LastModified    string `xml:"Last-Modified"`
+	Etag            string `xml:"Etag"`
+	ContentLength   string `xml:"Content-Length,omitempty"`
+	ContentType     string `xml:"Content-Type,omitempty"`
+	ContentEncoding string `xml:"Content-Encoding,omitempty"`
+	ContentLanguage string `xml:"Content-Language,omitempty"`
+	ContentMD5      string `xml:"Content-MD5,omitempty"`
+	CacheControl    string `xml:"Cache-Control,omitempty"`
+	SequenceNumber  string `xml:"x-ms-blob-sequence-number,omitempty"`
+	BlobType        string `xml:"BlobType,omitempty"`
+	LeaseStatus     string `xml:"LeaseStatus"`
+	LeaseState      string `xml:"LeaseState"`
+}
+
+type blobXML struct {
+	Name       string        `xml:"Name"`
+	Properties propertiesXML `xml:"Properties"`
+	Metadata   metadataXML   `xml:"Metadata,omitempty"`
+}
+
+type blobPrefixXML struct {
+	Name string `xml:"Name"`
+}
+
+type blobListXML struct {
+	XMLName         xml.Name        `xml:"EnumerationResults"`
+	ServiceEndpoint string          `xml:"ServiceEndpoint,attr"`
+	ContainerName   string          `xml:"ContainerName,attr"`
+	Prefix          string          `xml:"Prefix"`
+	Marker          string          `xml:"Marker"`
+	MaxResults      string          `xml:"MaxResults,omitempty"`
+	Delimiter       string          `xml:"Delimiter,omitempty"`
+	Blobs           []blobXML       `xml:"Blobs>Blob"`
+	BlobPrefixes    []blobPrefixXML `xml:"Blobs>BlobPrefix"`
+	NextMarker      string          `xml:"NextMarker"`
+}
+
+func (s *Server) listBlobs(c *call, cnt *container) (*response, *storageError) {
+	prefix := c.query.Get("prefix")
+	delimiter := c.query.Get("delimiter")
+
+	// With a delimiter, blobs whose names continue past it are rolled up into a single prefix
+	// entry, which takes part in paging like a blob does.
+	seen := map[string]bool{}
+	var names []string
+	for name, b := range cnt.blobs {
+		if !b.committed {
+			continue
+		}
+		if delimiter != "" && strings.HasPrefix(name, prefix) {
+			if i := strings.Index(name[len(prefix):], delimiter); i >= 0 {
+				name = name[:len(prefix)+i+len(delimiter)]
+			}
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	selected, next, serr := page(c, names)
+	if serr != nil {
+		return nil, serr
+	}
+
+	result := blobListXML{
+		ServiceEndpoint: "http://" + c.r.Host + "/",
+		ContainerName:   cnt.name,
+		Prefix:          prefix,
+		Marker:          c.query.Get("marker"),
+		MaxResults:      c.query.Get("maxresults"),
+		Delimiter:       delimiter,
+		NextMarker:      next,
+	}
+	includeMetadata := strings.Contains(c.query.Get("include"), "metadata")
+	for _, name := range selected {
+		b, ok := cnt.blobs[name]
+		if !ok || !b.committed {
+			result.BlobPrefixes = append(result.BlobPrefixes, blobPrefixXML{Name: name})
+			continue
+		}
+		entry := blobXML{
+			Name: name,
+			Properties: propertiesXML{
+				LastModified:    b.lastModified,
+				Etag:            b.etag,
+				ContentLength:   fmt.Sprint(len(b.data)),
+				ContentType:     b.contentType,
+				ContentEncoding: b.contentEncoding,
+				ContentLanguage: b.contentLanguage,
+				ContentMD5:      b.contentMD5,
+				CacheControl:    b.cacheControl,
+				BlobType:        b.typ,
+				LeaseStatus:     "unlocked",
+				LeaseState:      "available",
+			},
+		}
+		if b.typ == pageBlob {
+			entry.Properties.SequenceNumber = fmt.Sprint(b.sequenceNumber)
+		}
+		if includeMetadata {
+			entry.Metadata = b.meta
+		}
+		result.Blobs = append(result.Blobs, entry)
+	}
+	return newResponse(http.StatusOK).xml(result), nil
+}
@@ -0,0 +1,214 @@
+package blobfake
+
+import (
+	"encoding/xml"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type container struct {
+	name         string
+	access       string
+	meta         map[string]string
+	etag         string
+	lastModified string
+	blobs        map[string]*blob
+}
+
+var containerNamePattern = regexp.MustCompile(`^[a-z0-9](?:[a-z0-9]|-[a-z0-9]){2,62}$`)
+
+func (s *Server) routeService(c *call) (*response, *storageError) {
+	if c.anonymous {
+		return nil, errResourceNotFound
+	}
+	if c.r.Method == "GET" && c.query.Get("comp") == "list" {
+		return s.listContainers(c)
+	}
+	return nil, errorf(http.StatusBadRequest, "InvalidQueryParameterValue", "Value for one of the query parameters specified in the request URI is invalid.")
+}
+
+func (s *Server) routeContainer(c *call) (*response, *storageError) {
+	if c.query.Get("restype") != "container" {
+		// Without restype, a single path segment is a blob in the root container, which the
+		// fake does not support.
+		return nil, errorf(http.StatusBadRequest, "InvalidUri", "The requested URI does not represent any resource on the server.")
+	}
+
+	cnt, exists := c.account.containers[c.container]
+	comp := c.query.Get("comp")
+
+	if c.anonymous {
+		if !exists || cnt.access != "container" || c.r.Method != "GET" || comp != "list" {
+			return nil, errResourceNotFound
+		}
+	}
+
+	if c.r.Method == "PUT" && comp == "" {
+		return s.createContainer(c, exists)
+	}
+	if !exists {
+		return nil, errContainerNotFound
+	}
+
+	switch {
+	case c.r.Method == "DELETE" && comp == "":
+		delete(c.account.containers, c.container)
+		return newResponse(http.StatusAccepted), nil
+	case (c.r.Method == "GET" || c.r.Method == "HEAD") && comp == "":
+		resp := newResponse(http.StatusOK)
+		resp.header.Set("ETag", cnt.etag)
+		resp.header.Set("Last-Modified", cnt.lastModified)
+		setMetadata(resp.header, cnt.meta)
+		return resp, nil
+	case c.r.Method == "GET" && comp == "list":
+		return s.listBlobs(c, cnt)
+	case (c.r.Method == "GET" || c.r.Method == "HEAD") && comp == "metadata":
+		resp := newResponse(http.StatusOK)
+		resp.header.Set("ETag", cnt.etag)
+		setMetadata(resp.header, cnt.meta)
+		return resp, nil
+	case c.r.Method == "PUT" && comp == "metadata":
+		cnt.meta = metadata(c.r.Header)
+		return s.touchContainer(cnt, http.StatusOK), nil
+	case c.r.Method == "GET" && comp == "acl":
+		resp := newResponse(http.StatusOK)
+		if cnt.access != "" {
+			resp.header.Set("x-ms-blob-public-access", cnt.access)
+		}
+		resp.header.Set("ETag", cnt.etag)
+		resp.header.Set("Content-Type", "application/xml")
+		resp.body = []byte(xml.Header + "<SignedIdentifiers />")
+		return resp, nil
+	case c.r.Method == "PUT" && comp == "acl":
+		access, serr := publicAccess(c.r)
+		if serr != nil {
+			return nil, serr
+		}
+		cnt.access = access
+		return s.touchContainer(cnt, http.StatusOK), nil
+	}
+	return nil, errorf(http.StatusBadRequest, "UnsupportedHttpVerb", "The resource doesn't support specified Http Verb.")
+}
+
+func publicAccess(r *http.Request) (string, *storageError) {
+	access := r.Header.Get("x-ms-blob-public-access")
+	switch access {
+	case "", "blob", "container":
+		return access, nil
+	}
+	return "", errorf(http.StatusBadRequest, "InvalidHeaderValue", "The value for one of the HTTP headers is not in the correct format.")
+}
+
+func (s *Server) createContainer(c *call, exists bool) (*response, *storageError) {
+	if !containerNamePattern.MatchString(c.container) {
+		return nil, errorf(http.StatusBadRequest, "InvalidResourceName", "The specifed resource name contains invalid characters.")
+	}
+	if exists {
+		return nil, errorf(http.StatusConflict, "ContainerAlreadyExists", "The specified container already exists.")
+	}
+	access, serr := publicAccess(c.r)
+	if serr != nil {
+		return nil, serr
+	}
+	cnt := &container{
+		name:   c.container,
+		access: access,
+		meta:   metadata(c.r.Header),
+		blobs:  map[string]*blob{},
+	}
+	c.account.containers[c.container] = cnt
+	return s.touchContainer(cnt, http.StatusCreated), nil
+}
+
+func (s *Server) touchContainer(cnt *container, status int) *response {
+	cnt.etag = s.etag()
+	cnt.lastModified = now()
+	resp := newResponse(status)
+	resp.header.Set("ETag", cnt.etag)
+	resp.header.Set("Last-Modified", cnt.lastModified)
+	return resp
+}
+
+// page applies the prefix, marker and maxresults parameters of a listing to a sorted list of
+// names, returning the names to include and the marker of the next page.
+func page(c *call, names []string) ([]string, string, *storageError) {
+	prefix := c.query.Get("prefix")
+	marker := c.query.Get("marker")
+	max := 5000
+	if v := c.query.Get("maxresults"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, "", errorf(http.StatusBadRequest, "OutOfRangeQueryParameterValue", "One of the query parameters specified in the request URI is outside the permissible range.")
+		}
+		max = n
+	}
+
+	var selected []string
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) || name < marker {
+			continue
+		}
+		if len(selected) == max {
+			return selected, name, nil
+		}
+		selected = append(selected, name)
+	}
+	return selected, "", nil
+}
+
+type containerXML struct {
+	Name       string        `xml:"Name"`
+	Properties propertiesXML `xml:"Properties"`
+	Metadata   metadataXML   `xml:"Metadata,omitempty"`
+}
+
+type containerListXML struct {
+	XMLName         xml.Name       `xml:"EnumerationResults"`
+	ServiceEndpoint string         `xml:"ServiceEndpoint,attr"`
+	Prefix          string         `xml:"Prefix"`
+	Marker          string         `xml:"Marker"`
+	MaxResults      string         `xml:"MaxResults,omitempty"`
+	Containers      []containerXML `xml:"Containers>Container"`
+	NextMarker      string         `xml:"NextMarker"`
+}
+
+func (s *Server) listContainers(c *call) (*response, *storageError) {
+	var names []string
+	for name := range c.account.containers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	selected, next, serr := page(c, names)
+	if serr != nil {
+		return nil, serr
+	}
+
+	result := containerListXML{
+		ServiceEndpoint: "http://" + c.r.Host + "/",
+		Prefix:          c.query.Get("prefix"),
+		Marker:          c.query.Get("marker"),
+		MaxResults:      c.query.Get("maxresults"),
+		NextMarker:      next,
+	}
+	includeMetadata := strings.Contains(c.query.Get("include"), "metadata")
+	for _, name := range selected {
+		cnt := c.account.containers[name]
+		entry := containerXML{
+			Name: name,
+			Properties: propertiesXML{
+				LastModified: cnt.lastModified,
+				Etag:         cnt.etag,
+				LeaseStatus:  "unlocked",
+				LeaseState:   "available",
+			},
+		}
+		if includeMetadata {
+			entry.Metadata = cnt.meta
+		}
+		result.Containers = append(result.Containers, entry)
+	}
+	return newResponse(http.StatusOK).xml(result), nil
+}
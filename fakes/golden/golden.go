@@ -0,0 +1,94 @@
+// Package golden compares what a test produces, such as the JSON bodies of the requests a sample
+// sends, against files checked in under the testdata directory of the package being tested.
+//
+// When the output changes on purpose, the files are regenerated by running the package's tests
+// with the -update flag:
+//
+//	go test ./arm/resources/create-vm -update
+//
+// and the changes to testdata reviewed like any other change.
+package golden
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata with the actual output")
+
+// Path returns the path of the golden file with the passed name.
+func Path(name string) string {
+	return filepath.Join("testdata", name+".golden")
+}
+
+// Check compares the actual output with the golden file of the passed name, or writes the output
+// to the file when the tests run with -update.
+func Check(t *testing.T, name string, actual []byte) {
+	t.Helper()
+	path := Path(name)
+
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("unable to create %s: %v", filepath.Dir(path), err)
+		}
+		if err := ioutil.WriteFile(path, actual, 0644); err != nil {
+			t.Fatalf("unable to write %s: %v", path, err)
+		}
+		return
+	}
+
+	expected, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Errorf("unable to read %s: %v; run the tests with -update to create it", path, err)
+		return
+	}
+	if !bytes.Equal(actual, expected) {
+		t.Errorf("%s doesn't match the golden file %s\n%s\nrun the tests with -update if the change is intended", name, path, diff(string(expected), string(actual)))
+	}
+}
+
+// CheckJSON compares a value, serialized as indented JSON, with the golden file of the passed name.
+// Maps are serialized with their keys sorted, so a request body that was decoded into a map gives
+// the same file no matter in which order the SDK wrote its fields.
+func CheckJSON(t *testing.T, name string, v interface{}) {
+	t.Helper()
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		t.Fatalf("unable to serialize %s: %v", name, err)
+	}
+	Check(t, name, append(b, '\n'))
+}
+
+// diff describes the first line in which two texts differ, with a couple of lines of context.
+func diff(expected, actual string) string {
+	exp := strings.Split(expected, "\n")
+	act := strings.Split(actual, "\n")
+
+	i := 0
+	for i < len(exp) && i < len(act) && exp[i] == act[i] {
+		i++
+	}
+
+	var b bytes.Buffer
+	from := i - 2
+	if from < 0 {
+		from = 0
+	}
+	for j := from; j < i; j++ {
+		fmt.Fprintf(&b, "  %4d   %s\n", j+1, exp[j])
+	}
+	if i < len(exp) {
+		fmt.Fprintf(&b, "- %4d   %s\n", i+1, exp[i])
+	}
+	if i < len(act) {
+		fmt.Fprintf(&b, "+ %4d   %s\n", i+1, act[i])
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
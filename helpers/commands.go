@@ -0,0 +1,121 @@
+package helpers
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/arm"
+)
+
+// Command is one of the operations of a sample that has several, such as 'create' or 'list'. Its
+// Setup function registers the command's flags and returns the function that runs it once they are
+// parsed.
+type Command struct {
+	Summary string
+	Setup   func(fs *flag.FlagSet) func(client arm.Client) (interface{}, error)
+}
+
+// RunCommands runs the command named by the first argument of the program with the flags that
+// follow it, and prints its result with Output, whose --output and --query flags every command
+// gets. It prints the usage when the command is missing or unknown, and exits with a non-zero
+// status on failure. See CommandLine for running commands from tests.
+func RunCommands(commands map[string]Command) {
+	if status := (CommandLine{Args: os.Args}).Run(commands); status != 0 {
+		os.Exit(status)
+	}
+}
+
+// CommandLine is what RunCommands takes from the program's environment, so that tests can run
+// commands with other arguments, against a fake, and read what they print.
+type CommandLine struct {
+	// Args are the name of the program, the command and its flags, as in os.Args.
+	Args []string
+
+	// Authenticate returns the client the command runs with. When nil, AuthenticateForARM is used.
+	Authenticate func() (arm.Client, error)
+
+	// Stdout receives the result, and Stderr the usage and errors. When nil, standard output and
+	// standard error are used.
+	Stdout, Stderr io.Writer
+}
+
+// Run runs the command named by Args[1] with the flags that follow it, the way RunCommands does,
+// and returns the exit status: 0 on success, 1 when the command fails and 2 when it is missing or
+// unknown, or its flags are invalid. The client is given the logging inspectors of WithInspection
+// and ByInspecting, after those it may already have.
+func (c CommandLine) Run(commands map[string]Command) int {
+	stderr := c.Stderr
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	program := "sample"
+	if len(c.Args) > 0 {
+		program = c.Args[0]
+	}
+
+	if len(c.Args) < 2 {
+		commandUsage(stderr, program, commands)
+		return 2
+	}
+	cmd, ok := commands[c.Args[1]]
+	if !ok {
+		fmt.Fprintf(stderr, "Unknown command '%s'\n\n", c.Args[1])
+		commandUsage(stderr, program, commands)
+		return 2
+	}
+
+	fs := flag.NewFlagSet(program+" "+c.Args[1], flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	output := OutputFlags(fs)
+	output.Writer = c.Stdout
+	run := cmd.Setup(fs)
+	if err := fs.Parse(c.Args[2:]); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+
+	authenticate := c.Authenticate
+	if authenticate == nil {
+		authenticate = AuthenticateForARM
+	}
+	client, err := authenticate()
+	if err != nil {
+		fmt.Fprintf(stderr, "Failed to authenticate: '%s'\n", err.Error())
+		return 1
+	}
+
+	client.RequestInspector = ChainPreparers(client.RequestInspector, WithInspection())
+	client.ResponseInspector = ChainResponders(client.ResponseInspector, ByInspecting())
+
+	result, err := run(client)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s\n", strings.TrimRight(err.Error(), "\n"))
+		return 1
+	}
+
+	if err := output.Print(result); err != nil {
+		fmt.Fprintf(stderr, "%s\n", err.Error())
+		return 1
+	}
+	return 0
+}
+
+func commandUsage(w io.Writer, program string, commands map[string]Command) {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(w, "Usage: %s <command> [flags]\n\nCommands:\n", program)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-10s %s\n", name, commands[name].Summary)
+	}
+	fmt.Fprintf(w, "\nRun '%s <command> -h' for the flags of a command.\n", program)
+}
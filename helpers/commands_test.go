@@ -0,0 +1,77 @@
+package helpers_test
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-go-samples/fakes/armfake"
+	"github.com/Azure/azure-go-samples/helpers"
+	"github.com/Azure/azure-sdk-for-go/Godeps/_workspace/src/github.com/Azure/go-autorest/autorest/to"
+	"github.com/Azure/azure-sdk-for-go/arm"
+	"github.com/Azure/azure-sdk-for-go/arm/resources/resources"
+)
+
+func TestCommandLine(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+
+	commands := map[string]helpers.Command{
+		"create": {Summary: "create a resource group", Setup: func(fs *flag.FlagSet) func(arm.Client) (interface{}, error) {
+			name := fs.String("name", "", "name of the resource group")
+			return func(client arm.Client) (interface{}, error) {
+				if *name == "" {
+					return nil, errors.New("ERROR: --name is missing\n")
+				}
+				return client.ResourceGroups().CreateOrUpdate(*name, resources.ResourceGroup{Location: to.StringPtr("West US")})
+			}
+		}},
+		"show": {Summary: "show a resource group", Setup: func(fs *flag.FlagSet) func(arm.Client) (interface{}, error) {
+			return func(arm.Client) (interface{}, error) { return nil, nil }
+		}},
+	}
+	authenticate := func() (arm.Client, error) {
+		return helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL), nil
+	}
+	usage := "Usage: groups <command> [flags]\n\nCommands:\n" +
+		"  create     create a resource group\n" +
+		"  show       show a resource group\n\n" +
+		"Run 'groups <command> -h' for the flags of a command.\n"
+
+	for _, c := range []struct {
+		args         []string
+		authenticate func() (arm.Client, error)
+		status       int
+		stdout       string
+		stderr       string
+	}{
+		{[]string{"groups", "create", "--name", "cmd", "--output", "tsv", "--query", "name"}, authenticate, 0, "cmd\n", ""},
+		{[]string{"groups"}, authenticate, 2, "", usage},
+		{[]string{"groups", "remove"}, authenticate, 2, "", "Unknown command 'remove'\n\n" + usage},
+		{[]string{"groups", "create", "--top", "1"}, authenticate, 2, "", "flag provided but not defined: -top"},
+		{[]string{"groups", "create"}, authenticate, 1, "", "ERROR: --name is missing\n"},
+		{[]string{"groups", "create", "--name", "cmd", "--output", "xml"}, authenticate, 1, "", "ERROR: Unknown output format 'xml'"},
+		{[]string{"groups", "show"}, func() (arm.Client, error) { return arm.Client{}, errors.New("no credentials") }, 1, "", "Failed to authenticate: 'no credentials'\n"},
+	} {
+		var stdout, stderr bytes.Buffer
+		cl := helpers.CommandLine{Args: c.args, Authenticate: c.authenticate, Stdout: &stdout, Stderr: &stderr}
+		if status := cl.Run(commands); status != c.status {
+			t.Errorf("%v: expected the status %d, got %d (%s)", c.args, c.status, status, stderr.String())
+		}
+		if stdout.String() != c.stdout {
+			t.Errorf("%v: expected the output '%s', got '%s'", c.args, c.stdout, stdout.String())
+		}
+		if c.stderr == usage || strings.HasSuffix(c.stderr, usage) {
+			if stderr.String() != c.stderr {
+				t.Errorf("%v: expected the errors '%s', got '%s'", c.args, c.stderr, stderr.String())
+			}
+		} else if !strings.Contains(stderr.String(), c.stderr) {
+			t.Errorf("%v: expected the errors to hold '%s', got '%s'", c.args, c.stderr, stderr.String())
+		}
+	}
+	if _, ok := srv.Resource("/subscriptions/" + srv.SubscriptionID + "/resourceGroups/cmd"); !ok {
+		t.Errorf("the resource group was not created")
+	}
+}
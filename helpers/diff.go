@@ -0,0 +1,206 @@
+package helpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Change is a field whose current value, as ARM returns it, differs from the value a sample
+// wants it to have. A nil Current means the field, or the element of a list, is missing; a nil
+// Desired means it is there but shouldn't be.
+type Change struct {
+	Field   string      `json:"field"`
+	Current interface{} `json:"current"`
+	Desired interface{} `json:"desired"`
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s => %s", c.Field, changeValue(c.Current), changeValue(c.Desired))
+}
+
+func changeValue(v interface{}) string {
+	if v == nil {
+		return "(none)"
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// Diff compares the state a sample wants a resource in with its current state, both given as
+// the SDK structures or anything else that marshals to JSON, and returns the fields that differ.
+// Only the fields set in desired are compared, since ARM fills in many read-only ones, and:
+//
+//	strings are compared ignoring case, and locations in any of their forms ("West US", "westus")
+//	objects with an "id", such as references to other resources, are compared by their ID only
+//	lists of objects with a "name", such as subnets, are matched by name, in any order
+//	lists of objects with an "id", such as network interface references, are compared by ID
+//
+// Fields that ARM never returns, such as passwords, are listed in ignore by their path, as in
+// "properties.osProfile.adminPassword".
+func Diff(current, desired interface{}, ignore ...string) []Change {
+	d := &differ{ignore: map[string]bool{}}
+	for _, path := range ignore {
+		d.ignore[path] = true
+	}
+	d.diff("", generic(current), generic(desired))
+	return d.changes
+}
+
+type differ struct {
+	ignore  map[string]bool
+	changes []Change
+}
+
+// generic converts a value to the maps and slices encoding/json decodes into.
+func generic(v interface{}) interface{} {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var g interface{}
+	if err := json.Unmarshal(b, &g); err != nil {
+		return nil
+	}
+	return g
+}
+
+func (d *differ) report(path string, current, desired interface{}) {
+	d.changes = append(d.changes, Change{Field: path, Current: current, Desired: desired})
+}
+
+func (d *differ) diff(path string, current, desired interface{}) {
+	if desired == nil || d.ignore[path] {
+		return
+	}
+
+	switch desired := desired.(type) {
+	case map[string]interface{}:
+		cm, _ := current.(map[string]interface{})
+		if id, ok := desired["id"].(string); ok {
+			if cid, _ := cm["id"].(string); !strings.EqualFold(cid, id) {
+				d.report(fieldPath(path, "id"), cm["id"], id)
+			}
+			return
+		}
+		keys := make([]string, 0, len(desired))
+		for k := range desired {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			d.diff(fieldPath(path, k), cm[k], desired[k])
+		}
+
+	case []interface{}:
+		cl, _ := current.([]interface{})
+		if namedElements(desired) && namedElements(cl) {
+			d.diffNamed(path, cl, desired)
+		} else if ids := elementIDs(desired); ids != nil {
+			if !reflect.DeepEqual(elementIDs(cl), ids) {
+				d.report(path, current, desired)
+			}
+		} else if !reflect.DeepEqual(normalize(current), normalize(desired)) {
+			d.report(path, current, desired)
+		}
+
+	case string:
+		cs, ok := current.(string)
+		equal := ok && strings.EqualFold(cs, desired)
+		if path == "location" || strings.HasSuffix(path, ".location") {
+			equal = ok && NormalizeLocation(cs) == NormalizeLocation(desired)
+		}
+		if !equal {
+			d.report(path, current, desired)
+		}
+
+	default:
+		if !reflect.DeepEqual(current, desired) {
+			d.report(path, current, desired)
+		}
+	}
+}
+
+// diffNamed compares lists of objects by their names: the elements missing from either list are
+// reported as a whole, the others field by field.
+func (d *differ) diffNamed(path string, current, desired []interface{}) {
+	byName := map[string]interface{}{}
+	for _, c := range current {
+		byName[strings.ToLower(c.(map[string]interface{})["name"].(string))] = c
+	}
+	seen := map[string]bool{}
+	for _, e := range desired {
+		name := e.(map[string]interface{})["name"].(string)
+		seen[strings.ToLower(name)] = true
+		elementPath := fmt.Sprintf("%s[%s]", path, name)
+		if c, ok := byName[strings.ToLower(name)]; ok {
+			d.diff(elementPath, c, e)
+		} else {
+			d.report(elementPath, nil, e)
+		}
+	}
+	for _, c := range current {
+		name := c.(map[string]interface{})["name"].(string)
+		if !seen[strings.ToLower(name)] {
+			d.report(fmt.Sprintf("%s[%s]", path, name), c, nil)
+		}
+	}
+}
+
+func namedElements(list []interface{}) bool {
+	for _, e := range list {
+		m, ok := e.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		if _, ok := m["name"].(string); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// elementIDs returns the IDs of the objects in a list, in lower case, or nil if some element has
+// no ID.
+func elementIDs(list []interface{}) []string {
+	ids := []string{}
+	for _, e := range list {
+		m, _ := e.(map[string]interface{})
+		id, ok := m["id"].(string)
+		if !ok {
+			return nil
+		}
+		ids = append(ids, strings.ToLower(id))
+	}
+	return ids
+}
+
+// normalize folds the case of the strings in a value, for comparing lists of scalars.
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return strings.ToLower(v)
+	case []interface{}:
+		n := make([]interface{}, len(v))
+		for i, e := range v {
+			n[i] = normalize(e)
+		}
+		return n
+	case map[string]interface{}:
+		n := map[string]interface{}{}
+		for k, e := range v {
+			n[k] = normalize(e)
+		}
+		return n
+	}
+	return v
+}
+
+func fieldPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
@@ -0,0 +1,63 @@
+package helpers_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-go-samples/helpers"
+)
+
+func TestDiff(t *testing.T) {
+	current := map[string]interface{}{
+		"id":       "/subscriptions/s/resourceGroups/g/providers/Microsoft.Network/virtualNetworks/vnet",
+		"location": "westus",
+		"properties": map[string]interface{}{
+			"provisioningState": "Succeeded",
+			"addressSpace":      map[string]interface{}{"addressPrefixes": []interface{}{"10.0.0.0/16"}},
+			"subnets": []interface{}{
+				map[string]interface{}{"name": "back", "properties": map[string]interface{}{"addressPrefix": "10.0.1.0/24"}},
+				map[string]interface{}{"name": "front", "properties": map[string]interface{}{"addressPrefix": "10.0.0.0/24"}},
+				map[string]interface{}{"name": "old", "properties": map[string]interface{}{"addressPrefix": "10.0.9.0/24"}},
+			},
+			"routeTable": map[string]interface{}{"id": "/subscriptions/s/resourceGroups/G/providers/Microsoft.Network/routeTables/RT"},
+			"nics":       []interface{}{map[string]interface{}{"id": "/a/NIC1", "properties": map[string]interface{}{"primary": true}}},
+			"enabled":    true,
+		},
+	}
+	desired := map[string]interface{}{
+		"location": "West US",
+		"properties": map[string]interface{}{
+			"addressSpace": map[string]interface{}{"addressPrefixes": []interface{}{"10.0.0.0/16", "10.1.0.0/16"}},
+			"subnets": []interface{}{
+				map[string]interface{}{"name": "FRONT", "properties": map[string]interface{}{"addressPrefix": "10.0.0.0/24"}},
+				map[string]interface{}{"name": "back", "properties": map[string]interface{}{"addressPrefix": "10.0.2.0/24"}},
+				map[string]interface{}{"name": "new", "properties": map[string]interface{}{"addressPrefix": "10.0.3.0/24"}},
+			},
+			"routeTable": map[string]interface{}{"id": "/subscriptions/s/resourceGroups/g/providers/Microsoft.Network/routeTables/rt", "name": "ignored"},
+			"nics":       []interface{}{map[string]interface{}{"id": "/a/nic1"}},
+			"enabled":    true,
+			"secret":     "never returned",
+		},
+	}
+
+	var changes []string
+	for _, c := range helpers.Diff(current, desired, "properties.secret") {
+		changes = append(changes, c.String())
+	}
+	expected := []string{
+		`properties.addressSpace.addressPrefixes: ["10.0.0.0/16"] => ["10.0.0.0/16","10.1.0.0/16"]`,
+		`properties.subnets[back].properties.addressPrefix: "10.0.1.0/24" => "10.0.2.0/24"`,
+		`properties.subnets[new]: (none) => {"name":"new","properties":{"addressPrefix":"10.0.3.0/24"}}`,
+		`properties.subnets[old]: {"name":"old","properties":{"addressPrefix":"10.0.9.0/24"}} => (none)`,
+	}
+	if strings.Join(changes, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected changes:\n%s\nexpected:\n%s", strings.Join(changes, "\n"), strings.Join(expected, "\n"))
+	}
+
+	if changes := helpers.Diff(current, map[string]interface{}{"location": "East US"}); len(changes) != 1 || changes[0].Field != "location" {
+		t.Errorf("expected the location to differ, got %v", changes)
+	}
+	if changes := helpers.Diff(nil, map[string]interface{}{"properties": map[string]interface{}{"enabled": false}}); len(changes) != 1 || changes[0].Current != nil {
+		t.Errorf("expected the missing field to be reported, got %v", changes)
+	}
+}
@@ -0,0 +1,282 @@
+package helpers
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/Godeps/_workspace/src/github.com/Azure/go-autorest/autorest"
+)
+
+// FaultInjector describes faults to introduce into the HTTP traffic of a client, so that code
+// built on top of the SDK can be tested against throttling, timeouts, server errors and dropped
+// connections without waiting for Azure to produce them.
+//
+// The faults are injected where the request is sent, so that they reach the client the way real
+// ones do, through the Sender, and its retries, if any, get to handle them. Attach it to the
+// clients using an endpoint, such as the URL of a fake, with InjectFaults, or to an http.Client
+// with Transport. Clients that can't be given either, such as an arm.Client whose requests go to
+// Azure, can use WithFaults and ByFaults as their inspectors instead. A zero FaultInjector does
+// nothing.
+type FaultInjector struct {
+	// Probability is the chance, between 0 and 1, that a matching request is faulted. Each fault
+	// below is rolled for separately.
+	Probability float64
+
+	// URLPatterns limits fault injection to requests whose URL matches one of the regular
+	// expressions. When empty, every request matches.
+	URLPatterns []*regexp.Regexp
+
+	// StatusCodes are the status codes that replace the status of a faulted response. One is
+	// picked at random for each fault. A Retry-After header is added for 429 and 503.
+	StatusCodes []int
+
+	// Latency is added to every matching request before it is sent, regardless of Probability.
+	Latency time.Duration
+
+	// ResetConnections makes faulted requests fail with a connection reset before they are sent.
+	ResetConnections bool
+
+	// Timeouts makes faulted requests fail with a network timeout error before they are sent.
+	Timeouts bool
+
+	// Seed initializes the random source, which makes a test run repeatable. When zero, the
+	// current time is used.
+	Seed int64
+
+	mu       sync.Mutex
+	rnd      *rand.Rand
+	injected int
+}
+
+// NewFaultInjector returns a FaultInjector that faults requests matching any of the passed
+// URL patterns with the given probability, replacing their status with one of the given codes.
+func NewFaultInjector(probability float64, statusCodes []int, urlPatterns ...string) (*FaultInjector, error) {
+	fi := &FaultInjector{Probability: probability, StatusCodes: statusCodes}
+	for _, p := range urlPatterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("ERROR: Invalid URL pattern '%s' (%v)", p, err)
+		}
+		fi.URLPatterns = append(fi.URLPatterns, re)
+	}
+	return fi, nil
+}
+
+// Injected returns the number of faults injected so far.
+func (fi *FaultInjector) Injected() int {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	return fi.injected
+}
+
+// InjectFaults injects faults into the requests that ARM and storage clients send to an endpoint,
+// such as the URL of a fake in fakes, until the returned 'restore' function is called. Those
+// clients send through http.DefaultTransport, since the SDK doesn't let callers give them another
+// one, so it is wrapped, once, the same way as for GetStorageClientForEndpoint; only the requests
+// to the endpoint are faulted, and tests faulting different endpoints can run in parallel.
+func InjectFaults(endpoint string, fi *FaultInjector) (restore func(), err error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("ERROR: Invalid endpoint '%s'", endpoint)
+	}
+
+	installRoutes()
+	routes.Lock()
+	defer routes.Unlock()
+	if _, ok := routes.faults[u.Host]; ok {
+		return nil, fmt.Errorf("ERROR: Faults are already injected into the requests to '%s'", endpoint)
+	}
+	routes.faults[u.Host] = fi
+	return func() {
+		routes.Lock()
+		delete(routes.faults, u.Host)
+		routes.Unlock()
+	}, nil
+}
+
+// Transport wraps an http.RoundTripper, http.DefaultTransport when nil, so that every request sent
+// through it is subject to all the configured faults. An http.Client using it can be the Sender of
+// a generated client.
+//
+// Requests that fail with a connection reset or a timeout are never sent. Responses whose status is
+// replaced have been processed by the server, which is also what happens when a real connection
+// breaks after the request was sent.
+func (fi *FaultInjector) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return faultTransport{fi: fi, base: base}
+}
+
+type faultTransport struct {
+	fi   *FaultInjector
+	base http.RoundTripper
+}
+
+func (t faultTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if err := t.fi.beforeSend(r); err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(r)
+	if err != nil {
+		return resp, err
+	}
+	t.fi.afterSend(resp)
+	return resp, nil
+}
+
+// WithFaults returns a PrepareDecorator that subjects the requests of a client to the latency,
+// connection resets and timeouts of the injector, to be used with ByFaults as the inspectors of a
+// client, such as an arm.Client, combined with others by ChainPreparers. Unlike with Transport,
+// faulted requests fail while they are prepared, so the retries of the Sender don't see them.
+func (fi *FaultInjector) WithFaults() autorest.PrepareDecorator {
+	return func(p autorest.Preparer) autorest.Preparer {
+		return autorest.PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			r, err := p.Prepare(r)
+			if err == nil {
+				err = fi.beforeSend(r)
+			}
+			return r, err
+		})
+	}
+}
+
+// ByFaults returns a RespondDecorator that replaces the status of the responses of a client with
+// the status codes of the injector, before the client checks it; see WithFaults.
+func (fi *FaultInjector) ByFaults() autorest.RespondDecorator {
+	return func(r autorest.Responder) autorest.Responder {
+		return autorest.ResponderFunc(func(resp *http.Response) error {
+			fi.afterSend(resp)
+			return r.Respond(resp)
+		})
+	}
+}
+
+// ChainPreparers combines several PrepareDecorators into one, since clients only accept a
+// single RequestInspector. Nil decorators are skipped.
+func ChainPreparers(decorators ...autorest.PrepareDecorator) autorest.PrepareDecorator {
+	var chain []autorest.PrepareDecorator
+	for _, d := range decorators {
+		if d != nil {
+			chain = append(chain, d)
+		}
+	}
+	return func(p autorest.Preparer) autorest.Preparer {
+		return autorest.DecoratePreparer(p, chain...)
+	}
+}
+
+// ChainResponders combines several RespondDecorators into one, since clients only accept a
+// single ResponseInspector. Nil decorators are skipped.
+func ChainResponders(decorators ...autorest.RespondDecorator) autorest.RespondDecorator {
+	var chain []autorest.RespondDecorator
+	for _, d := range decorators {
+		if d != nil {
+			chain = append(chain, d)
+		}
+	}
+	return func(r autorest.Responder) autorest.Responder {
+		return autorest.DecorateResponder(r, chain...)
+	}
+}
+
+func (fi *FaultInjector) beforeSend(r *http.Request) error {
+	if fi == nil || !fi.matches(r) {
+		return nil
+	}
+	if fi.Latency > 0 {
+		time.Sleep(fi.Latency)
+	}
+	if fi.ResetConnections && fi.roll() {
+		return &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	}
+	if fi.Timeouts && fi.roll() {
+		return &net.OpError{Op: "read", Net: "tcp", Err: timeoutError{}}
+	}
+	return nil
+}
+
+func (fi *FaultInjector) afterSend(resp *http.Response) {
+	if fi == nil || resp == nil || len(fi.StatusCodes) == 0 || !fi.matches(resp.Request) || !fi.roll() {
+		return
+	}
+
+	fi.mu.Lock()
+	code := fi.StatusCodes[fi.rnd.Intn(len(fi.StatusCodes))]
+	fi.mu.Unlock()
+
+	if resp.Body != nil {
+		resp.Body.Close()
+	}
+
+	// Storage services, whose requests name their version in x-ms-version, report errors in XML,
+	// and ARM in JSON.
+	message := fmt.Sprintf("Fault injected with status %d", code)
+	body := []byte(fmt.Sprintf(`{"error":{"code":"InjectedFault","message":"%s"}}`, message))
+	contentType := "application/json"
+	if resp.Request.Header.Get("x-ms-version") != "" {
+		body = []byte(fmt.Sprintf(`<?xml version="1.0" encoding="utf-8"?><Error><Code>InjectedFault</Code><Message>%s</Message></Error>`, message))
+		contentType = "application/xml"
+	}
+
+	resp.StatusCode = code
+	resp.Status = fmt.Sprintf("%d %s", code, http.StatusText(code))
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header = http.Header{}
+	resp.Header.Set("Content-Type", contentType)
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	if code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable {
+		resp.Header.Set("Retry-After", "1")
+	}
+}
+
+func (fi *FaultInjector) matches(r *http.Request) bool {
+	if r == nil || r.URL == nil {
+		return false
+	}
+	if len(fi.URLPatterns) == 0 {
+		return true
+	}
+	u := r.URL.String()
+	for _, re := range fi.URLPatterns {
+		if re.MatchString(u) {
+			return true
+		}
+	}
+	return false
+}
+
+func (fi *FaultInjector) roll() bool {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+
+	if fi.rnd == nil {
+		seed := fi.Seed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		fi.rnd = rand.New(rand.NewSource(seed))
+	}
+	if fi.rnd.Float64() >= fi.Probability {
+		return false
+	}
+	fi.injected++
+	return true
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout (injected)" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
@@ -0,0 +1,184 @@
+package helpers_test
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-go-samples/fakes/armfake"
+	"github.com/Azure/azure-go-samples/fakes/blobfake"
+	"github.com/Azure/azure-go-samples/helpers"
+	"github.com/Azure/azure-sdk-for-go/Godeps/_workspace/src/github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/azure-sdk-for-go/Godeps/_workspace/src/github.com/Azure/go-autorest/autorest/to"
+	"github.com/Azure/azure-sdk-for-go/arm/resources/resources"
+	"github.com/Azure/azure-sdk-for-go/storage"
+)
+
+func TestFaultsThrottling(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+
+	fi := &helpers.FaultInjector{Probability: 1, StatusCodes: []int{http.StatusTooManyRequests}}
+	restore, err := helpers.InjectFaults(srv.URL, fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := helpers.InjectFaults(srv.URL, fi); err == nil {
+		t.Errorf("expected the faults to be injected only once")
+	}
+
+	_, err = client.ResourceGroups().CreateOrUpdate("throttled", resources.ResourceGroup{Location: to.StringPtr("West US")})
+	if err == nil || !strings.Contains(err.Error(), "429") {
+		t.Errorf("expected the request to be throttled, got %v", err)
+	}
+	if fi.Injected() != 1 {
+		t.Errorf("expected 1 fault, got %d", fi.Injected())
+	}
+	// The server processed the request before its response was replaced.
+	if _, ok := srv.Resource("/subscriptions/" + srv.SubscriptionID + "/resourceGroups/throttled"); !ok {
+		t.Errorf("the resource group was not created")
+	}
+
+	restore()
+	if _, err := client.ResourceGroups().Get("throttled"); err != nil {
+		t.Errorf("expected no more faults, got %v", err)
+	}
+}
+
+func TestFaultsRetried(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+
+	fi := &helpers.FaultInjector{Probability: 0.5, StatusCodes: []int{http.StatusServiceUnavailable}, ResetConnections: true, Seed: 13}
+	restore, err := helpers.InjectFaults(srv.URL, fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restore()
+
+	// The faults reach the Sender, so that its retries get past them.
+	req, err := http.NewRequest("GET", srv.URL+"/subscriptions/"+srv.SubscriptionID+"/resourcegroups?api-version=2015-11-01", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := autorest.SendWithSender(&http.Client{}, req,
+		autorest.DoErrorUnlessStatusCode(http.StatusOK),
+		autorest.DoRetryForAttempts(10, 0))
+	if err != nil {
+		t.Fatalf("expected the retries to succeed, got %v", err)
+	}
+	resp.Body.Close()
+	// With this seed, the first attempt is reset, and never reaches the server, the second gets a
+	// 503 and the third succeeds.
+	if fi.Injected() != 2 {
+		t.Errorf("expected 2 faults, got %d", fi.Injected())
+	}
+	if n := len(srv.Requests()); n != 2 {
+		t.Errorf("expected 2 requests to reach the server, got %d", n)
+	}
+}
+
+func TestFaultsInStorage(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		fi       *helpers.FaultInjector
+		expected string
+	}{
+		{"reset", &helpers.FaultInjector{Probability: 1, ResetConnections: true}, "connection reset"},
+		{"timeout", &helpers.FaultInjector{Probability: 1, Timeouts: true}, "i/o timeout"},
+		{"error", &helpers.FaultInjector{Probability: 1, StatusCodes: []int{http.StatusInternalServerError}}, "500"},
+	} {
+		srv, blobs, done := blobfake.NewClient(t)
+		c.fi.URLPatterns = []*regexp.Regexp{regexp.MustCompile("/faulted")}
+		restore, err := helpers.InjectFaults(srv.URL, c.fi)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = blobs.CreateContainer("faulted", storage.ContainerAccessTypePrivate)
+		if err == nil || !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("%s: expected '%s', got %v", c.name, c.expected, err)
+		}
+		if err := blobs.CreateContainer("kept", storage.ContainerAccessTypePrivate); err != nil {
+			t.Errorf("%s: expected requests to other URLs to succeed, got %v", c.name, err)
+		}
+
+		reached := len(srv.Containers(blobfake.DefaultAccountName))
+		if c.fi.StatusCodes == nil && reached != 1 || c.fi.StatusCodes != nil && reached != 2 {
+			t.Errorf("%s: unexpected containers %v", c.name, srv.Containers(blobfake.DefaultAccountName))
+		}
+
+		restore()
+		done()
+	}
+}
+
+func TestFaultsLatency(t *testing.T) {
+	srv, blobs, done := blobfake.NewClient(t)
+	defer done()
+
+	fi := &helpers.FaultInjector{Latency: 50 * time.Millisecond}
+	restore, err := helpers.InjectFaults(srv.URL, fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restore()
+
+	start := time.Now()
+	if err := blobs.CreateContainer("slow", storage.ContainerAccessTypePrivate); err != nil {
+		t.Fatalf("expected the request to succeed, got %v", err)
+	}
+	if d := time.Since(start); d < fi.Latency {
+		t.Errorf("expected the request to take at least %v, took %v", fi.Latency, d)
+	}
+	if fi.Injected() != 0 {
+		t.Errorf("latency is not a fault, got %d", fi.Injected())
+	}
+}
+
+func TestFaultsAsInspectors(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+
+	fi := &helpers.FaultInjector{Probability: 1, StatusCodes: []int{http.StatusServiceUnavailable}}
+	var inspected []string
+	endpoint := client.RequestInspector
+	client.RequestInspector = helpers.ChainPreparers(endpoint, nil, fi.WithFaults(), helpers.WithInspection(func(r *http.Request) {
+		inspected = append(inspected, r.Method)
+	}))
+	client.ResponseInspector = helpers.ChainResponders(fi.ByFaults(), nil)
+
+	_, err := client.ResourceGroups().CreateOrUpdate("faulted", resources.ResourceGroup{Location: to.StringPtr("West US")})
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("expected the response to be replaced, got %v", err)
+	}
+	if _, ok := srv.Resource("/subscriptions/" + srv.SubscriptionID + "/resourceGroups/faulted"); !ok {
+		t.Errorf("the resource group was not created")
+	}
+	if strings.Join(inspected, ",") != "PUT" {
+		t.Errorf("expected the other inspectors to run, got %v", inspected)
+	}
+
+	// Faulted requests fail before they are sent.
+	fi.StatusCodes, fi.ResetConnections = nil, true
+	if _, err := client.ResourceGroups().Get("faulted"); err == nil || !strings.Contains(err.Error(), "connection reset") {
+		t.Errorf("expected the connection to be reset, got %v", err)
+	}
+	if n := len(srv.Requests()); n != 1 {
+		t.Errorf("expected 1 request to reach the server, got %d", n)
+	}
+	if fi.Injected() != 2 {
+		t.Errorf("expected 2 faults, got %d", fi.Injected())
+	}
+
+	// Without faults, the chains only hold the other inspectors.
+	client.RequestInspector = helpers.ChainPreparers(endpoint, nil, (*helpers.FaultInjector)(nil).WithFaults())
+	client.ResponseInspector = helpers.ChainResponders(nil, (*helpers.FaultInjector)(nil).ByFaults())
+	if _, err := client.ResourceGroups().Get("faulted"); err != nil {
+		t.Errorf("expected no faults, got %v", err)
+	}
+}
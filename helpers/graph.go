@@ -0,0 +1,171 @@
+package helpers
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Graph runs tasks that depend on each other, such as the steps provisioning resources that refer
+// to one another. A task starts once the tasks it depends on have succeeded, and tasks that don't
+// depend on each other run concurrently. A failure only stops the tasks that depend on it.
+type Graph struct {
+	tasks  []*graphTask
+	byName map[string]*graphTask
+}
+
+type graphTask struct {
+	name  string
+	run   func() error
+	deps  []*graphTask
+	state taskState
+	err   error
+}
+
+type taskState int
+
+const (
+	taskPending taskState = iota
+	taskRunning
+	taskSucceeded
+	taskFailed
+	taskSkipped
+)
+
+// NewGraph returns an empty graph.
+func NewGraph() *Graph {
+	return &Graph{byName: make(map[string]*graphTask)}
+}
+
+// Add adds a task that runs once the tasks named in dependsOn have succeeded. Those must have been
+// added before, which rules out cycles; Add panics otherwise, or when the name is already taken.
+func (g *Graph) Add(name string, run func() error, dependsOn ...string) {
+	if _, ok := g.byName[name]; ok {
+		panic(fmt.Sprintf("task '%s' is added twice", name))
+	}
+	t := &graphTask{name: name, run: run}
+	for _, d := range dependsOn {
+		dep, ok := g.byName[d]
+		if !ok {
+			panic(fmt.Sprintf("task '%s' depends on '%s', which hasn't been added", name, d))
+		}
+		t.deps = append(t.deps, dep)
+	}
+	g.tasks = append(g.tasks, t)
+	g.byName[name] = t
+}
+
+// TaskError is the error of a task that failed, or the reason one wasn't run.
+type TaskError struct {
+	Task string
+	Err  error
+}
+
+// GraphError is returned by Run when tasks failed. It lists the errors of the tasks that failed,
+// and the tasks that weren't run because of them, in the order the tasks were added.
+type GraphError struct {
+	Failed  []TaskError
+	Skipped []TaskError
+}
+
+// Error returns the errors of the tasks that failed, one per line.
+func (e *GraphError) Error() string {
+	msgs := make([]string, len(e.Failed))
+	for i, f := range e.Failed {
+		msgs[i] = strings.TrimSuffix(f.Err.Error(), "\n")
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Run runs the tasks, at most 'limit' at a time, or all that are ready when limit is zero or less.
+// Ready tasks start in the order they were added, so that with a limit of 1 the tasks run one
+// after the other in that order. When a task fails, the tasks that depend on it, directly or not,
+// are skipped, but the others still run, and Run returns a *GraphError once they have finished.
+func (g *Graph) Run(limit int) error {
+	done := make(chan *graphTask)
+	running := 0
+	failed := false
+
+	for {
+		for _, t := range g.tasks {
+			if t.state != taskPending {
+				continue
+			}
+			// Tasks come after their dependencies, so a skip reaches the dependents of a
+			// skipped task in the same pass.
+			if dep := t.blockedBy(); dep != nil {
+				t.skip(dep)
+				continue
+			}
+			if limit > 0 && running >= limit {
+				continue
+			}
+			if t.ready() {
+				t.state = taskRunning
+				running++
+				go func(t *graphTask) {
+					t.err = t.run()
+					done <- t
+				}(t)
+			}
+		}
+		if running == 0 {
+			break
+		}
+
+		t := <-done
+		running--
+		t.state = taskSucceeded
+		if t.err != nil {
+			t.state = taskFailed
+			failed = true
+		}
+	}
+
+	if !failed {
+		return nil
+	}
+	return g.failure()
+}
+
+func (t *graphTask) ready() bool {
+	for _, d := range t.deps {
+		if d.state != taskSucceeded {
+			return false
+		}
+	}
+	return true
+}
+
+// blockedBy returns the first dependency of the task that failed or was skipped, if any.
+func (t *graphTask) blockedBy() *graphTask {
+	for _, d := range t.deps {
+		if d.state == taskFailed || d.state == taskSkipped {
+			return d
+		}
+	}
+	return nil
+}
+
+// skip marks the task as not run because of the passed dependency.
+func (t *graphTask) skip(dep *graphTask) {
+	t.state = taskSkipped
+	if dep.state == taskFailed {
+		t.err = fmt.Errorf("ERROR: Task '%s' was not run, since it depends on '%s', which failed", t.name, dep.name)
+	} else {
+		t.err = fmt.Errorf("ERROR: Task '%s' was not run, since it depends on '%s', which was not run", t.name, dep.name)
+	}
+}
+
+// failure reports the tasks that failed, and those that weren't run because of them.
+func (g *Graph) failure() *GraphError {
+	e := &GraphError{}
+	for _, t := range g.tasks {
+		switch t.state {
+		case taskFailed:
+			e.Failed = append(e.Failed, TaskError{Task: t.name, Err: t.err})
+		case taskSkipped:
+			e.Skipped = append(e.Skipped, TaskError{Task: t.name, Err: t.err})
+		}
+	}
+	return e
+}
@@ -0,0 +1,172 @@
+package helpers_test
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-go-samples/helpers"
+)
+
+// recorder records the order in which tasks start, and the most that ran at the same time.
+type recorder struct {
+	mu      sync.Mutex
+	started []string
+	running int
+	most    int
+}
+
+func (r *recorder) task(name string, wait func(), err error) func() error {
+	return func() error {
+		r.mu.Lock()
+		r.started = append(r.started, name)
+		r.running++
+		if r.running > r.most {
+			r.most = r.running
+		}
+		r.mu.Unlock()
+
+		if wait != nil {
+			wait()
+		}
+
+		r.mu.Lock()
+		r.running--
+		r.mu.Unlock()
+		return err
+	}
+}
+
+func TestGraphOrder(t *testing.T) {
+	r := &recorder{}
+	g := helpers.NewGraph()
+	g.Add("group", r.task("group", nil, nil))
+	g.Add("storage", r.task("storage", nil, nil), "group")
+	g.Add("vnet", r.task("vnet", nil, nil), "group")
+	g.Add("nic", r.task("nic", nil, nil), "vnet")
+	g.Add("vm", r.task("vm", nil, nil), "nic", "storage")
+
+	if err := g.Run(1); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if s := strings.Join(r.started, " "); s != "group storage vnet nic vm" || r.most != 1 {
+		t.Errorf("expected the tasks to run one at a time in order, got %s with %d at once", s, r.most)
+	}
+}
+
+func TestGraphConcurrency(t *testing.T) {
+	r := &recorder{}
+	// The independent tasks wait for each other, which deadlocks unless they run concurrently.
+	var started sync.WaitGroup
+	started.Add(3)
+	barrier := func() {
+		started.Done()
+		started.Wait()
+	}
+
+	g := helpers.NewGraph()
+	g.Add("group", r.task("group", nil, nil))
+	for _, name := range []string{"storage", "avset", "vnet"} {
+		g.Add(name, r.task(name, barrier, nil), "group")
+	}
+	g.Add("vm", r.task("vm", nil, nil), "storage", "avset", "vnet")
+
+	if err := g.Run(0); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if r.most != 3 || r.started[0] != "group" || r.started[4] != "vm" {
+		t.Errorf("unexpected run: %v with %d at once", r.started, r.most)
+	}
+}
+
+func TestGraphLimit(t *testing.T) {
+	r := &recorder{}
+	g := helpers.NewGraph()
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		g.Add(name, r.task(name, nil, nil))
+	}
+	if err := g.Run(2); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if len(r.started) != 5 || r.most > 2 {
+		t.Errorf("expected at most 2 tasks at once, got %d", r.most)
+	}
+}
+
+func TestGraphFailure(t *testing.T) {
+	r := &recorder{}
+	g := helpers.NewGraph()
+	g.Add("group", r.task("group", nil, nil))
+	g.Add("ip", r.task("ip", nil, errors.New("Failed to create public ip address 'ip01'\n")), "group")
+	g.Add("vnet", r.task("vnet", nil, nil), "group")
+	g.Add("nic", r.task("nic", nil, nil), "ip", "vnet")
+	g.Add("vm", r.task("vm", nil, nil), "nic")
+	g.Add("storage", r.task("storage", nil, nil), "group")
+	g.Add("avset", r.task("avset", nil, nil), "storage")
+
+	err := g.Run(1)
+	ge, ok := err.(*helpers.GraphError)
+	if !ok {
+		t.Fatalf("expected a *GraphError, got %v", err)
+	}
+	if err.Error() != "Failed to create public ip address 'ip01'" {
+		t.Errorf("unexpected error '%v'", err)
+	}
+	// The tasks that don't depend on the failed one still run.
+	if s := strings.Join(r.started, " "); s != "group ip vnet storage avset" {
+		t.Errorf("expected the independent tasks to run after the failure, got %s", s)
+	}
+	if len(ge.Failed) != 1 || ge.Failed[0].Task != "ip" {
+		t.Errorf("unexpected failed tasks %+v", ge.Failed)
+	}
+
+	expected := []string{
+		"ERROR: Task 'nic' was not run, since it depends on 'ip', which failed",
+		"ERROR: Task 'vm' was not run, since it depends on 'nic', which was not run",
+	}
+	var skipped []string
+	for _, s := range ge.Skipped {
+		skipped = append(skipped, s.Err.Error())
+	}
+	if strings.Join(skipped, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected skipped tasks:\n%s\nexpected:\n%s", strings.Join(skipped, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestGraphUnknownDependency(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected Add to panic")
+		}
+	}()
+	g := helpers.NewGraph()
+	g.Add("vm", func() error { return nil }, "nic")
+}
+
+func TestGraphFailureWhileRunning(t *testing.T) {
+	r := &recorder{}
+	// 'a' fails while 'b' is running, and 'b' only finishes once 'a' has failed: 'c' must still
+	// start afterwards, and 'd', which depends on 'a', must not.
+	failed := make(chan struct{})
+	g := helpers.NewGraph()
+	g.Add("a", func() error {
+		defer close(failed)
+		return r.task("a", nil, errors.New("a failed"))()
+	})
+	g.Add("b", r.task("b", func() { <-failed }, nil))
+	g.Add("c", r.task("c", nil, nil), "b")
+	g.Add("d", r.task("d", nil, nil), "a")
+
+	err := g.Run(2)
+	ge, ok := err.(*helpers.GraphError)
+	if !ok {
+		t.Fatalf("expected a *GraphError, got %v", err)
+	}
+	if s := strings.Join(r.started, " "); len(r.started) != 3 || r.started[2] != "c" {
+		t.Errorf("expected a, b then c to start, got %s", s)
+	}
+	if len(ge.Skipped) != 1 || ge.Skipped[0].Task != "d" {
+		t.Errorf("unexpected skipped tasks %+v", ge.Skipped)
+	}
+}
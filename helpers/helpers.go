@@ -7,10 +7,15 @@ import (
 	"os"
 	"os/user"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"crypto/rand"
+	"time"
 	
 	"github.com/Azure/azure-sdk-for-go/storage"	
 	"github.com/Azure/azure-sdk-for-go/arm"	
+	"github.com/Azure/azure-sdk-for-go/management"
 	"github.com/Azure/azure-sdk-for-go/Godeps/_workspace/src/github.com/Azure/go-autorest/autorest"
 	"github.com/Azure/azure-sdk-for-go/Godeps/_workspace/src/github.com/Azure/go-autorest/autorest/azure"
 )
@@ -104,6 +109,32 @@ func AuthenticateForARM() (client arm.Client,  err error) {
 	return 
 }
 
+// ARMClientForEndpoint creates an ARM client for the passed subscription that sends all its
+// requests to another endpoint, without authenticating. It is meant for running the samples
+// against a local fake of the Resource Manager, such as the one in the fakes/armfake package.
+func ARMClientForEndpoint(subscriptionID, endpoint string) arm.Client {
+	client := arm.NewClient(subscriptionID, autorest.NullAuthorizer{})
+	client.RequestInspector = WithEndpoint(endpoint)
+	return client
+}
+
+// WithEndpoint redirects each HTTP request of a client to the passed endpoint, keeping its path
+// and query. Use ChainPreparers to combine it with other request inspectors.
+func WithEndpoint(endpoint string) autorest.PrepareDecorator {
+	return func(p autorest.Preparer) autorest.Preparer {
+		return autorest.PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			u, err := url.Parse(endpoint)
+			if err != nil {
+				return r, fmt.Errorf("ERROR: Invalid endpoint '%s' (%v)", endpoint, err)
+			}
+			r.URL.Scheme = u.Scheme
+			r.URL.Host = u.Host
+			r.Host = ""
+			return p.Prepare(r)
+		})
+	}
+}
+
 func ensureValueStrings(mapOfInterface map[string]interface{}) map[string]string {
 	mapOfStrings := make(map[string]string)
 	for key, value := range mapOfInterface {
@@ -136,7 +167,7 @@ type ResponseObserver func(*http.Response)
 func WithInspection(callbacks ...RequestObserver) autorest.PrepareDecorator {
 	return func(p autorest.Preparer) autorest.Preparer {
 		return autorest.PreparerFunc(func(r *http.Request) (*http.Request, error) {
-			fmt.Printf("Inspecting Request: %s %s\n", r.Method, r.URL)
+			Logf("Inspecting Request: %s %s\n", r.Method, r.URL)
 			for _,cb := range callbacks {
 				if cb != nil {
 					cb(r)
@@ -151,7 +182,7 @@ func WithInspection(callbacks ...RequestObserver) autorest.PrepareDecorator {
 func ByInspecting(callbacks ...ResponseObserver) autorest.RespondDecorator {
 	return func(r autorest.Responder) autorest.Responder {
 		return autorest.ResponderFunc(func(resp *http.Response) error {
-			fmt.Printf("Inspecting Response: %s for %s %s\n", resp.Status, resp.Request.Method, resp.Request.URL)		   
+			Logf("Inspecting Response: %s for %s %s\n", resp.Status, resp.Request.Method, resp.Request.URL)		   
 			for _,cb := range callbacks {
 				if cb != nil {
 					cb(resp)
@@ -162,19 +193,19 @@ func ByInspecting(callbacks ...ResponseObserver) autorest.RespondDecorator {
 	}
 }
 
+const (
+	alphanumeric      = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	lowerAlphanumeric = "0123456789abcdefghijklmnopqrstuvwxyz"
+)
+
 // RandBytes creates a byte array with length 'n' and fills it with random numbers. By making
-// the data readable characters, looking at the data is easier.
+// the data readable characters, looking at the data is easier. Use a RandomSource instead
+// when the data needs to be reproducible.
 func RandBytes(n int) []byte {
 	if n <= 0 {
 		panic("negative number")
 	}
-	const alphanum = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
-	var bytes = make([]byte, n)
-	rand.Read(bytes)
-	for i, b := range bytes {
-		bytes[i] = alphanum[b%byte(len(alphanum))]
-	}
-	return bytes	
+	return randChars(n, alphanumeric)
 }
 
 // RandString generates a random string containing only lower-case letters and numbers.
@@ -183,13 +214,30 @@ func RandString(n int) string {
 	if n <= 0 {
 		panic("negative number")
 	}
-	const alphanum = "0123456789abcdefghijklmnopqrstuvwxyz"
+	return string(randChars(n, lowerAlphanumeric))
+}
+
+// randChars fills a byte array with characters from the alphabet, picked using crypto/rand.
+// Random bytes that would make some characters more likely than others, because the alphabet
+// size doesn't divide 256, are discarded.
+func randChars(n int, alphabet string) []byte {
+	limit := 256 - 256%len(alphabet)
 	var bytes = make([]byte, n)
-	rand.Read(bytes)
-	for i, b := range bytes {
-		bytes[i] = alphanum[b%byte(len(alphanum))]
+	var buf = make([]byte, n)
+	for i := 0; i < n; {
+		rand.Read(buf)
+		for _, b := range buf {
+			if int(b) >= limit {
+				continue
+			}
+			bytes[i] = alphabet[int(b)%len(alphabet)]
+			i++
+			if i == n {
+				break
+			}
+		}
 	}
-	return string(bytes)
+	return bytes	
 }
 
 // GetStorageClient returns an Azure storage client, which can be used to retrieve service 
@@ -197,4 +245,109 @@ func RandString(n int) string {
 func GetStorageClient(storageAccount string, storageAccountKey string) storage.Client {
 	cli, _ := storage.NewBasicClient(storageAccount, storageAccountKey)
 	return cli
-}
\ No newline at end of file
+}
+
+// GetStorageClientForEndpoint returns a storage client that sends its requests to another endpoint,
+// such as the local blob service in fakes/blobfake, instead of Azure. The storage client always
+// sends through http.DefaultTransport, which the SDK doesn't let callers replace, so the client is
+// given a placeholder domain of its own, and the first call wraps http.DefaultTransport, once, with
+// a transport that sends the requests for those domains to their endpoints, leaving every other
+// request alone. Clients for different endpoints can therefore be used in parallel. Call the
+// returned 'restore' function once the client is no longer used.
+func GetStorageClientForEndpoint(storageAccount, storageAccountKey, endpoint string) (cli storage.Client, restore func(), err error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return cli, nil, fmt.Errorf("ERROR: Invalid endpoint '%s'", endpoint)
+	}
+
+	routes.Lock()
+	routes.next++
+	baseURL := fmt.Sprintf("c%d.%s", routes.next, redirectedBaseURL)
+	routes.Unlock()
+
+	cli, err = storage.NewClient(storageAccount, storageAccountKey, baseURL, storage.DefaultAPIVersion, false)
+	if err != nil {
+		return cli, nil, err
+	}
+
+	installRoutes()
+	routes.Lock()
+	routes.hosts[baseURL] = u.Host
+	routes.Unlock()
+	return cli, func() {
+		routes.Lock()
+		delete(routes.hosts, baseURL)
+		routes.Unlock()
+	}, nil
+}
+
+// redirectedBaseURL is the domain under which clients created by GetStorageClientForEndpoint get
+// their base URL. The .invalid domain never resolves, so nothing leaks to the network once the
+// client's endpoint is forgotten.
+const redirectedBaseURL = "fake.invalid"
+
+// routes holds the endpoints the transport installed by installRoutes sends requests to, by the
+// base URL of the client sending them, and the faults it injects, by endpoint host.
+var routes = struct {
+	sync.Mutex
+	once   sync.Once
+	next   int
+	hosts  map[string]string
+	faults map[string]*FaultInjector
+}{hosts: map[string]string{}, faults: map[string]*FaultInjector{}}
+
+// installRoutes wraps http.DefaultTransport with a routeTransport, the first time it is called.
+func installRoutes() {
+	routes.once.Do(func() {
+		http.DefaultTransport = routeTransport{base: http.DefaultTransport}
+	})
+}
+
+// routeTransport sends requests for hosts of the form '{account}.{service}.{baseURL}' to the host
+// registered for their base URL, keeping the original Host header, which storage services take
+// the account name from, and injects the faults registered for the host a request is sent to.
+// Other requests go to 'base' unchanged.
+type routeTransport struct {
+	base http.RoundTripper
+}
+
+func (t routeTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if parts := strings.SplitN(r.URL.Host, ".", 3); len(parts) == 3 && strings.HasSuffix(parts[2], "."+redirectedBaseURL) {
+		routes.Lock()
+		host, ok := routes.hosts[parts[2]]
+		routes.Unlock()
+		if !ok {
+			return nil, fmt.Errorf("ERROR: No endpoint for '%s', the client was restored", r.URL.Host)
+		}
+
+		redirected := new(http.Request)
+		*redirected = *r
+		u := *r.URL
+		u.Host = host
+		redirected.URL = &u
+		redirected.Host = r.URL.Host
+		r = redirected
+	}
+
+	routes.Lock()
+	fi := routes.faults[r.URL.Host]
+	routes.Unlock()
+	if fi != nil {
+		return fi.Transport(t.base).RoundTrip(r)
+	}
+	return t.base.RoundTrip(r)
+}
+
+// ManagementClientForEndpoint returns a classic service management client that sends its requests
+// to another endpoint, such as the local fake in fakes/asmfake, authenticating with the passed PEM
+// encoded certificate and key. The client verifies the endpoint's TLS certificate against the
+// system roots, so a self-signed endpoint has to be trusted through SSL_CERT_FILE.
+func ManagementClientForEndpoint(subscriptionID string, managementCert []byte, endpoint string) (management.Client, error) {
+	config := management.DefaultConfig()
+	config.ManagementURL = endpoint
+	
+	// Local endpoints complete operations quickly, so there is no point in waiting long between polls.
+	config.OperationPollInterval = 100 * time.Millisecond
+	
+	return management.NewClientFromConfig(subscriptionID, managementCert, config)
+}
@@ -0,0 +1,77 @@
+package helpers_test
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-go-samples/fakes/blobfake"
+	"github.com/Azure/azure-go-samples/helpers"
+	"github.com/Azure/azure-sdk-for-go/storage"
+)
+
+func TestStorageClientsForEndpoints(t *testing.T) {
+	// Each client only talks to its own fake, even when they are used at the same time.
+	var servers []*blobfake.Server
+	var clients []storage.BlobStorageClient
+	for i := 0; i < 3; i++ {
+		srv, err := blobfake.NewServer("", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer srv.Close()
+		cli, restore, err := helpers.GetStorageClientForEndpoint(blobfake.DefaultAccountName, blobfake.DefaultAccountKey, srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer restore()
+		servers, clients = append(servers, srv), append(clients, cli.GetBlobService())
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(clients))
+	for i := range clients {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = clients[i].CreateContainer(fmt.Sprintf("container%d", i), storage.ContainerAccessTypePrivate)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, srv := range servers {
+		if errs[i] != nil {
+			t.Errorf("client %d failed: %v", i, errs[i])
+		}
+		expected := fmt.Sprintf("container%d", i)
+		if c := srv.Containers(blobfake.DefaultAccountName); len(c) != 1 || c[0] != expected {
+			t.Errorf("expected server %d to hold %s, got %v", i, expected, c)
+		}
+	}
+}
+
+func TestStorageClientForEndpointRestored(t *testing.T) {
+	srv, err := blobfake.NewServer("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	cli, restore, err := helpers.GetStorageClientForEndpoint(blobfake.DefaultAccountName, blobfake.DefaultAccountKey, srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restore()
+
+	err = cli.GetBlobService().CreateContainer("container", storage.ContainerAccessTypePrivate)
+	if err == nil || !strings.Contains(err.Error(), "the client was restored") {
+		t.Errorf("expected the client to have no endpoint, got %v", err)
+	}
+	if r := srv.Requests(); len(r) != 0 {
+		t.Errorf("unexpected requests %v", r)
+	}
+
+	if _, _, err := helpers.GetStorageClientForEndpoint(blobfake.DefaultAccountName, blobfake.DefaultAccountKey, "not a URL"); err == nil {
+		t.Errorf("expected the endpoint to be rejected")
+	}
+}
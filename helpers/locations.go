@@ -0,0 +1,182 @@
+package helpers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/Godeps/_workspace/src/github.com/Azure/go-autorest/autorest/to"
+	"github.com/Azure/azure-sdk-for-go/arm"
+	"github.com/Azure/azure-sdk-for-go/arm/resources/resources"
+	"github.com/Azure/azure-sdk-for-go/arm/resources/subscriptions"
+)
+
+// NormalizeLocation turns a location given by its display name, such as "West US", into the form
+// ARM uses in IDs and responses, "westus". Names already in that form are returned in lower case.
+func NormalizeLocation(location string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(location), " ", "", -1))
+}
+
+// Location is a region the subscription can create resources in.
+type Location struct {
+	// Name is the normalized name, such as "westus".
+	Name string `json:"name"`
+
+	// DisplayName is the name shown in the portal, such as "West US".
+	DisplayName string `json:"displayName"`
+}
+
+// Locations lists the locations available to a subscription and tells, for each resource type,
+// where its provider can create it. Provider documents are fetched once, when a resource type of
+// the provider is first asked about.
+type Locations struct {
+	client    arm.Client
+	locations []Location
+	providers map[string]resources.Provider
+}
+
+// LoadLocations lists the locations available to the subscription of the passed client.
+func LoadLocations(client arm.Client) (*Locations, error) {
+
+	// arm.Client has no accessor for the subscriptions API, so borrow the configured autorest
+	// client, with its authorizer and inspectors, from the providers client.
+	pc := client.Providers()
+	sc := subscriptions.NewSubscriptionsClient(pc.SubscriptionID)
+	sc.Client.Client = pc.Client
+
+	result, err := sc.ListLocations(pc.SubscriptionID)
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: Unable to list the locations of subscription '%s' (%v)", pc.SubscriptionID, err)
+	}
+
+	l := &Locations{client: client, providers: map[string]resources.Provider{}}
+	if result.Value != nil {
+		for _, loc := range *result.Value {
+			name := NormalizeLocation(to.String(loc.Name))
+			display := to.String(loc.DisplayName)
+			if display == "" {
+				display = name
+			}
+			l.locations = append(l.locations, Location{Name: name, DisplayName: display})
+		}
+	}
+	sort.Sort(byName(l.locations))
+	return l, nil
+}
+
+// All returns the locations available to the subscription, sorted by name.
+func (l *Locations) All() []Location {
+	return append([]Location(nil), l.locations...)
+}
+
+// Find returns the location with the passed name, given either in normalized form or as a display
+// name.
+func (l *Locations) Find(location string) (Location, bool) {
+	return find(l.locations, NormalizeLocation(location))
+}
+
+// ResourceTypeLocations returns the locations in which a resource type, given as
+// "Namespace/type" such as "Microsoft.Compute/virtualMachines", can be created. Global resource
+// types, such as "Microsoft.Resources/deployments", which providers list without locations or in
+// the location "global", are available in all of them.
+func (l *Locations) ResourceTypeLocations(resourceType string) ([]Location, error) {
+	i := strings.Index(resourceType, "/")
+	if i <= 0 || i == len(resourceType)-1 {
+		return nil, fmt.Errorf("ERROR: Invalid resource type '%s', expected a provider namespace and a type such as 'Microsoft.Compute/virtualMachines'", resourceType)
+	}
+	namespace, typ := resourceType[:i], resourceType[i+1:]
+
+	p, ok := l.providers[strings.ToLower(namespace)]
+	if !ok {
+		var err error
+		p, err = l.client.Providers().Get(namespace)
+		if err != nil {
+			return nil, fmt.Errorf("ERROR: Unable to get resource provider '%s' (%v)", namespace, err)
+		}
+		l.providers[strings.ToLower(namespace)] = p
+	}
+
+	if p.ResourceTypes != nil {
+		for _, rt := range *p.ResourceTypes {
+			if !strings.EqualFold(to.String(rt.ResourceType), typ) {
+				continue
+			}
+			if rt.Locations == nil || len(*rt.Locations) == 0 {
+				return l.All(), nil
+			}
+			var result []Location
+			for _, name := range *rt.Locations {
+				if strings.EqualFold(name, "global") {
+					return l.All(), nil
+				}
+				// Providers name locations by display name; locations the subscription can't
+				// use are left out.
+				if loc, ok := l.Find(name); ok {
+					result = append(result, loc)
+				}
+			}
+			sort.Sort(byName(result))
+			return result, nil
+		}
+	}
+	return nil, fmt.Errorf("ERROR: Resource provider '%s' has no resource type '%s'", namespace, typ)
+}
+
+// Validate checks that a location is available to the subscription and that each of the passed
+// resource types can be created in it. It returns the normalized name of the location.
+func (l *Locations) Validate(location string, resourceTypes ...string) (string, error) {
+	if strings.TrimSpace(location) == "" {
+		return "", fmt.Errorf("ERROR: A location is required, use one of %s", displayNames(l.locations))
+	}
+	loc, ok := l.Find(location)
+	if !ok {
+		return "", fmt.Errorf("ERROR: Unknown location '%s', use one of %s", location, displayNames(l.locations))
+	}
+
+	for _, t := range resourceTypes {
+		available, err := l.ResourceTypeLocations(t)
+		if err != nil {
+			return "", err
+		}
+		if _, ok := find(available, loc.Name); !ok {
+			if len(available) == 0 {
+				return "", fmt.Errorf("ERROR: Resource type '%s' is not available to the subscription in any location", t)
+			}
+			return "", fmt.Errorf("ERROR: Resource type '%s' is not available in '%s', use one of %s", t, loc.DisplayName, displayNames(available))
+		}
+	}
+	return loc.Name, nil
+}
+
+// ValidateLocation loads the locations of the client's subscription and validates a location
+// for the passed resource types. See Locations.Validate.
+func ValidateLocation(client arm.Client, location string, resourceTypes ...string) (string, error) {
+	l, err := LoadLocations(client)
+	if err != nil {
+		return "", err
+	}
+	return l.Validate(location, resourceTypes...)
+}
+
+func find(locations []Location, name string) (Location, bool) {
+	for _, loc := range locations {
+		if loc.Name == name {
+			return loc, true
+		}
+	}
+	return Location{}, false
+}
+
+func displayNames(locations []Location) string {
+	var names []string
+	for _, loc := range locations {
+		names = append(names, "'"+loc.DisplayName+"'")
+	}
+	return strings.Join(names, ", ")
+}
+
+type byName []Location
+
+func (l byName) Len() int           { return len(l) }
+func (l byName) Less(i, j int) bool { return l[i].Name < l[j].Name }
+func (l byName) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
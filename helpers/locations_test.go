@@ -0,0 +1,91 @@
+package helpers_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-go-samples/fakes/armfake"
+	"github.com/Azure/azure-go-samples/helpers"
+)
+
+func TestNormalizeLocation(t *testing.T) {
+	for in, expected := range map[string]string{
+		"West US":        "westus",
+		"westus":         "westus",
+		" North Europe ": "northeurope",
+		"EASTUS":         "eastus",
+	} {
+		if n := helpers.NormalizeLocation(in); n != expected {
+			t.Errorf("NormalizeLocation(%q): expected '%s', got '%s'", in, expected, n)
+		}
+	}
+}
+
+func TestLocations(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	srv.LimitResourceType("Microsoft.Web/sites", "West Europe", "West US")
+
+	l, err := helpers.LoadLocations(helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL))
+	if err != nil {
+		t.Fatalf("LoadLocations failed: %v", err)
+	}
+
+	var names []string
+	for _, loc := range l.All() {
+		names = append(names, loc.Name+"="+loc.DisplayName)
+	}
+	if s := strings.Join(names, " "); s != "eastus=East US northeurope=North Europe westeurope=West Europe westus=West US" {
+		t.Errorf("unexpected locations '%s'", s)
+	}
+
+	sites, err := l.ResourceTypeLocations("microsoft.web/Sites")
+	if err != nil {
+		t.Fatalf("ResourceTypeLocations failed: %v", err)
+	}
+	if len(sites) != 2 || sites[0].Name != "westeurope" || sites[1].Name != "westus" {
+		t.Errorf("unexpected locations for web apps %v", sites)
+	}
+
+	// Deployments are global: their provider lists no locations for them.
+	if deployments, err := l.ResourceTypeLocations("Microsoft.Resources/deployments"); err != nil || len(deployments) != 4 {
+		t.Errorf("expected deployments to be available everywhere, got %v (%v)", deployments, err)
+	}
+
+	for _, c := range []struct {
+		location string
+		types    []string
+		expected string
+		err      string
+	}{
+		{"West US", nil, "westus", ""},
+		{"westus", []string{"Microsoft.Web/sites", "Microsoft.Storage/storageAccounts"}, "westus", ""},
+		{"West Europe", []string{"Microsoft.Web/sites"}, "westeurope", ""},
+		{"North Europe", []string{"Microsoft.Resources/deployments", "Microsoft.Storage/storageAccounts"}, "northeurope", ""},
+		{"", nil, "", "A location is required"},
+		{"West Us 2", nil, "", "Unknown location 'West Us 2', use one of 'East US', 'North Europe', 'West Europe', 'West US'"},
+		{"East US", []string{"Microsoft.Web/sites"}, "", "'Microsoft.Web/sites' is not available in 'East US', use one of 'West Europe', 'West US'"},
+		{"East US", []string{"Microsoft.Web/farms"}, "", "Resource provider 'Microsoft.Web' has no resource type 'farms'"},
+		{"East US", []string{"Microsoft.Web"}, "", "Invalid resource type 'Microsoft.Web'"},
+		{"East US", []string{"Contoso.Widgets/widgets"}, "", "Unable to get resource provider 'Contoso.Widgets'"},
+	} {
+		n, err := l.Validate(c.location, c.types...)
+		if c.err == "" && (err != nil || n != c.expected) {
+			t.Errorf("Validate(%q, %v): expected '%s', got '%s' (%v)", c.location, c.types, c.expected, n, err)
+		}
+		if c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("Validate(%q, %v): expected an error containing '%s', got %v", c.location, c.types, c.err, err)
+		}
+	}
+
+	// Provider documents are fetched once per namespace.
+	gets := 0
+	for _, r := range srv.Requests() {
+		if strings.HasSuffix(strings.ToLower(r.Path), "/providers/microsoft.web") {
+			gets++
+		}
+	}
+	if gets != 1 {
+		t.Errorf("expected the provider to be fetched once, got %d", gets)
+	}
+}
@@ -0,0 +1,245 @@
+package helpers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/Godeps/_workspace/src/github.com/Azure/go-autorest/autorest/to"
+	"github.com/Azure/azure-sdk-for-go/arm"
+	"github.com/Azure/azure-sdk-for-go/arm/authorization"
+)
+
+// The levels of a management lock. CanNotDelete lets authorized users read and modify a resource
+// but not delete it; ReadOnly also prevents modifying it.
+const (
+	LockCanNotDelete = string(authorization.CanNotDelete)
+	LockReadOnly     = string(authorization.ReadOnly)
+)
+
+// LockScope tells what a management lock applies to: the whole subscription when both fields are
+// empty, a resource group, or a resource of a group. Locks apply to everything below their scope.
+type LockScope struct {
+	ResourceGroup string
+
+	// Resource is the resource within ResourceGroup, given as its type followed by its name, such as
+	// "Microsoft.Network/publicIPAddresses/ip01". Child resources repeat the type and name of their
+	// parents, as in "Microsoft.Network/virtualNetworks/vnet01/subnets/subnet01".
+	Resource string
+}
+
+func (s LockScope) String() string {
+	switch {
+	case s.ResourceGroup == "":
+		return "the subscription"
+	case s.Resource == "":
+		return fmt.Sprintf("resource group '%s'", s.ResourceGroup)
+	}
+	return fmt.Sprintf("resource '%s' of resource group '%s'", s.Resource, s.ResourceGroup)
+}
+
+// resource splits Resource into the arguments the SDK expects: the provider namespace, the path of
+// the parent resources, if any, and the type and name of the resource.
+func (s LockScope) resource() (namespace, parentPath, resourceType, name string, err error) {
+	parts := strings.Split(strings.Trim(s.Resource, "/"), "/")
+	if len(parts) < 3 || len(parts)%2 == 0 {
+		err = fmt.Errorf("ERROR: Invalid resource '%s', expected a type and a name such as 'Microsoft.Network/publicIPAddresses/ip01'", s.Resource)
+		return
+	}
+	n := len(parts)
+	return parts[0], strings.Join(parts[1:n-2], "/"), parts[n-2], parts[n-1], nil
+}
+
+func (s LockScope) validate() error {
+	if s.ResourceGroup == "" {
+		if s.Resource != "" {
+			return fmt.Errorf("ERROR: The resource group of resource '%s' is required", s.Resource)
+		}
+		return nil
+	}
+	if err := ResourceGroupName.Validate(s.ResourceGroup); err != nil {
+		return err
+	}
+	if s.Resource != "" {
+		_, _, _, _, err := s.resource()
+		return err
+	}
+	return nil
+}
+
+// Lock is a management lock, as listed by the samples.
+type Lock struct {
+	Name  string `json:"name"`
+	Level string `json:"level"`
+
+	// Scope is the ID of what the lock applies to, which may be above the scope the locks were
+	// listed for.
+	Scope string `json:"scope"`
+	Notes string `json:"notes,omitempty"`
+	ID    string `json:"id"`
+}
+
+func newLock(l authorization.ManagementLockObject) Lock {
+	lock := Lock{Name: to.String(l.Name), ID: to.String(l.ID)}
+	if l.Properties != nil {
+		lock.Level = l.Properties.Level
+		lock.Notes = to.String(l.Properties.Notes)
+	}
+	if i := strings.Index(strings.ToLower(lock.ID), "/providers/microsoft.authorization/locks/"); i >= 0 {
+		lock.Scope = lock.ID[:i]
+	}
+	return lock
+}
+
+// ValidateLockLevel checks a lock level, regardless of case, and returns it as ARM spells it.
+func ValidateLockLevel(level string) (string, error) {
+	for _, l := range []string{LockCanNotDelete, LockReadOnly} {
+		if strings.EqualFold(level, l) {
+			return l, nil
+		}
+	}
+	return "", fmt.Errorf("ERROR: Invalid lock level '%s', use '%s' or '%s'", level, LockCanNotDelete, LockReadOnly)
+}
+
+// locksClient returns a management locks client for the subscription of the passed client.
+func locksClient(client arm.Client) authorization.ManagementLocksClient {
+
+	// arm.Client has no accessor for the authorization API, so borrow the configured autorest
+	// client, with its authorizer and inspectors, from the providers client.
+	pc := client.Providers()
+	lc := authorization.NewManagementLocksClient(pc.SubscriptionID)
+	lc.Client.Client = pc.Client
+	return lc
+}
+
+// CreateLock creates a management lock with the passed level at a scope, or updates the lock of
+// that name.
+func CreateLock(client arm.Client, scope LockScope, name, level, notes string) (Lock, error) {
+	if err := LockName.Validate(name); err != nil {
+		return Lock{}, err
+	}
+	level, err := ValidateLockLevel(level)
+	if err != nil {
+		return Lock{}, err
+	}
+	if err := scope.validate(); err != nil {
+		return Lock{}, err
+	}
+
+	lc := locksClient(client)
+	params := authorization.ManagementLockProperties{Level: level}
+	if notes != "" {
+		params.Notes = &notes
+	}
+
+	var result authorization.ManagementLockObject
+	switch {
+	case scope.ResourceGroup == "":
+		result, err = lc.CreateOrUpdateAtSubscriptionLevel(name, params)
+	case scope.Resource == "":
+		result, err = lc.CreateOrUpdateAtResourceGroupLevel(scope.ResourceGroup, name, params)
+	default:
+		ns, parent, typ, resource, _ := scope.resource()
+		result, err = lc.CreateOrUpdateAtResourceLevel(scope.ResourceGroup, ns, parent, typ, resource, name, params)
+	}
+	if err != nil {
+		return Lock{}, fmt.Errorf("ERROR: Unable to lock %s (%v)", scope, err)
+	}
+
+	Logf("Locked %s with %s lock '%s'\n", scope, level, name)
+	return newLock(result), nil
+}
+
+// ListLocks lists the management locks that apply at a scope, following the next links of the
+// result. ARM includes the locks inherited from the scopes above and, for the subscription and
+// groups, those of the resources below. The locks are sorted by scope, then by name.
+func ListLocks(client arm.Client, scope LockScope) ([]Lock, error) {
+	if err := scope.validate(); err != nil {
+		return nil, err
+	}
+
+	lc := locksClient(client)
+
+	var page authorization.ManagementLockListResult
+	var err error
+	var next func(authorization.ManagementLockListResult) (authorization.ManagementLockListResult, error)
+	switch {
+	case scope.ResourceGroup == "":
+		page, err = lc.ListAtSubscriptionLevel("")
+		next = lc.ListAtSubscriptionLevelNextResults
+	case scope.Resource == "":
+		page, err = lc.ListAtResourceGroupLevel(scope.ResourceGroup, "")
+		next = lc.ListAtResourceGroupLevelNextResults
+	default:
+		ns, parent, typ, resource, _ := scope.resource()
+		page, err = lc.ListAtResourceLevel(scope.ResourceGroup, ns, parent, typ, resource, "")
+		next = lc.ListAtResourceLevelNextResults
+	}
+
+	locks := []Lock{}
+	for {
+		if err != nil {
+			return nil, fmt.Errorf("ERROR: Unable to list the locks of %s (%v)", scope, err)
+		}
+		if page.Value != nil {
+			for _, l := range *page.Value {
+				locks = append(locks, newLock(l))
+			}
+		}
+		if page.NextLink == nil || *page.NextLink == "" {
+			break
+		}
+		page, err = next(page)
+	}
+
+	sort.Sort(byScopeAndName(locks))
+	return locks, nil
+}
+
+// DeleteLock removes a management lock from a scope. Removing a lock that doesn't exist succeeds.
+func DeleteLock(client arm.Client, scope LockScope, name string) error {
+	if err := LockName.Validate(name); err != nil {
+		return err
+	}
+	if err := scope.validate(); err != nil {
+		return err
+	}
+
+	lc := locksClient(client)
+
+	var err error
+	switch {
+	case scope.ResourceGroup == "":
+		_, err = lc.DeleteAtSubscriptionLevel(name)
+	case scope.Resource == "":
+		_, err = lc.DeleteAtResourceGroupLevel(scope.ResourceGroup, name)
+	default:
+		ns, parent, typ, resource, _ := scope.resource()
+		_, err = lc.DeleteAtResourceLevel(scope.ResourceGroup, ns, parent, typ, resource, name)
+	}
+	if err != nil {
+		return fmt.Errorf("ERROR: Unable to remove lock '%s' from %s (%v)", name, scope, err)
+	}
+
+	Logf("Removed lock '%s' from %s\n", name, scope)
+	return nil
+}
+
+// LockGroup locks a resource group once it has been provisioned, with a lock named after the
+// group, such as 'createvm01-lock'.
+func LockGroup(client arm.Client, group, level string) (Lock, error) {
+	return CreateLock(client, LockScope{ResourceGroup: group}, group+"-lock", level,
+		fmt.Sprintf("Protects the resources provisioned in resource group '%s'", group))
+}
+
+type byScopeAndName []Lock
+
+func (l byScopeAndName) Len() int      { return len(l) }
+func (l byScopeAndName) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l byScopeAndName) Less(i, j int) bool {
+	si, sj := strings.ToLower(l[i].Scope), strings.ToLower(l[j].Scope)
+	if si != sj {
+		return si < sj
+	}
+	return strings.ToLower(l[i].Name) < strings.ToLower(l[j].Name)
+}
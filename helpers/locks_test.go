@@ -0,0 +1,129 @@
+package helpers_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-go-samples/fakes/armfake"
+	"github.com/Azure/azure-go-samples/helpers"
+	"github.com/Azure/azure-sdk-for-go/Godeps/_workspace/src/github.com/Azure/go-autorest/autorest/to"
+	"github.com/Azure/azure-sdk-for-go/arm/network"
+	"github.com/Azure/azure-sdk-for-go/arm/resources/resources"
+)
+
+func TestValidateLockLevel(t *testing.T) {
+	for in, expected := range map[string]string{
+		"CanNotDelete": "CanNotDelete",
+		"cannotdelete": "CanNotDelete",
+		"READONLY":     "ReadOnly",
+	} {
+		if l, err := helpers.ValidateLockLevel(in); err != nil || l != expected {
+			t.Errorf("ValidateLockLevel(%q): expected '%s', got '%s' (%v)", in, expected, l, err)
+		}
+	}
+	if _, err := helpers.ValidateLockLevel("NotSpecified"); err == nil || !strings.Contains(err.Error(), "Invalid lock level 'NotSpecified'") {
+		t.Errorf("expected the level to be rejected, got %v", err)
+	}
+}
+
+func TestLocks(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	srv.PageSize = 1
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+
+	location := to.StringPtr("West US")
+	if _, err := client.ResourceGroups().CreateOrUpdate("lockedgroup", resources.ResourceGroup{Location: location}); err != nil {
+		t.Fatal(err)
+	}
+	ip := network.PublicIPAddress{Location: location, Properties: &network.PublicIPAddressPropertiesFormat{PublicIPAllocationMethod: network.Dynamic}}
+	if _, err := client.PublicIPAddresses().CreateOrUpdate("lockedgroup", "ip01", ip); err != nil {
+		t.Fatal(err)
+	}
+
+	subscription := helpers.LockScope{}
+	group := helpers.LockScope{ResourceGroup: "lockedgroup"}
+	resource := helpers.LockScope{ResourceGroup: "lockedgroup", Resource: "Microsoft.Network/publicIPAddresses/ip01"}
+
+	lock, err := helpers.CreateLock(client, resource, "keepip", "readonly", "the DNS name is published")
+	if err != nil {
+		t.Fatalf("CreateLock failed: %v", err)
+	}
+	if lock.Level != "ReadOnly" || lock.Notes != "the DNS name is published" || !strings.HasSuffix(lock.Scope, "/publicIPAddresses/ip01") {
+		t.Errorf("unexpected lock %+v", lock)
+	}
+	if _, err := helpers.LockGroup(client, "lockedgroup", helpers.LockCanNotDelete); err != nil {
+		t.Fatalf("LockGroup failed: %v", err)
+	}
+	if _, err := helpers.CreateLock(client, subscription, "nodelete", helpers.LockCanNotDelete, ""); err != nil {
+		t.Fatalf("CreateLock failed: %v", err)
+	}
+
+	for _, c := range []struct {
+		scope    helpers.LockScope
+		expected string
+	}{
+		{subscription, "nodelete lockedgroup-lock keepip"},
+		{group, "nodelete lockedgroup-lock keepip"},
+		{resource, "nodelete lockedgroup-lock keepip"},
+	} {
+		locks, err := helpers.ListLocks(client, c.scope)
+		if err != nil {
+			t.Fatalf("ListLocks(%s) failed: %v", c.scope, err)
+		}
+		var names []string
+		for _, l := range locks {
+			names = append(names, l.Name)
+		}
+		if s := strings.Join(names, " "); s != c.expected {
+			t.Errorf("unexpected locks for %s: '%s'", c.scope, s)
+		}
+	}
+
+	// The locks are enforced until removed.
+	ip.Tags = &map[string]*string{"env": to.StringPtr("test")}
+	if _, err := client.PublicIPAddresses().CreateOrUpdate("lockedgroup", "ip01", ip); err == nil || !strings.Contains(err.Error(), "409") {
+		t.Errorf("expected the update of a read-only resource to fail, got %v", err)
+	}
+	if err := helpers.DeleteLock(client, resource, "keepip"); err != nil {
+		t.Fatalf("DeleteLock failed: %v", err)
+	}
+	if _, err := client.PublicIPAddresses().CreateOrUpdate("lockedgroup", "ip01", ip); err != nil {
+		t.Errorf("unable to update the unlocked resource: %v", err)
+	}
+	if _, err := client.PublicIPAddresses().Delete("lockedgroup", "ip01"); err == nil {
+		t.Errorf("expected the deletion of a resource in a locked group to fail")
+	}
+
+	locks, err := helpers.ListLocks(client, group)
+	if err != nil {
+		t.Fatalf("ListLocks failed: %v", err)
+	}
+	if len(locks) != 2 {
+		t.Errorf("expected two locks left, got %+v", locks)
+	}
+}
+
+func TestLockScopeValidation(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+
+	for _, c := range []struct {
+		scope helpers.LockScope
+		name  string
+		error string
+	}{
+		{helpers.LockScope{Resource: "Microsoft.Network/publicIPAddresses/ip01"}, "lock", "The resource group of resource"},
+		{helpers.LockScope{ResourceGroup: "g", Resource: "Microsoft.Network/publicIPAddresses"}, "lock", "Invalid resource"},
+		{helpers.LockScope{ResourceGroup: "g"}, "a/b", "invalid character '/'"},
+	} {
+		_, err := helpers.CreateLock(client, c.scope, c.name, helpers.LockReadOnly, "")
+		if err == nil || !strings.Contains(err.Error(), c.error) {
+			t.Errorf("CreateLock(%s, '%s'): expected '%s', got %v", c.scope, c.name, c.error, err)
+		}
+	}
+	if n := len(srv.Requests()); n != 0 {
+		t.Errorf("expected no requests, got %d", n)
+	}
+}
@@ -0,0 +1,296 @@
+package helpers
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+const (
+	lowerAlpha = "abcdefghijklmnopqrstuvwxyz"
+	upperAlpha = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	digits     = "0123456789"
+)
+
+// NameRule captures the naming restrictions Azure places on one type of resource.
+type NameRule struct {
+	// Resource is a human-readable name of the resource type, used in error messages.
+	Resource string
+
+	MinLength int
+	MaxLength int
+
+	// Charset holds every character allowed in a name.
+	Charset string
+
+	// First and Last restrict the first and last characters of a name. When empty, any
+	// character in Charset is allowed.
+	First string
+	Last  string
+
+	// Lowercase indicates that upper-case letters are not allowed and are folded when
+	// a name is sanitized.
+	Lowercase bool
+
+	// NoConsecutiveHyphens disallows "--" anywhere in a name.
+	NoConsecutiveHyphens bool
+
+	// NotAllDigits disallows names made up of digits only.
+	NotAllDigits bool
+}
+
+// The naming rules for the resource types created by the samples.
+var (
+	StorageAccountName = NameRule{
+		Resource:  "storage account",
+		MinLength: 3, MaxLength: 24,
+		Charset:   lowerAlpha + digits,
+		Lowercase: true,
+	}
+
+	ContainerName = NameRule{
+		Resource:  "blob container",
+		MinLength: 3, MaxLength: 63,
+		Charset:              lowerAlpha + digits + "-",
+		First:                lowerAlpha + digits,
+		Last:                 lowerAlpha + digits,
+		Lowercase:            true,
+		NoConsecutiveHyphens: true,
+	}
+
+	BlobName = NameRule{
+		Resource:  "blob",
+		MinLength: 1, MaxLength: 1024,
+		Charset: lowerAlpha + upperAlpha + digits + "-_.~/",
+		Last:    lowerAlpha + upperAlpha + digits + "-_~",
+	}
+
+	DNSLabel = NameRule{
+		Resource:  "DNS label",
+		MinLength: 3, MaxLength: 63,
+		Charset:   lowerAlpha + digits + "-",
+		First:     lowerAlpha,
+		Last:      lowerAlpha + digits,
+		Lowercase: true,
+	}
+
+	// WebAppName is the host name of a web app within azurewebsites.net.
+	WebAppName = NameRule{
+		Resource:  "web app",
+		MinLength: 2, MaxLength: 60,
+		Charset: lowerAlpha + upperAlpha + digits + "-",
+		First:   lowerAlpha + upperAlpha + digits,
+		Last:    lowerAlpha + upperAlpha + digits,
+	}
+
+	// VirtualMachineName is limited to 15 characters, since the VM name doubles as the
+	// Windows computer name in the samples.
+	VirtualMachineName = NameRule{
+		Resource:  "virtual machine",
+		MinLength: 1, MaxLength: 15,
+		Charset:      lowerAlpha + upperAlpha + digits + "-",
+		First:        lowerAlpha + upperAlpha + digits,
+		Last:         lowerAlpha + upperAlpha + digits,
+		NotAllDigits: true,
+	}
+
+	ResourceGroupName = NameRule{
+		Resource:  "resource group",
+		MinLength: 1, MaxLength: 90,
+		Charset: lowerAlpha + upperAlpha + digits + "-_.()",
+		Last:    lowerAlpha + upperAlpha + digits + "-_()",
+	}
+
+	// LockName is the name of a management lock, which is long enough to hold the name of the
+	// resource group it protects.
+	LockName = NameRule{
+		Resource:  "lock",
+		MinLength: 1, MaxLength: 260,
+		Charset: lowerAlpha + upperAlpha + digits + "-_.()",
+	}
+)
+
+// Validate returns an error describing the first rule the passed name breaks, or nil if the
+// name is valid.
+func (rule NameRule) Validate(name string) error {
+	if len(name) < rule.MinLength || len(name) > rule.MaxLength {
+		return fmt.Errorf("ERROR: The %s name '%s' must be between %d and %d characters long", rule.Resource, name, rule.MinLength, rule.MaxLength)
+	}
+	for _, c := range name {
+		if !strings.ContainsRune(rule.Charset, c) {
+			return fmt.Errorf("ERROR: The %s name '%s' contains the invalid character '%c'", rule.Resource, name, c)
+		}
+	}
+	if rule.First != "" && !strings.ContainsRune(rule.First, rune(name[0])) {
+		return fmt.Errorf("ERROR: The %s name '%s' cannot start with '%c'", rule.Resource, name, name[0])
+	}
+	if rule.Last != "" && !strings.ContainsRune(rule.Last, rune(name[len(name)-1])) {
+		return fmt.Errorf("ERROR: The %s name '%s' cannot end with '%c'", rule.Resource, name, name[len(name)-1])
+	}
+	if rule.NoConsecutiveHyphens && strings.Contains(name, "--") {
+		return fmt.Errorf("ERROR: The %s name '%s' cannot contain consecutive hyphens", rule.Resource, name)
+	}
+	if rule.NotAllDigits && strings.Trim(name, digits) == "" {
+		return fmt.Errorf("ERROR: The %s name '%s' cannot consist of digits only", rule.Resource, name)
+	}
+	return nil
+}
+
+// Sanitize turns an arbitrary string into something as close to a valid name as possible by
+// folding case, dropping invalid characters and truncating it. The result may still be too
+// short to be valid, so callers should either validate it or use a NameGenerator.
+func (rule NameRule) Sanitize(name string) string {
+	if rule.Lowercase {
+		name = strings.ToLower(name)
+	}
+
+	b := make([]byte, 0, len(name))
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !strings.ContainsRune(rule.Charset, rune(c)) {
+			continue
+		}
+		if rule.NoConsecutiveHyphens && c == '-' && len(b) > 0 && b[len(b)-1] == '-' {
+			continue
+		}
+		b = append(b, c)
+	}
+
+	s := string(b)
+	if rule.First != "" {
+		s = strings.TrimLeftFunc(s, func(c rune) bool { return !strings.ContainsRune(rule.First, c) })
+	}
+	if len(s) > rule.MaxLength {
+		s = s[:rule.MaxLength]
+	}
+	return rule.trimEnd(s)
+}
+
+func (rule NameRule) trimEnd(s string) string {
+	if rule.Last == "" {
+		return s
+	}
+	return strings.TrimRightFunc(s, func(c rune) bool { return !strings.ContainsRune(rule.Last, c) })
+}
+
+// NameGenerator makes up valid, unique resource names from a base name and a suffix drawn
+// from a seeded random source. Two generators created with the same seed produce the same
+// sequence of names, so a rerun of a sample can find the resources created the last time.
+type NameGenerator struct {
+	// SuffixLength is the number of random characters appended to each name. It is shortened
+	// when a rule doesn't leave room for it.
+	SuffixLength int
+
+	seed  int64
+	rnd   *rand.Rand
+	given map[string]bool
+}
+
+// NewNameGenerator creates a NameGenerator from the passed seed. When the seed is zero, the
+// current time is used instead; Seed returns the value actually used.
+func NewNameGenerator(seed int64) *NameGenerator {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &NameGenerator{
+		SuffixLength: 8,
+		seed:         seed,
+		rnd:          rand.New(rand.NewSource(seed)),
+		given:        map[string]bool{},
+	}
+}
+
+// SeedFromString derives a generator seed from a string, such as a resource group name, so that
+// names belonging to the same group are stable from one run to the next.
+func SeedFromString(s string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	seed := int64(h.Sum64() & (1<<63 - 1))
+	if seed == 0 {
+		seed = 1
+	}
+	return seed
+}
+
+// Seed returns the seed the generator was created with.
+func (g *NameGenerator) Seed() int64 {
+	return g.seed
+}
+
+// Name returns a valid name for the passed resource type, made up of the sanitized base name
+// and a random suffix. The base name is truncated as needed to fit the suffix, and names already
+// handed out by this generator are never repeated.
+func (g *NameGenerator) Name(rule NameRule, base string) (string, error) {
+	const attempts = 100
+
+	var err error
+	for i := 0; i < attempts; i++ {
+		name := g.compose(rule, base)
+		if g.given[name] {
+			continue
+		}
+		if err = rule.Validate(name); err != nil {
+			continue
+		}
+		g.given[name] = true
+		return name, nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("ERROR: Unable to make up a unique %s name from '%s'", rule.Resource, base)
+}
+
+func (g *NameGenerator) compose(rule NameRule, base string) string {
+	n := g.SuffixLength
+	if n > rule.MaxLength {
+		n = rule.MaxLength
+	}
+	if n < rule.MinLength {
+		n = rule.MinLength
+	}
+
+	base = rule.Sanitize(base)
+
+	sep := ""
+	if base != "" && strings.ContainsRune(rule.Charset, '-') {
+		sep = "-"
+	}
+
+	if room := rule.MaxLength - n - len(sep); len(base) > room {
+		if room < 0 {
+			room = 0
+		}
+		base = rule.trimEnd(base[:room])
+		if base == "" {
+			sep = ""
+		}
+	}
+
+	suffix := make([]byte, n)
+	for i := range suffix {
+		alphabet := lowerAlpha + digits
+		if i == 0 && base == "" && rule.First != "" {
+			alphabet = intersect(alphabet, rule.First)
+		}
+		if i == 0 && n == 1 && rule.NotAllDigits {
+			alphabet = lowerAlpha
+		}
+		suffix[i] = alphabet[g.rnd.Intn(len(alphabet))]
+	}
+
+	return base + sep + string(suffix)
+}
+
+func intersect(alphabet, allowed string) string {
+	b := make([]byte, 0, len(alphabet))
+	for i := 0; i < len(alphabet); i++ {
+		if strings.IndexByte(allowed, alphabet[i]) >= 0 {
+			b = append(b, alphabet[i])
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,135 @@
+package helpers_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-go-samples/helpers"
+)
+
+func TestNameRuleValidate(t *testing.T) {
+	for _, c := range []struct {
+		rule     helpers.NameRule
+		name     string
+		expected string
+	}{
+		{helpers.StorageAccountName, "account01", ""},
+		{helpers.StorageAccountName, "ab", "ERROR: The storage account name 'ab' must be between 3 and 24 characters long"},
+		{helpers.StorageAccountName, strings.Repeat("a", 25), "must be between 3 and 24 characters long"},
+		{helpers.StorageAccountName, "MyAccount", "ERROR: The storage account name 'MyAccount' contains the invalid character 'M'"},
+		{helpers.ContainerName, "my-container", ""},
+		{helpers.ContainerName, "-abc", "ERROR: The blob container name '-abc' cannot start with '-'"},
+		{helpers.ContainerName, "abc-", "ERROR: The blob container name 'abc-' cannot end with '-'"},
+		{helpers.ContainerName, "a--b", "ERROR: The blob container name 'a--b' cannot contain consecutive hyphens"},
+		{helpers.BlobName, "dir/file.txt", ""},
+		{helpers.BlobName, "dir/", "ERROR: The blob name 'dir/' cannot end with '/'"},
+		{helpers.BlobName, "a b", "ERROR: The blob name 'a b' contains the invalid character ' '"},
+		{helpers.DNSLabel, "web-01", ""},
+		{helpers.DNSLabel, "1web", "ERROR: The DNS label name '1web' cannot start with '1'"},
+		{helpers.WebAppName, "My-App", ""},
+		{helpers.WebAppName, "a", "ERROR: The web app name 'a' must be between 2 and 60 characters long"},
+		{helpers.WebAppName, "app-", "ERROR: The web app name 'app-' cannot end with '-'"},
+		{helpers.VirtualMachineName, "vm001", ""},
+		{helpers.VirtualMachineName, "12345", "ERROR: The virtual machine name '12345' cannot consist of digits only"},
+		{helpers.VirtualMachineName, "vm_01", "ERROR: The virtual machine name 'vm_01' contains the invalid character '_'"},
+		{helpers.VirtualMachineName, "this-name-is-long001", "ERROR: The virtual machine name 'this-name-is-long001' must be between 1 and 15 characters long"},
+		{helpers.ResourceGroupName, "my_group(1)", ""},
+		{helpers.ResourceGroupName, "group.", "ERROR: The resource group name 'group.' cannot end with '.'"},
+		{helpers.ResourceGroupName, "", "ERROR: The resource group name '' must be between 1 and 90 characters long"},
+		{helpers.LockName, "createvm01.lock", ""},
+		{helpers.LockName, "lock/1", "ERROR: The lock name 'lock/1' contains the invalid character '/'"},
+	} {
+		err := c.rule.Validate(c.name)
+		if c.expected == "" {
+			if err != nil {
+				t.Errorf("expected the %s name '%s' to be valid, got %v", c.rule.Resource, c.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("expected '%s', got %v", c.expected, err)
+		}
+	}
+}
+
+func TestNameRuleSanitize(t *testing.T) {
+	for _, c := range []struct {
+		rule     helpers.NameRule
+		name     string
+		expected string
+	}{
+		{helpers.StorageAccountName, "My_Storage-Account!", "mystorageaccount"},
+		{helpers.StorageAccountName, strings.Repeat("ab", 20), strings.Repeat("ab", 12)},
+		{helpers.ContainerName, "--My--Container--", "my-container"},
+		{helpers.ContainerName, strings.Repeat("a", 62) + "-b", strings.Repeat("a", 62)},
+		{helpers.DNSLabel, "9Lives", "lives"},
+		{helpers.VirtualMachineName, "Web Server 01", "WebServer01"},
+		{helpers.BlobName, "dir/file.txt/", "dir/file.txt"},
+		{helpers.StorageAccountName, "!!!", ""},
+	} {
+		if s := c.rule.Sanitize(c.name); s != c.expected {
+			t.Errorf("expected the %s name '%s' to become '%s', got '%s'", c.rule.Resource, c.name, c.expected, s)
+		}
+	}
+}
+
+func TestNameGenerator(t *testing.T) {
+	seed := helpers.SeedFromString("createvm01")
+	if seed <= 0 || seed != helpers.SeedFromString("createvm01") || seed == helpers.SeedFromString("createvm02") {
+		t.Errorf("unexpected seeds %d, %d", seed, helpers.SeedFromString("createvm02"))
+	}
+
+	a, b := helpers.NewNameGenerator(seed), helpers.NewNameGenerator(seed)
+	if a.Seed() != seed {
+		t.Errorf("expected the seed %d, got %d", seed, a.Seed())
+	}
+	for _, c := range []struct {
+		rule   helpers.NameRule
+		base   string
+		prefix string
+	}{
+		{helpers.StorageAccountName, "createvm01", "createvm01"},
+		{helpers.StorageAccountName, strings.Repeat("x", 40), strings.Repeat("x", 16)},
+		{helpers.ContainerName, "My Container", "mycontainer-"},
+		{helpers.DNSLabel, "", ""},
+		{helpers.VirtualMachineName, "web server", "webser-"},
+	} {
+		x, err := a.Name(c.rule, c.base)
+		if err != nil {
+			t.Errorf("Name failed for '%s': %v", c.base, err)
+			continue
+		}
+		if y, _ := b.Name(c.rule, c.base); x != y {
+			t.Errorf("the same seed produced the names '%s' and '%s'", x, y)
+		}
+		if err := c.rule.Validate(x); err != nil {
+			t.Errorf("the name '%s' is not valid: %v", x, err)
+		}
+		if !strings.HasPrefix(x, c.prefix) || len(x) != len(c.prefix)+a.SuffixLength {
+			t.Errorf("expected '%s' followed by %d characters, got '%s'", c.prefix, a.SuffixLength, x)
+		}
+	}
+
+	// Names are not repeated, and a different seed gives different names.
+	x, _ := a.Name(helpers.StorageAccountName, "createvm01")
+	y, _ := a.Name(helpers.StorageAccountName, "createvm01")
+	z, _ := helpers.NewNameGenerator(seed+1).Name(helpers.StorageAccountName, "createvm01")
+	if x == y || x == z {
+		t.Errorf("unexpected names '%s', '%s' and '%s'", x, y, z)
+	}
+
+	// A one-character suffix is never all digits on its own.
+	g := helpers.NewNameGenerator(seed)
+	g.SuffixLength = 1
+	for i := 0; i < 20; i++ {
+		if name, err := g.Name(helpers.VirtualMachineName, ""); err != nil || len(name) != 1 {
+			t.Errorf("unexpected name '%s' (%v)", name, err)
+		}
+	}
+
+	// Names a rule can never accept are reported.
+	rule := helpers.NameRule{Resource: "thing", MinLength: 1, MaxLength: 10, Charset: "-"}
+	if _, err := g.Name(rule, "a"); err == nil || !strings.Contains(err.Error(), "ERROR: The thing name") {
+		t.Errorf("expected the names to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,38 @@
+package helpers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CallerIPService answers a GET request with the public IP address it came from, as plain text.
+const CallerIPService = "https://api.ipify.org"
+
+// CallerIPAddress returns the public IP address this machine reaches the Internet from, as seen
+// by the service at serviceURL, such as CallerIPService. Behind a NAT, that is the address of the
+// NAT, which is what network security rules see as the source of the traffic.
+func CallerIPAddress(serviceURL string) (string, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(serviceURL)
+	if err != nil {
+		return "", fmt.Errorf("ERROR: Unable to find the public IP address of this machine (%v)", err)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("ERROR: Unable to find the public IP address of this machine (%v)", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("ERROR: Unable to find the public IP address of this machine (%s answered %s)", serviceURL, resp.Status)
+	}
+	ip := net.ParseIP(strings.TrimSpace(string(b)))
+	if ip == nil || ip.To4() == nil {
+		return "", fmt.Errorf("ERROR: %s did not answer with an IPv4 address", serviceURL)
+	}
+	return ip.String(), nil
+}
@@ -0,0 +1,38 @@
+package helpers_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-go-samples/helpers"
+)
+
+func TestCallerIPAddress(t *testing.T) {
+	for _, c := range []struct {
+		status int
+		body   string
+		ip     string
+		err    string
+	}{
+		{http.StatusOK, "203.0.113.10\n", "203.0.113.10", ""},
+		{http.StatusOK, "2001:db8::1", "", "did not answer with an IPv4 address"},
+		{http.StatusOK, "<html>", "", "did not answer with an IPv4 address"},
+		{http.StatusServiceUnavailable, "", "", "answered 503 Service Unavailable"},
+	} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(c.status)
+			fmt.Fprint(w, c.body)
+		}))
+		ip, err := helpers.CallerIPAddress(srv.URL)
+		srv.Close()
+		if c.err == "" && (err != nil || ip != c.ip) {
+			t.Errorf("expected %s, got '%s' (%v)", c.ip, ip, err)
+		}
+		if c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("expected '%s', got '%s' (%v)", c.err, ip, err)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package helpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/Godeps/_workspace/src/github.com/Azure/go-autorest/autorest"
+)
+
+// The states of a long-running ARM operation.
+const (
+	OperationInProgress = "InProgress"
+	OperationSucceeded  = "Succeeded"
+	OperationFailed     = "Failed"
+	OperationCanceled   = "Canceled"
+)
+
+// Operation is a long-running ARM operation, such as the deletion of a resource group. ARM
+// accepts the request with 201 or 202 and names a URL at which to follow the operation, either
+// in the Azure-AsyncOperation header, which returns a status document, or in the Location header,
+// which returns 202 until the operation is done.
+type Operation struct {
+	// URL is polled for the state of the operation.
+	URL string
+
+	// Async is set when URL came from the Azure-AsyncOperation header.
+	Async bool
+
+	// Status is OperationSucceeded, OperationFailed or OperationCanceled once the operation is
+	// done, and OperationInProgress, or whichever state ARM reports, until then.
+	Status string
+
+	// Message holds the error reported by ARM when the operation failed.
+	Message string
+
+	// Started is the time at which the request starting the operation was sent.
+	Started time.Time
+
+	// Polls counts the requests sent to URL.
+	Polls int
+
+	client     autorest.Client
+	body       []byte
+	retryAfter time.Duration
+}
+
+// StartOperation sends a request that starts a long-running operation, using the authorizer and
+// inspectors of the passed client, but doesn't wait for the operation to finish. Requests that
+// complete right away return an Operation whose Status is already final.
+func StartOperation(client autorest.Client, req *http.Request) (*Operation, error) {
+	op := &Operation{client: client, Started: time.Now(), Status: OperationInProgress}
+
+	resp, err := op.send(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusAccepted, http.StatusNoContent:
+	default:
+		return nil, fmt.Errorf("ERROR: %s %s failed: %s", req.Method, req.URL.Path, op.Message)
+	}
+
+	if u := resp.Header.Get("Azure-AsyncOperation"); u != "" {
+		op.URL, op.Async = u, true
+	} else if u := resp.Header.Get("Location"); u != "" {
+		op.URL = u
+	} else if resp.StatusCode == http.StatusAccepted {
+		return nil, fmt.Errorf("ERROR: %s %s was accepted without a URL at which to follow the operation", req.Method, req.URL.Path)
+	} else {
+		op.Status = OperationSucceeded
+	}
+	return op, nil
+}
+
+// Done reports whether the operation has reached a final state: Succeeded, Failed or Canceled.
+// Resource providers report other states while the operation is in progress, such as Running,
+// Accepted or Deleting, besides InProgress.
+func (op *Operation) Done() bool {
+	for _, s := range []string{OperationSucceeded, OperationFailed, OperationCanceled} {
+		if strings.EqualFold(op.Status, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Elapsed returns the time since the operation was started.
+func (op *Operation) Elapsed() time.Duration {
+	return time.Since(op.Started)
+}
+
+// Decode unmarshals the body of the last response received for the operation. Operations that
+// produce a result, such as exporting a template, return it with the response that completes
+// them, either right away or when polled through the Location header.
+func (op *Operation) Decode(v interface{}) error {
+	if err := json.Unmarshal(op.body, v); err != nil {
+		return fmt.Errorf("ERROR: Unable to decode the result of operation '%s' (%v)", op.URL, err)
+	}
+	return nil
+}
+
+// Poll asks ARM for the state of the operation once, and updates Status and Message.
+func (op *Operation) Poll() error {
+	if op.Done() {
+		return nil
+	}
+
+	req, err := http.NewRequest("GET", op.URL, nil)
+	if err != nil {
+		return fmt.Errorf("ERROR: Invalid operation URL '%s' (%v)", op.URL, err)
+	}
+	resp, err := op.send(req)
+	if err != nil {
+		return err
+	}
+	op.Polls++
+
+	if op.Async {
+		var doc struct {
+			Status string `json:"status"`
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("ERROR: Polling operation '%s' failed with status %d: %s", op.URL, resp.StatusCode, op.Message)
+		}
+		if err := json.Unmarshal(op.body, &doc); err != nil || doc.Status == "" {
+			return fmt.Errorf("ERROR: The status of operation '%s' is missing", op.URL)
+		}
+		op.Status = doc.Status
+		return nil
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusAccepted:
+		if u := resp.Header.Get("Location"); u != "" {
+			op.URL = u
+		}
+	case resp.StatusCode < 300:
+		op.Status = OperationSucceeded
+	default:
+		op.Status = OperationFailed
+	}
+	return nil
+}
+
+// send sends a request with the client's authorization and inspectors, without the polling the
+// SDK would do, and records the Retry-After header and any error reported in the body.
+func (op *Operation) send(req *http.Request) (*http.Response, error) {
+	req, err := autorest.Prepare(req,
+		op.client.WithAuthorization(),
+		op.client.WithInspection())
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: Unable to prepare request to '%s' (%v)", req.URL, err)
+	}
+
+	resp, err := autorest.SendWithSender(op.client, req)
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: Request to '%s' failed (%v)", req.URL, err)
+	}
+
+	err = autorest.Respond(resp, op.client.ByInspecting())
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	op.body, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: Unable to read the response from '%s' (%v)", req.URL, err)
+	}
+
+	op.Message = ""
+	op.retryAfter = 0
+	if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 {
+		op.retryAfter = time.Duration(s) * time.Second
+	}
+
+	var doc struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(op.body, &doc) == nil && doc.Error.Code != "" {
+		op.Message = fmt.Sprintf("%s: %s", doc.Error.Code, doc.Error.Message)
+	} else if resp.StatusCode >= 300 {
+		op.Message = resp.Status
+	}
+	return resp, nil
+}
+
+// OperationPoller waits for long-running operations, backing off between polls.
+type OperationPoller struct {
+	// Delay is the wait before the first poll. It doubles after each poll, up to MaxDelay. When
+	// ARM asks for a longer wait with a Retry-After header, that is used instead.
+	Delay    time.Duration
+	MaxDelay time.Duration
+
+	// Timeout limits the time spent waiting for an operation, counted from its start. Zero means
+	// no limit.
+	Timeout time.Duration
+
+	// Progress, when set, is called after each poll.
+	Progress func(op *Operation)
+}
+
+// DefaultOperationPoller polls after 2 seconds, then backs off to at most a poll every 30
+// seconds, and gives up after an hour.
+var DefaultOperationPoller = OperationPoller{Delay: 2 * time.Second, MaxDelay: 30 * time.Second, Timeout: time.Hour}
+
+// Wait polls an operation until it reaches a final state. It returns an error when the operation
+// failed or was canceled, when it can't be polled, or when it doesn't finish within the timeout;
+// the operation continues in Azure in that case.
+func (p OperationPoller) Wait(op *Operation) error {
+	delay := p.Delay
+	if delay <= 0 {
+		delay = DefaultOperationPoller.Delay
+	}
+
+	for !op.Done() {
+		wait := delay
+		if op.retryAfter > wait {
+			wait = op.retryAfter
+		}
+		if p.Timeout > 0 && op.Elapsed()+wait > p.Timeout {
+			return fmt.Errorf("ERROR: The operation didn't finish within %v and is still %s; follow it at '%s'", p.Timeout, op.Status, op.URL)
+		}
+		time.Sleep(wait)
+
+		if err := op.Poll(); err != nil {
+			return err
+		}
+		if p.Progress != nil {
+			p.Progress(op)
+		}
+
+		delay *= 2
+		if p.MaxDelay > 0 && delay > p.MaxDelay {
+			delay = p.MaxDelay
+		}
+	}
+
+	switch {
+	case strings.EqualFold(op.Status, OperationSucceeded):
+		return nil
+	case strings.EqualFold(op.Status, OperationFailed):
+		return fmt.Errorf("ERROR: The operation failed after %.0f seconds: %s", op.Elapsed().Seconds(), op.Message)
+	}
+	return fmt.Errorf("ERROR: The operation ended with status '%s' after %.0f seconds", op.Status, op.Elapsed().Seconds())
+}
@@ -0,0 +1,152 @@
+package helpers_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-go-samples/fakes/armfake"
+	"github.com/Azure/azure-go-samples/helpers"
+	"github.com/Azure/azure-sdk-for-go/Godeps/_workspace/src/github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/azure-sdk-for-go/Godeps/_workspace/src/github.com/Azure/go-autorest/autorest/to"
+	"github.com/Azure/azure-sdk-for-go/arm/resources/resources"
+)
+
+// startVirtualNetwork starts creating a virtual network, an operation ARM reports through the
+// Azure-AsyncOperation header.
+func startVirtualNetwork(t *testing.T, srv *armfake.Server, name string) *helpers.Operation {
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+	if _, err := client.ResourceGroups().CreateOrUpdate("operations", resources.ResourceGroup{Location: to.StringPtr("West US")}); err != nil {
+		t.Fatalf("unable to create the group: %v", err)
+	}
+
+	body := `{"location": "West US", "properties": {"addressSpace": {"addressPrefixes": ["10.0.0.0/16"]}}}`
+	req, err := http.NewRequest("PUT", srv.URL+"/subscriptions/"+srv.SubscriptionID+"/resourceGroups/operations/providers/Microsoft.Network/virtualNetworks/"+name+"?api-version=2015-05-01-preview", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	op, err := helpers.StartOperation(autorest.Client{}, req)
+	if err != nil {
+		t.Fatalf("StartOperation failed: %v", err)
+	}
+	if !op.Async || op.Done() {
+		t.Fatalf("expected an asynchronous operation in progress, got %+v", op)
+	}
+	return op
+}
+
+func TestOperationPollerAsync(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	srv.PollCount = 2
+
+	op := startVirtualNetwork(t, srv, "operationstest")
+
+	var delays []time.Duration
+	last := time.Now()
+	poller := helpers.OperationPoller{Delay: 5 * time.Millisecond, MaxDelay: 10 * time.Millisecond}
+	poller.Progress = func(op *helpers.Operation) {
+		delays = append(delays, time.Since(last))
+		last = time.Now()
+	}
+
+	if err := poller.Wait(op); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+	if op.Status != helpers.OperationSucceeded || op.Polls != 3 {
+		t.Errorf("expected the operation to succeed after 3 polls, got %s after %d", op.Status, op.Polls)
+	}
+	// The delays double from 5ms and are capped at 10ms.
+	for i, min := range []time.Duration{5, 10, 10} {
+		if delays[i] < min*time.Millisecond {
+			t.Errorf("poll %d came after %v, expected at least %dms", i+1, delays[i], min)
+		}
+	}
+}
+
+func TestOperationPollerRunning(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	srv.PollCount = 2
+	srv.RunningStatus = "Running"
+
+	op := startVirtualNetwork(t, srv, "operationstest")
+
+	var statuses []string
+	poller := helpers.OperationPoller{Delay: time.Millisecond}
+	poller.Progress = func(op *helpers.Operation) {
+		statuses = append(statuses, op.Status)
+	}
+	if err := poller.Wait(op); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+	if strings.Join(statuses, " ") != "Running Running Succeeded" {
+		t.Errorf("expected the operation to be polled until it succeeded, got %v", statuses)
+	}
+
+	for status, done := range map[string]bool{"Accepted": false, "Deleting": false, "InProgress": false, "succeeded": true, "FAILED": true, "Canceled": true} {
+		if (&helpers.Operation{Status: status}).Done() != done {
+			t.Errorf("expected Done to be %v for %s", done, status)
+		}
+	}
+}
+
+func TestOperationPollerAsyncFailure(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	srv.Fail("PUT", "/virtualNetworks/", 400, "InvalidAddressPrefix", "The address prefix is invalid.", false)
+
+	op := startVirtualNetwork(t, srv, "operationstest")
+
+	err := helpers.OperationPoller{Delay: time.Millisecond}.Wait(op)
+	if err == nil || op.Status != helpers.OperationFailed || !strings.Contains(err.Error(), "InvalidAddressPrefix: The address prefix is invalid.") {
+		t.Errorf("expected the operation to fail, got %v", err)
+	}
+}
+
+func TestOperationDecode(t *testing.T) {
+	srv := armfake.NewServer("")
+	defer srv.Close()
+	srv.PollCount = 2
+
+	client := helpers.ARMClientForEndpoint(srv.SubscriptionID, srv.URL)
+	if _, err := client.ResourceGroups().CreateOrUpdate("operations", resources.ResourceGroup{Location: to.StringPtr("West US")}); err != nil {
+		t.Fatalf("unable to create the group: %v", err)
+	}
+
+	// Creating a storage account is followed through the Location header, whose last response
+	// holds the account.
+	body := `{"location": "West US", "properties": {"accountType": "Standard_LRS"}}`
+	req, err := http.NewRequest("PUT", srv.URL+"/subscriptions/"+srv.SubscriptionID+"/resourceGroups/operations/providers/Microsoft.Storage/storageAccounts/operationstest?api-version=2015-05-01-preview", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	op, err := helpers.StartOperation(autorest.Client{}, req)
+	if err != nil {
+		t.Fatalf("StartOperation failed: %v", err)
+	}
+	if op.Async || op.Done() {
+		t.Fatalf("expected a Location operation in progress, got %+v", op)
+	}
+	if err := (helpers.OperationPoller{Delay: time.Millisecond}).Wait(op); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+
+	var account struct {
+		Name       string `json:"name"`
+		Properties struct {
+			ProvisioningState string `json:"provisioningState"`
+		} `json:"properties"`
+	}
+	if err := op.Decode(&account); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if account.Name != "operationstest" || account.Properties.ProvisioningState != "Succeeded" {
+		t.Errorf("unexpected result %+v", account)
+	}
+}
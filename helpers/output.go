@@ -0,0 +1,627 @@
+package helpers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// The result formats supported by Output.
+const (
+	FormatJSON  = "json"
+	FormatYAML  = "yaml"
+	FormatTable = "table"
+	FormatTSV   = "tsv"
+	FormatCSV   = "csv"
+)
+
+// Output prints the results of a sample, such as resources, blob properties or page ranges, in
+// a format chosen by the user, so that they can be piped into scripts. Progress and error messages
+// belong on standard error, see Logf.
+type Output struct {
+	// Format is one of "json", "yaml", "table", "tsv" or "csv".
+	Format string
+
+	// Query selects the parts of a result to print, using a subset of JMESPath:
+	//
+	//	properties.provisioningState     fields, separated by dots
+	//	value[0]                         array elements, negative indices count from the end
+	//	value[*].name                    projections over all the elements of an array
+	//	value[].tags[]                   flattening projections, which merge nested arrays into
+	//	                                 one, and end the projection on their left
+	//	[].{name: name, state: properties.provisioningState}
+	//	                                 multi-select, building a new object
+	Query string
+
+	// Writer receives the results. When nil, standard output is used.
+	Writer io.Writer
+}
+
+// OutputFlags registers the common --output and --query flags with the passed flag set, or with
+// the program's command line if it is nil, and returns the Output they configure. The flags must
+// be parsed before the Output is used.
+func OutputFlags(fs *flag.FlagSet) *Output {
+	if fs == nil {
+		fs = flag.CommandLine
+	}
+	o := &Output{}
+	fs.StringVar(&o.Format, "output", FormatJSON, "result format: json, yaml, table, tsv or csv")
+	fs.StringVar(&o.Query, "query", "", "JMESPath-like query selecting the fields to print, e.g. 'value[].{name: name, location: location}'")
+	return o
+}
+
+// Logf prints progress and error messages to standard error, which keeps standard output free
+// for the results printed through Output.
+func Logf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format, args...)
+}
+
+// Print writes the passed value in the configured format, after applying the query to it.
+func (o *Output) Print(v interface{}) error {
+	w := o.Writer
+	if w == nil {
+		w = os.Stdout
+	}
+
+	doc, err := toDocument(v)
+	if err != nil {
+		return fmt.Errorf("ERROR: Unable to convert result for output (%v)", err)
+	}
+
+	if o.Query != "" {
+		steps, err := parseQuery(o.Query)
+		if err != nil {
+			return err
+		}
+		doc = evalQuery(doc, steps)
+	}
+
+	switch strings.ToLower(o.Format) {
+	case FormatJSON, "":
+		b, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return fmt.Errorf("ERROR: Unable to format result as JSON (%v)", err)
+		}
+		_, err = fmt.Fprintf(w, "%s\n", b)
+		return err
+	case FormatYAML:
+		_, err := io.WriteString(w, strings.Join(yamlLines(doc), "\n")+"\n")
+		return err
+	case FormatTable:
+		return writeRows(w, doc, true)
+	case FormatTSV:
+		return writeRows(w, doc, false)
+	case FormatCSV:
+		return writeCSV(w, doc)
+	}
+
+	return fmt.Errorf("ERROR: Unknown output format '%s', use json, yaml, table, tsv or csv", o.Format)
+}
+
+// record is a JSON object that remembers the order of its keys, so that fields are printed in
+// the order the SDK declares them, or the order a multi-select query names them.
+type record struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newRecord() *record {
+	return &record{values: map[string]interface{}{}}
+}
+
+func (r *record) set(key string, value interface{}) {
+	if _, ok := r.values[key]; !ok {
+		r.keys = append(r.keys, key)
+	}
+	r.values[key] = value
+}
+
+func (r *record) get(key string) (interface{}, bool) {
+	if v, ok := r.values[key]; ok {
+		return v, true
+	}
+	for _, k := range r.keys {
+		if strings.EqualFold(k, key) {
+			return r.values[k], true
+		}
+	}
+	return nil, false
+}
+
+func (r *record) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range r.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		kb, _ := json.Marshal(k)
+		vb, err := json.Marshal(r.values[k])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(kb)
+		buf.WriteByte(':')
+		buf.Write(vb)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// toDocument turns any value into a tree of records, []interface{} and scalars by way of its JSON
+// encoding, so that the output honors the same field names and omissions as the REST API.
+func toDocument(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	return decodeValue(dec)
+}
+
+func decodeValue(dec *json.Decoder) (interface{}, error) {
+	t, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t {
+	case json.Delim('{'):
+		r := newRecord()
+		for dec.More() {
+			kt, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			r.set(kt.(string), v)
+		}
+		_, err = dec.Token()
+		return r, err
+	case json.Delim('['):
+		a := []interface{}{}
+		for dec.More() {
+			v, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, v)
+		}
+		_, err = dec.Token()
+		return a, err
+	}
+	return t, nil
+}
+
+const (
+	stepField = iota
+	stepIndex
+	stepProject
+	stepFlatten
+	stepSelect
+)
+
+type queryStep struct {
+	kind  int
+	name  string
+	index int
+	keys  []string
+	exprs [][]queryStep
+}
+
+func parseQuery(q string) ([]queryStep, error) {
+	p := &queryParser{q: q}
+	steps, err := p.parse(false)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(q) {
+		return nil, p.errorf("unexpected '%c'", q[p.pos])
+	}
+	return steps, nil
+}
+
+type queryParser struct {
+	q   string
+	pos int
+}
+
+func (p *queryParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("ERROR: Invalid query '%s' at position %d: %s", p.q, p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *queryParser) skipSpace() {
+	for p.pos < len(p.q) && p.q[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *queryParser) ident() string {
+	start := p.pos
+	for p.pos < len(p.q) {
+		c := p.q[p.pos]
+		if c == '_' || c == '-' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' {
+			p.pos++
+			continue
+		}
+		break
+	}
+	return p.q[start:p.pos]
+}
+
+// parse reads a sequence of steps. Inside a multi-select, it stops at the ',' or '}' ending
+// the current expression.
+func (p *queryParser) parse(nested bool) ([]queryStep, error) {
+	var steps []queryStep
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.q) {
+			return steps, nil
+		}
+		c := p.q[p.pos]
+		switch {
+		case nested && (c == ',' || c == '}'):
+			return steps, nil
+		case c == '.' && len(steps) > 0:
+			p.pos++
+		case c == '[':
+			p.pos++
+			end := strings.IndexByte(p.q[p.pos:], ']')
+			if end < 0 {
+				return nil, p.errorf("missing ']'")
+			}
+			inner := strings.TrimSpace(p.q[p.pos : p.pos+end])
+			p.pos += end + 1
+			if inner == "" {
+				steps = append(steps, queryStep{kind: stepFlatten})
+				continue
+			}
+			if inner == "*" {
+				steps = append(steps, queryStep{kind: stepProject})
+				continue
+			}
+			n, err := strconv.Atoi(inner)
+			if err != nil {
+				return nil, p.errorf("invalid index '%s'", inner)
+			}
+			steps = append(steps, queryStep{kind: stepIndex, index: n})
+		case c == '{':
+			p.pos++
+			s := queryStep{kind: stepSelect}
+			for {
+				p.skipSpace()
+				key := p.ident()
+				if key == "" {
+					return nil, p.errorf("expected a key")
+				}
+				p.skipSpace()
+				if p.pos >= len(p.q) || p.q[p.pos] != ':' {
+					return nil, p.errorf("expected ':' after '%s'", key)
+				}
+				p.pos++
+				expr, err := p.parse(true)
+				if err != nil {
+					return nil, err
+				}
+				if len(expr) == 0 {
+					return nil, p.errorf("missing expression for '%s'", key)
+				}
+				s.keys = append(s.keys, key)
+				s.exprs = append(s.exprs, expr)
+				if p.pos >= len(p.q) {
+					return nil, p.errorf("missing '}'")
+				}
+				p.pos++
+				if p.q[p.pos-1] == '}' {
+					break
+				}
+			}
+			steps = append(steps, s)
+		default:
+			name := p.ident()
+			if name == "" {
+				return nil, p.errorf("unexpected '%c'", c)
+			}
+			steps = append(steps, queryStep{kind: stepField, name: name})
+		}
+	}
+}
+
+func evalQuery(v interface{}, steps []queryStep) interface{} {
+	for i := 0; i < len(steps); i++ {
+		s := steps[i]
+		if v == nil {
+			return nil
+		}
+		switch s.kind {
+		case stepField:
+			r, ok := v.(*record)
+			if !ok {
+				return nil
+			}
+			v, _ = r.get(s.name)
+		case stepIndex:
+			a, ok := v.([]interface{})
+			if !ok {
+				return nil
+			}
+			n := s.index
+			if n < 0 {
+				n += len(a)
+			}
+			if n < 0 || n >= len(a) {
+				return nil
+			}
+			v = a[n]
+		case stepProject, stepFlatten:
+			a, ok := v.([]interface{})
+			if !ok {
+				return nil
+			}
+			if s.kind == stepFlatten {
+				a = flatten(a)
+			}
+			// The projection applies the steps up to the next flattening, which then applies
+			// to the projected array as a whole, as in JMESPath.
+			end := len(steps)
+			for j := i + 1; j < len(steps); j++ {
+				if steps[j].kind == stepFlatten {
+					end = j
+					break
+				}
+			}
+			result := []interface{}{}
+			for _, e := range a {
+				if r := evalQuery(e, steps[i+1:end]); r != nil {
+					result = append(result, r)
+				}
+			}
+			v = result
+			i = end - 1
+		case stepSelect:
+			r := newRecord()
+			for j, k := range s.keys {
+				r.set(k, evalQuery(v, s.exprs[j]))
+			}
+			v = r
+		}
+	}
+	return v
+}
+
+// flatten merges the elements of an array that are arrays themselves into it, one level deep.
+func flatten(a []interface{}) []interface{} {
+	result := []interface{}{}
+	for _, e := range a {
+		if nested, ok := e.([]interface{}); ok {
+			result = append(result, nested...)
+			continue
+		}
+		result = append(result, e)
+	}
+	return result
+}
+
+func isScalar(v interface{}) bool {
+	switch x := v.(type) {
+	case *record:
+		return len(x.keys) == 0
+	case []interface{}:
+		return len(x) == 0
+	}
+	return true
+}
+
+func scalarText(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case json.Number:
+		return x.String()
+	case bool:
+		return strconv.FormatBool(x)
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func yamlLines(v interface{}) []string {
+	switch x := v.(type) {
+	case *record:
+		if len(x.keys) == 0 {
+			return []string{"{}"}
+		}
+		var lines []string
+		for _, k := range x.keys {
+			child := x.values[k]
+			if isScalar(child) {
+				lines = append(lines, yamlKey(k)+": "+yamlScalar(child))
+				continue
+			}
+			lines = append(lines, yamlKey(k)+":")
+			for _, l := range yamlLines(child) {
+				lines = append(lines, "  "+l)
+			}
+		}
+		return lines
+	case []interface{}:
+		if len(x) == 0 {
+			return []string{"[]"}
+		}
+		var lines []string
+		for _, e := range x {
+			for i, l := range yamlLines(e) {
+				if i == 0 {
+					lines = append(lines, "- "+l)
+				} else {
+					lines = append(lines, "  "+l)
+				}
+			}
+		}
+		return lines
+	}
+	return []string{yamlScalar(v)}
+}
+
+func yamlKey(k string) string {
+	if k == "" || strings.ContainsAny(k, ":#{}[],&*?|<>=!%@`'\" \t\n") {
+		return strconv.Quote(k)
+	}
+	return k
+}
+
+func yamlScalar(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return yamlString(x)
+	case *record:
+		return "{}"
+	case []interface{}:
+		return "[]"
+	}
+	return scalarText(v)
+}
+
+func yamlString(s string) string {
+	switch strings.ToLower(s) {
+	case "", "null", "~", "true", "false", "yes", "no", "on", "off":
+		return strconv.Quote(s)
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return strconv.Quote(s)
+	}
+	if strings.ContainsAny(s, ":#\n\t\"'\\") || strings.ContainsAny(s[:1], "-?{}[],&*!|>%@` ") || strings.HasSuffix(s, " ") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// writeRows prints a result as rows of tab-separated columns, one row per array element. Nested
+// objects are flattened into dotted column names, and nested arrays are printed as JSON. ARM list
+// results are printed as one row per element of their 'value' array.
+func writeRows(w io.Writer, v interface{}, header bool) error {
+	columns, rows := tabulate(v)
+
+	if header {
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		if len(columns) > 0 {
+			fmt.Fprintln(tw, strings.Join(columns, "\t"))
+			dashes := make([]string, len(columns))
+			for i, c := range columns {
+				dashes[i] = strings.Repeat("-", len(c))
+			}
+			fmt.Fprintln(tw, strings.Join(dashes, "\t"))
+		}
+		for _, row := range rows {
+			fmt.Fprintln(tw, strings.Join(rowValues(row, columns, true), "\t"))
+		}
+		return tw.Flush()
+	}
+
+	for _, row := range rows {
+		if _, err := fmt.Fprintln(w, strings.Join(rowValues(row, columns, false), "\t")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeCSV prints a result as comma-separated values with a header row, flattened the same way as
+// by writeRows.
+func writeCSV(w io.Writer, v interface{}) error {
+	columns, rows := tabulate(v)
+
+	cw := csv.NewWriter(w)
+	if len(columns) > 0 {
+		cw.Write(columns)
+	}
+	for _, row := range rows {
+		values := make([]string, len(columns))
+		for i, c := range columns {
+			values[i] = row[c]
+		}
+		cw.Write(values)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// tabulate turns a result into rows, one per array element, and the union of their columns in
+// the order they first appear.
+func tabulate(v interface{}) ([]string, []map[string]string) {
+	if r, ok := v.(*record); ok {
+
+		if a, ok := r.values["value"].([]interface{}); ok {
+			v = a
+		}
+	}
+
+	var items []interface{}
+	if a, ok := v.([]interface{}); ok {
+		items = a
+	} else if v != nil {
+		items = []interface{}{v}
+	}
+
+	var columns []string
+	seen := map[string]bool{}
+	rows := make([]map[string]string, len(items))
+
+	for i, item := range items {
+		rows[i] = map[string]string{}
+		if r, ok := item.(*record); ok {
+			flattenRecord("", r, rows[i], func(c string) {
+				if !seen[c] {
+					seen[c] = true
+					columns = append(columns, c)
+				}
+			})
+			continue
+		}
+		if !seen["value"] {
+			seen["value"] = true
+			columns = append(columns, "value")
+		}
+		rows[i]["value"] = scalarText(item)
+	}
+
+	return columns, rows
+}
+
+func flattenRecord(prefix string, r *record, row map[string]string, column func(string)) {
+	for _, k := range r.keys {
+		name := prefix + k
+		if child, ok := r.values[k].(*record); ok && len(child.keys) > 0 {
+			flattenRecord(name+".", child, row, column)
+			continue
+		}
+		column(name)
+		row[name] = scalarText(r.values[k])
+	}
+}
+
+func rowValues(row map[string]string, columns []string, table bool) []string {
+	values := make([]string, len(columns))
+	for i, c := range columns {
+		s := strings.NewReplacer("\t", " ", "\n", " ").Replace(row[c])
+		if table && s == "" {
+			s = "-"
+		}
+		values[i] = s
+	}
+	return values
+}
@@ -0,0 +1,177 @@
+package helpers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/Azure/azure-go-samples/helpers"
+)
+
+func TestOutputCSV(t *testing.T) {
+	type item struct {
+		Name string            `json:"name"`
+		Tags map[string]string `json:"tags,omitempty"`
+	}
+	items := []item{
+		{Name: "vm001", Tags: map[string]string{"env": "test"}},
+		{Name: "nic01, primary"},
+		{Name: "ip01", Tags: map[string]string{"env": "prod", "owner": "web"}},
+	}
+
+	var b bytes.Buffer
+	o := helpers.Output{Format: helpers.FormatCSV, Writer: &b}
+	if err := o.Print(items); err != nil {
+		t.Fatalf("Print failed: %v", err)
+	}
+
+	expected := "name,tags.env,tags.owner\nvm001,test,\n\"nic01, primary\",,\nip01,prod,web\n"
+	if b.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, b.String())
+	}
+}
+
+type outputProperties struct {
+	State string `json:"provisioningState"`
+	Size  int    `json:"size"`
+}
+
+type outputResource struct {
+	Name       string           `json:"name"`
+	Location   string           `json:"location"`
+	Properties outputProperties `json:"properties"`
+	Tags       []string         `json:"tags,omitempty"`
+}
+
+// outputList is shaped like the results of ARM list operations.
+var outputList = struct {
+	Value []outputResource `json:"value"`
+}{[]outputResource{
+	{"vm001", "westus", outputProperties{"Succeeded", 2}, []string{"web"}},
+	{"nic01", "westus", outputProperties{"Updating", 0}, nil},
+	{"ip01", "eastus", outputProperties{"Failed", 1}, []string{"public", "web"}},
+}}
+
+func TestOutputQuery(t *testing.T) {
+	for _, c := range []struct {
+		query    string
+		expected string
+	}{
+		{"value[0].name", `"vm001"`},
+		{"value[-1].location", `"eastus"`},
+		{"value[3].name", `null`},
+		{"value[0].properties.size", `2`},
+		{"VALUE[1].Properties.provisioningState", `"Updating"`},
+		{"value.name", `null`},
+		{"value[].name", `["vm001","nic01","ip01"]`},
+		{"value[*].properties.provisioningState", `["Succeeded","Updating","Failed"]`},
+		{"value[].tags[0]", `["web","public"]`},
+		{"value[].tags[]", `["web","public","web"]`},
+		{"value[*].tags[*]", `[["web"],["public","web"]]`},
+		{"value[*].tags[]", `["web","public","web"]`},
+		{"value[].tags[][0]", `[]`},
+		{"value[1].{name: name, state: properties.provisioningState}", `{"name":"nic01","state":"Updating"}`},
+		{"value[].{ state: properties.provisioningState , n: name }", `[{"state":"Succeeded","n":"vm001"},{"state":"Updating","n":"nic01"},{"state":"Failed","n":"ip01"}]`},
+	} {
+		var b bytes.Buffer
+		o := helpers.Output{Query: c.query, Writer: &b}
+		if err := o.Print(outputList); err != nil {
+			t.Errorf("%s: Print failed: %v", c.query, err)
+			continue
+		}
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, b.Bytes()); err != nil {
+			t.Errorf("%s: invalid JSON %s", c.query, b.String())
+			continue
+		}
+		if compact.String() != c.expected {
+			t.Errorf("%s: expected %s, got %s", c.query, c.expected, compact.String())
+		}
+	}
+}
+
+func TestOutputQueryErrors(t *testing.T) {
+	for _, c := range []struct {
+		query    string
+		expected string
+	}{
+		{"value[", "ERROR: Invalid query 'value[' at position 6: missing ']'"},
+		{"value[x]", "ERROR: Invalid query 'value[x]' at position 8: invalid index 'x'"},
+		{"value[].{name}", "ERROR: Invalid query 'value[].{name}' at position 13: expected ':' after 'name'"},
+		{"{: name}", "ERROR: Invalid query '{: name}' at position 1: expected a key"},
+		{"{a: }", "ERROR: Invalid query '{a: }' at position 4: missing expression for 'a'"},
+		{"{a: name", "ERROR: Invalid query '{a: name' at position 8: missing '}'"},
+		{"name!", "ERROR: Invalid query 'name!' at position 4: unexpected '!'"},
+		{"value[0]}", "ERROR: Invalid query 'value[0]}' at position 8: unexpected '}'"},
+	} {
+		o := helpers.Output{Query: c.query, Writer: &bytes.Buffer{}}
+		if err := o.Print(outputList); err == nil || err.Error() != c.expected {
+			t.Errorf("expected '%s', got %v", c.expected, err)
+		}
+	}
+}
+
+func TestOutputFormats(t *testing.T) {
+	for _, c := range []struct {
+		format   string
+		query    string
+		expected string
+	}{
+		{helpers.FormatJSON, "value[1]", `{
+  "name": "nic01",
+  "location": "westus",
+  "properties": {
+    "provisioningState": "Updating",
+    "size": 0
+  }
+}
+`},
+		{helpers.FormatYAML, "", `value:
+  - name: vm001
+    location: westus
+    properties:
+      provisioningState: Succeeded
+      size: 2
+    tags:
+      - web
+  - name: nic01
+    location: westus
+    properties:
+      provisioningState: Updating
+      size: 0
+  - name: ip01
+    location: eastus
+    properties:
+      provisioningState: Failed
+      size: 1
+    tags:
+      - public
+      - web
+`},
+		{helpers.FormatYAML, "value[0].{name: name, empty: tags[5], size: properties.size}", "name: vm001\nempty: null\nsize: 2\n"},
+		{helpers.FormatTable, "", `name   location  properties.provisioningState  properties.size  tags
+----   --------  ----------------------------  ---------------  ----
+vm001  westus    Succeeded                     2                ["web"]
+nic01  westus    Updating                      0                -
+ip01   eastus    Failed                        1                ["public","web"]
+`},
+		{helpers.FormatTSV, "value[].{name: name, state: properties.provisioningState}", "vm001\tSucceeded\nnic01\tUpdating\nip01\tFailed\n"},
+		{helpers.FormatTSV, "value[].name", "vm001\nnic01\nip01\n"},
+		{"TABLE", "value[0].{name: name}", "name\n----\nvm001\n"},
+	} {
+		var b bytes.Buffer
+		o := helpers.Output{Format: c.format, Query: c.query, Writer: &b}
+		if err := o.Print(outputList); err != nil {
+			t.Errorf("%s %s: Print failed: %v", c.format, c.query, err)
+			continue
+		}
+		if b.String() != c.expected {
+			t.Errorf("%s %s: expected:\n%s\ngot:\n%s", c.format, c.query, c.expected, b.String())
+		}
+	}
+
+	o := helpers.Output{Format: "xml", Writer: &bytes.Buffer{}}
+	if err := o.Print(outputList); err == nil || err.Error() != "ERROR: Unknown output format 'xml', use json, yaml, table, tsv or csv" {
+		t.Errorf("expected the format to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,243 @@
+package helpers
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SeedVariable is the environment variable consulted for a seed when a RandomSource is created
+// without one, which makes it possible to replay a failed run without changing any code.
+const SeedVariable = "AZURE_SAMPLES_SEED"
+
+// RandomSource produces pseudo-random test data from a seed. Unlike RandBytes and RandString,
+// the data is the same every time the same seed is used, so failures can be reproduced.
+type RandomSource struct {
+	seed int64
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// NewRandomSource creates a RandomSource from the passed seed. When the seed is zero, the value
+// of the AZURE_SAMPLES_SEED environment variable is used, and if that isn't set either, the
+// current time. Seed returns the value actually used.
+func NewRandomSource(seed int64) *RandomSource {
+	if seed == 0 {
+		if s, err := strconv.ParseInt(os.Getenv(SeedVariable), 10, 64); err == nil {
+			seed = s
+		}
+	}
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &RandomSource{seed: seed, rnd: rand.New(rand.NewSource(seed))}
+}
+
+// Seed returns the seed the source was created with.
+func (s *RandomSource) Seed() int64 {
+	return s.seed
+}
+
+// Annotate adds the seed to an error, so that the data which caused it can be regenerated.
+func (s *RandomSource) Annotate(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := strings.TrimRight(err.Error(), "\n")
+	return fmt.Errorf("%s (random seed %d, set %s=%d to reproduce)", msg, s.seed, SeedVariable, s.seed)
+}
+
+// Intn returns a uniformly distributed number in [0,n).
+func (s *RandomSource) Intn(n int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rnd.Intn(n)
+}
+
+// Bytes creates a byte array with length 'n' and fills it with readable characters, just like
+// RandBytes, but drawn from the seeded source.
+func (s *RandomSource) Bytes(n int) []byte {
+	if n <= 0 {
+		panic("negative number")
+	}
+	return s.sample(n, alphanumeric)
+}
+
+// String generates a string containing only lower-case letters and numbers, just like RandString,
+// but drawn from the seeded source.
+func (s *RandomSource) String(n int) string {
+	if n <= 0 {
+		panic("negative number")
+	}
+	return string(s.sample(n, lowerAlphanumeric))
+}
+
+func (s *RandomSource) sample(n int, alphabet string) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bytes := make([]byte, n)
+	for i := range bytes {
+		bytes[i] = alphabet[s.rnd.Intn(len(alphabet))]
+	}
+	return bytes
+}
+
+// Reader returns a DataReader producing 'size' bytes, seeded from this source. Subsequent calls
+// return readers with different contents.
+func (s *RandomSource) Reader(size int64) *DataReader {
+	s.mu.Lock()
+	seed := s.rnd.Int63()
+	s.mu.Unlock()
+	return NewDataReader(seed, size)
+}
+
+// The alphabet used by data readers has 64 characters, so that each character can be taken from
+// six bits of a random number without favoring any of them.
+const streamAlphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ-_"
+
+// DataReader is an io.Reader that generates an arbitrarily large, deterministic payload of readable
+// characters from a seed, computing the MD5 checksum of everything read so far. It never holds more
+// than a few bytes of the payload in memory.
+type DataReader struct {
+	seed int64
+	size int64
+	read int64
+
+	rnd  *rand.Rand
+	bits int64
+	left uint
+	sum  hash.Hash
+}
+
+// NewDataReader creates a DataReader that produces 'size' bytes generated from 'seed'.
+func NewDataReader(seed int64, size int64) *DataReader {
+	return &DataReader{
+		seed: seed,
+		size: size,
+		rnd:  rand.New(rand.NewSource(seed)),
+		sum:  md5.New(),
+	}
+}
+
+// Read implements io.Reader. The payload doesn't depend on the size of the buffers passed in.
+func (r *DataReader) Read(p []byte) (int, error) {
+	if r.read >= r.size {
+		return 0, io.EOF
+	}
+	if rem := r.size - r.read; int64(len(p)) > rem {
+		p = p[:rem]
+	}
+	for i := range p {
+		p[i] = r.next()
+	}
+	r.read += int64(len(p))
+	r.sum.Write(p)
+	return len(p), nil
+}
+
+func (r *DataReader) next() byte {
+	if r.left == 0 {
+		r.bits = r.rnd.Int63()
+		r.left = 10
+	}
+	c := streamAlphabet[r.bits&63]
+	r.bits >>= 6
+	r.left--
+	return c
+}
+
+// Size returns the total number of bytes the reader produces.
+func (r *DataReader) Size() int64 {
+	return r.size
+}
+
+// Seed returns the seed the payload is generated from.
+func (r *DataReader) Seed() int64 {
+	return r.seed
+}
+
+// Sum returns the MD5 checksum of the bytes read so far. Once the reader is exhausted, it is the
+// checksum of the whole payload.
+func (r *DataReader) Sum() []byte {
+	return r.sum.Sum(nil)
+}
+
+// ContentMD5 returns Sum encoded the way Azure Storage reports it in the Content-MD5 property.
+func (r *DataReader) ContentMD5() string {
+	return base64.StdEncoding.EncodeToString(r.Sum())
+}
+
+// Verifier returns a DataVerifier for the data produced by a DataReader with the same seed and size.
+func (r *DataReader) Verifier() *DataVerifier {
+	return NewDataVerifier(r.seed, r.size)
+}
+
+// DataVerifier is an io.Writer that checks the data written to it, such as a downloaded blob,
+// against the payload a DataReader with the same seed produces.
+type DataVerifier struct {
+	expected *DataReader
+	written  int64
+	err      error
+	buf      []byte
+}
+
+// NewDataVerifier creates a DataVerifier expecting the 'size' bytes generated from 'seed'.
+func NewDataVerifier(seed int64, size int64) *DataVerifier {
+	return &DataVerifier{expected: NewDataReader(seed, size)}
+}
+
+// Write implements io.Writer. It fails at the first byte that doesn't match, or when more data is
+// written than expected.
+func (v *DataVerifier) Write(p []byte) (int, error) {
+	if v.err != nil {
+		return 0, v.err
+	}
+	if cap(v.buf) < len(p) {
+		v.buf = make([]byte, len(p))
+	}
+	want := v.buf[:len(p)]
+	n, _ := io.ReadFull(v.expected, want)
+	for i := 0; i < n; i++ {
+		if p[i] != want[i] {
+			v.err = fmt.Errorf("ERROR: Data differs at offset %d (seed %d)", v.written+int64(i), v.expected.seed)
+			return i, v.err
+		}
+	}
+	v.written += int64(n)
+	if n < len(p) {
+		v.err = fmt.Errorf("ERROR: Received more than the expected %d bytes (seed %d)", v.expected.size, v.expected.seed)
+		return n, v.err
+	}
+	return n, nil
+}
+
+// Verify returns an error if the data written was wrong or incomplete.
+func (v *DataVerifier) Verify() error {
+	if v.err != nil {
+		return v.err
+	}
+	if v.written != v.expected.size {
+		return fmt.Errorf("ERROR: Received %d bytes, expected %d (seed %d)", v.written, v.expected.size, v.expected.seed)
+	}
+	return nil
+}
+
+// Size returns the number of bytes the verifier expects.
+func (v *DataVerifier) Size() int64 {
+	return v.expected.size
+}
+
+// Sum returns the MD5 checksum of the data verified so far.
+func (v *DataVerifier) Sum() []byte {
+	return v.expected.Sum()
+}
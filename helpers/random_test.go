@@ -0,0 +1,118 @@
+package helpers_test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-go-samples/helpers"
+)
+
+func TestRandomSourceIsRepeatable(t *testing.T) {
+	a, b := helpers.NewRandomSource(42), helpers.NewRandomSource(42)
+	if x, y := a.Bytes(100), b.Bytes(100); !bytes.Equal(x, y) {
+		t.Errorf("the same seed produced different bytes:\n%s\n%s", x, y)
+	}
+	if x, y := a.String(20), b.String(20); x != y {
+		t.Errorf("the same seed produced different strings '%s' and '%s'", x, y)
+	}
+	if x, y := helpers.NewRandomSource(43).Bytes(100), helpers.NewRandomSource(42).Bytes(100); bytes.Equal(x, y) {
+		t.Errorf("different seeds produced the same bytes")
+	}
+
+	os.Setenv(helpers.SeedVariable, "1234")
+	defer os.Unsetenv(helpers.SeedVariable)
+	src := helpers.NewRandomSource(0)
+	if src.Seed() != 1234 {
+		t.Errorf("expected the seed from %s, got %d", helpers.SeedVariable, src.Seed())
+	}
+	err := src.Annotate(fmt.Errorf("ERROR: Failed\n"))
+	if err == nil || err.Error() != "ERROR: Failed (random seed 1234, set AZURE_SAMPLES_SEED=1234 to reproduce)" {
+		t.Errorf("unexpected annotation %v", err)
+	}
+}
+
+func TestDataReader(t *testing.T) {
+	const size = 10000
+	data, err := ioutil.ReadAll(helpers.NewDataReader(7, size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != size {
+		t.Fatalf("expected %d bytes, got %d", size, len(data))
+	}
+
+	// The payload doesn't depend on the size of the reads.
+	r := helpers.NewDataReader(7, size)
+	var small bytes.Buffer
+	if _, err := io.CopyBuffer(&small, struct{ io.Reader }{r}, make([]byte, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(small.Bytes(), data) {
+		t.Errorf("the payload changed with the size of the reads")
+	}
+	if other, _ := ioutil.ReadAll(helpers.NewDataReader(8, size)); bytes.Equal(other, data) {
+		t.Errorf("different seeds produced the same payload")
+	}
+
+	sum := md5.Sum(data)
+	if !bytes.Equal(r.Sum(), sum[:]) {
+		t.Errorf("expected the checksum %x, got %x", sum, r.Sum())
+	}
+	if md5 := base64.StdEncoding.EncodeToString(sum[:]); r.ContentMD5() != md5 {
+		t.Errorf("expected the Content-MD5 '%s', got '%s'", md5, r.ContentMD5())
+	}
+	if r.Size() != size || r.Seed() != 7 {
+		t.Errorf("unexpected size %d and seed %d", r.Size(), r.Seed())
+	}
+
+	// Readers from a source differ from each other, but not from one run to the next.
+	first, _ := ioutil.ReadAll(helpers.NewRandomSource(1).Reader(100))
+	again, _ := ioutil.ReadAll(helpers.NewRandomSource(1).Reader(100))
+	src := helpers.NewRandomSource(1)
+	src.Reader(100)
+	second, _ := ioutil.ReadAll(src.Reader(100))
+	if !bytes.Equal(first, again) || bytes.Equal(first, second) {
+		t.Errorf("unexpected payloads:\n%s\n%s\n%s", first, again, second)
+	}
+}
+
+func TestDataVerifier(t *testing.T) {
+	const size = 5000
+	data, _ := ioutil.ReadAll(helpers.NewDataReader(3, size))
+
+	v := helpers.NewDataVerifier(3, size)
+	if _, err := io.Copy(v, bytes.NewReader(data)); err != nil {
+		t.Fatalf("expected the payload to be accepted, got %v", err)
+	}
+	if err := v.Verify(); err != nil {
+		t.Errorf("expected the payload to be verified, got %v", err)
+	}
+	if sum := md5.Sum(data); !bytes.Equal(v.Sum(), sum[:]) {
+		t.Errorf("expected the checksum %x, got %x", sum, v.Sum())
+	}
+
+	flipped := append([]byte(nil), data...)
+	flipped[1234] ^= 1
+	for _, c := range []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{"flipped", flipped, "ERROR: Data differs at offset 1234 (seed 3)"},
+		{"short", data[:size-1], "ERROR: Received 4999 bytes, expected 5000 (seed 3)"},
+		{"long", append(append([]byte(nil), data...), 'x'), "ERROR: Received more than the expected 5000 bytes (seed 3)"},
+	} {
+		v := helpers.NewDataReader(3, size).Verifier()
+		io.Copy(v, bytes.NewReader(c.data))
+		if err := v.Verify(); err == nil || !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("%s: expected '%s', got %v", c.name, c.expected, err)
+		}
+	}
+}
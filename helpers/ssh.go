@@ -0,0 +1,98 @@
+package helpers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+)
+
+// sshKeyBits is the size of the RSA keys GenerateSSHKey creates, the smallest Azure accepts.
+const sshKeyBits = 2048
+
+// SSHPublicKey encodes an RSA public key in the OpenSSH format, "ssh-rsa AAAA...", which is what
+// Azure expects in the SSH configuration of a Linux virtual machine.
+func SSHPublicKey(key *rsa.PublicKey) string {
+	var b bytes.Buffer
+	writeSSHString(&b, []byte("ssh-rsa"))
+	writeSSHString(&b, sshMPInt(big.NewInt(int64(key.E))))
+	writeSSHString(&b, sshMPInt(key.N))
+	return "ssh-rsa " + base64.StdEncoding.EncodeToString(b.Bytes())
+}
+
+// writeSSHString writes a string as the SSH wire format does, after its length.
+func writeSSHString(b *bytes.Buffer, s []byte) {
+	binary.Write(b, binary.BigEndian, uint32(len(s)))
+	b.Write(s)
+}
+
+// sshMPInt returns the bytes of a positive integer in the SSH wire format, with a leading zero
+// when the high bit is set, so that it doesn't read as negative.
+func sshMPInt(n *big.Int) []byte {
+	b := n.Bytes()
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return b
+}
+
+// ReadSSHPublicKey reads a public key in the OpenSSH format, such as the id_rsa.pub file written
+// by ssh-keygen, and returns it with its comment, if any.
+func ReadSSHPublicKey(fileName string) (string, error) {
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return "", fmt.Errorf("ERROR: Unable to read the SSH public key (%v)", err)
+	}
+	key := strings.TrimSpace(string(b))
+	fields := strings.Fields(key)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "ssh-") || strings.Contains(key, "\n") {
+		return "", fmt.Errorf("ERROR: %s is not an SSH public key in the OpenSSH format, such as 'ssh-rsa AAAA...'", fileName)
+	}
+	if _, err := base64.StdEncoding.DecodeString(fields[1]); err != nil {
+		return "", fmt.Errorf("ERROR: %s is not an SSH public key in the OpenSSH format, such as 'ssh-rsa AAAA...'", fileName)
+	}
+	return key, nil
+}
+
+// GenerateSSHKey returns the public key of the RSA private key saved in privateKeyFile, creating
+// the key pair when the file doesn't exist yet. A new private key is saved in PEM format, readable
+// only by the user, and its public key next to it, with the extension .pub, as ssh-keygen does.
+// Reusing the saved key keeps the public key the same from one run to the next.
+func GenerateSSHKey(privateKeyFile string) (publicKey string, generated bool, err error) {
+	if b, err := ioutil.ReadFile(privateKeyFile); err == nil {
+		block, _ := pem.Decode(b)
+		if block == nil || block.Type != "RSA PRIVATE KEY" {
+			return "", false, fmt.Errorf("ERROR: %s is not an RSA private key in PEM format", privateKeyFile)
+		}
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return "", false, fmt.Errorf("ERROR: Unable to parse the private key in %s (%v)", privateKeyFile, err)
+		}
+		return SSHPublicKey(&key.PublicKey), false, nil
+	} else if !os.IsNotExist(err) {
+		return "", false, fmt.Errorf("ERROR: Unable to read the private key (%v)", err)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, sshKeyBits)
+	if err != nil {
+		return "", false, fmt.Errorf("ERROR: Unable to generate an SSH key (%v)", err)
+	}
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	if err := ioutil.WriteFile(privateKeyFile, pem.EncodeToMemory(block), 0600); err != nil {
+		return "", false, fmt.Errorf("ERROR: Unable to save the private key (%v)", err)
+	}
+
+	publicKey = SSHPublicKey(&key.PublicKey)
+	if err := ioutil.WriteFile(privateKeyFile+".pub", []byte(publicKey+"\n"), 0644); err != nil {
+		return "", false, fmt.Errorf("ERROR: Unable to save the public key (%v)", err)
+	}
+	return publicKey, true, nil
+}
@@ -0,0 +1,333 @@
+package helpers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ReadDocument reads a file holding a JSON object or, if its name ends in .yaml or .yml, a YAML
+// mapping, and returns it as a map in the form encoding/json would produce.
+func ReadDocument(fileName string) (map[string]interface{}, error) {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	if ext != ".yaml" && ext != ".yml" {
+		return ReadMap(fileName)
+	}
+
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: Unable to read %s (%v)", fileName, err)
+	}
+	v, err := ParseYAML(b)
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: %s contained invalid YAML (%v)", fileName, err)
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("ERROR: %s doesn't hold a YAML mapping", fileName)
+	}
+	return m, nil
+}
+
+// ParseYAML parses the subset of YAML used for configuration files: block mappings and sequences,
+// flow sequences of scalars, empty flow mappings, plain and quoted scalars, and comments. Anchors,
+// tags, multi-line scalars and multiple documents are not supported. Mappings are returned as
+// map[string]interface{} and sequences as []interface{}, like encoding/json does; integers are
+// returned as int64 and other numbers as float64.
+func ParseYAML(b []byte) (interface{}, error) {
+	p := &yamlParser{}
+	for i, text := range strings.Split(strings.Replace(string(b), "\r\n", "\n", -1), "\n") {
+		if i == 0 && strings.HasPrefix(text, "\ufeff") {
+			text = text[len("\ufeff"):]
+		}
+		text = strings.TrimRight(stripYAMLComment(text), " \t")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || (len(p.lines) == 0 && trimmed == "---") {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: tabs can't be used for indentation", i+1)
+		}
+		if trimmed == "---" || trimmed == "..." {
+			return nil, fmt.Errorf("line %d: multiple documents are not supported", i+1)
+		}
+		p.lines = append(p.lines, yamlLine{indent: len(text) - len(trimmed), text: trimmed, n: i + 1})
+	}
+	if len(p.lines) == 0 {
+		return nil, nil
+	}
+
+	v, err := p.parseBlock()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].n)
+	}
+	return v, nil
+}
+
+type yamlLine struct {
+	indent int
+	text   string
+	n      int
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// parseBlock parses the mapping or sequence starting at the current line.
+func (p *yamlParser) parseBlock() (interface{}, error) {
+	l := p.lines[p.pos]
+	if isYAMLSequenceItem(l.text) {
+		return p.parseSequence(l.indent)
+	}
+	if _, _, ok := splitYAMLKey(l.text); ok {
+		return p.parseMapping(l.indent)
+	}
+	p.pos++
+	return parseYAMLScalar(l.text, l.n)
+}
+
+func (p *yamlParser) parseMapping(indent int) (interface{}, error) {
+	m := map[string]interface{}{}
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", l.n)
+		}
+		key, value, ok := splitYAMLKey(l.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected 'key: value', found '%s'", l.n, l.text)
+		}
+		if key == "" {
+			return nil, fmt.Errorf("line %d: empty key", l.n)
+		}
+		if _, dup := m[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key '%s'", l.n, key)
+		}
+		p.pos++
+
+		var v interface{}
+		var err error
+		if value != "" {
+			v, err = parseYAMLScalar(value, l.n)
+		} else if p.pos < len(p.lines) {
+			// The value is the block below the key, which may be a sequence at the key's indentation.
+			next := p.lines[p.pos]
+			if next.indent > indent || (next.indent == indent && isYAMLSequenceItem(next.text)) {
+				v, err = p.parseBlock()
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	return m, nil
+}
+
+func (p *yamlParser) parseSequence(indent int) (interface{}, error) {
+	seq := []interface{}{}
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent < indent || (l.indent == indent && !isYAMLSequenceItem(l.text)) {
+			break
+		}
+		if l.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", l.n)
+		}
+
+		rest := strings.TrimLeft(l.text[1:], " ")
+		if rest == "" {
+			p.pos++
+			var v interface{}
+			if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+				var err error
+				if v, err = p.parseBlock(); err != nil {
+					return nil, err
+				}
+			}
+			seq = append(seq, v)
+			continue
+		}
+
+		// The item continues on the following lines at the column of its first character, as in
+		// "- name: a" followed by "  size: b".
+		p.lines[p.pos] = yamlLine{indent: indent + len(l.text) - len(rest), text: rest, n: l.n}
+		v, err := p.parseBlock()
+		if err != nil {
+			return nil, err
+		}
+		seq = append(seq, v)
+	}
+	return seq, nil
+}
+
+func isYAMLSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splitYAMLKey splits "key: value" and "key:" lines, with plain or quoted keys. The key of a line
+// such as ": value" is empty, which the caller reports.
+func splitYAMLKey(text string) (key, value string, ok bool) {
+	end := -1
+	switch text[0] {
+	case '"', '\'':
+		if i := closingQuote(text); i > 0 {
+			end = i + 1
+		}
+	default:
+		if i := strings.Index(text, ": "); i > 0 {
+			end = i
+		} else if strings.HasSuffix(text, ":") {
+			end = len(text) - 1
+		}
+	}
+	if end < 0 || end >= len(text) || text[end] != ':' || (end+1 < len(text) && text[end+1] != ' ') {
+		return "", "", false
+	}
+
+	key = strings.TrimSpace(text[:end])
+	if key == "" {
+		return "", strings.TrimSpace(text[end+1:]), true
+	}
+	if key[0] == '"' || key[0] == '\'' {
+		s, err := parseYAMLScalar(key, 0)
+		if err != nil {
+			return "", "", false
+		}
+		key, _ = s.(string)
+	}
+	if key != "" && strings.ContainsAny(key[:1], "[{&*!|>%@`") {
+		return "", "", false
+	}
+	return key, strings.TrimSpace(text[end+1:]), true
+}
+
+// closingQuote returns the index of the quote closing the string that starts text, or -1.
+func closingQuote(text string) int {
+	q := text[0]
+	for i := 1; i < len(text); i++ {
+		switch {
+		case q == '"' && text[i] == '\\':
+			i++
+		case text[i] == q && q == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++
+		case text[i] == q:
+			return i
+		}
+	}
+	return -1
+}
+
+// stripYAMLComment removes a comment, which starts with '#' at the beginning of the line or after
+// a space, outside of quotes.
+func stripYAMLComment(text string) string {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && (i == 0 || strings.ContainsRune(" [,:-", rune(text[i-1]))):
+			quote = c
+		case c == '#' && (i == 0 || text[i-1] == ' ' || text[i-1] == '\t'):
+			return text[:i]
+		}
+	}
+	return text
+}
+
+func parseYAMLScalar(text string, line int) (interface{}, error) {
+	switch text[0] {
+	case '"':
+		if closingQuote(text) != len(text)-1 {
+			return nil, fmt.Errorf("line %d: unterminated string %s", line, text)
+		}
+		s, err := strconv.Unquote(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid string %s", line, text)
+		}
+		return s, nil
+	case '\'':
+		if closingQuote(text) != len(text)-1 {
+			return nil, fmt.Errorf("line %d: unterminated string %s", line, text)
+		}
+		return strings.Replace(text[1:len(text)-1], "''", "'", -1), nil
+	case '[':
+		return parseYAMLFlowSequence(text, line)
+	case '{':
+		if strings.HasSuffix(text, "}") && strings.TrimSpace(text[1:len(text)-1]) == "" {
+			return map[string]interface{}{}, nil
+		}
+		return nil, fmt.Errorf("line %d: flow mappings are not supported, write the mapping as a block", line)
+	case '|', '>':
+		return nil, fmt.Errorf("line %d: multi-line strings are not supported", line)
+	case '&', '*', '!':
+		return nil, fmt.Errorf("line %d: anchors, aliases and tags are not supported", line)
+	}
+
+	switch text {
+	case "null", "Null", "NULL", "~":
+		return nil, nil
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	}
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(text, 64); err == nil && strings.IndexAny(text, "0123456789") >= 0 {
+		return f, nil
+	}
+	return text, nil
+}
+
+func parseYAMLFlowSequence(text string, line int) (interface{}, error) {
+	if !strings.HasSuffix(text, "]") {
+		return nil, fmt.Errorf("line %d: unterminated sequence %s", line, text)
+	}
+	seq := []interface{}{}
+	inner := strings.TrimSpace(text[1 : len(text)-1])
+	for inner != "" {
+		item := inner
+		if inner[0] == '"' || inner[0] == '\'' {
+			if i := closingQuote(inner); i > 0 {
+				item = inner[:i+1]
+			}
+		} else if i := strings.Index(inner, ","); i >= 0 {
+			item = inner[:i]
+		}
+		item = strings.TrimSpace(item)
+		if item == "" || strings.ContainsAny(item[:1], "[{") {
+			return nil, fmt.Errorf("line %d: only sequences of scalars can be written inline", line)
+		}
+		v, err := parseYAMLScalar(item, line)
+		if err != nil {
+			return nil, err
+		}
+		seq = append(seq, v)
+
+		inner = strings.TrimSpace(strings.TrimSpace(inner)[len(item):])
+		if inner != "" {
+			if inner[0] != ',' {
+				return nil, fmt.Errorf("line %d: expected ',' in sequence %s", line, text)
+			}
+			inner = strings.TrimSpace(inner[1:])
+		}
+	}
+	return seq, nil
+}
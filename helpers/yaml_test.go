@@ -0,0 +1,118 @@
+package helpers_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-go-samples/helpers"
+)
+
+func TestParseYAML(t *testing.T) {
+	doc := `
+# A comment
+group:
+  name: "createvm01"   # a trailing comment
+  location: West US
+  tags: {}
+prefixes: [10.0.0.0/16, '10.1.0.0/16']
+subnets:
+- name: front#end
+  count: 2
+- name: back
+  enabled: false
+  ratio: 0.5
+empty:
+list:
+  -
+    nested: ~
+  - plain
+`
+	expected := map[string]interface{}{
+		"group": map[string]interface{}{
+			"name":     "createvm01",
+			"location": "West US",
+			"tags":     map[string]interface{}{},
+		},
+		"prefixes": []interface{}{"10.0.0.0/16", "10.1.0.0/16"},
+		"subnets": []interface{}{
+			map[string]interface{}{"name": "front#end", "count": int64(2)},
+			map[string]interface{}{"name": "back", "enabled": false, "ratio": 0.5},
+		},
+		"empty": nil,
+		"list": []interface{}{
+			map[string]interface{}{"nested": nil},
+			"plain",
+		},
+	}
+
+	v, err := helpers.ParseYAML([]byte(doc))
+	if err != nil {
+		t.Fatalf("ParseYAML failed: %v", err)
+	}
+	if !reflect.DeepEqual(v, expected) {
+		t.Errorf("unexpected document:\n%#v\nexpected:\n%#v", v, expected)
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	for doc, expected := range map[string]string{
+		"a: 1\n  b: 2":        "line 2: unexpected indentation",
+		"a: 1\na: 2":          "line 2: duplicate key 'a'",
+		"a:\n\tb: 1":          "line 2: tabs can't be used",
+		"a: |\n  text":        "line 1: multi-line strings are not supported",
+		"a: &anchor 1":        "line 1: anchors, aliases and tags are not supported",
+		"a: {b: 1}":           "line 1: flow mappings are not supported",
+		"a: \"open":           "line 1: unterminated string",
+		"a: [1, [2]]":         "line 1: only sequences of scalars",
+		"a: 1\n---\nb: 2":     "line 2: multiple documents are not supported",
+		"- a\nb: 1":           "line 2: unexpected indentation",
+		"a:\n  - x\n  y: 1":   "line 3: unexpected indentation",
+		"a: 1\njust a scalar": "line 2: expected 'key: value'",
+		"a: 1\n:":             "line 2: empty key",
+		"\"\": x":             "line 1: empty key",
+		"a:\n  '': x":         "line 2: empty key",
+	} {
+		if _, err := helpers.ParseYAML([]byte(doc)); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("ParseYAML(%q): expected '%s', got %v", doc, expected, err)
+		}
+	}
+}
+
+func TestReadDocument(t *testing.T) {
+	dir, err := ioutil.TempDir("", "readdocument")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"spec.yaml": "name: a\nsizes: [1, 2]\n",
+		"spec.json": `{"name": "a", "sizes": [1, 2]}`,
+		"list.yml":  "- a\n- b\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	yaml, err := helpers.ReadDocument(filepath.Join(dir, "spec.yaml"))
+	if err != nil {
+		t.Fatalf("ReadDocument failed: %v", err)
+	}
+	json, err := helpers.ReadDocument(filepath.Join(dir, "spec.json"))
+	if err != nil {
+		t.Fatalf("ReadDocument failed: %v", err)
+	}
+	if helpers.ToJSON(yaml) != helpers.ToJSON(json) {
+		t.Errorf("the YAML and JSON documents differ:\n%s\n%s", helpers.ToJSON(yaml), helpers.ToJSON(json))
+	}
+
+	if _, err := helpers.ReadDocument(filepath.Join(dir, "list.yml")); err == nil || !strings.Contains(err.Error(), "doesn't hold a YAML mapping") {
+		t.Errorf("expected the list to be rejected, got %v", err)
+	}
+}
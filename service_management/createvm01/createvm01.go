@@ -24,6 +24,14 @@ func main() {
 		panic(err)
 	}
 
+	if err := createVM(client, dnsName, storageAccount, location, vmSize, vmImage, userName, userPassword); err != nil {
+		panic(err)
+	}
+}
+
+// createVM creates the hosted service, unless it already exists, and deploys a Linux virtual
+// machine from a platform image to it, waiting for the deployment to finish.
+func createVM(client management.Client, dnsName, storageAccount, location, vmSize, vmImage, userName, userPassword string) error {
 	// create hosted service
 	if err := hostedservice.NewClient(client).CreateHostedService(hostedservice.CreateHostedServiceParameters{
 		ServiceName: dnsName,
@@ -31,7 +39,7 @@ func main() {
 		Label:	   base64.StdEncoding.EncodeToString([]byte(dnsName))}); err != nil {
 		
 		if azErr,ok := err.(management.AzureError); (!ok || azErr.Code != "ConflictError") {
-			panic(err)			
+			return err
 		}
 	}
 
@@ -48,9 +56,7 @@ func main() {
 	operationID, err := virtualmachine.NewClient(client).
 		CreateDeployment(role, dnsName, virtualmachine.CreateDeploymentOptions{})
 	if err != nil {
-		panic(err)
-	}
-	if err := client.WaitForOperation(operationID, nil); err != nil {
-		panic(err)
+		return err
 	}
+	return client.WaitForOperation(operationID, nil)
 }
\ No newline at end of file
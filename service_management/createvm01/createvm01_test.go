@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-go-samples/fakes/asmfake"
+	"github.com/Azure/azure-go-samples/helpers"
+	"github.com/Azure/azure-sdk-for-go/management"
+	"github.com/Azure/azure-sdk-for-go/management/hostedservice"
+)
+
+const (
+	testImage = "b39f27a8b8c64d52b05eac6a62ebad85__Ubuntu-14_04-LTS-amd64-server-20140724-en-us-30GB"
+)
+
+// TestMain makes the management client trust the fakes' TLS certificate, which all test servers
+// share. The system roots are only loaded once, so this has to happen before any test runs.
+func TestMain(m *testing.M) {
+	srv, err := asmfake.NewServer("")
+	if err != nil {
+		panic(err)
+	}
+	dir, err := ioutil.TempDir("", "createvm01")
+	if err != nil {
+		panic(err)
+	}
+	rootFile := filepath.Join(dir, "root.pem")
+	err = srv.WriteRootCertificate(rootFile)
+	srv.Close()
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("SSL_CERT_FILE", rootFile)
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func newManagementService(t *testing.T) (*asmfake.Server, management.Client) {
+	srv, err := asmfake.NewServer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.AddStorageAccount("gosdktest", "West US")
+	client, err := helpers.ManagementClientForEndpoint(srv.SubscriptionID, srv.ManagementCertificate, srv.URL)
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	return srv, client
+}
+
+func TestCreateVM(t *testing.T) {
+	srv, client := newManagementService(t)
+	defer srv.Close()
+
+	if err := createVM(client, "test-vm-from-go", "gosdktest", "West US", "Small", testImage, "testuser", "Test123"); err != nil {
+		t.Fatalf("createVM failed: %v", err)
+	}
+	if services := srv.HostedServices(); len(services) != 1 || services[0] != "test-vm-from-go" {
+		t.Errorf("unexpected hosted services %v", services)
+	}
+	if status, _ := srv.DeploymentStatus("test-vm-from-go"); status != "Running" {
+		t.Errorf("unexpected deployment status '%s'", status)
+	}
+
+	var deployment *asmfake.Request
+	for _, r := range srv.Requests() {
+		r := r
+		if r.Method == "POST" && strings.HasSuffix(r.Path, "/services/hostedservices/test-vm-from-go/deployments") {
+			deployment = &r
+		}
+	}
+	if deployment == nil {
+		t.Fatalf("the deployment was not created")
+	}
+	for _, s := range []string{
+		"<MediaLink>http://gosdktest.blob.core.windows.net/sdktest/test-vm-from-go.vhd</MediaLink>",
+		"<RoleSize>Small</RoleSize>",
+		"<UserName>testuser</UserName>",
+	} {
+		if !strings.Contains(string(deployment.Body), s) {
+			t.Errorf("the deployment doesn't contain '%s'", s)
+		}
+	}
+}
+
+func TestCreateVMExistingService(t *testing.T) {
+	srv, client := newManagementService(t)
+	defer srv.Close()
+
+	// A conflict on the hosted service is taken to mean it was created by an earlier run.
+	err := hostedservice.NewClient(client).CreateHostedService(hostedservice.CreateHostedServiceParameters{
+		ServiceName: "test-vm-from-go",
+		Location:    "West US",
+		Label:       base64.StdEncoding.EncodeToString([]byte("test-vm-from-go"))})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := createVM(client, "test-vm-from-go", "gosdktest", "West US", "Small", testImage, "testuser", "Test123"); err != nil {
+		t.Fatalf("createVM failed: %v", err)
+	}
+
+	// Deploying again is an error, though, since the slot is taken.
+	err = createVM(client, "test-vm-from-go", "gosdktest", "West US", "Small", testImage, "testuser", "Test123")
+	if azErr, ok := err.(management.AzureError); !ok || azErr.Code != "ConflictError" {
+		t.Errorf("expected a conflict, got %v", err)
+	}
+}
+
+func TestCreateVMInvalidLocation(t *testing.T) {
+	srv, client := newManagementService(t)
+	defer srv.Close()
+
+	if err := createVM(client, "test-vm-from-go", "gosdktest", "Atlantis", "Small", testImage, "testuser", "Test123"); err == nil {
+		t.Fatalf("expected createVM to fail")
+	}
+	for _, r := range srv.Requests() {
+		if strings.HasSuffix(r.Path, "/deployments") {
+			t.Errorf("unexpected request after the failure: %s %s", r.Method, r.Path)
+		}
+	}
+}
+
+func TestCreateVMFailedOperation(t *testing.T) {
+	srv, client := newManagementService(t)
+	defer srv.Close()
+	srv.Fail("POST", "/deployments$", 500, "InternalError", "The server encountered an internal error. Please retry the request.", false)
+
+	err := createVM(client, "test-vm-from-go", "gosdktest", "West US", "Small", testImage, "testuser", "Test123")
+	if err == nil || !strings.Contains(err.Error(), "InternalError") {
+		t.Fatalf("expected the deployment operation to fail, got %v", err)
+	}
+	if _, ok := srv.DeploymentStatus("test-vm-from-go"); ok {
+		t.Errorf("the failed deployment was not removed")
+	}
+}
@@ -4,47 +4,72 @@ package main
 
 import (
 	"fmt"
-
+	
 	"github.com/Azure/azure-sdk-for-go/storage"
 	"github.com/Azure/azure-go-samples/helpers"
 )
-const containerPrefix = "cont-"
-const blobPrefix = "blob-"
+const containerPrefix = "cont"
+const blobPrefix = "blob"
 
 const accountName = "<<INSERT ACCOUNT NAME HERE>>"
 const accountKey  = "<<INSERT ACCOUNT KEY HERE>>"
 
 func main() {
     
-	cnt := containerPrefix + helpers.RandString(32-len(containerPrefix))
-	
+	names := helpers.NewNameGenerator(0)
+
 	cli := helpers.GetStorageClient(accountName, accountKey).GetBlobService()
 	
-	// Create a container.
+	if _, _, err := run(cli, names); err != nil {
+		helpers.Logf("%s", err.Error())
+	}
+}
+
+// run creates a container and an empty blob in it, and deletes the container again on the way
+// out, whether or not creating the blob succeeded. It returns the names it used.
+func run(cli storage.BlobStorageClient, names *helpers.NameGenerator) (cnt, blob string, err error) {
+
+	cnt, err = names.Name(helpers.ContainerName, containerPrefix)
+	if err != nil {
+		err = fmt.Errorf("%s\n", err.Error())
+		return
+	}
+	
+	// Create a container. If it's already there, it isn't ours to use, or to delete.
 	
 	ok, err := cli.CreateContainerIfNotExists(cnt, storage.ContainerAccessTypePrivate)   
+	if err != nil {
+		err = fmt.Errorf("Failed to create container '%s': %s\n", cnt, err.Error())
+		return
+	}
 	if !ok {
-		fmt.Printf("Failed to create container '%s': %s\n", cnt, err.Error())
+		err = fmt.Errorf("Failed to create container '%s': it already exists\n", cnt)
 		return
 	}
 
 	// Make sure not to clobber up the storage account.
 	
 	defer func() { 
-			fmt.Printf("Deleting '%s' and all its contents\n", cnt)
+			helpers.Logf("Deleting '%s' and all its contents\n", cnt)
 			cli.DeleteContainer(cnt) 
 		}() 
 
-	fmt.Printf("Successfully created '%s'\n", cnt)
+	helpers.Logf("Successfully created '%s'\n", cnt)
 	
 	// Create an empty blob
 	
-	blob := blobPrefix + helpers.RandString(32-len(blobPrefix))
+	blob, err = names.Name(helpers.BlobName, blobPrefix)
+	if err != nil {
+		err = fmt.Errorf("%s\n", err.Error())
+		return
+	}
 	
-	if err := cli.CreateBlockBlob(cnt, blob); err != nil {
-		fmt.Printf("Failed to create blob '%s' in  '%s': %s\n", blob, cnt, err.Error())
+	if err = cli.CreateBlockBlob(cnt, blob); err != nil {
+		err = fmt.Errorf("Failed to create blob '%s' in  '%s': %s\n", blob, cnt, err.Error())
 		return
 	}
 
-	fmt.Printf("Successfully created '%s'\n", blob)
+	helpers.Logf("Successfully created '%s'\n", blob)
+	
+	return
 }
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-go-samples/fakes/blobfake"
+	"github.com/Azure/azure-go-samples/helpers"
+	"github.com/Azure/azure-sdk-for-go/storage"
+)
+
+func TestRun(t *testing.T) {
+	srv, cli, done := blobfake.NewClient(t)
+	defer done()
+
+	cnt, blob, err := run(cli, helpers.NewNameGenerator(1))
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if !strings.HasPrefix(cnt, containerPrefix) || !strings.HasPrefix(blob, blobPrefix) {
+		t.Errorf("unexpected names '%s' and '%s'", cnt, blob)
+	}
+
+	var calls []string
+	for _, r := range srv.Requests() {
+		calls = append(calls, r.Method+" "+r.Path)
+	}
+	created := false
+	for _, c := range calls {
+		if c == "PUT /"+cnt+"/"+blob {
+			created = true
+		}
+	}
+	if !created {
+		t.Errorf("the blob was not created:\n%s", strings.Join(calls, "\n"))
+	}
+	if last := calls[len(calls)-1]; last != "DELETE /"+cnt {
+		t.Errorf("expected the container to be deleted last, got %s", last)
+	}
+	if len(srv.Containers(blobfake.DefaultAccountName)) != 0 {
+		t.Errorf("the container was left behind")
+	}
+}
+
+func TestRunCleansUpAfterFailure(t *testing.T) {
+	srv, cli, done := blobfake.NewClient(t)
+	defer done()
+	srv.Fail("PUT", "^/"+containerPrefix+"[^/]*/"+blobPrefix, 500, "InternalError", "The server encountered an internal error. Please retry the request.", false)
+
+	_, _, err := run(cli, helpers.NewNameGenerator(1))
+	if err == nil || !strings.Contains(err.Error(), "Failed to create blob") {
+		t.Fatalf("expected creating the blob to fail, got %v", err)
+	}
+	if len(srv.Containers(blobfake.DefaultAccountName)) != 0 {
+		t.Errorf("the container was not deleted after the failure")
+	}
+}
+
+func TestRunExistingContainer(t *testing.T) {
+	srv, cli, done := blobfake.NewClient(t)
+	defer done()
+
+	// The same seed makes up the same container name.
+	cnt, err := helpers.NewNameGenerator(1).Name(helpers.ContainerName, containerPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.CreateContainer(cnt, storage.ContainerAccessTypePrivate); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = run(cli, helpers.NewNameGenerator(1))
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("expected the existing container to be refused, got %v", err)
+	}
+	if len(srv.Containers(blobfake.DefaultAccountName)) != 1 {
+		t.Errorf("a container the sample didn't create was deleted")
+	}
+}
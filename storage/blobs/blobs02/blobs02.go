@@ -4,6 +4,7 @@ package main
 
 import (
 	"os"
+	"flag"
 	"fmt"
 	
 	"github.com/Azure/azure-sdk-for-go/storage"
@@ -17,31 +18,51 @@ const accountKey  = "<<INSERT ACCOUNT KEY HERE>>"
 
 func main() {
 	
-	if len(os.Args) < 3 {
-		fmt.Printf("usage: blob02 file-name blob-name\n")
+	output := helpers.OutputFlags(nil)
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		helpers.Logf("usage: blob02 [--output format] [--query expression] file-name blob-name\n")
 		return
 	}
 	
-	fileName := os.Args[1]
-	blob := os.Args[2]	
+	fileName := flag.Arg(0)
+	blob := flag.Arg(1)	
 
 	cli := helpers.GetStorageClient(accountName, accountKey).GetBlobService()
 	
+	stored, err := upload(cli, fileName, blob)
+	if err != nil {
+		helpers.Logf("%s", err.Error())
+		return
+	}
+	
+	helpers.Logf("Stored properties:\n")
+
+	if err := output.Print(*stored); err != nil {
+		helpers.Logf("%s\n", err.Error())
+	}
+}
+
+// upload creates the container, if necessary, and uploads the file to a blob in it, setting its
+// content type. It returns the properties the server has stored for the blob.
+func upload(cli storage.BlobStorageClient, fileName, blob string) (stored *storage.BlobProperties, err error) {
+
 	// Create a container.
 	
-	_, err := cli.CreateContainerIfNotExists(cnt, storage.ContainerAccessTypeBlob)   
+	_, err = cli.CreateContainerIfNotExists(cnt, storage.ContainerAccessTypeBlob)   
 	if err != nil {
-		fmt.Printf("ERROR: Failed to create container '%s': %s\n", cnt, err.Error())
+		err = fmt.Errorf("ERROR: Failed to create container '%s': %s\n", cnt, err.Error())
 		return
 	}
 
-	fmt.Printf("Successfully created '%s'\n", cnt)
+	helpers.Logf("Successfully created '%s'\n", cnt)
 	
 	// Open the file
 	
 	f, err := os.Open(fileName)
 	if err != nil {
-		fmt.Printf("ERROR: Unable to locate or open file at %s (%s)\n", fileName, err.Error())
+		err = fmt.Errorf("ERROR: Unable to locate or open file at %s (%s)\n", fileName, err.Error())
 		return
 	}
 
@@ -52,7 +73,7 @@ func main() {
 	fileInfo, err := f.Stat()
 	
 	if err != nil {
-		fmt.Printf("ERROR: Unable to retrieve info on file at %s (%s)\n", fileName, err.Error())
+		err = fmt.Errorf("ERROR: Unable to retrieve info on file at %s (%s)\n", fileName, err.Error())
 		return
 	}
 	
@@ -60,29 +81,29 @@ func main() {
 	
 	// Create the blob from the file. Also, pass in a properties block so that the
 	// content type may be set.
-	if err := cli.CreateBlockBlobFromReader(cnt, blob, uint64(fileInfo.Size()), f, &props); err != nil {
-		fmt.Printf("Failed to create '%s' in  '%s': %s\n", blob, cnt, err.Error())
+	if err = cli.CreateBlockBlobFromReader(cnt, blob, uint64(fileInfo.Size()), f, &props); err != nil {
+		err = fmt.Errorf("Failed to create '%s' in  '%s': %s\n", blob, cnt, err.Error())
 		return
 	}
 	
 	// We'll want the url of the blob
 	url := cli.GetBlobURL(cnt,blob)
 	
-	fmt.Printf("Successfully uploaded file to '%s'\n", url)
+	helpers.Logf("Successfully uploaded file to '%s'\n", url)
 	
-	if err := cli.SetBlobProperties(cnt, blob, props); err != nil {
-		fmt.Printf("Failed to set properties for '%s': %s\n", url, err.Error())
+	if err = cli.SetBlobProperties(cnt, blob, props); err != nil {
+		err = fmt.Errorf("Failed to set properties for '%s': %s\n", url, err.Error())
 		return
 	}
 	
-   	fmt.Printf("Successfully set properties for '%s'\n", url)
+   	helpers.Logf("Successfully set properties for '%s'\n", url)
 
 	// Just to make sure, let's see what the properties on the server!
-	stored,err := cli.GetBlobProperties(cnt,blob)
+	stored,err = cli.GetBlobProperties(cnt,blob)
 	if err != nil {
-		fmt.Printf("Failed to retrieve blob properties for '%s': %s\n", url, err.Error())
+		err = fmt.Errorf("Failed to retrieve blob properties for '%s': %s\n", url, err.Error())
 		return
-	}	
+	}
 	
-	fmt.Printf("Stored properties: %s\n", helpers.ToJSON(*stored))
+	return
 }
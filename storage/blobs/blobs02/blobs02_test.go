@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-go-samples/fakes/blobfake"
+	"github.com/Azure/azure-go-samples/helpers"
+)
+
+func TestUpload(t *testing.T) {
+	srv, cli, done := blobfake.NewClient(t)
+	defer done()
+
+	f, err := ioutil.TempFile("", "blobs02")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	contents := helpers.NewRandomSource(1).Bytes(3000)
+	f.Write(contents)
+	f.Close()
+
+	stored, err := upload(cli, f.Name(), "image.jpg")
+	if err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	if stored.ContentType != imageJPG {
+		t.Errorf("unexpected content type '%s'", stored.ContentType)
+	}
+	if stored.ContentLength != int64(len(contents)) {
+		t.Errorf("unexpected content length %d", stored.ContentLength)
+	}
+
+	data, ok := srv.Blob(blobfake.DefaultAccountName, cnt, "image.jpg")
+	if !ok || !bytes.Equal(data, contents) {
+		t.Errorf("the blob doesn't hold the contents of the file")
+	}
+	if access, _ := srv.ContainerAccess(blobfake.DefaultAccountName, cnt); access != "blob" {
+		t.Errorf("unexpected container access '%s'", access)
+	}
+
+	// Uploading again goes on with the existing container.
+	if _, err := upload(cli, f.Name(), "image.jpg"); err != nil {
+		t.Errorf("uploading again failed: %v", err)
+	}
+}
+
+func TestUploadMissingFile(t *testing.T) {
+	srv, cli, done := blobfake.NewClient(t)
+	defer done()
+
+	_, err := upload(cli, "no-such-file.jpg", "image.jpg")
+	if err == nil || !strings.Contains(err.Error(), "Unable to locate or open file at no-such-file.jpg") {
+		t.Fatalf("expected opening the file to fail, got %v", err)
+	}
+	for _, r := range srv.Requests() {
+		if strings.HasPrefix(r.Path, "/"+cnt+"/") {
+			t.Errorf("unexpected request for the blob: %s %s", r.Method, r.Path)
+		}
+	}
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
-	"os"
+	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"strconv"
 		
 	"github.com/Azure/azure-sdk-for-go/storage"
 	"github.com/Azure/azure-go-samples/helpers"
@@ -18,42 +20,82 @@ const blobSize = 8*512	// Each page is 512 bytes long.
 
 func main() {
 	
-	if len(os.Args) < 2 {
-		fmt.Printf("usage: blob03 blob-name\n")
+	output := helpers.OutputFlags(nil)
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		helpers.Logf("usage: blob03 [--output format] [--query expression] blob-name [seed]\n")
 		return
 	}
 	
-	blob := os.Args[1]
+	blob := flag.Arg(0)
+
+	// The page data is generated from a seed, which is printed if validation fails, so that
+	// a failing run can be repeated with the same data.
+
+	var seed int64
+	if flag.NArg() >= 2 {
+		s, err := strconv.ParseInt(flag.Arg(1), 10, 64)
+		if err != nil {
+			helpers.Logf("ERROR: Invalid seed '%s'\n", flag.Arg(1))
+			return
+		}
+		seed = s
+	}
+
+	src := helpers.NewRandomSource(seed)
 	
 	cli := helpers.GetStorageClient(accountName, accountKey).GetBlobService()
 		
+	pl, err := writeAndVerify(cli, blob, src)
+	if pl != nil {
+		if err := output.Print(pl); err != nil {
+			helpers.Logf("%s\n", err.Error())
+		}
+	}
+	if err != nil {
+		helpers.Logf("%s", err.Error())
+	}
+}
+
+// writeAndVerify creates a page blob, writes three pages of data from the random source to it,
+// clears one of them, and reads the blob back after each step to validate its contents. It
+// returns the page ranges the server reports at the end, which are checked as well.
+func writeAndVerify(cli storage.BlobStorageClient, blob string, src *helpers.RandomSource) (pl []storage.PageRange, err error) {
+
 	// Create a container.
 	
-	_, err := cli.CreateContainerIfNotExists(cnt, storage.ContainerAccessTypeBlob)
+	_, err = cli.CreateContainerIfNotExists(cnt, storage.ContainerAccessTypeBlob)
 	if err != nil {
-		fmt.Printf("ERROR: Failed to create container '%s': %s\n", cnt, err.Error())
+		err = fmt.Errorf("ERROR: Failed to create container '%s': %s\n", cnt, err.Error())
 		return
 	}
 
-	fmt.Printf("Successfully created '%s'\n", cnt)
+	helpers.Logf("Successfully created '%s'\n", cnt)
 	
 	// First, create an empty page blob
-	if err := cli.PutPageBlob(cnt, blob, blobSize); err != nil {
-		fmt.Printf("Failed to create '%s' in  '%s': %s\n", blob, cnt, err.Error())
+	if err = cli.PutPageBlob(cnt, blob, blobSize); err != nil {
+		err = fmt.Errorf("Failed to create '%s' in  '%s': %s\n", blob, cnt, err.Error())
 		return
 	}
 	
-	// Then, write some pages. We will write pages 0, 1, and 2, leaving five pages blank.
+	// Then, write some pages. We will write pages 0, 1, and 2, leaving five pages blank. The data
+	// is read from a payload generated by the random source, which can be generated again to
+	// check what the blob holds.
 	
-	data := helpers.RandBytes(1536)
+	payload := src.Reader(1536)
+	data, err := ioutil.ReadAll(payload)
+	if err != nil {
+		return
+	}
 	
-	if err := writePage(cli, blob, 0, 511, data[0:512]); err != nil {
+	if err = writePage(cli, blob, 0, 511, data[0:512]); err != nil {
 		return
 	}
-	if err := writePage(cli, blob, 512, 1023, data[512:1024]); err != nil {
+	if err = writePage(cli, blob, 512, 1023, data[512:1024]); err != nil {
 		return	
 	}
-	if err := writePage(cli, blob, 1024, 1535, data[1024:1536]); err != nil {
+	if err = writePage(cli, blob, 1024, 1535, data[1024:1536]); err != nil {
 		return	
 	}
 	
@@ -62,45 +104,45 @@ func main() {
 	url := cli.GetBlobURL(cnt,blob)
 
 	// The first three pages should correspond to what was written.
-	if err := validate(cli, blob, 0, 1535, data[0:1536]); err != nil {
-		fmt.Printf("Failed validation of %s: %s\n", url, err.Error())
+	if err = verify(cli, blob, payload.Verifier()); err != nil {
+		err = fmt.Errorf("Failed validation of %s: %s\n", url, src.Annotate(err).Error())
 		return
 	}
 
 	// The next five pages should be all zeroes.
-	if err := validate(cli, blob, 1536, blobSize-1, make([]byte,blobSize)); err != nil {
-		fmt.Printf("Failed validation of %s: %s\n", url, err.Error())
+	if err = validate(cli, blob, 1536, blobSize-1, make([]byte,blobSize)); err != nil {
+		err = fmt.Errorf("Failed validation of %s: %s\n", url, src.Annotate(err).Error())
 		return
 	}
 	
-	fmt.Printf("Successfully created, wrote to, and read a page blob at '%s\n", url)
+	helpers.Logf("Successfully created, wrote to, and read a page blob at '%s\n", url)
 
 	// Next, let's try clearing a page, that is, setting it to all zeroes.
 	
-	if err := clearPage(cli, blob, 512, 1023); err != nil {
+	if err = clearPage(cli, blob, 512, 1023); err != nil {
 		return	
 	}
 	
 	// Now that we have written the pages, let's check what's been stored.
 	
 	// The first and third pages should correspond to what was written.
-	if err := validate(cli, blob, 0, 511, data[0:512]); err != nil {
-		fmt.Printf("Failed validation of %s: %s\n", url, err.Error())
+	if err = validate(cli, blob, 0, 511, data[0:512]); err != nil {
+		err = fmt.Errorf("Failed validation of %s: %s\n", url, src.Annotate(err).Error())
 		return
 	}
 	// The second page should be all zeroes.
-	if err := validate(cli, blob, 512, 1023, make([]byte,1024)); err != nil {
-		fmt.Printf("Failed validation of %s: %s\n", url, err.Error())
+	if err = validate(cli, blob, 512, 1023, make([]byte,1024)); err != nil {
+		err = fmt.Errorf("Failed validation of %s: %s\n", url, src.Annotate(err).Error())
 		return
 	}
-	if err := validate(cli, blob, 1024, 1535, data[0:1536]); err != nil {
-		fmt.Printf("Failed validation of %s: %s\n", url, err.Error())
+	if err = validate(cli, blob, 1024, 1535, data[0:1536]); err != nil {
+		err = fmt.Errorf("Failed validation of %s: %s\n", url, src.Annotate(err).Error())
 		return
 	}
 	
 	// The last five pages should still be all zeroes.
-	if err := validate(cli, blob, 1536, blobSize-1, make([]byte,blobSize)); err != nil {
-		fmt.Printf("Failed validation of %s: %s\n", url, err.Error())
+	if err = validate(cli, blob, 1536, blobSize-1, make([]byte,blobSize)); err != nil {
+		err = fmt.Errorf("Failed validation of %s: %s\n", url, src.Annotate(err).Error())
 		return
 	}
 	
@@ -108,32 +150,29 @@ func main() {
 	
 	ranges,err := cli.GetPageRanges(cnt,blob)
 	if err != nil {
-		fmt.Printf("Failed to get page ranges for %s: %s\n", url, err.Error())
+		err = fmt.Errorf("Failed to get page ranges for %s: %s\n", url, err.Error())
 		return	
 	}
 	
-	fmt.Printf("Successfully cleared a page at '%s\n", url)
+	helpers.Logf("Successfully cleared a page at '%s\n", url)
 
-	for _,rng := range ranges.PageList {
-		fmt.Printf("[%d,%d]\n", rng.Start, rng.End)
-	}
-	
-	pl := ranges.PageList
+	pl = ranges.PageList
 	
 	if len(pl) != 2 || pl[0].Start != 0 || pl[0].End != 511 || pl[1].Start != 1024 || pl[1].End != 1535 {
-		fmt.Printf("Incorrect page list returned\n")
+		err = fmt.Errorf("Incorrect page list returned (random seed %d)\n", src.Seed())
 		return
 	}
 
-	fmt.Printf("Successfully checked page ranges of '%s\n", url)
+	helpers.Logf("Successfully checked page ranges of '%s\n", url)
+	
+	return
 }
 
 func writePage(cli storage.BlobStorageClient, name string, startByte, endByte int64, chunk []byte) error {
 	
 	if err := cli.PutPage(cnt, name, startByte, endByte, storage.PageWriteTypeUpdate, chunk); err != nil {
 		url := cli.GetBlobURL(cnt,name)
-		fmt.Printf("Failed to write pages to %s: %s\n", url, err.Error())
-		return err
+		return fmt.Errorf("Failed to write pages to %s: %s\n", url, err.Error())
 	}
 	return nil	
 }
@@ -142,12 +181,33 @@ func clearPage(cli storage.BlobStorageClient, name string, startByte, endByte in
 	
 	if err := cli.PutPage(cnt, name, startByte, endByte, storage.PageWriteTypeClear, nil); err != nil {
 		url := cli.GetBlobURL(cnt,name)
-		fmt.Printf("Failed to clear pages of %s: %s\n", url, err.Error())
-		return err
+		return fmt.Errorf("Failed to clear pages of %s: %s\n", url, err.Error())
 	}
 	return nil	
 }
 
+// verify streams the beginning of a blob into a DataVerifier, which checks it against the payload
+// it was written from, as it is downloaded.
+func verify(cli storage.BlobStorageClient, blob string, v *helpers.DataVerifier) error {
+
+	url := cli.GetBlobURL(cnt,blob)
+
+	reader,err := cli.GetBlob(cnt, blob)
+	if err != nil {
+		return fmt.Errorf("Failed to read from %s: %s\n", url, err.Error())
+	}
+
+	defer reader.Close()
+
+	if _, err = io.Copy(v, io.LimitReader(reader, v.Size())); err != nil {
+		return fmt.Errorf("Failed to read data properly from %s: %s", url, err.Error())
+	}
+	if err = v.Verify(); err != nil {
+		return fmt.Errorf("Failed to read data properly from %s: %s", url, err.Error())
+	}
+	return nil
+}
+
 func validate(cli storage.BlobStorageClient, blob string, startByte, endByte int64, data []byte) error {
 	
 	url := cli.GetBlobURL(cnt,blob)
@@ -165,6 +225,10 @@ func validate(cli storage.BlobStorageClient, blob string, startByte, endByte int
 		return fmt.Errorf("Failed to read from %s: %s\n", url, err.Error())
 	}	
 	
+	if int64(len(dataRead)) <= endByte {
+		return fmt.Errorf("Read %d bytes from %s, expected %d", len(dataRead), url, blobSize)
+	}
+	
 	same := true
 	for i := startByte; i <= endByte; i++ {
 		if data[i] != dataRead[i] {
@@ -173,7 +237,7 @@ func validate(cli storage.BlobStorageClient, blob string, startByte, endByte int
 	}
 	
 	if !same {
-		return fmt.Errorf("Failed to read data properly from %s: %s\n", url, err.Error())
+		return fmt.Errorf("Failed to read data properly from %s", url)
 	}
 	
 	return nil	
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-go-samples/fakes/blobfake"
+	"github.com/Azure/azure-go-samples/helpers"
+	"github.com/Azure/azure-sdk-for-go/storage"
+)
+
+func TestWriteAndVerify(t *testing.T) {
+	srv, cli, done := blobfake.NewClient(t)
+	defer done()
+
+	pl, err := writeAndVerify(cli, "pages", helpers.NewRandomSource(1))
+	if err != nil {
+		t.Fatalf("writeAndVerify failed: %v", err)
+	}
+	if len(pl) != 2 || pl[0].Start != 0 || pl[0].End != 511 || pl[1].Start != 1024 || pl[1].End != 1535 {
+		t.Errorf("unexpected page ranges %v", pl)
+	}
+
+	data, ok := srv.Blob(blobfake.DefaultAccountName, cnt, "pages")
+	if !ok || len(data) != blobSize {
+		t.Fatalf("the page blob was not created with %d bytes", blobSize)
+	}
+	// The same seed produces the same payload, whose second page was cleared.
+	expected, _ := ioutil.ReadAll(helpers.NewRandomSource(1).Reader(1536))
+	copy(expected[512:1024], make([]byte, 512))
+	if !bytes.Equal(data[:1536], expected) {
+		t.Errorf("the page blob doesn't hold the data that was written")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	srv, cli, done := blobfake.NewClient(t)
+	defer done()
+
+	if _, err := cli.CreateContainerIfNotExists(cnt, storage.ContainerAccessTypeBlob); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.PutPageBlob(cnt, "pages", blobSize); err != nil {
+		t.Fatal(err)
+	}
+	payload := helpers.NewRandomSource(3).Reader(1024)
+	data, _ := ioutil.ReadAll(payload)
+	if err := writePage(cli, "pages", 0, 1023, data); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := srv.Blob(blobfake.DefaultAccountName, cnt, "pages")
+	if sum := md5.Sum(stored[:1024]); base64.StdEncoding.EncodeToString(sum[:]) != payload.ContentMD5() {
+		t.Errorf("the checksum of the stored pages differs from the payload's")
+	}
+
+	v := payload.Verifier()
+	if err := verify(cli, "pages", v); err != nil {
+		t.Errorf("verify failed: %v", err)
+	}
+	if !bytes.Equal(v.Sum(), payload.Sum()) {
+		t.Errorf("the checksum of the download differs from the payload's")
+	}
+
+	// Another payload of the same size doesn't match.
+	err := verify(cli, "pages", helpers.NewRandomSource(4).Reader(1024).Verifier())
+	if err == nil || !strings.Contains(err.Error(), "Data differs at offset 0") {
+		t.Errorf("expected the pages to differ, got %v", err)
+	}
+}
+
+// corruptingTransport flips a byte in the body of every blob it reads.
+type corruptingTransport struct {
+	base http.RoundTripper
+}
+
+func (t corruptingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(r)
+	if err != nil || r.Method != "GET" || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > 100 {
+		body[100] ^= 0xff
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+func TestWriteAndVerifyValidationFailure(t *testing.T) {
+	_, cli, done := blobfake.NewClient(t)
+	defer done()
+
+	original := http.DefaultTransport
+	http.DefaultTransport = corruptingTransport{base: original}
+	defer func() { http.DefaultTransport = original }()
+
+	pl, err := writeAndVerify(cli, "pages", helpers.NewRandomSource(7))
+	if err == nil {
+		t.Fatalf("expected the validation to fail")
+	}
+	if !strings.Contains(err.Error(), "Failed validation of") || !strings.Contains(err.Error(), "Data differs at offset 100") || !strings.Contains(err.Error(), "random seed 7") {
+		t.Errorf("expected a validation failure naming the seed, got %v", err)
+	}
+	if pl != nil {
+		t.Errorf("unexpected page ranges after a validation failure")
+	}
+}
+
+func TestWriteAndVerifyWriteFailure(t *testing.T) {
+	srv, cli, done := blobfake.NewClient(t)
+	defer done()
+	srv.Fail("PUT", "/pages$", 500, "InternalError", "The server encountered an internal error. Please retry the request.", false)
+
+	_, err := writeAndVerify(cli, "pages", helpers.NewRandomSource(1))
+	if err == nil || !strings.Contains(err.Error(), "Failed to create 'pages'") {
+		t.Fatalf("expected creating the page blob to fail, got %v", err)
+	}
+}